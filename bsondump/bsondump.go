@@ -18,6 +18,7 @@ import (
 
 	"github.com/mongodb/mongo-tools/common/bsonutil"
 	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/dumprestore"
 	"github.com/mongodb/mongo-tools/common/failpoint"
 	"github.com/mongodb/mongo-tools/common/json"
 	"github.com/mongodb/mongo-tools/common/log"
@@ -219,6 +220,46 @@ func (bd *BSONDump) Debug() (int, error) {
 	return numFound, nil
 }
 
+// Schema iterates through the BSON file and samples the documents it finds,
+// then prints a JSON summary of their fields, in the form that mongodump writes
+// with --schemaSummary.
+// It returns the number of documents processed and a non-nil error if one is
+// encountered before the end of the file is reached.
+func (bd *BSONDump) Schema() (int, error) {
+	numFound := 0
+
+	if bd.InputSource == nil {
+		panic("Tried to call Schema() before opening file")
+	}
+
+	sampler := dumprestore.NewSchemaSampler(bd.OutputOptions.SchemaSampleSize)
+	for {
+		result := bson.Raw(bd.InputSource.LoadNext())
+		if result == nil {
+			break
+		}
+
+		if err := sampler.Add(result); err != nil {
+			log.Logvf(log.Always, "unable to sample document %v: %v", numFound+1, err)
+
+			if bd.OutputOptions.ObjCheck {
+				return numFound, err
+			}
+		}
+		numFound++
+	}
+	if err := bd.InputSource.Err(); err != nil {
+		return numFound, err
+	}
+
+	content, err := sampler.Schema().Marshal()
+	if err != nil {
+		return numFound, fmt.Errorf("error marshaling schema summary: %v", err)
+	}
+	_, err = bd.OutputWriter.Write(content)
+	return numFound, err
+}
+
 func printBSON(raw bson.Raw, indentLevel int, out io.Writer) error {
 	indent := strings.Repeat("\t", indentLevel)
 	fmt.Fprintf(out, "%v--- new object ---\n", indent)
@@ -9,6 +9,7 @@ package bsondump
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
@@ -17,6 +18,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/mongodb/mongo-tools/common/dumprestore"
 	"github.com/mongodb/mongo-tools/common/testtype"
 	"github.com/mongodb/mongo-tools/common/testutil"
 	"github.com/stretchr/testify/assert"
@@ -284,6 +286,28 @@ func TestBsondumpAllTypesDebug(t *testing.T) {
 	}
 }
 
+func TestBsondumpSchema(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	out, err := bsondumpCommand("--type=schema", "testdata/all_types.bson").Output()
+	require.NoError(t, err, "bsondump should exit successfully with --type=schema")
+
+	var schema dumprestore.Schema
+	require.NoError(t, json.Unmarshal(out, &schema))
+	assert.Equal(t, int64(20), schema.Documents)
+	assert.Equal(t, 2, schema.MaxDepth)
+	require.NotEmpty(t, schema.Fields)
+	assert.Equal(t, "_id", schema.Fields[0].Path)
+	assert.Equal(t, 1.0, schema.Fields[0].Presence)
+	assert.Equal(t, map[string]int64{"objectId": 20}, schema.Fields[0].Types)
+
+	out, err = bsondumpCommand("--type=schema", "--schemaSampleSize=2", "testdata/sample.bson").
+		Output()
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(out, &schema))
+	assert.Equal(t, int64(2), schema.Documents)
+}
+
 // TestBsondumpAllTypesJSON verifies that bsondump --type=json correctly serializes all
 // non-deprecated BSON types to Extended JSON.
 func TestBsondumpAllTypesJSON(t *testing.T) {
@@ -27,13 +27,17 @@ type Options struct {
 
 // Types out output supported by the --type option.
 const (
-	DebugOutputType = "debug"
-	JSONOutputType  = "json"
+	DebugOutputType  = "debug"
+	JSONOutputType   = "json"
+	SchemaOutputType = "schema"
 )
 
 type OutputOptions struct {
 	// Format to display the BSON data file
-	Type string `long:"type" value-name:"<type>" default:"json" default-mask:"-" description:"type of output: debug, json"`
+	Type string `long:"type" value-name:"<type>" default:"json" default-mask:"-" description:"type of output: debug, json, schema"`
+
+	// Number of documents that --type=schema samples
+	SchemaSampleSize int64 `long:"schemaSampleSize" value-name:"<n>" description:"number of documents that --type=schema samples, or 0 for all of them (defaults to 0)" default:"0" default-mask:"-"`
 
 	// Validate each BSON document before displaying
 	ObjCheck bool `long:"objcheck" description:"validate BSON during processing"`
@@ -85,15 +89,20 @@ func ParseOptions(rawArgs []string, versionStr, gitCommit string) (Options, erro
 		outputOpts.BSONFileName = args[0]
 	}
 
+	if outputOpts.SchemaSampleSize < 0 {
+		return Options{}, fmt.Errorf("--schemaSampleSize can't be negative")
+	}
+
 	switch outputOpts.Type {
-	case "", DebugOutputType, JSONOutputType:
+	case "", DebugOutputType, JSONOutputType, SchemaOutputType:
 		return Options{toolOpts, outputOpts}, nil
 	default:
 		return Options{}, fmt.Errorf(
-			"unsupported output type %#q. Must be either %#q or %#q",
+			"unsupported output type %#q. Must be %#q, %#q or %#q",
+			outputOpts.Type,
 			DebugOutputType,
 			JSONOutputType,
-			outputOpts.Type,
+			SchemaOutputType,
 		)
 	}
 }
@@ -114,12 +114,16 @@ func (nh *NamespaceHeader) UnmarshalBSON(in []byte) error {
 // For a CollectionMetadata for collection X with Type == "timeseries",
 // there will be no data for collection X. Instead there will be data for collection system.buckets.X.
 // Mongorestore will restore both the timeseries view and the underlying system.buckets collection.
+// Schema is the JSON schema summary of the collection, when mongodump is run with --schemaSummary.
+// ViewSources are the namespaces that a view reads from, which mongorestore creates first.
 type CollectionMetadata struct {
-	Database   string `bson:"db"`
-	Collection string `bson:"collection"`
-	Metadata   string `bson:"metadata"`
-	Size       int    `bson:"size"`
-	Type       string `bson:"type"`
+	Database    string   `bson:"db"`
+	Collection  string   `bson:"collection"`
+	Metadata    string   `bson:"metadata"`
+	Size        int      `bson:"size"`
+	Type        string   `bson:"type"`
+	Schema      string   `bson:"schema,omitempty"`
+	ViewSources []string `bson:"viewSources,omitempty"`
 }
 
 // Header is a data structure that, as BSON, is found immediately after the magic
@@ -129,6 +133,9 @@ type Header struct {
 	FormatVersion         string `bson:"version"`
 	ServerVersion         string `bson:"server_version"`
 	ToolVersion           string `bson:"tool_version"`
+	Compression           string `bson:"compression,omitempty"`
+	Encryption            string `bson:"encryption,omitempty"`
+	EncryptionKeyID       string `bson:"encryption_key_id,omitempty"`
 }
 
 const minBSONSize = 4 + 1 // an empty BSON document should be exactly five bytes long
@@ -0,0 +1,257 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package archive
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// IndexedFormatVersion is the format version of archives that end with an index
+// of their blocks. Older readers can't parse the index, so it is only written
+// when asked for.
+const IndexedFormatVersion = "0.2"
+
+// indexTrailerMagic marks the trailer at the very end of an indexed archive. It
+// is the bytes "indx" read as a little endian uint32.
+const indexTrailerMagic uint32 = 0x78646e69
+
+// indexTrailerSize is the size of the trailer: the offset of the index block as
+// a little endian int64, followed by indexTrailerMagic.
+const indexTrailerSize = 8 + 4
+
+// IndexHeader is a data structure that, as BSON, is the header of the index block
+// at the end of an indexed archive. The body of the index block is one
+// NamespaceIndex per namespace in the archive.
+type IndexHeader struct {
+	Index bool `bson:"index"`
+}
+
+// NamespaceIndex is a data structure that, as BSON, lists where the blocks of a
+// namespace are found in an indexed archive. The last block is the namespace's
+// EOF block.
+type NamespaceIndex struct {
+	Database   string       `bson:"db"`
+	Collection string       `bson:"collection"`
+	Blocks     []IndexBlock `bson:"blocks"`
+}
+
+// IndexBlock is the location of a single block in an indexed archive, including
+// its header and terminator.
+type IndexBlock struct {
+	Offset int64 `bson:"offset"`
+	Length int64 `bson:"length"`
+	EOF    bool  `bson:"eof,omitempty"`
+}
+
+// Namespace returns the namespace the NamespaceIndex is for.
+func (ni *NamespaceIndex) Namespace() string {
+	return ni.Database + "." + ni.Collection
+}
+
+// Index is the index read from the end of an indexed archive.
+type Index struct {
+	Namespaces []*NamespaceIndex
+}
+
+// isIndexHeader returns whether the header of a block is an IndexHeader.
+func isIndexHeader(buf []byte) bool {
+	_, err := bson.Raw(buf).LookupErr("index")
+	return err == nil
+}
+
+// offsetWriter counts the bytes written through it, so that the multiplexer
+// knows where each block starts.
+type offsetWriter struct {
+	io.WriteCloser
+	offset int64
+}
+
+func (ow *offsetWriter) Write(p []byte) (int, error) {
+	n, err := ow.WriteCloser.Write(p)
+	ow.offset += int64(n)
+	return n, err
+}
+
+// indexBuilder collects the location of every block the multiplexer writes.
+type indexBuilder struct {
+	out         *offsetWriter
+	namespaces  []*NamespaceIndex
+	byNamespace map[string]*NamespaceIndex
+	// last is the most recently started block, whose length is only known
+	// once the next block starts.
+	last *NamespaceIndex
+}
+
+// startBlock records that a block for the given namespace starts at the current offset.
+func (ib *indexBuilder) startBlock(db, collection string, eof bool) {
+	ib.endBlock()
+	ns := db + "." + collection
+	ni, ok := ib.byNamespace[ns]
+	if !ok {
+		ni = &NamespaceIndex{Database: db, Collection: collection}
+		ib.byNamespace[ns] = ni
+		ib.namespaces = append(ib.namespaces, ni)
+	}
+	ni.Blocks = append(ni.Blocks, IndexBlock{Offset: ib.out.offset, EOF: eof})
+	ib.last = ni
+}
+
+// endBlock sets the length of the most recently started block, which ends at
+// the current offset.
+func (ib *indexBuilder) endBlock() {
+	if ib.last == nil {
+		return
+	}
+	block := &ib.last.Blocks[len(ib.last.Blocks)-1]
+	block.Length = ib.out.offset - block.Offset
+	ib.last = nil
+}
+
+// EnableIndex makes the Multiplexer end the archive with an index of its blocks.
+// It must be called before anything is written to the archive, and everything
+// written to the archive, including the prelude, must go through mux.Out.
+func (mux *Multiplexer) EnableIndex() {
+	out := &offsetWriter{WriteCloser: mux.Out}
+	mux.Out = out
+	mux.index = &indexBuilder{
+		out:         out,
+		byNamespace: make(map[string]*NamespaceIndex),
+	}
+}
+
+// Indexed returns whether the Multiplexer ends the archive with an index.
+func (mux *Multiplexer) Indexed() bool {
+	return mux.index != nil
+}
+
+// writeIndex writes the index block and the trailer that locates it.
+func (mux *Multiplexer) writeIndex() error {
+	mux.index.endBlock()
+	indexOffset := mux.index.out.offset
+
+	header, err := bson.Marshal(IndexHeader{Index: true})
+	if err != nil {
+		return err
+	}
+	_, err = mux.Out.Write(header)
+	if err != nil {
+		return err
+	}
+	for _, ni := range mux.index.namespaces {
+		buf, err := bson.Marshal(ni)
+		if err != nil {
+			return err
+		}
+		_, err = mux.Out.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = mux.Out.Write(terminatorBytes)
+	if err != nil {
+		return err
+	}
+
+	trailer := binary.LittleEndian.AppendUint64(nil, uint64(indexOffset))
+	trailer = binary.LittleEndian.AppendUint32(trailer, indexTrailerMagic)
+	_, err = mux.Out.Write(trailer)
+	return err
+}
+
+// ReadIndex reads the index from the end of an indexed archive of the given size.
+func ReadIndex(in io.ReaderAt, size int64) (*Index, error) {
+	if size < indexTrailerSize {
+		return nil, fmt.Errorf("archive is too small to have an index")
+	}
+	trailer := make([]byte, indexTrailerSize)
+	_, err := in.ReadAt(trailer, size-indexTrailerSize)
+	if err != nil {
+		return nil, fmt.Errorf("error reading archive index trailer: %v", err)
+	}
+	if binary.LittleEndian.Uint32(trailer[8:]) != indexTrailerMagic {
+		return nil, fmt.Errorf("archive does not end with an index trailer")
+	}
+	indexOffset := int64(binary.LittleEndian.Uint64(trailer))
+	if indexOffset < 0 || indexOffset >= size-indexTrailerSize {
+		return nil, fmt.Errorf("archive index offset %v is out of range", indexOffset)
+	}
+
+	parser := Parser{
+		In: io.NewSectionReader(in, indexOffset, size-indexTrailerSize-indexOffset),
+	}
+	isTerminator, err := parser.readBSONOrTerminator()
+	if err != nil {
+		return nil, fmt.Errorf("error reading archive index header: %v", err)
+	}
+	if isTerminator || !isIndexHeader(parser.buf[:parser.length]) {
+		return nil, fmt.Errorf(
+			"archive index offset %v does not point at an index",
+			indexOffset,
+		)
+	}
+
+	index := &Index{}
+	for {
+		isTerminator, err = parser.readBSONOrTerminator()
+		if err != nil {
+			return nil, fmt.Errorf("error reading archive index: %v", err)
+		}
+		if isTerminator {
+			return index, nil
+		}
+		ni := &NamespaceIndex{}
+		err = bson.Unmarshal(parser.buf[:parser.length], ni)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshaling archive index: %v", err)
+		}
+		for _, block := range ni.Blocks {
+			if block.Offset < 0 || block.Length <= 0 || block.Offset+block.Length > indexOffset {
+				return nil, fmt.Errorf(
+					"archive index has an invalid block for %#q",
+					ni.Namespace(),
+				)
+			}
+		}
+		index.Namespaces = append(index.Namespaces, ni)
+	}
+}
+
+// UseIndex makes the Demultiplexer read only the blocks it needs from in, using
+// the index of the archive. Blocks of namespaces that have been muted with a
+// MutedCollection are skipped rather than read and discarded, so it must be
+// called after every MutedCollection has been opened and before Run.
+func (demux *Demultiplexer) UseIndex(in io.ReaderAt, index *Index) {
+	var blocks []IndexBlock
+	for _, ni := range index.Namespaces {
+		ns := ni.Namespace()
+		if _, muted := demux.outs[ns].(*MutedCollection); muted {
+			delete(demux.outs, ns)
+			delete(demux.lengths, ns)
+			if _, ok := demux.NamespaceStatus[ns]; ok {
+				demux.NamespaceStatus[ns] = NamespaceClosed
+			}
+			continue
+		}
+		blocks = append(blocks, ni.Blocks...)
+	}
+	// Namespaces must still be announced in the order they appear in the
+	// archive, so the blocks are read in file order.
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Offset < blocks[j].Offset
+	})
+
+	readers := make([]io.Reader, len(blocks))
+	for i, block := range blocks {
+		readers[i] = io.NewSectionReader(in, block.Offset, block.Length)
+	}
+	demux.In = io.MultiReader(readers...)
+}
@@ -0,0 +1,191 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package archive
+
+import (
+	"bytes"
+	"hash"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// buildIndexedArchive multiplexes testIntents into an indexed archive, prelude included.
+func buildIndexedArchive(t *testing.T) ([]byte, map[string]hash.Hash) {
+	buf := &closingBuffer{bytes.Buffer{}}
+	mux := NewMultiplexer(buf, new(testNotifier))
+	mux.EnableIndex()
+	require.True(t, mux.Indexed())
+
+	prelude := &Prelude{Header: &Header{FormatVersion: IndexedFormatVersion}}
+	require.NoError(t, prelude.Write(mux.Out))
+
+	inChecksum := map[string]hash.Hash{}
+	errChan := make(chan error)
+	makeIns(testIntents, mux, inChecksum, map[string]*MuxIn{}, map[string]*int{}, errChan)
+
+	go mux.Run()
+	for range testIntents {
+		require.NoError(t, <-errChan)
+	}
+	close(mux.Control)
+	require.NoError(t, <-mux.Completed)
+
+	return buf.Bytes(), inChecksum
+}
+
+// readRecorder is an io.ReaderAt that records the offsets it was read at.
+type readRecorder struct {
+	io.ReaderAt
+	mutex sync.Mutex
+	reads []IndexBlock
+}
+
+func (rr *readRecorder) ReadAt(p []byte, off int64) (int, error) {
+	n, err := rr.ReaderAt.ReadAt(p, off)
+	rr.mutex.Lock()
+	rr.reads = append(rr.reads, IndexBlock{Offset: off, Length: int64(n)})
+	rr.mutex.Unlock()
+	return n, err
+}
+
+// headerRecorder is a ParserConsumer that records the namespace headers it sees.
+type headerRecorder struct {
+	headers []NamespaceHeader
+}
+
+func (hr *headerRecorder) HeaderBSON(buf []byte) error {
+	header := NamespaceHeader{}
+	err := bson.Unmarshal(buf, &header)
+	hr.headers = append(hr.headers, header)
+	return err
+}
+
+func (*headerRecorder) BodyBSON([]byte) error { return nil }
+
+func (*headerRecorder) End() error { return nil }
+
+func TestReadIndex(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	archiveBytes, _ := buildIndexedArchive(t)
+	index, err := ReadIndex(bytes.NewReader(archiveBytes), int64(len(archiveBytes)))
+	require.NoError(t, err)
+	require.Len(t, index.Namespaces, len(testIntents))
+
+	for _, ni := range index.Namespaces {
+		require.NotEmpty(t, ni.Blocks)
+		for i, block := range ni.Blocks {
+			assert.Equal(t, i == len(ni.Blocks)-1, block.EOF, "only the last block is the EOF")
+
+			// Each block must start with a header for its own namespace.
+			parser := Parser{
+				In: io.NewSectionReader(bytes.NewReader(archiveBytes), block.Offset, block.Length),
+			}
+			consumer := &headerRecorder{}
+			require.NoError(t, parser.ReadBlock(consumer))
+			require.Len(t, consumer.headers, 1)
+			assert.Equal(t, ni.Database, consumer.headers[0].Database)
+			assert.Equal(t, ni.Collection, consumer.headers[0].Collection)
+			assert.Equal(t, block.EOF, consumer.headers[0].EOF)
+			assert.ErrorIs(t, parser.ReadBlock(consumer), io.EOF, "the block is read completely")
+		}
+	}
+
+	t.Run("archive without an index", func(t *testing.T) {
+		unindexed := buildSingleIntentArchive(t, testIntents[0]).Bytes()
+		_, err := ReadIndex(bytes.NewReader(unindexed), int64(len(unindexed)))
+		require.ErrorContains(t, err, "does not end with an index trailer")
+	})
+}
+
+func TestIndexedArchiveSequentialRead(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	archiveBytes, inChecksum := buildIndexedArchive(t)
+	in := bytes.NewReader(archiveBytes)
+
+	prelude := &Prelude{}
+	require.NoError(t, prelude.Read(in))
+	assert.Equal(t, IndexedFormatVersion, prelude.Header.FormatVersion)
+
+	// Without seeking, the parser must stop when it reaches the index.
+	demux := &Demultiplexer{In: in, NamespaceStatus: make(map[string]int)}
+	outChecksum := map[string]hash.Hash{}
+	errChan := make(chan error)
+	makeOuts(
+		t, testIntents, demux, outChecksum,
+		map[string]*RegularCollectionReceiver{}, map[string]*int{}, errChan,
+	)
+
+	require.NoError(t, demux.Run())
+	for range testIntents {
+		require.NoError(t, <-errChan)
+	}
+	for _, intent := range testIntents {
+		ns := intent.Namespace()
+		assert.Equal(t, inChecksum[ns].Sum(nil), outChecksum[ns].Sum(nil))
+	}
+}
+
+func TestDemuxUseIndex(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	archiveBytes, inChecksum := buildIndexedArchive(t)
+	recorder := &readRecorder{ReaderAt: bytes.NewReader(archiveBytes)}
+	index, err := ReadIndex(recorder, int64(len(archiveBytes)))
+	require.NoError(t, err)
+	recorder.reads = nil
+
+	restored, muted := testIntents[:2], testIntents[2:]
+	demux := &Demultiplexer{NamespaceStatus: make(map[string]int)}
+	for _, intent := range testIntents {
+		demux.NamespaceStatus[intent.Namespace()] = NamespaceUnopened
+	}
+	for _, intent := range muted {
+		demux.Open(intent.Namespace(), &MutedCollection{Intent: intent, Demux: demux})
+	}
+	outChecksum := map[string]hash.Hash{}
+	errChan := make(chan error)
+	makeOuts(
+		t, restored, demux, outChecksum,
+		map[string]*RegularCollectionReceiver{}, map[string]*int{}, errChan,
+	)
+
+	demux.UseIndex(recorder, index)
+	require.NoError(t, demux.Run())
+	for range restored {
+		require.NoError(t, <-errChan)
+	}
+	for _, intent := range restored {
+		ns := intent.Namespace()
+		assert.Equal(t, inChecksum[ns].Sum(nil), outChecksum[ns].Sum(nil))
+	}
+
+	// None of the blocks of the muted namespaces may have been read.
+	for _, ni := range index.Namespaces {
+		isMuted := false
+		for _, intent := range muted {
+			isMuted = isMuted || intent.Namespace() == ni.Namespace()
+		}
+		if !isMuted {
+			continue
+		}
+		for _, block := range ni.Blocks {
+			for _, read := range recorder.reads {
+				overlaps := read.Offset < block.Offset+block.Length &&
+					block.Offset < read.Offset+read.Length
+				assert.False(t, overlaps, "block of muted namespace %#q was read", ni.Namespace())
+			}
+		}
+	}
+}
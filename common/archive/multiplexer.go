@@ -36,6 +36,8 @@ type Multiplexer struct {
 	ins              []*MuxIn
 	selectCases      []reflect.SelectCase
 	currentNamespace string
+	// index is set by EnableIndex, and records where each block is written
+	index *indexBuilder
 }
 
 type notifier interface {
@@ -75,6 +77,9 @@ func (mux *Multiplexer) Run() {
 		if index == 0 { //Control index
 			if EOF {
 				log.Logvf(log.DebugLow, "Mux finish")
+				if mux.index != nil && completionErr == nil {
+					completionErr = mux.writeIndex()
+				}
 				mux.Out.Close()
 				if completionErr != nil {
 					mux.Completed <- completionErr
@@ -164,6 +169,9 @@ func (mux *Multiplexer) formatBody(in *MuxIn, bsonBytes []byte) error {
 				return io.ErrShortWrite
 			}
 		}
+		if mux.index != nil {
+			mux.index.startBlock(in.Intent.DB, in.Intent.DataCollection(), false)
+		}
 		header, err := bson.Marshal(NamespaceHeader{
 			Database:   in.Intent.DB,
 			Collection: in.Intent.DataCollection(),
@@ -199,6 +207,9 @@ func (mux *Multiplexer) formatEOF(in *MuxIn) error {
 			return io.ErrShortWrite
 		}
 	}
+	if mux.index != nil {
+		mux.index.startBlock(in.Intent.DB, in.Intent.DataCollection(), true)
+	}
 	eofHeader, err := bson.Marshal(NamespaceHeader{
 		Database:   in.Intent.DB,
 		Collection: in.Intent.DataCollection(),
@@ -133,7 +133,8 @@ func (parse *Parser) ReadAllBlocks(consumer ParserConsumer) (err error) {
 // ReadBlock reads one archive block ( header + body* + terminator )
 // calling consumer.HeaderBSON() on the header, consumer.BodyBSON() on each piece of body,
 // and consumer.EOF() when EOF is encountered before any data was read.
-// It returns nil if a whole block was read, io.EOF if nothing was read,
+// The index block that ends an indexed archive is not passed to the consumer.
+// It returns nil if a whole block was read, io.EOF if nothing or the index was read,
 // and a parserError if there was any io error in the middle of the block,
 // if either of the consumer methods return error, or if there was any sort of
 // parsing failure.
@@ -145,6 +146,11 @@ func (parse *Parser) ReadBlock(consumer ParserConsumer) (err error) {
 	if isTerminator {
 		return newParserError("consecutive terminators / headerless blocks are not allowed")
 	}
+	if isIndexHeader(parse.buf[:parse.length]) {
+		// Everything after the index header is the index and its trailer,
+		// which are only read when seeking through the archive.
+		return io.EOF
+	}
 	err = consumer.HeaderBSON(parse.buf[:parse.length])
 	if err != nil {
 		return newParserWrappedError("ParserConsumer.HeaderBSON()", err)
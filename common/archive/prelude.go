@@ -117,16 +117,18 @@ func NewPrelude(
 				return nil, fmt.Errorf("MetadataFile is not an archive.Metadata")
 			}
 			prelude.AddMetadata(&CollectionMetadata{
-				Database:   intent.DB,
-				Collection: intent.C,
-				Metadata:   archiveMetadata.String(),
-				Type:       intent.Type,
+				Database:    intent.DB,
+				Collection:  intent.C,
+				Metadata:    archiveMetadata.String(),
+				Type:        intent.Type,
+				ViewSources: intent.ViewSources(),
 			})
 		} else {
 			prelude.AddMetadata(&CollectionMetadata{
-				Database:   intent.DB,
-				Collection: intent.C,
-				Type:       intent.Type,
+				Database:    intent.DB,
+				Collection:  intent.C,
+				Type:        intent.Type,
+				ViewSources: intent.ViewSources(),
 			})
 		}
 	}
@@ -150,6 +152,23 @@ func (prelude *Prelude) AddMetadata(cm *CollectionMetadata) {
 	log.Logvf(log.Info, "archive prelude %#q", cm.Database+"."+cm.Collection)
 }
 
+// ViewDependencies returns the namespaces that each view of the archive reads
+// from, as mongodump recorded them, by the namespace of the view. Archives of
+// older versions of mongodump don't record them.
+func (prelude *Prelude) ViewDependencies() map[string][]string {
+	var dependencies map[string][]string
+	for _, cm := range prelude.NamespaceMetadatas {
+		if len(cm.ViewSources) == 0 {
+			continue
+		}
+		if dependencies == nil {
+			dependencies = map[string][]string{}
+		}
+		dependencies[cm.Database+"."+cm.Collection] = cm.ViewSources
+	}
+	return dependencies
+}
+
 // Write writes the archive header.
 func (prelude *Prelude) Write(out io.Writer) error {
 	magicNumberBytes := binary.LittleEndian.AppendUint32(nil, MagicNumber)
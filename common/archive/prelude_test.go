@@ -24,9 +24,11 @@ func TestPreludeRoundtrip(t *testing.T) {
 		Metadata:   "m1",
 	}
 	cm2 := &CollectionMetadata{
-		Database:   "db1",
-		Collection: "c2",
-		Metadata:   "m2",
+		Database:    "db1",
+		Collection:  "c2",
+		Metadata:    "m2",
+		Type:        "view",
+		ViewSources: []string{"db1.c1", "db2.c3"},
 	}
 	cm3 := &CollectionMetadata{
 		Database:   "db2",
@@ -60,4 +62,9 @@ func TestPreludeRoundtrip(t *testing.T) {
 	err = archivePrelude2.Read(buf)
 	require.NoError(t, err)
 	assert.Equal(t, archivePrelude2, archivePrelude)
+	assert.Equal(
+		t,
+		map[string][]string{"db1.c2": {"db1.c1", "db2.c3"}},
+		archivePrelude2.ViewDependencies(),
+	)
 }
@@ -0,0 +1,173 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+// Package compression implements the codecs that dump files and archives can be
+// compressed with.
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Writer is a compressing writer that can be reset to write a new stream to a
+// different destination. Close flushes the stream, but does not close the
+// destination.
+type Writer interface {
+	io.WriteCloser
+	Reset(io.Writer)
+}
+
+// Codec is a compression format. A nil *Codec means no compression.
+type Codec struct {
+	// Name selects the codec with --compress, and is recorded in archive headers.
+	Name string
+	// Extension is appended to the names of files compressed with the codec.
+	Extension string
+
+	magic     []byte
+	newWriter func(io.Writer) Writer
+	newReader func(io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	// Gzip is the codec used by --gzip.
+	Gzip = &Codec{
+		Name:      "gzip",
+		Extension: ".gz",
+		magic:     []byte{0x1f, 0x8b},
+		newWriter: func(w io.Writer) Writer {
+			return gzip.NewWriter(w)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	}
+
+	// Zstd compresses with Zstandard, which is much faster than gzip at a similar ratio.
+	Zstd = &Codec{
+		Name:      "zstd",
+		Extension: ".zst",
+		magic:     []byte{0x28, 0xb5, 0x2f, 0xfd},
+		newWriter: func(w io.Writer) Writer {
+			// NewWriter only fails on invalid options, and none are given.
+			encoder, _ := zstd.NewWriter(w)
+			return encoder
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			decoder, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return decoder.IOReadCloser(), nil
+		},
+	}
+
+	// Snappy writes the snappy framing format, which trades ratio for speed.
+	Snappy = &Codec{
+		Name:      "snappy",
+		Extension: ".sz",
+		magic:     []byte("\xff\x06\x00\x00sNaPpY"),
+		newWriter: func(w io.Writer) Writer {
+			return snappy.NewBufferedWriter(w)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(snappy.NewReader(r)), nil
+		},
+	}
+)
+
+// Codecs lists every supported codec.
+var Codecs = []*Codec{Gzip, Zstd, Snappy}
+
+// Names returns the names of every supported codec, for use in messages.
+func Names() string {
+	names := make([]string, len(Codecs))
+	for i, codec := range Codecs {
+		names[i] = codec.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// ByName returns the codec with the given name. The empty string and "none"
+// return a nil codec.
+func ByName(name string) (*Codec, error) {
+	if name == "" || name == "none" {
+		return nil, nil
+	}
+	for _, codec := range Codecs {
+		if codec.Name == name {
+			return codec, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown compression codec %#q, must be one of: %v", name, Names())
+}
+
+// ByExtension returns the codec whose extension the given file name ends with,
+// or nil if the name has no codec extension.
+func ByExtension(name string) *Codec {
+	for _, codec := range Codecs {
+		if strings.HasSuffix(name, codec.Extension) {
+			return codec
+		}
+	}
+	return nil
+}
+
+// Detect peeks at the beginning of a stream and returns the codec it was
+// compressed with, or nil if it does not start with any codec's magic number.
+func Detect(r *bufio.Reader) (*Codec, error) {
+	for _, codec := range Codecs {
+		prefix, err := r.Peek(len(codec.magic))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if bytes.Equal(prefix, codec.magic) {
+			return codec, nil
+		}
+	}
+	return nil, nil
+}
+
+// String returns the name of the codec, or "none" for a nil codec.
+func (codec *Codec) String() string {
+	if codec == nil {
+		return "none"
+	}
+	return codec.Name
+}
+
+// FileName appends the codec's extension to name. A nil codec returns name
+// unchanged.
+func (codec *Codec) FileName(name string) string {
+	if codec == nil {
+		return name
+	}
+	return name + codec.Extension
+}
+
+// NewWriter returns a Writer that compresses to w. The writer must be closed to
+// flush the end of the stream. w may be nil if the writer is Reset before use.
+func (codec *Codec) NewWriter(w io.Writer) Writer {
+	return codec.newWriter(w)
+}
+
+// NewReader returns a reader that decompresses r. Closing it does not close r.
+func (codec *Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	rc, err := codec.newReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %v stream: %w", codec.Name, err)
+	}
+	return rc, nil
+}
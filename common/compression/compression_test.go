@@ -0,0 +1,88 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	content := bytes.Repeat([]byte("mongodump compression round trip "), 10000)
+
+	for _, codec := range Codecs {
+		t.Run(codec.Name, func(t *testing.T) {
+			// Write two streams with the same writer to exercise Reset.
+			writer := codec.NewWriter(nil)
+			for range 2 {
+				var compressed bytes.Buffer
+				writer.Reset(&compressed)
+				_, err := writer.Write(content)
+				require.NoError(t, err)
+				require.NoError(t, writer.Close())
+				assert.Less(t, compressed.Len(), len(content))
+
+				buffered := bufio.NewReader(&compressed)
+				detected, err := Detect(buffered)
+				require.NoError(t, err)
+				assert.Equal(t, codec, detected)
+
+				reader, err := codec.NewReader(buffered)
+				require.NoError(t, err)
+				decompressed, err := io.ReadAll(reader)
+				require.NoError(t, err)
+				require.NoError(t, reader.Close())
+				assert.Equal(t, content, decompressed)
+			}
+		})
+	}
+}
+
+func TestDetectUncompressed(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	detected, err := Detect(bufio.NewReader(bytes.NewReader([]byte{0x6d, 0xe2, 0x99, 0x81})))
+	require.NoError(t, err)
+	assert.Nil(t, detected)
+
+	detected, err = Detect(bufio.NewReader(bytes.NewReader(nil)))
+	require.NoError(t, err)
+	assert.Nil(t, detected)
+}
+
+func TestCodecLookup(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	for _, name := range []string{"", "none"} {
+		codec, err := ByName(name)
+		require.NoError(t, err)
+		assert.Nil(t, codec)
+	}
+	codec, err := ByName("zstd")
+	require.NoError(t, err)
+	assert.Equal(t, Zstd, codec)
+	_, err = ByName("lz4")
+	assert.ErrorContains(t, err, "unknown compression codec")
+
+	assert.Equal(t, Gzip, ByExtension("foo.bson.gz"))
+	assert.Equal(t, Zstd, ByExtension("foo.metadata.json.zst"))
+	assert.Equal(t, Snappy, ByExtension("archive.sz"))
+	assert.Nil(t, ByExtension("foo.bson"))
+
+	var none *Codec
+	assert.Equal(t, "foo.bson", none.FileName("foo.bson"))
+	assert.Equal(t, "foo.bson.zst", Zstd.FileName("foo.bson"))
+	assert.Equal(t, "none", none.String())
+}
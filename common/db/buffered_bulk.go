@@ -134,6 +134,12 @@ func (bb *BufferedBulkInserter) ResetBulk() {
 	bb.byteCount = 0
 }
 
+// BufferedCount returns the number of documents that are buffered and have not
+// been written yet.
+func (bb *BufferedBulkInserter) BufferedCount() int {
+	return bb.docCount
+}
+
 // Insert adds a document to the buffer for bulk insertion. If the buffer becomes full, the bulk write is performed, returning
 // any error that occurs.
 func (bb *BufferedBulkInserter) Insert(
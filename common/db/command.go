@@ -242,3 +242,52 @@ func ApplyFlags(opts *mopt.FindOneOptionsBuilder, flags int) {
 		opts.SetOplogReplay(true)
 	}
 }
+
+// CommandRunner runs a command on a database and returns its reply. Tests
+// stub it to reply to commands without a server.
+type CommandRunner func(ctx context.Context, dbName string, command bson.D) (bson.Raw, error)
+
+// RunCommand runs command on the dbName database and returns its reply. It's
+// the CommandRunner of a SessionProvider.
+func (sp *SessionProvider) RunCommand(
+	ctx context.Context,
+	dbName string,
+	command bson.D,
+) (bson.Raw, error) {
+	return sp.DB(dbName).RunCommand(ctx, command).Raw()
+}
+
+// RunCursorCommand runs a command that replies with a cursor, such as find
+// or aggregate, on a collection and returns all of the documents of the
+// cursor, which it iterates with getMore.
+func RunCursorCommand(
+	ctx context.Context,
+	run CommandRunner,
+	dbName, collName string,
+	command bson.D,
+) ([]bson.Raw, error) {
+	var docs []bson.Raw
+	for {
+		reply, err := run(ctx, dbName, command)
+		if err != nil {
+			return nil, err
+		}
+		var batch struct {
+			Cursor struct {
+				ID         int64      `bson:"id"`
+				FirstBatch []bson.Raw `bson:"firstBatch"`
+				NextBatch  []bson.Raw `bson:"nextBatch"`
+			} `bson:"cursor"`
+		}
+		err = bson.Unmarshal(reply, &batch)
+		if err != nil {
+			return nil, fmt.Errorf("error reading cursor: %v", err)
+		}
+		docs = append(docs, batch.Cursor.FirstBatch...)
+		docs = append(docs, batch.Cursor.NextBatch...)
+		if batch.Cursor.ID == 0 {
+			return docs, nil
+		}
+		command = bson.D{{"getMore", batch.Cursor.ID}, {"collection", collName}}
+	}
+}
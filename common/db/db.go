@@ -64,6 +64,8 @@ const (
 	ErrFailedDocumentValidation = 121
 	ErrUnacknowledgedWrite      = "unacknowledged write"
 
+	ErrIndexNotFound = 27
+
 	// ErrCannotInsertTimeseriesBucketsWithMixedSchema can be handled by turning TimeseriesBucketsWithMixedSchema off.
 	ErrCannotInsertTimeseriesBucketsWithMixedSchema = 408
 )
@@ -12,9 +12,15 @@ import (
 
 // DeferredQuery represents a deferred query.
 type DeferredQuery struct {
-	Coll      *mongo.Collection
-	Filter    any
-	Hint      any
+	Coll   *mongo.Collection
+	Filter any
+	Hint   any
+	Sort   any
+	// Projection selects the fields of the documents the query returns
+	Projection any
+	// Min and Max are the index bounds of the query, which require a Hint
+	Min       any
+	Max       any
 	LogReplay bool
 }
 
@@ -52,6 +58,18 @@ func (q *DeferredQuery) Iter(version Version) (*mongo.Cursor, error) {
 	if q.Hint != nil {
 		opts.SetHint(q.Hint)
 	}
+	if q.Sort != nil {
+		opts.SetSort(q.Sort)
+	}
+	if q.Projection != nil {
+		opts.SetProjection(q.Projection)
+	}
+	if q.Min != nil {
+		opts.SetMin(q.Min)
+	}
+	if q.Max != nil {
+		opts.SetMax(q.Max)
+	}
 	if q.LogReplay {
 		opts.SetOplogReplay(true)
 	}
@@ -0,0 +1,100 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// SearchIndex is the definition of an Atlas Search or vector search index,
+// as createSearchIndexes takes it.
+type SearchIndex struct {
+	Name string `bson:"name"`
+	// Type is "search" or "vectorSearch", where the server treats an empty
+	// type as "search".
+	Type       string `bson:"type,omitempty"`
+	Definition bson.D `bson:"definition"`
+}
+
+// searchUnsupportedCodes are the codes of the errors that servers without
+// search indexes reply with to their commands and aggregation stage.
+var searchUnsupportedCodes = []int{
+	59,    // CommandNotFound
+	115,   // CommandNotSupported
+	31082, // SearchNotEnabled
+	40324, // unrecognized pipeline stage name
+}
+
+// IsSearchUnsupported returns whether err is the error of a server that
+// doesn't support search indexes.
+func IsSearchUnsupported(err error) bool {
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	for _, code := range searchUnsupportedCodes {
+		if serverErr.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListSearchIndexes returns the definitions of the search and vector search
+// indexes of a collection, as $listSearchIndexes reports them.
+func ListSearchIndexes(
+	ctx context.Context,
+	run CommandRunner,
+	dbName, collName string,
+) ([]SearchIndex, error) {
+	docs, err := RunCursorCommand(ctx, run, dbName, collName, bson.D{
+		{"aggregate", collName},
+		{"pipeline", bson.A{bson.D{{"$listSearchIndexes", bson.D{}}}}},
+		{"cursor", bson.D{}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var indexes []SearchIndex
+	for _, doc := range docs {
+		var listed struct {
+			Name             string `bson:"name"`
+			Type             string `bson:"type"`
+			LatestDefinition bson.D `bson:"latestDefinition"`
+		}
+		err = bson.Unmarshal(doc, &listed)
+		if err != nil {
+			return nil, fmt.Errorf("error reading search index: %v", err)
+		}
+		indexes = append(indexes, SearchIndex{
+			Name:       listed.Name,
+			Type:       listed.Type,
+			Definition: listed.LatestDefinition,
+		})
+	}
+	return indexes, nil
+}
+
+// CreateSearchIndexes creates search and vector search indexes on a
+// collection with createSearchIndexes.
+func CreateSearchIndexes(
+	ctx context.Context,
+	run CommandRunner,
+	dbName, collName string,
+	indexes []SearchIndex,
+) error {
+	_, err := run(ctx, dbName, bson.D{
+		{"createSearchIndexes", collName},
+		{"indexes", indexes},
+	})
+	return err
+}
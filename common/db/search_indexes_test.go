@@ -0,0 +1,124 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// stubCommandRunner responds to the commands it's given with the replies of
+// replies in order, and records the commands.
+type stubCommandRunner struct {
+	commands []bson.D
+	replies  []bson.D
+	err      error
+}
+
+func (stub *stubCommandRunner) run(
+	_ context.Context,
+	dbName string,
+	command bson.D,
+) (bson.Raw, error) {
+	stub.commands = append(stub.commands, append(bson.D{{"$db", dbName}}, command...))
+	if stub.err != nil {
+		return nil, stub.err
+	}
+	if len(stub.replies) == 0 {
+		return nil, fmt.Errorf("unexpected command %v", command)
+	}
+	reply := stub.replies[0]
+	stub.replies = stub.replies[1:]
+	return bson.Marshal(reply)
+}
+
+func TestListSearchIndexes(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	definition := bson.D{{"mappings", bson.D{{"dynamic", true}}}}
+	vectorDefinition := bson.D{{"fields", bson.A{bson.D{
+		{"type", "vector"},
+		{"path", "embedding"},
+		{"numDimensions", int32(3)},
+		{"similarity", "cosine"},
+	}}}}
+	stub := &stubCommandRunner{replies: []bson.D{
+		{{"cursor", bson.D{
+			{"id", int64(42)},
+			{"firstBatch", bson.A{bson.D{
+				{"id", "1"},
+				{"name", "default"},
+				{"status", "READY"},
+				{"latestDefinition", definition},
+			}}},
+		}}, {"ok", 1}},
+		{{"cursor", bson.D{
+			{"id", int64(0)},
+			{"nextBatch", bson.A{bson.D{
+				{"id", "2"},
+				{"name", "vectors"},
+				{"type", "vectorSearch"},
+				{"latestDefinition", vectorDefinition},
+			}}},
+		}}, {"ok", 1}},
+	}}
+
+	indexes, err := ListSearchIndexes(context.Background(), stub.run, "app", "movies")
+	require.NoError(t, err)
+	assert.Equal(t, []SearchIndex{
+		{Name: "default", Definition: definition},
+		{Name: "vectors", Type: "vectorSearch", Definition: vectorDefinition},
+	}, indexes)
+	require.Len(t, stub.commands, 2)
+	assert.Equal(t, bson.D{
+		{"$db", "app"},
+		{"aggregate", "movies"},
+		{"pipeline", bson.A{bson.D{{"$listSearchIndexes", bson.D{}}}}},
+		{"cursor", bson.D{}},
+	}, stub.commands[0])
+	assert.Equal(t, bson.D{
+		{"$db", "app"},
+		{"getMore", int64(42)},
+		{"collection", "movies"},
+	}, stub.commands[1])
+
+	stub = &stubCommandRunner{replies: []bson.D{{{"ok", 1}}}}
+	err = CreateSearchIndexes(context.Background(), stub.run, "app", "movies", indexes)
+	require.NoError(t, err)
+	commandBytes, err := bson.Marshal(stub.commands[0])
+	require.NoError(t, err)
+	var command bson.D
+	require.NoError(t, bson.Unmarshal(commandBytes, &command))
+	assert.Equal(t, bson.D{
+		{"$db", "app"},
+		{"createSearchIndexes", "movies"},
+		{"indexes", bson.A{
+			bson.D{{"name", "default"}, {"definition", definition}},
+			bson.D{{"name", "vectors"}, {"type", "vectorSearch"}, {"definition", vectorDefinition}},
+		}},
+	}, command, "an index without a type is created as a search index")
+}
+
+func TestIsSearchUnsupported(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	assert.True(t, IsSearchUnsupported(mongo.CommandError{Code: 31082, Name: "SearchNotEnabled"}))
+	assert.True(t, IsSearchUnsupported(
+		fmt.Errorf("listing: %w", mongo.CommandError{Code: 40324}),
+	))
+	assert.False(t, IsSearchUnsupported(mongo.CommandError{Code: 13, Name: "Unauthorized"}))
+	assert.False(t, IsSearchUnsupported(errors.New("connection refused")))
+	assert.False(t, IsSearchUnsupported(nil))
+}
@@ -0,0 +1,69 @@
+package dumprestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// ClusterManifestFileName is the name of the manifest that mongodump
+// --shardedOplog writes to the top of the dump of a sharded cluster.
+const ClusterManifestFileName = "cluster.json"
+
+// ClusterManifest describes a consistent dump of a sharded cluster, which is
+// made of a dump of the config server and of each shard in directories of
+// their own. The oplog of every part ends at ClusterTime, so restoring each
+// part and replaying its oplog brings the whole cluster to that time.
+type ClusterManifest struct {
+	ToolVersion  string         `json:"ToolVersion"`
+	ClusterTime  bson.Timestamp `json:"ClusterTime"`
+	ConfigServer ClusterPart    `json:"ConfigServer"`
+	Shards       []ClusterPart  `json:"Shards"`
+}
+
+// ClusterPart is the dump of one replica set of a sharded cluster. Directory is
+// relative to the directory of the manifest.
+type ClusterPart struct {
+	Name       string         `json:"Name"`
+	Host       string         `json:"Host"`
+	Directory  string         `json:"Directory"`
+	OplogStart bson.Timestamp `json:"OplogStart"`
+}
+
+// Parts returns the config server followed by the shards, in the order they
+// are restored.
+func (manifest *ClusterManifest) Parts() []ClusterPart {
+	return append([]ClusterPart{manifest.ConfigServer}, manifest.Shards...)
+}
+
+// ReadClusterManifest reads the cluster manifest at path.
+func ReadClusterManifest(path string) (*ClusterManifest, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &ClusterManifest{}
+	err = json.Unmarshal(content, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing cluster manifest %#q: %v", path, err)
+	}
+	if manifest.ClusterTime.IsZero() {
+		return nil, fmt.Errorf("cluster manifest %#q does not contain a cluster time", path)
+	}
+	return manifest, nil
+}
+
+// Write saves the cluster manifest to path.
+func (manifest *ClusterManifest) Write(path string) error {
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling cluster manifest: %w", err)
+	}
+	err = os.WriteFile(path, append(content, '\n'), 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write cluster manifest to file %#q: %w", path, err)
+	}
+	return nil
+}
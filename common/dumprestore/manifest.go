@@ -0,0 +1,128 @@
+package dumprestore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// ManifestFileName is the name of the manifest that mongodump writes next to
+// prelude.json in a dump directory.
+const ManifestFileName = "manifest.json"
+
+// Manifest types of file.
+const (
+	ManifestBSONFile     = "bson"
+	ManifestMetadataFile = "metadata"
+)
+
+// Manifest lists every file that mongodump wrote to a dump directory, so that
+// missing, truncated or corrupted files can be found before restoring. It is
+// never compressed or encrypted, and the checksums are of the files as they are
+// on disk, so a dump can be checked without its key.
+type Manifest struct {
+	ServerVersion string          `json:"ServerVersion"`
+	ToolVersion   string          `json:"ToolVersion"`
+	OplogStart    *bson.Timestamp `json:"OplogStart,omitempty"`
+	OplogEnd      *bson.Timestamp `json:"OplogEnd,omitempty"`
+	Files         []ManifestFile  `json:"Files"`
+}
+
+// ManifestFile is the entry of a single file in a Manifest. Path is relative
+// to the directory of the manifest, with forward slashes.
+type ManifestFile struct {
+	Path      string `json:"Path"`
+	Namespace string `json:"Namespace"`
+	Type      string `json:"Type"`
+	Size      int64  `json:"Size"`
+	SHA256    string `json:"SHA256"`
+	Documents int64  `json:"Documents"`
+	UUID      string `json:"UUID,omitempty"`
+}
+
+// ReadManifest reads the manifest at path.
+func ReadManifest(path string) (*Manifest, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := ParseManifest(content)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing manifest %#q: %v", path, err)
+	}
+	return manifest, nil
+}
+
+// ParseManifest parses the content of a manifest.
+func ParseManifest(content []byte) (*Manifest, error) {
+	manifest := &Manifest{}
+	err := json.Unmarshal(content, manifest)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// ChecksumWriter passes writes through to a file while computing the size and
+// SHA-256 of what was written.
+type ChecksumWriter struct {
+	io.WriteCloser
+	hash hash.Hash
+	size int64
+}
+
+// NewChecksumWriter returns a ChecksumWriter that writes to w.
+func NewChecksumWriter(w io.WriteCloser) *ChecksumWriter {
+	return &ChecksumWriter{WriteCloser: w, hash: sha256.New()}
+}
+
+func (cw *ChecksumWriter) Write(p []byte) (int, error) {
+	n, err := cw.WriteCloser.Write(p)
+	cw.hash.Write(p[:n])
+	cw.size += int64(n)
+	return n, err
+}
+
+// Include adds what r reads to the size and checksum without writing it, for a
+// file that is appended to.
+func (cw *ChecksumWriter) Include(r io.Reader) error {
+	n, err := io.Copy(cw.hash, r)
+	cw.size += n
+	return err
+}
+
+// Size returns the number of bytes written.
+func (cw *ChecksumWriter) Size() int64 {
+	return cw.size
+}
+
+// Sum returns the SHA-256 of the bytes written, hex encoded.
+func (cw *ChecksumWriter) Sum() string {
+	return hex.EncodeToString(cw.hash.Sum(nil))
+}
+
+// ChecksumFile returns the size and the hex encoded SHA-256 of the file at path.
+func ChecksumFile(path string) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+	return Checksum(file)
+}
+
+// Checksum returns the size and the hex encoded SHA-256 of what r reads.
+func Checksum(r io.Reader) (int64, string, error) {
+	h := sha256.New()
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,28 @@
+package dumprestore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PartitionFileName returns the name of the file of partition n of a collection
+// whose first partition is in bsonFile, e.g. "foo.bson.2" for "foo.bson". Any
+// compression extension is appended after the partition number.
+func PartitionFileName(bsonFile string, n int) string {
+	return fmt.Sprintf("%s.%d", bsonFile, n)
+}
+
+// ParsePartitionFileName returns the name of the file of the first partition
+// and the partition number of a file named by PartitionFileName.
+func ParsePartitionFileName(name string) (string, int, bool) {
+	i := strings.LastIndexByte(name, '.')
+	if i < 0 || !strings.HasSuffix(name[:i], ".bson") {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(name[i+1:])
+	if err != nil || n < 1 || name[i+1] == '+' || name[i+1] == '0' {
+		return "", 0, false
+	}
+	return name[:i], n, true
+}
@@ -0,0 +1,26 @@
+package dumprestore
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePartitionFileName(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	name := PartitionFileName("foo.bson", 3)
+	assert.Equal(t, "foo.bson.3", name)
+	bsonFile, n, ok := ParsePartitionFileName(name)
+	assert.True(t, ok)
+	assert.Equal(t, "foo.bson", bsonFile)
+	assert.Equal(t, 3, n)
+
+	for _, name := range []string{
+		"foo.bson", "foo.bson.0", "foo.bson.01", "foo.bson.+1", "foo.bson.x", "foo.json.1", "foo.bson.",
+	} {
+		_, _, ok := ParsePartitionFileName(name)
+		assert.False(t, ok, name)
+	}
+}
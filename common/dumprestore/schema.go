@@ -0,0 +1,212 @@
+package dumprestore
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// SchemaFileExtension is the extension of the schema summary that mongodump
+// writes next to the metadata file of a collection with --schemaSummary.
+const SchemaFileExtension = ".schema.json"
+
+// SchemaArrayElement is the last component of the path of the elements of an
+// array, so that the elements of "tags" are summarized as "tags.[]".
+const SchemaArrayElement = "[]"
+
+// schemaTypeNames are the names of BSON types, as they're given to $type.
+var schemaTypeNames = map[bson.Type]string{
+	bson.TypeDouble:           "double",
+	bson.TypeString:           "string",
+	bson.TypeEmbeddedDocument: "object",
+	bson.TypeArray:            "array",
+	bson.TypeBinary:           "binData",
+	bson.TypeUndefined:        "undefined",
+	bson.TypeObjectID:         "objectId",
+	bson.TypeBoolean:          "bool",
+	bson.TypeDateTime:         "date",
+	bson.TypeNull:             "null",
+	bson.TypeRegex:            "regex",
+	bson.TypeDBPointer:        "dbPointer",
+	bson.TypeJavaScript:       "javascript",
+	bson.TypeSymbol:           "symbol",
+	bson.TypeCodeWithScope:    "javascriptWithScope",
+	bson.TypeInt32:            "int",
+	bson.TypeTimestamp:        "timestamp",
+	bson.TypeInt64:            "long",
+	bson.TypeDecimal128:       "decimal",
+	bson.TypeMinKey:           "minKey",
+	bson.TypeMaxKey:           "maxKey",
+}
+
+// Schema summarizes the fields of a sample of the documents of a collection.
+type Schema struct {
+	// Documents is the number of documents that were sampled.
+	Documents int64 `json:"documents"`
+	// MaxDepth is the deepest nesting of documents and arrays, where a
+	// document without any is of depth 1.
+	MaxDepth int            `json:"maxDepth"`
+	Fields   []*SchemaField `json:"fields"`
+}
+
+// Marshal returns the schema as indented JSON, as it's written to a
+// .schema.json file.
+func (schema *Schema) Marshal() ([]byte, error) {
+	content, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(content, '\n'), nil
+}
+
+// SchemaField summarizes a single field of a Schema, by its dotted path.
+type SchemaField struct {
+	Path string `json:"path"`
+	// Presence is the fraction of the sampled documents that have the field.
+	Presence float64 `json:"presence"`
+	// Types counts the values of the field by the name of their BSON type.
+	Types map[string]int64 `json:"types"`
+	// MaxArrayLength is the length of the longest array value of the field.
+	MaxArrayLength int `json:"maxArrayLength,omitempty"`
+}
+
+// SchemaSampler builds a Schema from documents. It's safe for concurrent use,
+// so that the partitions of a collection can be sampled together.
+type SchemaSampler struct {
+	mutex     sync.Mutex
+	max       int64
+	documents int64
+	maxDepth  int
+	fields    map[string]*schemaFieldStats
+}
+
+type schemaFieldStats struct {
+	documents      int64
+	types          map[string]int64
+	maxArrayLength int
+}
+
+// NewSchemaSampler returns a SchemaSampler of the first max documents that
+// it's given, or of all of them if max isn't positive.
+func NewSchemaSampler(max int64) *SchemaSampler {
+	return &SchemaSampler{
+		max:    max,
+		fields: map[string]*schemaFieldStats{},
+	}
+}
+
+// Add samples doc, unless the sampler already has as many documents as it
+// samples. A nil SchemaSampler ignores every document.
+func (sampler *SchemaSampler) Add(doc bson.Raw) error {
+	if sampler == nil {
+		return nil
+	}
+	sampler.mutex.Lock()
+	defer sampler.mutex.Unlock()
+	if sampler.max > 0 && sampler.documents >= sampler.max {
+		return nil
+	}
+	// an invalid document is rejected before any of it is counted
+	if err := doc.Validate(); err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	err := sampler.addDocument(doc, "", 1, seen)
+	if err != nil {
+		return err
+	}
+	sampler.documents++
+	for path := range seen {
+		sampler.fields[path].documents++
+	}
+	return nil
+}
+
+func (sampler *SchemaSampler) addDocument(
+	doc bson.Raw,
+	prefix string,
+	depth int,
+	seen map[string]bool,
+) error {
+	sampler.maxDepth = max(sampler.maxDepth, depth)
+	elements, err := doc.Elements()
+	if err != nil {
+		return err
+	}
+	for _, element := range elements {
+		err = sampler.addValue(prefix+element.Key(), element.Value(), depth, seen)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sampler *SchemaSampler) addValue(
+	path string,
+	value bson.RawValue,
+	depth int,
+	seen map[string]bool,
+) error {
+	field, ok := sampler.fields[path]
+	if !ok {
+		field = &schemaFieldStats{types: map[string]int64{}}
+		sampler.fields[path] = field
+	}
+	seen[path] = true
+	typeName, ok := schemaTypeNames[value.Type]
+	if !ok {
+		typeName = value.Type.String()
+	}
+	field.types[typeName]++
+
+	switch value.Type {
+	case bson.TypeEmbeddedDocument:
+		return sampler.addDocument(value.Document(), path+".", depth+1, seen)
+	case bson.TypeArray:
+		sampler.maxDepth = max(sampler.maxDepth, depth+1)
+		values, err := value.Array().Values()
+		if err != nil {
+			return err
+		}
+		field.maxArrayLength = max(field.maxArrayLength, len(values))
+		for _, element := range values {
+			err = sampler.addValue(path+"."+SchemaArrayElement, element, depth+1, seen)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Schema returns the summary of the documents that were sampled, with its
+// fields sorted by path.
+func (sampler *SchemaSampler) Schema() *Schema {
+	sampler.mutex.Lock()
+	defer sampler.mutex.Unlock()
+	schema := &Schema{
+		Documents: sampler.documents,
+		MaxDepth:  sampler.maxDepth,
+		Fields:    []*SchemaField{},
+	}
+	for path, stats := range sampler.fields {
+		field := &SchemaField{
+			Path:           path,
+			Types:          maps.Clone(stats.types),
+			MaxArrayLength: stats.maxArrayLength,
+		}
+		if sampler.documents > 0 {
+			field.Presence = float64(stats.documents) / float64(sampler.documents)
+		}
+		schema.Fields = append(schema.Fields, field)
+	}
+	slices.SortFunc(schema.Fields, func(a, b *SchemaField) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return schema
+}
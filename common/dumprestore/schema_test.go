@@ -0,0 +1,49 @@
+package dumprestore
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestSchemaSampler(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	sampler := NewSchemaSampler(3)
+	for _, doc := range []bson.D{
+		{{"_id", 1}, {"name", "a"}, {"tags", bson.A{"x", "y", 3}}},
+		{{"_id", 2}, {"name", nil}, {"address", bson.D{{"city", "b"}, {"geo", bson.A{1.5, 2.5}}}}},
+		{{"_id", int64(3)}, {"tags", bson.A{}}},
+		{{"_id", 4}, {"ignored", true}},
+	} {
+		raw, err := bson.Marshal(doc)
+		require.NoError(t, err)
+		require.NoError(t, sampler.Add(raw))
+	}
+	require.Error(t, NewSchemaSampler(0).Add(bson.Raw{5, 0, 0, 0, 1}))
+
+	schema := sampler.Schema()
+	assert.Equal(t, int64(3), schema.Documents, "only the first 3 documents are sampled")
+	assert.Equal(t, 3, schema.MaxDepth)
+
+	fields := map[string]*SchemaField{}
+	var paths []string
+	for _, field := range schema.Fields {
+		fields[field.Path] = field
+		paths = append(paths, field.Path)
+	}
+	assert.Equal(t, []string{
+		"_id", "address", "address.city", "address.geo", "address.geo.[]", "name", "tags", "tags.[]",
+	}, paths)
+	assert.Equal(t, map[string]int64{"int": 2, "long": 1}, fields["_id"].Types)
+	assert.Equal(t, 1.0, fields["_id"].Presence)
+	assert.Equal(t, map[string]int64{"string": 1, "null": 1}, fields["name"].Types)
+	assert.InDelta(t, 2.0/3, fields["name"].Presence, 0.0001)
+	assert.Equal(t, 3, fields["tags"].MaxArrayLength)
+	assert.Equal(t, map[string]int64{"string": 2, "int": 1}, fields["tags.[]"].Types)
+	assert.InDelta(t, 1.0/3, fields["tags.[]"].Presence, 0.0001, "an empty array has no elements")
+	assert.Equal(t, map[string]int64{"double": 2}, fields["address.geo.[]"].Types)
+}
@@ -0,0 +1,58 @@
+package dumprestore
+
+import (
+	"slices"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// CollectionSettings are the collection options that collMod can change after
+// a collection is created. mongodump records them as the settings of the
+// collection in its metadata, and mongorestore applies them with collMod once
+// the documents of the collection are restored, so that documents that
+// predate a validator are restored and inserting them records no pre-images.
+var CollectionSettings = []string{
+	"validator",
+	"validationLevel",
+	"validationAction",
+	"changeStreamPreAndPostImages",
+}
+
+// PreImagesCollection is the collection of the config database that holds the
+// pre-images of the collections that change streams record them for.
+const PreImagesCollection = "system.preimages"
+
+// SplitCollectionSettings returns the options of a collection without its
+// CollectionSettings, and those settings. Neither shares memory with options.
+func SplitCollectionSettings(options bson.D) (bson.D, bson.D) {
+	var rest, settings bson.D
+	for _, elem := range options {
+		if slices.Contains(CollectionSettings, elem.Key) {
+			settings = append(settings, elem)
+		} else {
+			rest = append(rest, elem)
+		}
+	}
+	return rest, settings
+}
+
+// RecordsPreImages returns whether the options of a collection enable
+// changeStreamPreAndPostImages.
+func RecordsPreImages(options bson.D) bool {
+	for _, elem := range options {
+		if elem.Key != "changeStreamPreAndPostImages" {
+			continue
+		}
+		setting, ok := elem.Value.(bson.D)
+		if !ok {
+			return false
+		}
+		for _, field := range setting {
+			if field.Key == "enabled" {
+				enabled, _ := field.Value.(bool)
+				return enabled
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package dumprestore
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestSplitCollectionSettings(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	options := bson.D{
+		{"capped", false},
+		{"validator", bson.D{{"age", bson.D{{"$gte", 0}}}}},
+		{"validationLevel", "moderate"},
+		{"collation", bson.D{{"locale", "fr"}}},
+		{"changeStreamPreAndPostImages", bson.D{{"enabled", true}}},
+	}
+	rest, settings := SplitCollectionSettings(options)
+	assert.Equal(t, bson.D{{"capped", false}, {"collation", bson.D{{"locale", "fr"}}}}, rest)
+	assert.Equal(t, bson.D{
+		{"validator", bson.D{{"age", bson.D{{"$gte", 0}}}}},
+		{"validationLevel", "moderate"},
+		{"changeStreamPreAndPostImages", bson.D{{"enabled", true}}},
+	}, settings)
+	assert.Len(t, options, 5, "the options are left as they are")
+
+	rest, settings = SplitCollectionSettings(nil)
+	assert.Nil(t, rest)
+	assert.Nil(t, settings)
+}
+
+func TestRecordsPreImages(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	assert.True(t, RecordsPreImages(bson.D{
+		{"changeStreamPreAndPostImages", bson.D{{"enabled", true}}},
+	}))
+	assert.False(t, RecordsPreImages(bson.D{
+		{"changeStreamPreAndPostImages", bson.D{{"enabled", false}}},
+	}))
+	assert.False(t, RecordsPreImages(bson.D{{"validationLevel", "strict"}}))
+	assert.False(t, RecordsPreImages(nil))
+}
@@ -0,0 +1,401 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+// Package encryption implements the authenticated encryption of dump files and
+// archives.
+//
+// An encrypted stream starts with a header holding the stream format version,
+// the cipher, the ID of the key, and a random salt. The salt and the key derive
+// a key for the stream alone, so nonces never repeat across streams. The data
+// follows in chunks, each sealed with AES-256-GCM and prefixed with its length
+// and a flag marking the final chunk. The chunk number is the nonce and the
+// header and flag are authenticated with each chunk, so chunks can't be
+// reordered, dropped, or moved between streams, and a truncated stream is
+// detected.
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Cipher is the name of the cipher that streams are encrypted with.
+const Cipher = "AES-256-GCM"
+
+// KeySize is the size of an encryption key in bytes.
+const KeySize = 32
+
+// magic is found at the beginning of every encrypted stream.
+const magic = "\x89MTENC"
+
+const (
+	formatVersion = 1
+	cipherAESGCM  = 1
+
+	keyIDSize = 8
+	saltSize  = 16
+	// headerSize is the size of the stream header: the magic number, the
+	// format version, the cipher, the key ID, and the salt.
+	headerSize = len(magic) + 2 + keyIDSize + saltSize
+
+	chunkSize = 64 * 1024
+	// chunkHeaderSize is the size of the sealed chunk length, as a little endian
+	// uint32, and of the final chunk flag.
+	chunkHeaderSize = 4 + 1
+)
+
+// ErrKeyRequired is returned when an encrypted stream is read without a key.
+var ErrKeyRequired = errors.New(
+	"data is encrypted; an encryption key must be given with --encryptionKeyFile or --encryptionKeyEnv",
+)
+
+// ErrNotEncrypted is returned when a key is given to read a stream that is not encrypted.
+var ErrNotEncrypted = errors.New("data is not encrypted, but an encryption key was given")
+
+// Key is a secret key that streams are encrypted with.
+type Key struct {
+	secret []byte
+	id     []byte
+}
+
+// NewKey returns a Key for the given secret, which must be KeySize bytes long.
+func NewKey(secret []byte) (*Key, error) {
+	if len(secret) != KeySize {
+		return nil, fmt.Errorf(
+			"encryption key must be %v bytes long, but is %v bytes long",
+			KeySize,
+			len(secret),
+		)
+	}
+	return &Key{
+		secret: secret,
+		id:     derive(secret, []byte("key id"))[:keyIDSize],
+	}, nil
+}
+
+// LoadKey reads the key from keyFile, or from the environment variable named
+// keyEnv. A key file holds either the raw key or its base64 encoding, and the
+// environment variable holds its base64 encoding. It returns a nil Key if
+// neither is given.
+func LoadKey(keyFile, keyEnv string) (*Key, error) {
+	switch {
+	case keyFile != "" && keyEnv != "":
+		return nil, fmt.Errorf("--encryptionKeyFile can't be used with --encryptionKeyEnv")
+	case keyFile != "":
+		content, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading encryption key file: %v", err)
+		}
+		if len(content) == KeySize {
+			return NewKey(content)
+		}
+		key, err := decodeKey(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("error reading encryption key file %#q: %v", keyFile, err)
+		}
+		return key, nil
+	case keyEnv != "":
+		value, ok := os.LookupEnv(keyEnv)
+		if !ok {
+			return nil, fmt.Errorf("encryption key environment variable %#q is not set", keyEnv)
+		}
+		key, err := decodeKey(value)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"error reading encryption key from environment variable %#q: %v",
+				keyEnv,
+				err,
+			)
+		}
+		return key, nil
+	}
+	return nil, nil
+}
+
+func decodeKey(encoded string) (*Key, error) {
+	secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("key is not base64 encoded: %v", err)
+	}
+	return NewKey(secret)
+}
+
+// ID returns the ID of the key. It is derived from the key, and is recorded in
+// encrypted streams so that a wrong key is reported as such.
+func (key *Key) ID() string {
+	return hex.EncodeToString(key.id)
+}
+
+// derive returns a secret derived from the key secret for the given purpose.
+func derive(secret, purpose []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("mongo-tools encryption "))
+	mac.Write(purpose)
+	return mac.Sum(nil)
+}
+
+// newAEAD returns the AEAD for the stream with the given salt.
+func (key *Key) newAEAD(salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(derive(key.secret, append([]byte("stream "), salt...)))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// nonce returns the nonce of the given chunk.
+func nonce(aead cipher.AEAD, counter uint64) []byte {
+	n := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(n[len(n)-8:], counter)
+	return n
+}
+
+// additionalData returns the data authenticated with each chunk.
+func additionalData(header []byte, final bool) []byte {
+	ad := append([]byte{}, header...)
+	if final {
+		return append(ad, 1)
+	}
+	return append(ad, 0)
+}
+
+// Writer encrypts a stream. It can be reset to encrypt a new stream to a
+// different destination. Close writes the end of the stream, but does not close
+// the destination.
+type Writer struct {
+	key     *Key
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	started bool
+	counter uint64
+	buf     []byte
+	err     error
+}
+
+// NewWriter returns a Writer that encrypts to w. The writer must be closed to
+// write the end of the stream. w may be nil if the writer is Reset before use.
+func (key *Key) NewWriter(w io.Writer) *Writer {
+	writer := &Writer{key: key, buf: make([]byte, 0, chunkSize)}
+	writer.Reset(w)
+	return writer
+}
+
+// Reset discards any state and makes the Writer encrypt a new stream to w.
+func (writer *Writer) Reset(w io.Writer) {
+	writer.w = w
+	writer.started = false
+	writer.counter = 0
+	writer.buf = writer.buf[:0]
+
+	salt := make([]byte, saltSize)
+	_, writer.err = rand.Read(salt)
+	if writer.err != nil {
+		return
+	}
+	writer.aead, writer.err = writer.key.newAEAD(salt)
+
+	writer.header = []byte(magic)
+	writer.header = append(writer.header, formatVersion, cipherAESGCM)
+	writer.header = append(writer.header, writer.key.id...)
+	writer.header = append(writer.header, salt...)
+}
+
+// Write encrypts p to the stream.
+func (writer *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(writer.buf) == chunkSize {
+			err := writer.writeChunk(false)
+			if err != nil {
+				return written, err
+			}
+		}
+		n := copy(writer.buf[len(writer.buf):chunkSize], p)
+		writer.buf = writer.buf[:len(writer.buf)+n]
+		written += n
+		p = p[n:]
+	}
+	return written, writer.err
+}
+
+// Close writes the final chunk of the stream. It does not close the destination.
+func (writer *Writer) Close() error {
+	return writer.writeChunk(true)
+}
+
+func (writer *Writer) writeChunk(final bool) error {
+	if writer.err != nil {
+		return writer.err
+	}
+	if !writer.started {
+		_, writer.err = writer.w.Write(writer.header)
+		if writer.err != nil {
+			return writer.err
+		}
+		writer.started = true
+	}
+
+	chunk := make([]byte, chunkHeaderSize, chunkHeaderSize+len(writer.buf)+writer.aead.Overhead())
+	chunk = writer.aead.Seal(
+		chunk,
+		nonce(writer.aead, writer.counter),
+		writer.buf,
+		additionalData(writer.header, final),
+	)
+	binary.LittleEndian.PutUint32(chunk, uint32(len(chunk)-chunkHeaderSize))
+	if final {
+		chunk[4] = 1
+	}
+	_, writer.err = writer.w.Write(chunk)
+	writer.counter++
+	writer.buf = writer.buf[:0]
+	return writer.err
+}
+
+// reader decrypts a stream.
+type reader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	header  []byte
+	counter uint64
+	chunk   []byte
+	buf     []byte
+	done    bool
+}
+
+// NewReader reads the header of a stream encrypted with key, and returns a
+// reader that decrypts it. The key must be the one the stream was encrypted
+// with.
+func (key *Key) NewReader(r io.Reader) (io.Reader, error) {
+	header := make([]byte, headerSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, fmt.Errorf("error reading encryption header: %v", err)
+	}
+	if !bytes.HasPrefix(header, []byte(magic)) {
+		return nil, ErrNotEncrypted
+	}
+	fields := header[len(magic):]
+	if fields[0] != formatVersion {
+		return nil, fmt.Errorf("unsupported encryption format version %v", fields[0])
+	}
+	if fields[1] != cipherAESGCM {
+		return nil, fmt.Errorf("unsupported encryption cipher %v", fields[1])
+	}
+	keyID := fields[2 : 2+keyIDSize]
+	if !bytes.Equal(keyID, key.id) {
+		return nil, fmt.Errorf(
+			"data was encrypted with key ID %v, but the given key has ID %v",
+			hex.EncodeToString(keyID),
+			key.ID(),
+		)
+	}
+	aead, err := key.newAEAD(fields[2+keyIDSize:])
+	if err != nil {
+		return nil, err
+	}
+	return &reader{
+		r:      r,
+		aead:   aead,
+		header: header,
+		chunk:  make([]byte, chunkSize+aead.Overhead()),
+	}, nil
+}
+
+// Read decrypts from the stream into p.
+func (reader *reader) Read(p []byte) (int, error) {
+	for len(reader.buf) == 0 {
+		if reader.done {
+			return 0, io.EOF
+		}
+		err := reader.readChunk()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, reader.buf)
+	reader.buf = reader.buf[n:]
+	return n, nil
+}
+
+func (reader *reader) readChunk() error {
+	var chunkHeader [chunkHeaderSize]byte
+	_, err := io.ReadFull(reader.r, chunkHeader[:])
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("encrypted data is truncated")
+	}
+	if err != nil {
+		return err
+	}
+	length := binary.LittleEndian.Uint32(chunkHeader[:])
+	if int(length) > len(reader.chunk) || int(length) < reader.aead.Overhead() {
+		return fmt.Errorf("encrypted data is corrupt: invalid chunk length %v", length)
+	}
+	final := chunkHeader[4] == 1
+
+	sealed := reader.chunk[:length]
+	_, err = io.ReadFull(reader.r, sealed)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("encrypted data is truncated")
+	}
+	if err != nil {
+		return err
+	}
+	reader.buf, err = reader.aead.Open(
+		sealed[:0],
+		nonce(reader.aead, reader.counter),
+		sealed,
+		additionalData(reader.header, final),
+	)
+	if err != nil {
+		return fmt.Errorf("encrypted data failed authentication; it is corrupt or was modified")
+	}
+	reader.counter++
+	reader.done = final
+	return nil
+}
+
+// IsEncrypted peeks at the beginning of a stream and returns whether it is encrypted.
+func IsEncrypted(r *bufio.Reader) (bool, error) {
+	prefix, err := r.Peek(len(magic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	return string(prefix) == magic, nil
+}
+
+// Decrypt returns a reader of the plaintext of r. If key is nil, r must not be
+// encrypted, and is read as it is. Otherwise r must have been encrypted with key.
+func Decrypt(r io.Reader, key *Key) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	encrypted, err := IsEncrypted(buffered)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case encrypted && key == nil:
+		return nil, ErrKeyRequired
+	case !encrypted && key != nil:
+		return nil, ErrNotEncrypted
+	case !encrypted:
+		return buffered, nil
+	}
+	return key.NewReader(buffered)
+}
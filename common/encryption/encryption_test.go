@@ -0,0 +1,164 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(t *testing.T, fill byte) *Key {
+	key, err := NewKey(bytes.Repeat([]byte{fill}, KeySize))
+	require.NoError(t, err)
+	return key
+}
+
+func encrypt(t *testing.T, key *Key, plaintext []byte) []byte {
+	var out bytes.Buffer
+	writer := key.NewWriter(&out)
+	_, err := writer.Write(plaintext)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	return out.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	key := testKey(t, 1)
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 17} {
+		plaintext := bytes.Repeat([]byte("0123456789"), size/10+1)[:size]
+		encrypted := encrypt(t, key, plaintext)
+		assert.NotContains(t, string(encrypted), "0123456789")
+
+		decrypted, err := Decrypt(bytes.NewReader(encrypted), key)
+		require.NoError(t, err)
+		roundTripped, err := io.ReadAll(decrypted)
+		require.NoError(t, err)
+		assert.Equal(t, plaintext, roundTripped, "size %v", size)
+	}
+}
+
+func TestWriterReset(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	key := testKey(t, 1)
+	var first, second bytes.Buffer
+	writer := key.NewWriter(&first)
+	_, err := writer.Write([]byte("same data"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	writer.Reset(&second)
+	_, err = writer.Write([]byte("same data"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	assert.NotEqual(t, first.Bytes(), second.Bytes(), "each stream has its own salt")
+	for _, encrypted := range []*bytes.Buffer{&first, &second} {
+		decrypted, err := Decrypt(encrypted, key)
+		require.NoError(t, err)
+		plaintext, err := io.ReadAll(decrypted)
+		require.NoError(t, err)
+		assert.Equal(t, "same data", string(plaintext))
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	key := testKey(t, 1)
+	encrypted := encrypt(t, key, bytes.Repeat([]byte("x"), 2*chunkSize))
+
+	t.Run("wrong key", func(t *testing.T) {
+		_, err := Decrypt(bytes.NewReader(encrypted), testKey(t, 2))
+		require.ErrorContains(t, err, "data was encrypted with key ID "+key.ID())
+	})
+
+	t.Run("no key", func(t *testing.T) {
+		_, err := Decrypt(bytes.NewReader(encrypted), nil)
+		require.ErrorIs(t, err, ErrKeyRequired)
+	})
+
+	t.Run("not encrypted", func(t *testing.T) {
+		_, err := Decrypt(bytes.NewReader([]byte("plain data")), key)
+		require.ErrorIs(t, err, ErrNotEncrypted)
+
+		decrypted, err := Decrypt(bytes.NewReader([]byte("plain data")), nil)
+		require.NoError(t, err)
+		plaintext, err := io.ReadAll(decrypted)
+		require.NoError(t, err)
+		assert.Equal(t, "plain data", string(plaintext))
+	})
+
+	t.Run("modified", func(t *testing.T) {
+		modified := append([]byte{}, encrypted...)
+		modified[len(modified)/2] ^= 1
+		decrypted, err := Decrypt(bytes.NewReader(modified), key)
+		require.NoError(t, err)
+		_, err = io.ReadAll(decrypted)
+		require.ErrorContains(t, err, "failed authentication")
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		// Cutting the stream at a chunk boundary leaves only whole chunks, none
+		// of which is final.
+		truncated := encrypted[:headerSize+chunkHeaderSize+chunkSize+16]
+		decrypted, err := Decrypt(bytes.NewReader(truncated), key)
+		require.NoError(t, err)
+		_, err = io.ReadAll(decrypted)
+		require.ErrorContains(t, err, "truncated")
+	})
+}
+
+func TestLoadKey(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	secret := bytes.Repeat([]byte{7}, KeySize)
+	expected, err := NewKey(secret)
+	require.NoError(t, err)
+	dir := t.TempDir()
+
+	rawFile := filepath.Join(dir, "raw.key")
+	require.NoError(t, os.WriteFile(rawFile, secret, 0o600))
+	key, err := LoadKey(rawFile, "")
+	require.NoError(t, err)
+	assert.Equal(t, expected.ID(), key.ID())
+
+	encodedFile := filepath.Join(dir, "encoded.key")
+	encoded := base64.StdEncoding.EncodeToString(secret)
+	require.NoError(t, os.WriteFile(encodedFile, []byte(encoded+"\n"), 0o600))
+	key, err = LoadKey(encodedFile, "")
+	require.NoError(t, err)
+	assert.Equal(t, expected.ID(), key.ID())
+
+	t.Setenv("MONGO_TOOLS_TEST_KEY", encoded)
+	key, err = LoadKey("", "MONGO_TOOLS_TEST_KEY")
+	require.NoError(t, err)
+	assert.Equal(t, expected.ID(), key.ID())
+
+	key, err = LoadKey("", "")
+	require.NoError(t, err)
+	assert.Nil(t, key)
+
+	_, err = LoadKey(rawFile, "MONGO_TOOLS_TEST_KEY")
+	require.ErrorContains(t, err, "can't be used with")
+
+	_, err = LoadKey("", "MONGO_TOOLS_TEST_UNSET_KEY")
+	require.ErrorContains(t, err, "is not set")
+
+	t.Setenv("MONGO_TOOLS_TEST_KEY", base64.StdEncoding.EncodeToString([]byte("short")))
+	_, err = LoadKey("", "MONGO_TOOLS_TEST_KEY")
+	require.ErrorContains(t, err, "must be 32 bytes long")
+}
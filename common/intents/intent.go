@@ -73,6 +73,10 @@ type Intent struct {
 
 	// Either view or timeseries. Empty string "" is a regular collection.
 	Type string
+
+	// Namespaces that a view reads from as the dump recorded them, which
+	// ViewSources returns rather than finding them in the view's options.
+	RecordedViewSources []string
 }
 
 func (it *Intent) DataNamespace() string {
@@ -437,6 +441,18 @@ func (mgr *Manager) NormalIntents() []*Intent {
 	return allIntents
 }
 
+// SourceNamespace returns the namespace that a normal intent was put into the
+// manager with, which is its namespace in the dump before it was renamed.
+// SourceNamespace is not thread safe.
+func (mgr *Manager) SourceNamespace(intent *Intent) string {
+	for ns, candidate := range mgr.intents {
+		if candidate == intent {
+			return ns
+		}
+	}
+	return intent.Namespace()
+}
+
 func (mgr *Manager) IntentForNamespace(ns string) *Intent {
 	intent := mgr.intents[ns]
 	if intent != nil {
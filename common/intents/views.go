@@ -0,0 +1,207 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package intents
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// ViewSources returns the namespaces of the collections and views that a view
+// reads from, which are its viewOn and those of the $lookup, $graphLookup and
+// $unionWith stages of its pipeline, sorted. Those that the dump recorded are
+// returned if there are any. It returns nil for intents that aren't views.
+func (it *Intent) ViewSources() []string {
+	if !it.IsView() {
+		return nil
+	}
+	if len(it.RecordedViewSources) > 0 {
+		return it.RecordedViewSources
+	}
+	sources := map[string]bool{}
+	if viewOn, err := bsonutil.FindStringValueByKey("viewOn", &it.Options); err == nil &&
+		viewOn != "" {
+		sources[it.DB+"."+viewOn] = true
+	}
+	pipeline, _ := bsonutil.FindValueByKey("pipeline", &it.Options)
+	addPipelineSources(it.DB, pipeline, sources)
+
+	var namespaces []string
+	for namespace := range sources {
+		namespaces = append(namespaces, namespace)
+	}
+	slices.Sort(namespaces)
+	return namespaces
+}
+
+// addPipelineSources adds the namespaces that the stages of pipeline read
+// from to sources, including those of the sub-pipelines of the stages.
+func addPipelineSources(dbName string, pipeline any, sources map[string]bool) {
+	stages, ok := pipeline.(bson.A)
+	if !ok {
+		return
+	}
+	for _, stage := range stages {
+		stageDoc, ok := stage.(bson.D)
+		if !ok {
+			continue
+		}
+		for _, elem := range stageDoc {
+			switch elem.Key {
+			case "$lookup", "$graphLookup":
+				spec, ok := elem.Value.(bson.D)
+				if !ok {
+					continue
+				}
+				from, _ := bsonutil.FindValueByKey("from", &spec)
+				addStageSource(dbName, from, sources)
+				subPipeline, _ := bsonutil.FindValueByKey("pipeline", &spec)
+				addPipelineSources(dbName, subPipeline, sources)
+			case "$unionWith":
+				spec, ok := elem.Value.(bson.D)
+				if !ok {
+					// the short form only names the collection
+					addStageSource(dbName, elem.Value, sources)
+					continue
+				}
+				coll, _ := bsonutil.FindValueByKey("coll", &spec)
+				addStageSource(dbName, coll, sources)
+				subPipeline, _ := bsonutil.FindValueByKey("pipeline", &spec)
+				addPipelineSources(dbName, subPipeline, sources)
+			case "$facet":
+				spec, ok := elem.Value.(bson.D)
+				if !ok {
+					continue
+				}
+				for _, facet := range spec {
+					addPipelineSources(dbName, facet.Value, sources)
+				}
+			}
+		}
+	}
+}
+
+// addStageSource adds the namespace that a stage reads from to sources, given
+// as a collection of dbName or as {db, coll}.
+func addStageSource(dbName string, from any, sources map[string]bool) {
+	switch from := from.(type) {
+	case string:
+		if from != "" {
+			sources[dbName+"."+from] = true
+		}
+	case bson.D:
+		fromDB, _ := bsonutil.FindStringValueByKey("db", &from)
+		fromColl, _ := bsonutil.FindStringValueByKey("coll", &from)
+		if fromDB != "" && fromColl != "" {
+			sources[fromDB+"."+fromColl] = true
+		}
+	}
+}
+
+// FinalizeWithViewDependencies is like Finalize, but holds each view back
+// until the intents of the collections and views that it reads from are
+// finished, so that views are created in dependency order.
+func (mgr *Manager) FinalizeWithViewDependencies(pType PriorityType) {
+	pending := map[string]bool{}
+	for _, intent := range mgr.intentsByDiscoveryOrder {
+		pending[intent.Namespace()] = true
+	}
+	mgr.Finalize(pType)
+	mgr.prioritizer = newViewDependencyPrioritizer(mgr.prioritizer, pending)
+}
+
+// viewDependencyPrioritizer hands out the intents of another prioritizer,
+// except for views that read from a collection or view whose intent isn't
+// finished yet, which are held back until it is.
+type viewDependencyPrioritizer struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+	inner IntentPrioritizer
+	// pending are the namespaces whose intents aren't finished
+	pending map[string]bool
+	// held are the views that wait for their sources
+	held []*Intent
+	// active is the number of intents handed out and not finished
+	active int
+}
+
+func newViewDependencyPrioritizer(
+	inner IntentPrioritizer,
+	pending map[string]bool,
+) *viewDependencyPrioritizer {
+	prioritizer := &viewDependencyPrioritizer{inner: inner, pending: pending}
+	prioritizer.cond = sync.NewCond(&prioritizer.mutex)
+	return prioritizer
+}
+
+// ready returns whether none of the sources of intent is pending.
+func (vdp *viewDependencyPrioritizer) ready(intent *Intent) bool {
+	for _, source := range intent.ViewSources() {
+		if source != intent.Namespace() && vdp.pending[source] {
+			return false
+		}
+	}
+	return true
+}
+
+// Get returns the next intent whose sources are finished, waiting for those
+// that are handed out to be finished if only held views remain.
+func (vdp *viewDependencyPrioritizer) Get() *Intent {
+	vdp.mutex.Lock()
+	defer vdp.mutex.Unlock()
+
+	for {
+		for i, view := range vdp.held {
+			if vdp.ready(view) {
+				vdp.held = slices.Delete(vdp.held, i, i+1)
+				vdp.active++
+				return view
+			}
+		}
+
+		intent := vdp.inner.Get()
+		switch {
+		case intent != nil && !vdp.ready(intent):
+			log.Logvf(
+				log.DebugLow,
+				"holding view %#q until the namespaces it reads from are restored",
+				intent.Namespace(),
+			)
+			vdp.held = append(vdp.held, intent)
+		case intent != nil:
+			vdp.active++
+			return intent
+		case len(vdp.held) == 0:
+			return nil
+		case vdp.active == 0:
+			// The held views depend on each other, which the server doesn't
+			// allow, so they're handed out as they are.
+			view := vdp.held[0]
+			vdp.held = vdp.held[1:]
+			vdp.active++
+			return view
+		default:
+			vdp.cond.Wait()
+		}
+	}
+}
+
+// Finish marks the namespace of intent as finished, which releases the views
+// that read from it.
+func (vdp *viewDependencyPrioritizer) Finish(intent *Intent) {
+	vdp.inner.Finish(intent)
+
+	vdp.mutex.Lock()
+	defer vdp.mutex.Unlock()
+	delete(vdp.pending, intent.Namespace())
+	vdp.active--
+	vdp.cond.Broadcast()
+}
@@ -0,0 +1,77 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package intents
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestViewSources(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	view := &Intent{DB: "app", C: "report", Type: "view", Options: bson.D{
+		{"viewOn", "orders"},
+		{"pipeline", bson.A{
+			bson.D{{"$lookup", bson.D{
+				{"from", "customers"},
+				{"pipeline", bson.A{bson.D{{"$unionWith", "archivedCustomers"}}}},
+			}}},
+			bson.D{{"$unionWith", bson.D{{"coll", "legacyOrders"}}}},
+			bson.D{{"$facet", bson.D{
+				{"byRegion", bson.A{bson.D{{"$graphLookup", bson.D{{"from", "regions"}}}}}},
+			}}},
+			bson.D{{"$lookup", bson.D{{"from", bson.D{{"db", "config"}, {"coll", "shards"}}}}}},
+		}},
+	}}
+	assert.Equal(t, []string{
+		"app.archivedCustomers",
+		"app.customers",
+		"app.legacyOrders",
+		"app.orders",
+		"app.regions",
+		"config.shards",
+	}, view.ViewSources())
+
+	collection := &Intent{DB: "app", C: "orders", Options: bson.D{{"viewOn", "x"}}}
+	assert.Nil(t, collection.ViewSources())
+}
+
+func TestViewDependencyPrioritizer(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	view := func(name, viewOn string) *Intent {
+		return &Intent{DB: "db", C: name, Type: "view", Options: bson.D{{"viewOn", viewOn}}}
+	}
+	mgr := NewIntentManager()
+	// views are discovered before what they read from
+	mgr.Put(view("top", "middle"))
+	mgr.Put(view("middle", "base"))
+	mgr.Put(view("elsewhere", "notInTheDump"))
+	mgr.Put(&Intent{DB: "db", C: "base"})
+	mgr.FinalizeWithViewDependencies(Legacy)
+
+	var order []string
+	for intent := mgr.Pop(); intent != nil; intent = mgr.Pop() {
+		order = append(order, intent.C)
+		mgr.Finish(intent)
+	}
+	assert.Equal(t, []string{"elsewhere", "base", "middle", "top"}, order)
+
+	mgr = NewIntentManager()
+	mgr.Put(view("a", "b"))
+	mgr.Put(view("b", "a"))
+	mgr.FinalizeWithViewDependencies(Legacy)
+	first := mgr.Pop()
+	require.NotNil(t, first, "views that depend on each other are still handed out")
+	mgr.Finish(first)
+	require.NotNil(t, mgr.Pop())
+}
@@ -0,0 +1,328 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	s3Scheme = "s3://"
+
+	// defaultS3Region is the region used when none is configured, which S3
+	// stand-ins like MinIO accept whatever it is.
+	defaultS3Region = "us-east-1"
+)
+
+// s3API is the part of the S3 API that the S3 backend uses.
+type s3API interface {
+	manager.UploadAPIClient
+	GetObject(context.Context, *s3.GetObjectInput, ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(context.Context, *s3.HeadObjectInput, ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	ListObjectsV2(context.Context, *s3.ListObjectsV2Input, ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	DeleteObject(context.Context, *s3.DeleteObjectInput, ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+}
+
+// S3 stores files as the objects of a bucket of an S3 API. Files are written
+// with streaming multipart uploads, and read with ranged reads. Directories are
+// the common prefixes of the keys of the objects, so they exist once a file is
+// written in them.
+//
+// The client is configured the way the AWS CLI is, from the environment and
+// the shared configuration files. An S3 stand-in like MinIO is used by setting
+// AWS_ENDPOINT_URL or AWS_ENDPOINT_URL_S3, in which case buckets are addressed
+// by path rather than by host name.
+type S3 struct {
+	client s3API
+	bucket string
+}
+
+// NewS3 returns the backend for bucket.
+func NewS3(ctx context.Context, bucket string) (*S3, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error loading AWS configuration: %v", err)
+	}
+	if cfg.Region == "" {
+		cfg.Region = defaultS3Region
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = o.BaseEndpoint != nil
+	})
+	return &S3{client: client, bucket: bucket}, nil
+}
+
+func (b *S3) Open(name string) (File, error) {
+	key := objectKey(name)
+	head, err := b.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, b.pathError("open", name, err)
+	}
+	return &s3Object{backend: b, name: name, key: key, size: aws.ToInt64(head.ContentLength)}, nil
+}
+
+// Create starts a multipart upload of the file, which is fed by the writes
+// to the file. A write blocks while the upload is behind, and fails once the
+// upload failed.
+func (b *S3) Create(name string) (io.WriteCloser, error) {
+	reader, writer := io.Pipe()
+	w := &s3Writer{PipeWriter: writer, done: make(chan error, 1)}
+	uploader := manager.NewUploader(b.client)
+	go func() {
+		_, err := uploader.Upload(context.Background(), &s3.PutObjectInput{
+			Bucket: aws.String(b.bucket),
+			Key:    aws.String(objectKey(name)),
+			Body:   reader,
+		})
+		if err != nil {
+			err = b.pathError("write", name, err)
+		}
+		reader.CloseWithError(err)
+		w.done <- err
+	}()
+	return w, nil
+}
+
+// MkdirAll does nothing, since directories exist once there are files in them.
+func (*S3) MkdirAll(string) error {
+	return nil
+}
+
+func (b *S3) Stat(name string) (fs.FileInfo, error) {
+	key := objectKey(name)
+	if key == "" {
+		return &s3FileInfo{name: ".", dir: true}, nil
+	}
+	head, err := b.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		return &s3FileInfo{
+			name:    path.Base(key),
+			size:    aws.ToInt64(head.ContentLength),
+			modTime: aws.ToTime(head.LastModified),
+		}, nil
+	} else if !isNotFound(err) {
+		return nil, b.pathError("stat", name, err)
+	}
+
+	list, err := b.client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+		Bucket:  aws.String(b.bucket),
+		Prefix:  aws.String(key + "/"),
+		MaxKeys: aws.Int32(1),
+	})
+	if err != nil {
+		return nil, b.pathError("stat", name, err)
+	}
+	if len(list.Contents) == 0 {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return &s3FileInfo{name: path.Base(key), dir: true}, nil
+}
+
+func (b *S3) ReadDir(name string) ([]fs.FileInfo, error) {
+	prefix := objectKey(name)
+	if prefix != "" {
+		prefix += "/"
+	}
+	var infos []fs.FileInfo
+	pages := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(b.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(context.Background())
+		if err != nil {
+			return nil, b.pathError("readdir", name, err)
+		}
+		for _, dir := range page.CommonPrefixes {
+			infos = append(infos, &s3FileInfo{
+				name: strings.TrimSuffix(strings.TrimPrefix(aws.ToString(dir.Prefix), prefix), "/"),
+				dir:  true,
+			})
+		}
+		for _, object := range page.Contents {
+			objectName := strings.TrimPrefix(aws.ToString(object.Key), prefix)
+			if objectName == "" {
+				// a marker for the directory itself
+				continue
+			}
+			infos = append(infos, &s3FileInfo{
+				name:    objectName,
+				size:    aws.ToInt64(object.Size),
+				modTime: aws.ToTime(object.LastModified),
+			})
+		}
+	}
+	if len(infos) == 0 && prefix != "" {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	slices.SortFunc(infos, func(a, b fs.FileInfo) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return infos, nil
+}
+
+func (b *S3) Remove(name string) error {
+	_, err := b.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(objectKey(name)),
+	})
+	if err != nil {
+		return b.pathError("remove", name, err)
+	}
+	return nil
+}
+
+// pathError describes an error of the S3 API about the file name, which is
+// os.ErrNotExist if there's no such object.
+func (b *S3) pathError(op, name string, err error) error {
+	if isNotFound(err) {
+		err = fs.ErrNotExist
+	}
+	return &fs.PathError{Op: op, Path: s3Scheme + b.bucket + "/" + objectKey(name), Err: err}
+}
+
+func isNotFound(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NotFound", "NoSuchKey", "NoSuchBucket":
+			return true
+		}
+	}
+	var notFound *types.NotFound
+	var noSuchKey *types.NoSuchKey
+	return errors.As(err, &notFound) || errors.As(err, &noSuchKey)
+}
+
+// s3Writer writes a file to its upload.
+type s3Writer struct {
+	*io.PipeWriter
+	done chan error
+}
+
+// Close waits for the upload to complete.
+func (w *s3Writer) Close() error {
+	err := w.PipeWriter.Close()
+	if uploadErr := <-w.done; uploadErr != nil {
+		return uploadErr
+	}
+	return err
+}
+
+// s3Object reads an object. Read streams the object from where the last read
+// stopped, while ReadAt reads just the range it is asked for.
+type s3Object struct {
+	backend *S3
+	name    string
+	key     string
+	size    int64
+
+	body io.ReadCloser
+	pos  int64
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.pos >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		body, err := o.get(o.pos, o.size)
+		if err != nil {
+			return 0, err
+		}
+		o.body = body
+	}
+	n, err := o.body.Read(p)
+	o.pos += int64(n)
+	if err == io.EOF && o.pos < o.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (o *s3Object) ReadAt(p []byte, off int64) (int, error) {
+	if off >= o.size {
+		return 0, io.EOF
+	}
+	end := min(off+int64(len(p)), o.size)
+	body, err := o.get(off, end)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	n, err := io.ReadFull(body, p[:end-off])
+	if err == nil && end < off+int64(len(p)) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// get reads the bytes of the object from start up to end.
+func (o *s3Object) get(start, end int64) (io.ReadCloser, error) {
+	if start >= end {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	out, err := o.backend.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(o.backend.bucket),
+		Key:    aws.String(o.key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", start, end-1)),
+	})
+	if err != nil {
+		return nil, o.backend.pathError("read", o.name, err)
+	}
+	return out.Body, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	return o.body.Close()
+}
+
+// s3FileInfo describes an object, or a common prefix of objects as a directory.
+type s3FileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (info *s3FileInfo) Name() string       { return info.name }
+func (info *s3FileInfo) Size() int64        { return info.size }
+func (info *s3FileInfo) ModTime() time.Time { return info.modTime }
+func (info *s3FileInfo) IsDir() bool        { return info.dir }
+func (info *s3FileInfo) Sys() any           { return nil }
+
+func (info *s3FileInfo) Mode() fs.FileMode {
+	if info.dir {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
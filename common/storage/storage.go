@@ -0,0 +1,163 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+// Package storage implements the backends that dumps can be written to and
+// read from: the local file system, and object storage with an S3 API.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Backend stores the files of a dump. Names are slash or OS separated paths
+// relative to the backend, like the paths of the local file system.
+type Backend interface {
+	// Open opens the file name for reading.
+	Open(name string) (File, error)
+	// Create creates or truncates the file name for writing. The file is only
+	// complete once it is closed without an error. The directory of the file
+	// must exist.
+	Create(name string) (io.WriteCloser, error)
+	// MkdirAll creates the directory name and any parents it needs.
+	MkdirAll(name string) error
+	// Stat describes the file or directory name. It returns an error that is
+	// os.ErrNotExist if there is none.
+	Stat(name string) (fs.FileInfo, error)
+	// ReadDir describes the entries of the directory name, sorted by name.
+	ReadDir(name string) ([]fs.FileInfo, error)
+	// Remove removes the file name.
+	Remove(name string) error
+}
+
+// File is a file being read from a Backend. ReadAt reads a range of the file
+// without changing where Read reads from.
+type File interface {
+	io.ReadCloser
+	io.ReaderAt
+}
+
+// IsURL returns whether target is the URL of an object storage location rather
+// than a path of the local file system.
+func IsURL(target string) bool {
+	return strings.HasPrefix(target, s3Scheme)
+}
+
+// Parse returns the backend that target is in, and the path of target in it.
+// A target like s3://bucket/prefix is in the bucket of an S3 API; anything else
+// is a path of the local file system.
+func Parse(ctx context.Context, target string) (Backend, string, error) {
+	if !IsURL(target) {
+		return Local{}, target, nil
+	}
+	bucket, _, _ := strings.Cut(strings.TrimPrefix(target, s3Scheme), "/")
+	if bucket == "" {
+		return nil, "", fmt.Errorf("invalid object storage URL %#q: no bucket", target)
+	}
+	backend, err := NewS3(ctx, bucket)
+	if err != nil {
+		return nil, "", err
+	}
+	return backend, Path(target), nil
+}
+
+// Path returns the path of target in the backend that Parse returns for it.
+func Path(target string) string {
+	if !IsURL(target) {
+		return target
+	}
+	_, prefix, _ := strings.Cut(strings.TrimPrefix(target, s3Scheme), "/")
+	return strings.Trim(prefix, "/")
+}
+
+// OrLocal returns backend, or the local file system if it is nil.
+func OrLocal(backend Backend) Backend {
+	if backend == nil {
+		return Local{}
+	}
+	return backend
+}
+
+// WriteFile writes content to the file name, like os.WriteFile.
+func WriteFile(backend Backend, name string, content []byte) error {
+	file, err := backend.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// ReadFile reads the file name, like os.ReadFile.
+func ReadFile(backend Backend, name string) ([]byte, error) {
+	file, err := backend.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// Local is the local file system.
+type Local struct{}
+
+func (Local) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+func (Local) Create(name string) (io.WriteCloser, error) {
+	return os.Create(name)
+}
+
+func (Local) MkdirAll(name string) error {
+	return os.MkdirAll(name, os.ModeDir|os.ModePerm)
+}
+
+func (Local) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (Local) ReadDir(name string) ([]fs.FileInfo, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			// removed since the directory was read
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (Local) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// objectKey returns the key of the object of the file name under a backend
+// whose files are all objects.
+func objectKey(name string) string {
+	key := strings.Trim(filepath.ToSlash(filepath.Clean(name)), "/")
+	if key == "." {
+		return ""
+	}
+	return key
+}
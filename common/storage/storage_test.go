@@ -0,0 +1,275 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	backend, path, err := Parse(context.Background(), "dump/test")
+	require.NoError(t, err)
+	assert.Equal(t, Local{}, backend)
+	assert.Equal(t, "dump/test", path)
+
+	assert.True(t, IsURL("s3://bucket/prefix"))
+	assert.Equal(t, "prefix/dump", Path("s3://bucket/prefix/dump/"))
+	assert.Equal(t, "", Path("s3://bucket"))
+
+	_, _, err = Parse(context.Background(), "s3:///prefix")
+	assert.ErrorContains(t, err, "no bucket")
+}
+
+func TestBackends(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	backends := map[string]func(t *testing.T) (Backend, string){
+		"local": func(t *testing.T) (Backend, string) {
+			return Local{}, t.TempDir()
+		},
+		"s3": func(*testing.T) (Backend, string) {
+			return &S3{client: newFakeS3(), bucket: "bucket"}, "prefix"
+		},
+	}
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			backend, root := newBackend(t)
+			dir := filepath.Join(root, "test")
+			require.NoError(t, backend.MkdirAll(dir))
+
+			// large enough to be uploaded in several parts
+			large := bytes.Repeat([]byte("0123456789"), 1200*1024)
+			require.NoError(t, WriteFile(backend, filepath.Join(dir, "foo.bson"), large))
+			require.NoError(t, WriteFile(backend, filepath.Join(dir, "bar.json"), []byte("{}")))
+			require.NoError(t, backend.MkdirAll(filepath.Join(dir, "sub")))
+			require.NoError(t, WriteFile(backend, filepath.Join(dir, "sub", "baz"), nil))
+
+			info, err := backend.Stat(dir)
+			require.NoError(t, err)
+			assert.True(t, info.IsDir())
+			info, err = backend.Stat(filepath.Join(dir, "foo.bson"))
+			require.NoError(t, err)
+			assert.False(t, info.IsDir())
+			assert.EqualValues(t, len(large), info.Size())
+			assert.True(t, info.Mode().IsRegular())
+			_, err = backend.Stat(filepath.Join(dir, "missing"))
+			assert.ErrorIs(t, err, os.ErrNotExist)
+
+			entries, err := backend.ReadDir(dir)
+			require.NoError(t, err)
+			var names []string
+			for _, entry := range entries {
+				names = append(names, fmt.Sprintf("%v:%v", entry.Name(), entry.IsDir()))
+			}
+			assert.Equal(t, []string{"bar.json:false", "foo.bson:false", "sub:true"}, names)
+
+			content, err := ReadFile(backend, filepath.Join(dir, "foo.bson"))
+			require.NoError(t, err)
+			assert.Equal(t, large, content)
+
+			file, err := backend.Open(filepath.Join(dir, "foo.bson"))
+			require.NoError(t, err)
+			p := make([]byte, 10)
+			n, err := file.ReadAt(p, 5)
+			require.NoError(t, err)
+			assert.Equal(t, "5678901234", string(p[:n]))
+			n, err = file.ReadAt(p, int64(len(large))-4)
+			assert.Equal(t, io.EOF, err)
+			assert.Equal(t, "6789", string(p[:n]))
+			require.NoError(t, file.Close())
+
+			require.NoError(t, backend.Remove(filepath.Join(dir, "bar.json")))
+			_, err = backend.Open(filepath.Join(dir, "bar.json"))
+			assert.ErrorIs(t, err, os.ErrNotExist)
+		})
+	}
+}
+
+// fakeS3 is an in-memory stand-in for the S3 API.
+type fakeS3 struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int32][]byte
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}, uploads: map[string]map[int32][]byte{}}
+}
+
+func (f *fakeS3) PutObject(
+	_ context.Context,
+	in *s3.PutObjectInput,
+	_ ...func(*s3.Options),
+) (*s3.PutObjectOutput, error) {
+	content, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.objects[aws.ToString(in.Key)] = content
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) CreateMultipartUpload(
+	_ context.Context,
+	in *s3.CreateMultipartUploadInput,
+	_ ...func(*s3.Options),
+) (*s3.CreateMultipartUploadOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.uploads[aws.ToString(in.Key)] = map[int32][]byte{}
+	return &s3.CreateMultipartUploadOutput{UploadId: in.Key}, nil
+}
+
+func (f *fakeS3) UploadPart(
+	_ context.Context,
+	in *s3.UploadPartInput,
+	_ ...func(*s3.Options),
+) (*s3.UploadPartOutput, error) {
+	content, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.uploads[aws.ToString(in.UploadId)][aws.ToInt32(in.PartNumber)] = content
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprint(aws.ToInt32(in.PartNumber)))}, nil
+}
+
+func (f *fakeS3) CompleteMultipartUpload(
+	_ context.Context,
+	in *s3.CompleteMultipartUploadInput,
+	_ ...func(*s3.Options),
+) (*s3.CompleteMultipartUploadOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	parts := f.uploads[aws.ToString(in.UploadId)]
+	var content []byte
+	for _, part := range in.MultipartUpload.Parts {
+		content = append(content, parts[aws.ToInt32(part.PartNumber)]...)
+	}
+	f.objects[aws.ToString(in.Key)] = content
+	delete(f.uploads, aws.ToString(in.UploadId))
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (f *fakeS3) AbortMultipartUpload(
+	_ context.Context,
+	in *s3.AbortMultipartUploadInput,
+	_ ...func(*s3.Options),
+) (*s3.AbortMultipartUploadOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.uploads, aws.ToString(in.UploadId))
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (f *fakeS3) GetObject(
+	_ context.Context,
+	in *s3.GetObjectInput,
+	_ ...func(*s3.Options),
+) (*s3.GetObjectOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	content, ok := f.objects[aws.ToString(in.Key)]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	if in.Range != nil {
+		var start, end int
+		_, err := fmt.Sscanf(aws.ToString(in.Range), "bytes=%d-%d", &start, &end)
+		if err != nil {
+			return nil, err
+		}
+		content = content[start:min(end+1, len(content))]
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(content))}, nil
+}
+
+func (f *fakeS3) HeadObject(
+	_ context.Context,
+	in *s3.HeadObjectInput,
+	_ ...func(*s3.Options),
+) (*s3.HeadObjectOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	content, ok := f.objects[aws.ToString(in.Key)]
+	if !ok {
+		return nil, &types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{ContentLength: aws.Int64(int64(len(content)))}, nil
+}
+
+func (f *fakeS3) ListObjectsV2(
+	_ context.Context,
+	in *s3.ListObjectsV2Input,
+	_ ...func(*s3.Options),
+) (*s3.ListObjectsV2Output, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	prefix := aws.ToString(in.Prefix)
+	delimiter := aws.ToString(in.Delimiter)
+	out := &s3.ListObjectsV2Output{}
+	var keys []string
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	seen := map[string]bool{}
+	for _, key := range keys {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			dir := prefix + rest[:i+1]
+			if !seen[dir] {
+				seen[dir] = true
+				out.CommonPrefixes = append(out.CommonPrefixes, types.CommonPrefix{Prefix: &dir})
+			}
+			continue
+		}
+		out.Contents = append(out.Contents, types.Object{
+			Key:  aws.String(key),
+			Size: aws.Int64(int64(len(f.objects[key]))),
+		})
+	}
+	if in.MaxKeys != nil && len(out.Contents) > int(*in.MaxKeys) {
+		out.Contents = out.Contents[:*in.MaxKeys]
+	}
+	return out, nil
+}
+
+func (f *fakeS3) DeleteObject(
+	_ context.Context,
+	in *s3.DeleteObjectInput,
+	_ ...func(*s3.Options),
+) (*s3.DeleteObjectOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.objects, aws.ToString(in.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
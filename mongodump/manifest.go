@@ -0,0 +1,124 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/mongodb/mongo-tools/common/dumprestore"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/storage"
+)
+
+// DumpManifest writes the manifest of a dump directory, listing every file that
+// was dumped with its size, checksum and document count. It must be called once
+// everything else has been dumped.
+func (dump *MongoDump) DumpManifest() error {
+	dir := dump.topLevelDir()
+	manifest, err := dump.buildManifest(dir)
+	if err != nil {
+		return err
+	}
+	if len(manifest.Files) == 0 {
+		log.Logvf(log.DebugLow, "nothing was dumped, not writing a manifest")
+		return nil
+	}
+
+	filename := filepath.Join(dir, dumprestore.ManifestFileName)
+	log.Logvf(log.DebugLow, "dumping manifest to file %#q", filename)
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling manifest: %w", err)
+	}
+	err = storage.WriteFile(storage.OrLocal(dump.storage), filename, append(content, '\n'))
+	if errors.Is(err, os.ErrNotExist) {
+		// as with prelude.json, there was no data to dump
+		log.Logvf(log.DebugLow, "parent directory does not exist, not writing %#q", filename)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to write manifest to file %#q: %w", filename, err)
+	}
+	return nil
+}
+
+// buildManifest builds the manifest from the files that the intents were dumped
+// to. The paths in the manifest are relative to dir.
+func (dump *MongoDump) buildManifest(dir string) (*dumprestore.Manifest, error) {
+	manifest := &dumprestore.Manifest{
+		ServerVersion: dump.serverVersion,
+		ToolVersion:   dump.ToolOptions.VersionStr,
+		Files:         []dumprestore.ManifestFile{},
+	}
+	if dump.dumpsOplog() {
+		manifest.OplogStart = &dump.oplogStart
+		manifest.OplogEnd = &dump.oplogEnd
+	}
+
+	for _, intent := range dump.manager.Intents() {
+		if bsonFile, ok := intent.BSONFile.(*realBSONFile); ok && bsonFile.checksum != nil {
+			file, err := manifestFile(dir, bsonFile.path, intent, bsonFile.checksum)
+			if err != nil {
+				return nil, err
+			}
+			file.Type = dumprestore.ManifestBSONFile
+			file.Documents = bsonFile.documents
+			manifest.Files = append(manifest.Files, file)
+			for _, part := range bsonFile.partitions {
+				file, err := manifestFile(dir, part.path, intent, part.checksum)
+				if err != nil {
+					return nil, err
+				}
+				file.Type = dumprestore.ManifestBSONFile
+				file.Documents = part.documents
+				manifest.Files = append(manifest.Files, file)
+			}
+		}
+		if metadataFile, ok := intent.MetadataFile.(*realMetadataFile); ok &&
+			metadataFile.checksum != nil {
+			file, err := manifestFile(dir, metadataFile.path, intent, metadataFile.checksum)
+			if err != nil {
+				return nil, err
+			}
+			file.Type = dumprestore.ManifestMetadataFile
+			manifest.Files = append(manifest.Files, file)
+		}
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Path < manifest.Files[j].Path
+	})
+	return manifest, nil
+}
+
+func manifestFile(
+	dir, path string,
+	intent *intents.Intent,
+	checksum *dumprestore.ChecksumWriter,
+) (dumprestore.ManifestFile, error) {
+	relPath, err := filepath.Rel(dir, path)
+	if err != nil {
+		return dumprestore.ManifestFile{}, fmt.Errorf(
+			"error finding path of %#q for manifest: %v", path, err,
+		)
+	}
+	namespace := intent.Namespace()
+	if intent.IsOplog() {
+		namespace = "oplog"
+	}
+	return dumprestore.ManifestFile{
+		Path:      filepath.ToSlash(relPath),
+		Namespace: namespace,
+		Size:      checksum.Size(),
+		SHA256:    checksum.Sum(),
+		UUID:      intent.UUID,
+	}, nil
+}
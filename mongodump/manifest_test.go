@@ -0,0 +1,92 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/dumprestore"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestDumpManifest(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	dir := t.TempDir()
+	dump := &MongoDump{
+		ToolOptions:   &options.ToolOptions{VersionStr: "100.0.0", Namespace: &options.Namespace{}},
+		InputOptions:  &InputOptions{},
+		OutputOptions: &OutputOptions{Out: dir},
+		manager:       intents.NewIntentManager(),
+		serverVersion: "7.0.0",
+	}
+
+	intent := &intents.Intent{
+		ServerVersion: db.Version{7, 0, 0},
+		DB:            "test",
+		C:             "foo",
+		UUID:          "0123456789abcdef0123456789abcdef",
+	}
+	bsonFile := &realBSONFile{path: dump.outputPath("test", "foo") + ".bson", intent: intent}
+	metadataFile := &realMetadataFile{
+		path:   dump.outputPath("test", "foo") + ".metadata.json",
+		intent: intent,
+	}
+	intent.BSONFile = bsonFile
+	intent.MetadataFile = metadataFile
+	dump.manager.Put(intent)
+
+	// A view without data has no BSON file to list.
+	view := &intents.Intent{ServerVersion: db.Version{7, 0, 0}, DB: "test", C: "view", Type: "view"}
+	dump.manager.Put(view)
+
+	doc, err := bson.Marshal(bson.D{{"_id", 1}})
+	require.NoError(t, err)
+	require.NoError(t, bsonFile.Open())
+	for range 3 {
+		_, err = bsonFile.Write(doc)
+		require.NoError(t, err)
+	}
+	require.NoError(t, bsonFile.Close())
+	bsonFile.documents = 3
+	require.NoError(t, metadataFile.Open())
+	_, err = metadataFile.Write([]byte(`{"indexes":[]}`))
+	require.NoError(t, err)
+	require.NoError(t, metadataFile.Close())
+
+	require.NoError(t, dump.DumpManifest())
+	manifest, err := dumprestore.ReadManifest(filepath.Join(dir, dumprestore.ManifestFileName))
+	require.NoError(t, err)
+
+	assert.Equal(t, "7.0.0", manifest.ServerVersion)
+	assert.Equal(t, "100.0.0", manifest.ToolVersion)
+	assert.Nil(t, manifest.OplogStart)
+	require.Len(t, manifest.Files, 2)
+
+	bsonEntry, metadataEntry := manifest.Files[0], manifest.Files[1]
+	assert.Equal(t, "test/foo.bson", bsonEntry.Path)
+	assert.Equal(t, dumprestore.ManifestBSONFile, bsonEntry.Type)
+	assert.Equal(t, "test.foo", bsonEntry.Namespace)
+	assert.EqualValues(t, 3, bsonEntry.Documents)
+	assert.EqualValues(t, 3*len(doc), bsonEntry.Size)
+	assert.Equal(t, intent.UUID, bsonEntry.UUID)
+	size, sum, err := dumprestore.ChecksumFile(bsonFile.path)
+	require.NoError(t, err)
+	assert.Equal(t, size, bsonEntry.Size)
+	assert.Equal(t, sum, bsonEntry.SHA256)
+
+	assert.Equal(t, "test/foo.metadata.json", metadataEntry.Path)
+	assert.Equal(t, dumprestore.ManifestMetadataFile, metadataEntry.Type)
+	assert.EqualValues(t, len(`{"indexes":[]}`), metadataEntry.Size)
+}
@@ -13,6 +13,7 @@ import (
 
 	"github.com/mongodb/mongo-tools/common/bsonutil"
 	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/dumprestore"
 	"github.com/mongodb/mongo-tools/common/intents"
 	"github.com/mongodb/mongo-tools/common/log"
 	"go.mongodb.org/mongo-driver/v2/bson"
@@ -25,6 +26,12 @@ type Metadata struct {
 	UUID           string   `bson:"uuid,omitempty"`
 	CollectionName string   `bson:"collectionName"`
 	Type           string   `bson:"type,omitempty"`
+	// Settings are the options of the collection that collMod can change,
+	// which are also part of Options for restores that don't know them.
+	Settings bson.D `bson:"settings,omitempty"`
+	// SearchIndexes are the Atlas Search and vector search indexes of the
+	// collection, which listIndexes doesn't report.
+	SearchIndexes []db.SearchIndex `bson:"searchIndexes,omitempty"`
 }
 
 // IndexDocumentFromDB is used internally to preserve key ordering.
@@ -49,6 +56,7 @@ func (dump *MongoDump) dumpMetadata(
 
 	// The collection options were already gathered while building the list of intents.
 	meta.Options = intent.Options
+	_, meta.Settings = dumprestore.SplitCollectionSettings(intent.Options)
 
 	// If a collection has a UUID, it was gathered while building the list of
 	// intents.  Otherwise, it will be the empty string.
@@ -114,6 +122,8 @@ func (dump *MongoDump) dumpMetadata(
 				err,
 			)
 		}
+
+		meta.SearchIndexes = dump.listSearchIndexes(intent)
 	}
 
 	// Finally, we send the results to the writer as JSON bytes
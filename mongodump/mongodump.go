@@ -9,28 +9,34 @@ package mongodump
 
 import (
 	"bufio"
-	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mongodb/mongo-tools/common"
 	"github.com/mongodb/mongo-tools/common/archive"
 	"github.com/mongodb/mongo-tools/common/auth"
 	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/compression"
 	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/dumprestore"
+	"github.com/mongodb/mongo-tools/common/encryption"
 	"github.com/mongodb/mongo-tools/common/failpoint"
 	"github.com/mongodb/mongo-tools/common/intents"
 	"github.com/mongodb/mongo-tools/common/log"
 	"github.com/mongodb/mongo-tools/common/options"
 	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/storage"
 	"github.com/mongodb/mongo-tools/common/util"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
@@ -54,6 +60,10 @@ type MongoDump struct {
 	SessionProvider    *db.SessionProvider
 	manager            *intents.Manager
 	query              bson.D
+	queries            []*namespaceQuery
+	preImagesFilter    bson.D
+	includer           *ns.Matcher
+	excluder           *ns.Matcher
 	oplogCollection    string
 	oplogStart         bson.Timestamp
 	oplogEnd           bson.Timestamp
@@ -63,6 +73,24 @@ type MongoDump struct {
 	serverVersionArray db.Version
 	authVersion        int
 	archive            *archive.Writer
+	codec              *compression.Codec
+	encryptionKey      *encryption.Key
+	// storage is where a dump directory is written, or nil for the local file system
+	storage storage.Backend
+	// resume records the progress of the dump when --resume is given
+	resume *dumpResume
+	// clusterTime synchronizes the end of the oplog with the dumps of the other
+	// parts of a sharded cluster when this dumps one of them
+	clusterTime *clusterTimeBarrier
+	// viewDependencies are the namespaces that each view reads from, which are
+	// recorded in prelude.json
+	viewDependencies map[string][]string
+	// runCommand runs the commands of search indexes, or is nil to run them
+	// with the SessionProvider
+	runCommand db.CommandRunner
+	// searchUnsupported is set once the server replied that it doesn't
+	// support search indexes
+	searchUnsupported atomic.Bool
 	// shutdownIntentsNotifier is provided to the multiplexer
 	// as well as the signal handler, and allows them to notify
 	// the intent dumpers that they should shutdown
@@ -87,6 +115,13 @@ func newNotifier() *notifier { return &notifier{notified: make(chan struct{})} }
 
 // ValidateOptions checks for any incompatible sets of options.
 func (dump *MongoDump) ValidateOptions() error {
+	codec, err := dump.OutputOptions.Codec()
+	if err != nil {
+		return err
+	}
+	encrypted := dump.OutputOptions.EncryptionKeyFile != "" ||
+		dump.OutputOptions.EncryptionKeyEnv != ""
+
 	switch {
 	case dump.OutputOptions.Out == "-" && dump.ToolOptions.Collection == "":
 		return fmt.Errorf("can only dump a single collection to stdout")
@@ -100,6 +135,12 @@ func (dump *MongoDump) ValidateOptions() error {
 		return fmt.Errorf("either query or queryFile can be specified as a query option, not both")
 	case dump.InputOptions.Query != "" && dump.InputOptions.TableScan:
 		return fmt.Errorf("cannot use --forceTableScan when specifying --query")
+	case dump.InputOptions.QueriesFile != "" && dump.InputOptions.HasQuery():
+		return fmt.Errorf("--queriesFile can't be used with --query or --queryFile")
+	case dump.InputOptions.QueriesFile != "" && dump.InputOptions.TableScan:
+		return fmt.Errorf("cannot use --forceTableScan when specifying --queriesFile")
+	case dump.InputOptions.QueriesFile != "" && dump.OutputOptions.Incremental:
+		return fmt.Errorf("cannot dump using --queriesFile when --incremental is specified")
 	case dump.OutputOptions.DumpDBUsersAndRoles && dump.ToolOptions.DB == "":
 		return fmt.Errorf("must specify a database when running with dumpDbUsersAndRoles")
 	case dump.OutputOptions.DumpDBUsersAndRoles && dump.ToolOptions.Collection != "":
@@ -109,6 +150,28 @@ func (dump *MongoDump) ValidateOptions() error {
 			"Specifying the timeseries collection will dump the system.buckets collection")
 	case dump.OutputOptions.Oplog && dump.ToolOptions.DB != "":
 		return fmt.Errorf("--oplog mode only supported on full dumps")
+	case dump.OutputOptions.ShardedOplog && dump.OutputOptions.Oplog:
+		return fmt.Errorf("--oplog is not allowed when --shardedOplog is specified")
+	case dump.OutputOptions.ShardedOplog && dump.OutputOptions.Incremental:
+		return fmt.Errorf("--incremental is not allowed when --shardedOplog is specified")
+	case dump.OutputOptions.ShardedOplog && (dump.ToolOptions.DB != "" || dump.selectsNamespaces()):
+		return fmt.Errorf("--shardedOplog mode only supported on full dumps")
+	case dump.OutputOptions.ShardedOplog && (dump.OutputOptions.Archive != "" || dump.OutputOptions.Out == "-"):
+		return fmt.Errorf("--shardedOplog can only be used when dumping to a directory")
+	case dump.OutputOptions.ShardedOplog && storage.IsURL(dump.OutputOptions.Out):
+		return fmt.Errorf("--shardedOplog can't be used when dumping to object storage")
+	case dump.OutputOptions.ShardedOplog && dump.OutputOptions.Resume:
+		return fmt.Errorf("--resume can't be used with --shardedOplog")
+	case dump.OutputOptions.Incremental && dump.OutputOptions.CheckpointFile == "":
+		return fmt.Errorf("--checkpointFile is required when --incremental is specified")
+	case dump.OutputOptions.Incremental && dump.OutputOptions.Oplog:
+		return fmt.Errorf("--oplog is not allowed when --incremental is specified")
+	case dump.OutputOptions.Incremental && dump.ToolOptions.DB != "":
+		return fmt.Errorf("--incremental mode only supported on full dumps")
+	case dump.OutputOptions.Incremental && dump.InputOptions.HasQuery():
+		return fmt.Errorf("cannot dump using a query when --incremental is specified")
+	case dump.OutputOptions.CheckpointFile != "" && !dump.dumpsOplog():
+		return fmt.Errorf("--checkpointFile requires --oplog or --incremental")
 	case len(dump.OutputOptions.ExcludedCollections) > 0 && dump.ToolOptions.Collection != "":
 		return fmt.Errorf("--collection is not allowed when --excludeCollection is specified")
 	case len(dump.OutputOptions.ExcludedCollectionPrefixes) > 0 && dump.ToolOptions.Collection != "":
@@ -119,12 +182,54 @@ func (dump *MongoDump) ValidateOptions() error {
 		return fmt.Errorf("--db is required when --excludeCollection is specified")
 	case len(dump.OutputOptions.ExcludedCollectionPrefixes) > 0 && dump.ToolOptions.DB == "":
 		return fmt.Errorf("--db is required when --excludeCollectionsWithPrefix is specified")
+	case dump.selectsNamespaces() && dump.ToolOptions.Collection != "":
+		return fmt.Errorf("--collection is not allowed when --nsInclude or --nsExclude is specified")
+	case dump.selectsNamespaces() && dump.OutputOptions.Oplog:
+		return fmt.Errorf("--oplog mode only supported on full dumps, not with --nsInclude or --nsExclude")
+	case dump.selectsNamespaces() && dump.OutputOptions.Incremental:
+		return fmt.Errorf(
+			"--incremental mode only supported on full dumps, not with --nsInclude or --nsExclude",
+		)
 	case dump.OutputOptions.Out != "" && dump.OutputOptions.Archive != "":
 		return fmt.Errorf("--out not allowed when --archive is specified")
-	case dump.OutputOptions.Out == "-" && dump.OutputOptions.Gzip:
+	case dump.OutputOptions.ArchiveIndex && dump.OutputOptions.Archive == "":
+		return fmt.Errorf("--archiveIndex requires --archive")
+	case dump.OutputOptions.ArchiveIndex && codec != nil:
+		return fmt.Errorf("--archiveIndex can't be used with compression")
+	case dump.OutputOptions.ArchiveIndex && encrypted:
+		return fmt.Errorf("--archiveIndex can't be used with encryption")
+	case dump.OutputOptions.EncryptionKeyFile != "" && dump.OutputOptions.EncryptionKeyEnv != "":
+		return fmt.Errorf("--encryptionKeyFile can't be used with --encryptionKeyEnv")
+	case dump.OutputOptions.Out == "-" && encrypted:
+		return fmt.Errorf(
+			"encryption can't be used when dumping a single collection to standard output",
+		)
+	case dump.OutputOptions.Out == "-" && codec != nil:
 		return fmt.Errorf(
 			"compression can't be used when dumping a single collection to standard output",
 		)
+	case dump.OutputOptions.Resume && (dump.OutputOptions.Archive != "" || dump.OutputOptions.Out == "-"):
+		return fmt.Errorf("--resume can only be used when dumping to a directory")
+	case dump.OutputOptions.Resume && encrypted:
+		return fmt.Errorf("--resume can't be used with encryption")
+	case dump.OutputOptions.Resume && dump.OutputOptions.Incremental:
+		return fmt.Errorf("--resume can't be used with --incremental")
+	case dump.OutputOptions.Resume && storage.IsURL(dump.OutputOptions.Out):
+		return fmt.Errorf("--resume can't be used when dumping to object storage")
+	case dump.OutputOptions.NumPartitions < 0:
+		return fmt.Errorf("numPartitionsPerCollection can't be negative")
+	case dump.OutputOptions.NumPartitions > 1 && dump.OutputOptions.Out == "-":
+		return fmt.Errorf(
+			"--numPartitionsPerCollection can't be used when dumping to standard output",
+		)
+	case dump.OutputOptions.NumPartitions > 1 && dump.OutputOptions.Resume:
+		return fmt.Errorf("--numPartitionsPerCollection can't be used with --resume")
+	case dump.OutputOptions.SchemaSampleSize < 0:
+		return fmt.Errorf("--schemaSampleSize can't be negative")
+	case dump.OutputOptions.SchemaSummary && dump.OutputOptions.Out == "-":
+		return fmt.Errorf("--schemaSummary can't be used when dumping to standard output")
+	case dump.OutputOptions.ChangeStreamPreImages && dump.OutputOptions.Out == "-":
+		return fmt.Errorf("--changeStreamPreImages can't be used when dumping to standard output")
 	case dump.OutputOptions.NumParallelCollections <= 0:
 		return fmt.Errorf("numParallelCollections must be positive")
 	case dump.isAtlasProxy && (dump.OutputOptions.DumpDBUsersAndRoles || dump.ToolOptions.DB == "admin"):
@@ -167,13 +272,46 @@ func (dump *MongoDump) Init() error {
 	if err != nil {
 		return fmt.Errorf("bad option: %v", err)
 	}
+	err = dump.initNamespaceMatchers()
+	if err != nil {
+		return fmt.Errorf("bad option: %v", err)
+	}
+	dump.codec, err = dump.OutputOptions.Codec()
+	if err != nil {
+		return fmt.Errorf("bad option: %v", err)
+	}
+	dump.encryptionKey, err = dump.OutputOptions.EncryptionKey()
+	if err != nil {
+		return fmt.Errorf("bad option: %v", err)
+	}
+	if dump.encryptionKey != nil {
+		log.Logvf(log.Info, "encrypting output with key ID %v", dump.encryptionKey.ID())
+	}
 	if dump.OutputWriter == nil {
 		dump.OutputWriter = os.Stdout
 	}
+	if dump.OutputOptions.Archive == "" && dump.OutputOptions.Out != "-" {
+		dump.storage, _, err = storage.Parse(context.Background(), dump.OutputOptions.Out)
+		if err != nil {
+			return fmt.Errorf("bad option: %v", err)
+		}
+	}
 
 	if dump.isMongos && dump.OutputOptions.Oplog {
 		return fmt.Errorf("can't use --oplog option when dumping from a mongos")
 	}
+	if dump.isMongos && dump.OutputOptions.Incremental {
+		return fmt.Errorf("can't use --incremental option when dumping from a mongos")
+	}
+	if dump.isMongos && dump.OutputOptions.ChangeStreamPreImages {
+		return fmt.Errorf(
+			"can't use --changeStreamPreImages option when dumping from a mongos, " +
+				"since each shard records its own pre-images",
+		)
+	}
+	if !dump.isMongos && dump.OutputOptions.ShardedOplog {
+		return fmt.Errorf("can only use --shardedOplog option when dumping from a mongos")
+	}
 
 	// warn if we are trying to dump from a secondary in a sharded cluster
 	if dump.isMongos && pref != readpref.Primary() {
@@ -185,6 +323,12 @@ func (dump *MongoDump) Init() error {
 	return nil
 }
 
+// dumpsOplog returns whether this dump captures a slice of the oplog, either
+// alongside the collections with --oplog or on its own with --incremental.
+func (dump *MongoDump) dumpsOplog() bool {
+	return dump.OutputOptions.Oplog || dump.OutputOptions.Incremental
+}
+
 func (dump *MongoDump) verifyCollectionExists() (bool, error) {
 	// Running MongoDump against a DB with no collection specified works. In this case, return true so the process
 	// can continue.
@@ -206,7 +350,20 @@ func (dump *MongoDump) verifyCollectionExists() (bool, error) {
 func (dump *MongoDump) Dump() (err error) {
 	defer dump.SessionProvider.Close()
 
-	if !dump.OutputOptions.Oplog && (dump.InputOptions.SourceWritesDoneBarrier != "") {
+	// The checkpoint must only be written once everything else, including the
+	// archive, has been written successfully. This defer is registered first so
+	// that it runs last.
+	defer func() {
+		if err == nil {
+			err = dump.writeOplogCheckpoint()
+		}
+	}()
+
+	if dump.OutputOptions.ShardedOplog {
+		return dump.DumpShardedCluster()
+	}
+
+	if !dump.dumpsOplog() && (dump.InputOptions.SourceWritesDoneBarrier != "") {
 		// Wait for tests to stop writes before dumping any collections.
 		//
 		// In resmoke testing, the barrier is used to ensure that mongodump captures the correct
@@ -254,6 +411,17 @@ func (dump *MongoDump) Dump() (err error) {
 		}
 		dump.query = query
 	}
+	if dump.InputOptions.QueriesFile != "" {
+		dump.queries, err = readQueriesFile(dump.InputOptions.QueriesFile)
+		if err != nil {
+			return err
+		}
+		for _, query := range dump.queries {
+			if query.projection != nil && dump.OutputOptions.Resume {
+				return fmt.Errorf("a projection of --queriesFile can't be used with --resume")
+			}
+		}
+	}
 
 	// If we enter this case, then we're not connected to an atlas proxy otherwise
 	// mongodump would have errored earlier.
@@ -280,11 +448,16 @@ func (dump *MongoDump) Dump() (err error) {
 		if err != nil {
 			return err
 		}
+		mux := archive.NewMultiplexer(archiveOut, dump.shutdownIntentsNotifier)
+		if dump.OutputOptions.ArchiveIndex {
+			mux.EnableIndex()
+		}
 		dump.archive = &archive.Writer{
-			// The archive.Writer needs its own copy of archiveOut because things
-			// like the prelude are not written by the multiplexer.
-			Out: archiveOut,
-			Mux: archive.NewMultiplexer(archiveOut, dump.shutdownIntentsNotifier),
+			// The archive.Writer needs its own copy of the mux's output because
+			// things like the prelude are not written by the multiplexer. It
+			// must be the same writer so that an index counts the prelude.
+			Out: mux.Out,
+			Mux: mux,
 		}
 		go dump.archive.Mux.Run()
 		defer func() {
@@ -326,19 +499,50 @@ func (dump *MongoDump) Dump() (err error) {
 		log.Logvf(log.Always, "warning, couldn't parse version information from server: %v", err)
 	}
 
+	if dump.OutputOptions.Resume {
+		dump.resume, err = openDumpResume(dump.topLevelDir())
+		if err != nil {
+			return err
+		}
+		if dump.resume.resumed && dump.resume.oplogStart().IsZero() == dump.dumpsOplog() {
+			return fmt.Errorf(
+				"the dump being resumed was made with a different --oplog setting",
+			)
+		}
+	}
+
 	// If oplog capturing is enabled, we first check the most recent
 	// oplog entry and save its timestamp, this will let us later
 	// copy all oplog entries that occurred while dumping, creating
 	// what is effectively a point-in-time snapshot.
-	if dump.OutputOptions.Oplog {
+	//
+	// An incremental dump instead picks up where the previous dump's oplog ended.
+	if dump.dumpsOplog() {
 		err := dump.determineOplogCollectionName()
 		if err != nil {
 			return fmt.Errorf("error finding oplog: %v", err)
 		}
-		log.Logvf(log.Info, "getting most recent oplog timestamp")
-		dump.oplogStart, err = dump.getOplogCopyStartTime()
+		if dump.OutputOptions.Incremental {
+			dump.oplogStart, err = dump.getIncrementalOplogStart()
+			if err != nil {
+				return err
+			}
+		} else if dump.resume != nil && dump.resume.resumed {
+			// The oplog must cover what the earlier attempts dumped too.
+			dump.oplogStart = dump.resume.oplogStart()
+			log.Logvf(log.Always, "resuming oplog window from %v", dump.oplogStart)
+		} else {
+			log.Logvf(log.Info, "getting most recent oplog timestamp")
+			dump.oplogStart, err = dump.getOplogCopyStartTime()
+			if err != nil {
+				return fmt.Errorf("error getting oplog start: %v", err)
+			}
+		}
+	}
+	if dump.resume != nil && !dump.resume.resumed {
+		err = dump.resume.setOplogStart(dump.oplogStart)
 		if err != nil {
-			return fmt.Errorf("error getting oplog start: %v", err)
+			return err
 		}
 	}
 
@@ -354,6 +558,8 @@ func (dump *MongoDump) Dump() (err error) {
 
 	// switch on what kind of execution to do
 	switch {
+	case dump.OutputOptions.Incremental:
+		log.Logvf(log.DebugLow, "incremental dump, skipping collections")
 	case dump.ToolOptions.DB == "" && dump.ToolOptions.Collection == "":
 		err = dump.CreateAllIntents()
 	case dump.ToolOptions.DB != "" && dump.ToolOptions.Collection == "":
@@ -365,7 +571,7 @@ func (dump *MongoDump) Dump() (err error) {
 		return fmt.Errorf("error creating intents to dump: %v", err)
 	}
 
-	if dump.OutputOptions.Oplog {
+	if dump.dumpsOplog() {
 		err = dump.CreateOplogIntents()
 		if err != nil {
 			return err
@@ -382,6 +588,13 @@ func (dump *MongoDump) Dump() (err error) {
 		}
 	}
 
+	if dump.OutputOptions.ChangeStreamPreImages {
+		err = dump.CreatePreImagesIntent()
+		if err != nil {
+			return err
+		}
+	}
+
 	// IO Phase I
 	// metadata, users, roles, and versions
 
@@ -403,6 +616,22 @@ func (dump *MongoDump) Dump() (err error) {
 		if err != nil {
 			return fmt.Errorf("creating archive prelude: %v", err)
 		}
+		if dump.codec != nil {
+			dump.archive.Prelude.Header.Compression = dump.codec.Name
+		}
+		if dump.encryptionKey != nil {
+			dump.archive.Prelude.Header.Encryption = encryption.Cipher
+			dump.archive.Prelude.Header.EncryptionKeyID = dump.encryptionKey.ID()
+		}
+		if dump.archive.Mux.Indexed() {
+			dump.archive.Prelude.Header.FormatVersion = archive.IndexedFormatVersion
+		}
+		if dump.OutputOptions.SchemaSummary {
+			err = dump.addSchemasToPrelude()
+			if err != nil {
+				return fmt.Errorf("error sampling schemas: %v", err)
+			}
+		}
 		err = dump.archive.Prelude.Write(dump.archive.Out)
 		if err != nil {
 			return fmt.Errorf("error writing metadata into archive: %v", err)
@@ -411,7 +640,7 @@ func (dump *MongoDump) Dump() (err error) {
 
 	// Dump users and roles only if these settings are not configured to be skipped,
 	// and mongodump isn't connected to an atlas proxy.
-	if !dump.SkipUsersAndRoles && !dump.isAtlasProxy {
+	if !dump.SkipUsersAndRoles && !dump.isAtlasProxy && !dump.OutputOptions.Incremental {
 		if dump.ToolOptions.DB == "admin" || dump.ToolOptions.DB == "" {
 			err = dump.DumpUsersAndRoles()
 			if err != nil {
@@ -437,6 +666,9 @@ func (dump *MongoDump) Dump() (err error) {
 	// TODO, either remove this debug or improve the language
 	log.Logvf(log.DebugHigh, "dump phase II: regular collections")
 
+	// the intents are gone once they're dumped
+	dump.viewDependencies = viewDependencies(dump.manager.Intents())
+
 	// begin dumping intents
 	if err := dump.DumpIntents(); err != nil {
 		return err
@@ -448,7 +680,7 @@ func (dump *MongoDump) Dump() (err error) {
 	// TODO, either remove this debug or improve the language
 	log.Logvf(log.DebugLow, "dump phase III: the oplog")
 
-	if dump.OutputOptions.Oplog {
+	if dump.dumpsOplog() {
 		if dump.InputOptions.SourceWritesDoneBarrier != "" {
 			// Wait for tests to stop writes before choosing the oplogEnd time.
 			//
@@ -461,7 +693,7 @@ func (dump *MongoDump) Dump() (err error) {
 				return err
 			}
 		}
-		dump.oplogEnd, err = dump.getCurrentOplogTime()
+		dump.oplogEnd, err = dump.getOplogEnd()
 		if err != nil {
 			return fmt.Errorf("error getting oplog end: %v", err)
 		}
@@ -511,6 +743,17 @@ func (dump *MongoDump) Dump() (err error) {
 		if err != nil {
 			return fmt.Errorf("failed to dump top level metadata: %v", err)
 		}
+		err = dump.DumpManifest()
+		if err != nil {
+			return fmt.Errorf("failed to dump manifest: %v", err)
+		}
+	}
+
+	if dump.resume != nil {
+		err = dump.resume.remove()
+		if err != nil {
+			return err
+		}
 	}
 
 	log.Logvf(log.DebugLow, "finishing dump")
@@ -532,13 +775,40 @@ func (w closableBufioWriter) Close() error {
 	return w.Flush()
 }
 
+// encryptedOutputBuffer encrypts the output of another resettableOutputBuffer.
+type encryptedOutputBuffer struct {
+	resettableOutputBuffer
+	encrypter *encryption.Writer
+}
+
+func (b *encryptedOutputBuffer) Reset(w io.Writer) {
+	b.encrypter.Reset(w)
+	b.resettableOutputBuffer.Reset(b.encrypter)
+}
+
+func (b *encryptedOutputBuffer) Close() error {
+	err := b.resettableOutputBuffer.Close()
+	encryptErr := b.encrypter.Close()
+	if err != nil {
+		return err
+	}
+	return encryptErr
+}
+
 func (dump *MongoDump) getResettableOutputBuffer() resettableOutputBuffer {
 	if dump.OutputOptions.Archive != "" {
 		return nil
-	} else if dump.OutputOptions.Gzip {
-		return gzip.NewWriter(nil)
 	}
-	return &closableBufioWriter{bufio.NewWriter(nil)}
+	var buffer resettableOutputBuffer
+	if dump.codec != nil {
+		buffer = dump.codec.NewWriter(nil)
+	} else {
+		buffer = &closableBufioWriter{bufio.NewWriter(nil)}
+	}
+	if dump.encryptionKey != nil {
+		return &encryptedOutputBuffer{buffer, dump.encryptionKey.NewWriter(nil)}
+	}
+	return buffer
 }
 
 // DumpIntents iterates through the previously-created intents and
@@ -549,10 +819,15 @@ func (dump *MongoDump) DumpIntents() error {
 		jobs = numIntents
 	}
 
+	priority := intents.Legacy
 	if jobs > 1 {
-		dump.manager.Finalize(intents.LongestTaskFirst)
+		priority = intents.LongestTaskFirst
+	}
+	if dump.OutputOptions.Archive != "" {
+		// mongorestore creates the views of an archive in the order they're in it
+		dump.manager.FinalizeWithViewDependencies(priority)
 	} else {
-		dump.manager.Finalize(intents.Legacy)
+		dump.manager.Finalize(priority)
 	}
 
 	resultChan := make(chan error, jobs)
@@ -612,7 +887,8 @@ func (dump *MongoDump) DumpIntent(intent *intents.Intent, buffer resettableOutpu
 	}
 
 	findQuery := &db.DeferredQuery{Coll: coll}
-	if len(dump.query) > 0 {
+	filter, projection := dump.queryFor(intent)
+	if len(filter) > 0 {
 		if intent.IsTimeseries() {
 			timeseriesOptions, err := bsonutil.FindSubdocumentByKey("timeseries", &intent.Options)
 			if err != nil {
@@ -630,21 +906,24 @@ func (dump *MongoDump) DumpIntent(intent *intents.Intent, buffer resettableOutpu
 					intent.Namespace(),
 				)
 			}
-			for i, predicate := range dump.query {
+			// the filter may be shared by other collections, so it's copied
+			// rather than rewritten in place
+			filter = slices.Clone(filter)
+			for i, predicate := range filter {
 				splitPredicateKey := strings.SplitN(predicate.Key, ".", 2)
 				if splitPredicateKey[0] != metaKey {
 					return fmt.Errorf("cannot process query %v for timeseries collection %#q. "+
-						"mongodump only processes queries on metadata fields for timeseries collections.", dump.query, intent.Namespace())
+						"mongodump only processes queries on metadata fields for timeseries collections.", filter, intent.Namespace())
 				}
 				if len(splitPredicateKey) > 1 {
-					dump.query[i].Key = "meta." + splitPredicateKey[1]
+					filter[i].Key = "meta." + splitPredicateKey[1]
 				} else {
-					dump.query[i].Key = "meta"
+					filter[i].Key = "meta"
 				}
 
 			}
 		}
-		findQuery.Filter = dump.query
+		findQuery.Filter = filter
 	} else {
 		// An unfiltered dump is a deliberate full collection scan. Saying so with a $natural
 		// hint exempts it from the server's maxEstimatedScanBytes rejection (SERVER-127688)
@@ -652,6 +931,42 @@ func (dump *MongoDump) DumpIntent(intent *intents.Intent, buffer resettableOutpu
 		// when there is a filter, which could otherwise be served by an index.
 		findQuery.Hint = bson.D{{"$natural", 1}}
 	}
+	if projection != nil {
+		if intent.IsTimeseries() {
+			return fmt.Errorf(
+				"cannot use a projection for timeseries collection %#q",
+				intent.Namespace(),
+			)
+		}
+		findQuery.Projection = projection
+	}
+
+	var validator documentValidator
+	if dump.resume != nil {
+		resumed := dump.resume.namespace(intent.Namespace())
+		bsonFile, isFile := intent.BSONFile.(*realBSONFile)
+		if resumed.Complete && isFile {
+			log.Logvf(log.Always, "skipping %#q, which was already dumped", intent.DataNamespace())
+			return bsonFile.reuse(resumed.Documents)
+		}
+		if !intent.IsView() {
+			// A resumable dump goes in _id order so that it can continue after
+			// the last _id that was dumped.
+			findQuery.Hint = bson.D{{"_id", 1}}
+			findQuery.Sort = bson.D{{"_id", 1}}
+			if resumed.LastID != nil && isFile {
+				log.Logvf(
+					log.Always,
+					"resuming %#q after %v %v",
+					intent.DataNamespace(),
+					resumed.Documents,
+					docPlural(resumed.Documents),
+				)
+				validator = resumeAfter(findQuery, resumed.LastID)
+				bsonFile.resumed = resumed
+			}
+		}
+	}
 
 	var dumpCount int64
 
@@ -665,10 +980,33 @@ func (dump *MongoDump) DumpIntent(intent *intents.Intent, buffer resettableOutpu
 		return err
 	}
 
-	log.Logvf(log.Always, "writing %#q to %#q", intent.DataNamespace(), intent.Location)
-	if dumpCount, err = dump.dumpQueryToIntent(findQuery, intent, buffer); err != nil {
+	bounds, err := dump.partitionBounds(intent, coll)
+	if err != nil {
+		return err
+	}
+	sampler := dump.schemaSamplerFor(intent)
+	if len(bounds) > 0 {
+		log.Logvf(
+			log.Always,
+			"writing %#q to %#q in %v partitions",
+			intent.DataNamespace(),
+			intent.Location,
+			len(bounds)+1,
+		)
+		dumpCount, err = dump.dumpPartitionsToIntent(findQuery, intent, bounds, sampler)
+	} else {
+		log.Logvf(log.Always, "writing %#q to %#q", intent.DataNamespace(), intent.Location)
+		dumpCount, err = dump.dumpValidatedQueryToIntent(findQuery, intent, buffer, validator, sampler)
+	}
+	if err != nil {
 		return err
 	}
+	if sampler != nil {
+		err = dump.writeSchema(intent, sampler)
+		if err != nil {
+			return err
+		}
+	}
 
 	log.Logvf(
 		log.Always,
@@ -681,9 +1019,12 @@ func (dump *MongoDump) DumpIntent(intent *intents.Intent, buffer resettableOutpu
 }
 
 // documentValidator represents a callback used to validate individual documents. It takes a slice of bytes for a
-// BSON document and returns a non-nil error if the document is not valid.
+// BSON document and returns a non-nil error if the document is not valid, or errSkipDocument if it isn't dumped.
 type documentValidator func([]byte) error
 
+// errSkipDocument is returned by a documentValidator for a document that is left out of the dump.
+var errSkipDocument = errors.New("skip document")
+
 // dumpQueryToIntent takes an mgo Query, its intent, and a writer, performs the query,
 // and writes the raw bson results to the writer. Returns a final count of documents
 // dumped, and any errors that occurred.
@@ -692,13 +1033,13 @@ func (dump *MongoDump) dumpQueryToIntent(
 	intent *intents.Intent,
 	buffer resettableOutputBuffer,
 ) (dumpCount int64, err error) {
-	return dump.dumpValidatedQueryToIntent(query, intent, buffer, nil)
+	return dump.dumpValidatedQueryToIntent(query, intent, buffer, nil, nil)
 }
 
 // getCount counts the number of documents in the namespace for the given intent. It does not run the count for
 // the oplog collection to avoid the performance issue in TOOLS-2068.
 func (dump *MongoDump) getCount(query *db.DeferredQuery, intent *intents.Intent) (int64, error) {
-	if len(dump.query) != 0 || intent.IsOplog() {
+	if filter, _ := dump.queryFor(intent); len(filter) != 0 || intent.IsOplog() {
 		log.Logvf(log.DebugLow, "not counting query on %#q", intent.Namespace())
 		return 0, nil
 	}
@@ -728,14 +1069,25 @@ func (dump *MongoDump) getCount(query *db.DeferredQuery, intent *intents.Intent)
 
 // dumpValidatedQueryToIntent takes an mgo Query, its intent, a writer, and a document validator, performs the query,
 // validates the results with the validator,
-// and writes the raw bson results to the writer. Returns a final count of documents
-// dumped, and any errors that occurred.
+// and writes the raw bson results to the writer, sampling them with sampler if it isn't nil.
+// Returns a final count of documents dumped, and any errors that occurred.
 func (dump *MongoDump) dumpValidatedQueryToIntent(
 	query *db.DeferredQuery,
 	intent *intents.Intent,
 	buffer resettableOutputBuffer,
 	validator documentValidator,
+	sampler *dumprestore.SchemaSampler,
 ) (dumpCount int64, err error) {
+	// progress is recorded once the files are closed, by this first deferred call
+	var last *lastIDWriter
+	flushed := true
+	bsonFile, isFile := intent.BSONFile.(*realBSONFile)
+	if dump.resume != nil && isFile && !intent.IsOplog() {
+		last = &lastIDWriter{}
+		defer func() {
+			dump.resume.record(intent, bsonFile.path, last, dumpCount, err, flushed)
+		}()
+	}
 
 	// restore of views from archives require an empty collection as the trigger to create the view
 	// so, we open here before the early return if IsView so that we write an empty collection to the archive
@@ -745,6 +1097,9 @@ func (dump *MongoDump) dumpValidatedQueryToIntent(
 	}
 	defer func() {
 		closeErr := intent.BSONFile.Close()
+		if closeErr != nil {
+			flushed = false
+		}
 		if err == nil && closeErr != nil {
 			err = fmt.Errorf(
 				"error writing data for collection %#q to disk: %v",
@@ -776,6 +1131,9 @@ func (dump *MongoDump) dumpValidatedQueryToIntent(
 		f = buffer
 		defer func() {
 			closeErr := buffer.Close()
+			if closeErr != nil {
+				flushed = false
+			}
 			if err == nil && closeErr != nil {
 				err = fmt.Errorf(
 					"error writing data for collection %#q to disk: %v",
@@ -790,8 +1148,17 @@ func (dump *MongoDump) dumpValidatedQueryToIntent(
 	if err != nil {
 		return
 	}
-	err = dump.dumpValidatedIterToWriter(cursor, f, dumpProgressor, validator)
+	if last != nil {
+		last.Writer = f
+		last.lastID = bsonFile.resumed.LastID
+		f = last
+		dumpProgressor.Set(bsonFile.resumed.Documents)
+	}
+	err = dump.dumpValidatedIterToWriter(cursor, f, dumpProgressor, validator, sampler)
 	dumpCount, _ = dumpProgressor.Progress()
+	if isFile {
+		bsonFile.documents = dumpCount
+	}
 	if err != nil {
 		err = fmt.Errorf(
 			"error writing data for collection %#q to disk: %v",
@@ -803,12 +1170,13 @@ func (dump *MongoDump) dumpValidatedQueryToIntent(
 }
 
 // dumpValidatedIterToWriter takes a cursor, a writer, an Updateable object, and a documentValidator and validates and
-// dumps the iterator's contents to the writer.
+// dumps the iterator's contents to the writer. The documents are also added to sampler, if it isn't nil.
 func (dump *MongoDump) dumpValidatedIterToWriter(
 	iter *mongo.Cursor,
 	writer io.Writer,
 	progressCount progress.Updateable,
 	validator documentValidator,
+	sampler *dumprestore.SchemaSampler,
 ) error {
 	defer iter.Close(context.Background())
 	var termErr error
@@ -836,7 +1204,11 @@ func (dump *MongoDump) dumpValidatedIterToWriter(
 				}
 
 				if validator != nil {
-					if err := validator(iter.Current); err != nil {
+					err := validator(iter.Current)
+					if err == errSkipDocument {
+						continue
+					}
+					if err != nil {
 						termErr = err
 						close(buffChan)
 						return
@@ -864,6 +1236,9 @@ func (dump *MongoDump) dumpValidatedIterToWriter(
 		if err != nil {
 			return fmt.Errorf("error writing to file: %v", err)
 		}
+		if err := sampler.Add(buff); err != nil {
+			return fmt.Errorf("error sampling schema: %v", err)
+		}
 		progressCount.Inc(1)
 	}
 	return termErr
@@ -952,35 +1327,48 @@ func (dump *MongoDump) DumpMetadata() error {
 }
 
 type PreludeData struct {
-	ServerVersion string `json:"ServerVersion"`
-	ToolVersion   string `json:"ToolVersion"`
+	ServerVersion    string              `json:"ServerVersion"`
+	ToolVersion      string              `json:"ToolVersion"`
+	ViewDependencies map[string][]string `json:"ViewDependencies,omitempty"`
+	// Partitions are the number of files that each collection split with
+	// --numPartitionsPerCollection was dumped to.
+	Partitions map[string]int `json:"Partitions,omitempty"`
+}
+
+// viewDependencies returns the namespaces that each view of allIntents reads
+// from, by the namespace of the view.
+func viewDependencies(allIntents []*intents.Intent) map[string][]string {
+	var dependencies map[string][]string
+	for _, intent := range allIntents {
+		sources := intent.ViewSources()
+		if len(sources) == 0 {
+			continue
+		}
+		if dependencies == nil {
+			dependencies = map[string][]string{}
+		}
+		dependencies[intent.Namespace()] = sources
+		log.Logvf(log.DebugLow, "view %#q reads from %v", intent.Namespace(), sources)
+	}
+	return dependencies
 }
 
 // DumpPreludeMetadata dumps information about the server and the dump in json format
-// Currently only writes the server version and tool version, but we can use this to write other metadata about the dump in the future.
+// Currently writes the server version, the tool version, the namespaces that each view reads from and the number of
+// partitions of the partitioned collections, but we can use this to write other metadata about the dump in the future.
 func (dump *MongoDump) DumpPreludeMetadata() error {
 	preludeData := PreludeData{
-		ServerVersion: dump.serverVersion,
-		ToolVersion:   dump.ToolOptions.VersionStr,
+		ServerVersion:    dump.serverVersion,
+		ToolVersion:      dump.ToolOptions.VersionStr,
+		ViewDependencies: dump.viewDependencies,
+		Partitions:       dump.partitionCounts(),
 	}
 
-	filename := "prelude.json"
-
-	if dump.ToolOptions.DB != "" {
-		filename = filepath.Join(dump.ToolOptions.DB, filename)
-	}
-	if dump.OutputOptions.Out == "" {
-		filename = filepath.Join("dump", filename)
-	} else {
-		filename = filepath.Join(dump.OutputOptions.Out, filename)
-	}
-	if dump.OutputOptions.Gzip {
-		filename += ".gz"
-	}
+	filename := dump.codec.FileName(filepath.Join(dump.topLevelDir(), "prelude.json"))
 
 	log.Logvf(log.DebugLow, "dumping prelude metadata to file %#q", filename)
 
-	file, err := os.Create(filename)
+	file, err := storage.OrLocal(dump.storage).Create(filename)
 	if errors.Is(err, os.ErrNotExist) {
 		// if parent directory doesn't exist, there was no data to dump, don't write prelude.json
 		log.Logvf(log.DebugLow, "parent directory does not exist, not writing %#q", filename)
@@ -990,17 +1378,18 @@ func (dump *MongoDump) DumpPreludeMetadata() error {
 	}
 	defer file.Close()
 
-	var writer io.WriteCloser = file
-	if dump.OutputOptions.Gzip {
-		writer = gzip.NewWriter(file)
-		defer writer.Close()
-	}
+	writer := dump.getResettableOutputBuffer()
+	writer.Reset(file)
+
 	bytes, err := json.Marshal(preludeData)
 	if err != nil {
 		return fmt.Errorf("error marshaling prelude data: %w", err)
 	}
 
 	_, err = writer.Write(bytes)
+	if err == nil {
+		err = writer.Close()
+	}
 	if err != nil {
 		return fmt.Errorf("failed to write prelude metadata to file %#q: %w", filename, err)
 	}
@@ -1008,6 +1397,19 @@ func (dump *MongoDump) DumpPreludeMetadata() error {
 	return nil
 }
 
+// topLevelDir returns the directory that prelude.json and the manifest are
+// written to, which is the database's directory when a single database is dumped.
+func (dump *MongoDump) topLevelDir() string {
+	dir := storage.Path(dump.OutputOptions.Out)
+	if dump.OutputOptions.Out == "" {
+		dir = "dump"
+	}
+	if dump.ToolOptions.DB != "" {
+		dir = filepath.Join(dir, dump.ToolOptions.DB)
+	}
+	return dir
+}
+
 // nopCloseWriter implements io.WriteCloser. It wraps up a io.Writer, and adds a no-op Close.
 type nopCloseWriter struct {
 	io.Writer
@@ -1021,14 +1423,24 @@ func (*nopCloseWriter) Close() error {
 func (dump *MongoDump) getArchiveOut() (out io.WriteCloser, err error) {
 	if dump.OutputOptions.Archive == "-" {
 		out = &nopCloseWriter{dump.OutputWriter}
+	} else if storage.IsURL(dump.OutputOptions.Archive) {
+		backend, name, err := storage.Parse(context.Background(), dump.OutputOptions.Archive)
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			name = dump.codec.FileName("archive")
+		}
+		out, err = backend.Create(name)
+		if err != nil {
+			return nil, err
+		}
 	} else {
 		targetStat, err := os.Stat(dump.OutputOptions.Archive)
 		if err == nil && targetStat.IsDir() {
-			defaultArchiveFilePath :=
-				filepath.Join(dump.OutputOptions.Archive, "archive")
-			if dump.OutputOptions.Gzip {
-				defaultArchiveFilePath = defaultArchiveFilePath + ".gz"
-			}
+			defaultArchiveFilePath := dump.codec.FileName(
+				filepath.Join(dump.OutputOptions.Archive, "archive"),
+			)
 			out, err = os.Create(defaultArchiveFilePath)
 			if err != nil {
 				return nil, err
@@ -1040,8 +1452,11 @@ func (dump *MongoDump) getArchiveOut() (out io.WriteCloser, err error) {
 			}
 		}
 	}
-	if dump.OutputOptions.Gzip {
-		return &util.WrappedWriteCloser{gzip.NewWriter(out), out}, nil
+	if dump.encryptionKey != nil {
+		out = &util.WrappedWriteCloser{dump.encryptionKey.NewWriter(out), out}
+	}
+	if dump.codec != nil {
+		return &util.WrappedWriteCloser{dump.codec.NewWriter(out), out}, nil
 	}
 	return out, nil
 }
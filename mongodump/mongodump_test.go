@@ -27,7 +27,9 @@ import (
 	"github.com/mongodb/mongo-tools/common"
 	"github.com/mongodb/mongo-tools/common/archive"
 	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/compression"
 	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/encryption"
 	"github.com/mongodb/mongo-tools/common/failpoint"
 	"github.com/mongodb/mongo-tools/common/json"
 	"github.com/mongodb/mongo-tools/common/log"
@@ -621,6 +623,205 @@ func TestMongoDumpValidateOptions(t *testing.T) {
 			"cannot dump using a query without a specified collection",
 		)
 	})
+
+	t.Run("incremental without checkpoint file", func(t *testing.T) {
+		md, err := simpleMongoDumpInstance()
+		require.NoError(t, err)
+
+		md.ToolOptions.DB = ""
+		md.OutputOptions.Incremental = true
+
+		err = md.ValidateOptions()
+		require.Error(t, err)
+		assert.ErrorContains(
+			t,
+			err,
+			"--checkpointFile is required when --incremental is specified",
+		)
+	})
+
+	t.Run("incremental with oplog", func(t *testing.T) {
+		md, err := simpleMongoDumpInstance()
+		require.NoError(t, err)
+
+		md.ToolOptions.DB = ""
+		md.OutputOptions.Incremental = true
+		md.OutputOptions.Oplog = true
+		md.OutputOptions.CheckpointFile = "checkpoint.json"
+
+		err = md.ValidateOptions()
+		require.Error(t, err)
+		assert.ErrorContains(
+			t,
+			err,
+			"--oplog is not allowed when --incremental is specified",
+		)
+	})
+
+	t.Run("incremental with db", func(t *testing.T) {
+		md, err := simpleMongoDumpInstance()
+		require.NoError(t, err)
+
+		md.OutputOptions.Incremental = true
+		md.OutputOptions.CheckpointFile = "checkpoint.json"
+
+		err = md.ValidateOptions()
+		require.Error(t, err)
+		assert.ErrorContains(
+			t,
+			err,
+			"--incremental mode only supported on full dumps",
+		)
+	})
+
+	t.Run("checkpoint file without oplog", func(t *testing.T) {
+		md, err := simpleMongoDumpInstance()
+		require.NoError(t, err)
+
+		md.ToolOptions.DB = ""
+		md.OutputOptions.CheckpointFile = "checkpoint.json"
+
+		err = md.ValidateOptions()
+		require.Error(t, err)
+		assert.ErrorContains(
+			t,
+			err,
+			"--checkpointFile requires --oplog or --incremental",
+		)
+	})
+
+	t.Run("unknown compression codec", func(t *testing.T) {
+		md, err := simpleMongoDumpInstance()
+		require.NoError(t, err)
+
+		md.OutputOptions.Compress = "lz4"
+
+		err = md.ValidateOptions()
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "unknown compression codec")
+	})
+
+	t.Run("gzip with another compression codec", func(t *testing.T) {
+		md, err := simpleMongoDumpInstance()
+		require.NoError(t, err)
+
+		md.OutputOptions.Gzip = true
+		md.OutputOptions.Compress = "zstd"
+
+		err = md.ValidateOptions()
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "--gzip can't be used with --compress=zstd")
+	})
+
+	t.Run("compression to stdout", func(t *testing.T) {
+		md, err := simpleMongoDumpInstance()
+		require.NoError(t, err)
+
+		md.ToolOptions.Collection = "some_collection"
+		md.OutputOptions.Out = "-"
+		md.OutputOptions.Compress = "snappy"
+
+		err = md.ValidateOptions()
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "compression can't be used")
+	})
+
+	t.Run("incremental with checkpoint file", func(t *testing.T) {
+		md, err := simpleMongoDumpInstance()
+		require.NoError(t, err)
+
+		md.ToolOptions.DB = ""
+		md.OutputOptions.Incremental = true
+		md.OutputOptions.CheckpointFile = "checkpoint.json"
+
+		require.NoError(t, md.ValidateOptions())
+	})
+
+	t.Run("archive index without archive", func(t *testing.T) {
+		md, err := simpleMongoDumpInstance()
+		require.NoError(t, err)
+
+		md.OutputOptions.ArchiveIndex = true
+
+		err = md.ValidateOptions()
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "--archiveIndex requires --archive")
+	})
+
+	t.Run("archive index with compression", func(t *testing.T) {
+		md, err := simpleMongoDumpInstance()
+		require.NoError(t, err)
+
+		md.OutputOptions.Out = ""
+		md.OutputOptions.Archive = "dump.archive"
+		md.OutputOptions.ArchiveIndex = true
+		md.OutputOptions.Gzip = true
+
+		err = md.ValidateOptions()
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "--archiveIndex can't be used with compression")
+	})
+
+	t.Run("encryption key file and environment variable", func(t *testing.T) {
+		md, err := simpleMongoDumpInstance()
+		require.NoError(t, err)
+
+		md.OutputOptions.EncryptionKeyFile = "dump.key"
+		md.OutputOptions.EncryptionKeyEnv = "DUMP_KEY"
+
+		err = md.ValidateOptions()
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "--encryptionKeyFile can't be used with --encryptionKeyEnv")
+	})
+
+	t.Run("encryption to stdout", func(t *testing.T) {
+		md, err := simpleMongoDumpInstance()
+		require.NoError(t, err)
+
+		md.ToolOptions.Collection = "some_collection"
+		md.OutputOptions.Out = "-"
+		md.OutputOptions.EncryptionKeyEnv = "DUMP_KEY"
+
+		err = md.ValidateOptions()
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "encryption can't be used")
+	})
+}
+
+func TestEncryptedOutputBuffer(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	key, err := encryption.NewKey(bytes.Repeat([]byte{1}, encryption.KeySize))
+	require.NoError(t, err)
+
+	for _, codec := range []*compression.Codec{nil, compression.Zstd} {
+		dump := &MongoDump{
+			OutputOptions: &OutputOptions{},
+			codec:         codec,
+			encryptionKey: key,
+		}
+		buffer := dump.getResettableOutputBuffer()
+
+		// The buffer is reused for each file, and must start a new stream each time.
+		for _, content := range []string{"first file", "second file"} {
+			var file bytes.Buffer
+			buffer.Reset(&file)
+			_, err = buffer.Write([]byte(content))
+			require.NoError(t, err)
+			require.NoError(t, buffer.Close())
+
+			decrypted, err := encryption.Decrypt(&file, key)
+			require.NoError(t, err)
+			plaintext := io.NopCloser(decrypted)
+			if codec != nil {
+				plaintext, err = codec.NewReader(decrypted)
+				require.NoError(t, err)
+			}
+			written, err := io.ReadAll(plaintext)
+			require.NoError(t, err)
+			assert.Equal(t, content, string(written), "codec %v", codec)
+		}
+	}
 }
 
 func TestMongoDumpConnectedToAtlasProxy(t *testing.T) {
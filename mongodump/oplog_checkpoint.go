@@ -0,0 +1,130 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mongodb/mongo-tools/common/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// OplogCheckpoint records the oplog window captured by a dump. It is written to
+// --checkpointFile after a successful --oplog or --incremental dump, and it tells
+// the next --incremental dump where to resume.
+//
+// An incremental dump starts at OplogEnd inclusively, so the first entry of each
+// incremental oplog is the same entry as the last one of the previous dump. This
+// shared entry is what lets mongorestore prove that a chain of oplogs has no gaps.
+type OplogCheckpoint struct {
+	OplogStart    bson.Timestamp `bson:"oplogStart"`
+	OplogEnd      bson.Timestamp `bson:"oplogEnd"`
+	ServerVersion string         `bson:"serverVersion"`
+	ToolVersion   string         `bson:"toolVersion"`
+}
+
+// ReadOplogCheckpoint reads and parses the checkpoint file at the given path.
+func ReadOplogCheckpoint(path string) (*OplogCheckpoint, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoint file %#q: %w", path, err)
+	}
+
+	checkpoint := &OplogCheckpoint{}
+	err = bson.UnmarshalExtJSON(content, true, checkpoint)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing checkpoint file %#q: %w", path, err)
+	}
+	if checkpoint.OplogEnd.IsZero() {
+		return nil, fmt.Errorf("checkpoint file %#q does not contain an oplogEnd timestamp", path)
+	}
+
+	return checkpoint, nil
+}
+
+// Write saves the checkpoint to the given path. The file is written next to its
+// final location and then renamed, so an interrupted write never leaves a
+// truncated checkpoint behind.
+func (checkpoint *OplogCheckpoint) Write(path string) error {
+	content, err := bson.MarshalExtJSONIndent(checkpoint, true, false, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error marshaling checkpoint: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating checkpoint file %#q: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing checkpoint file %#q: %w", path, err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("error writing checkpoint file %#q: %w", path, err)
+	}
+
+	log.Logvf(log.DebugLow, "wrote oplog checkpoint %v to %#q", checkpoint.OplogEnd, path)
+	return nil
+}
+
+// getIncrementalOplogStart reads --checkpointFile and returns the timestamp an
+// incremental dump should start from. It fails if that entry has already rolled
+// off the oplog, since the incremental dump would then leave a gap.
+func (dump *MongoDump) getIncrementalOplogStart() (bson.Timestamp, error) {
+	checkpoint, err := ReadOplogCheckpoint(dump.OutputOptions.CheckpointFile)
+	if err != nil {
+		return bson.Timestamp{}, err
+	}
+	log.Logvf(log.Always, "resuming oplog dump from checkpoint %v", checkpoint.OplogEnd)
+
+	exists, err := dump.checkOplogTimestampExists(checkpoint.OplogEnd)
+	if err != nil {
+		return bson.Timestamp{}, fmt.Errorf("unable to check oplog for checkpoint: %v", err)
+	}
+	if !exists {
+		return bson.Timestamp{}, fmt.Errorf(
+			"oplog overflow: oplog entry %v from checkpoint %#q no longer exists; "+
+				"take a new full dump with --oplog",
+			checkpoint.OplogEnd,
+			dump.OutputOptions.CheckpointFile,
+		)
+	}
+
+	return checkpoint.OplogEnd, nil
+}
+
+// writeOplogCheckpoint records the oplog window of the current dump in
+// --checkpointFile, if one was given.
+func (dump *MongoDump) writeOplogCheckpoint() error {
+	// No oplog end means the dump returned early without capturing any oplog.
+	if dump.OutputOptions.CheckpointFile == "" || dump.oplogEnd.IsZero() {
+		return nil
+	}
+
+	checkpoint := OplogCheckpoint{
+		OplogStart:    dump.oplogStart,
+		OplogEnd:      dump.oplogEnd,
+		ServerVersion: dump.serverVersion,
+		ToolVersion:   dump.ToolOptions.VersionStr,
+	}
+
+	log.Logvf(log.Always, "writing oplog checkpoint %v to %#q",
+		checkpoint.OplogEnd, dump.OutputOptions.CheckpointFile)
+	return checkpoint.Write(dump.OutputOptions.CheckpointFile)
+}
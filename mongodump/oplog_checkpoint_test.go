@@ -0,0 +1,67 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestOplogCheckpointRoundTrip(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	checkpoint := OplogCheckpoint{
+		OplogStart:    bson.Timestamp{T: 100, I: 1},
+		OplogEnd:      bson.Timestamp{T: 200, I: 3},
+		ServerVersion: "8.0.0",
+		ToolVersion:   "100.0.0",
+	}
+	require.NoError(t, checkpoint.Write(path))
+
+	read, err := ReadOplogCheckpoint(path)
+	require.NoError(t, err)
+	assert.Equal(t, checkpoint, *read)
+
+	// Overwriting an existing checkpoint leaves no temporary files behind.
+	checkpoint.OplogStart = checkpoint.OplogEnd
+	checkpoint.OplogEnd = bson.Timestamp{T: 300, I: 1}
+	require.NoError(t, checkpoint.Write(path))
+
+	read, err = ReadOplogCheckpoint(path)
+	require.NoError(t, err)
+	assert.Equal(t, checkpoint, *read)
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestReadOplogCheckpointErrors(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	dir := t.TempDir()
+
+	_, err := ReadOplogCheckpoint(filepath.Join(dir, "missing.json"))
+	assert.ErrorContains(t, err, "error reading checkpoint file")
+
+	path := filepath.Join(dir, "empty.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"serverVersion": "8.0.0"}`), 0o644))
+	_, err = ReadOplogCheckpoint(path)
+	assert.ErrorContains(t, err, "does not contain an oplogEnd timestamp")
+
+	path = filepath.Join(dir, "garbage.json")
+	require.NoError(t, os.WriteFile(path, []byte(`not json`), 0o644))
+	_, err = ReadOplogCheckpoint(path)
+	assert.ErrorContains(t, err, "error parsing checkpoint file")
+}
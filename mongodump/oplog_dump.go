@@ -215,6 +215,7 @@ func (dump *MongoDump) DumpOplogBetweenTimestamps(start, end bson.Timestamp) err
 		dump.manager.Oplog(),
 		dump.getResettableOutputBuffer(),
 		oplogDocumentValidator,
+		nil,
 	)
 	if err == nil {
 		log.Logvf(log.Always, "\tdumped %v oplog %v",
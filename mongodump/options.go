@@ -10,6 +10,8 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/encryption"
 	"github.com/mongodb/mongo-tools/common/options"
 )
 
@@ -27,6 +29,7 @@ See http://docs.mongodb.com/database-tools/mongodump/ for more information.`
 type InputOptions struct {
 	Query                   string `long:"query" short:"q" description:"query filter, as a v2 Extended JSON string, e.g., '{\"x\":{\"$gt\":1}}'"`
 	QueryFile               string `long:"queryFile" description:"path to a file containing a query filter (v2 Extended JSON)"`
+	QueriesFile             string `long:"queriesFile" value-name:"<file-path>" description:"path to a JSON file of filters and projections (v2 Extended JSON) for the namespaces that match its namespace patterns, which lets a single dump filter many collections"`
 	ReadPreference          string `long:"readPreference" value-name:"<string>|<json>" description:"specify either a preference mode (e.g. 'nearest') or a preference json object (e.g. '{mode: \"nearest\", tagSets: [{a: \"b\"}], maxStalenessSeconds: 123}')"`
 	TableScan               bool   `long:"forceTableScan" description:"force a table scan (do not use $snapshot or hint _id). Deprecated since this is default behavior on WiredTiger"`
 	SourceWritesDoneBarrier string `long:"internalOnlySourceWritesDoneBarrier" hidden:"true"`
@@ -56,15 +59,29 @@ func (inputOptions *InputOptions) GetQuery() ([]byte, error) {
 
 // OutputOptions defines the set of options for writing dump data.
 type OutputOptions struct {
-	Out                        string   `long:"out" value-name:"<directory-path>" short:"o" description:"output directory, or '-' for stdout (default: 'dump')"`
+	Out                        string   `long:"out" value-name:"<directory-path>" short:"o" description:"output directory, an s3://<bucket>/<prefix> URL of object storage, or '-' for stdout (default: 'dump')"`
 	Gzip                       bool     `long:"gzip" description:"compress archive or collection output with Gzip"`
-	Oplog                      bool     `long:"oplog" description:"for taking a point-in-time snapshot on a replica set that is not part of a sharded cluster."`
-	Archive                    string   `long:"archive" value-name:"<file-path>" optional:"true" optional-value:"-" description:"dump as an archive to the specified path. If flag is specified without a value, archive is written to stdout"`
+	Compress                   string   `long:"compress" value-name:"<codec>" description:"compress archive or collection output with the given codec: gzip, zstd, or snappy"`
+	EncryptionKeyFile          string   `long:"encryptionKeyFile" value-name:"<file-path>" description:"encrypt archive or collection output with the 32 byte key in the given file, stored raw or base64 encoded"`
+	EncryptionKeyEnv           string   `long:"encryptionKeyEnv" value-name:"<variable>" description:"encrypt archive or collection output with the base64 encoded 32 byte key in the given environment variable"`
+	Oplog                      bool     `long:"oplog" description:"for taking a point-in-time snapshot on a replica set that is not part of a sharded cluster. Use --shardedOplog for sharded clusters"`
+	ShardedOplog               bool     `long:"shardedOplog" description:"for taking a consistent point-in-time snapshot of a sharded cluster through a mongos. The config server and each shard are dumped with their oplogs into directories of their own, with the balancer stopped, and the oplogs all end at a common cluster time"`
+	Incremental                bool     `long:"incremental" description:"dump only the oplog entries written since the timestamp recorded in --checkpointFile by a previous --oplog or --incremental dump"`
+	CheckpointFile             string   `long:"checkpointFile" value-name:"<file-path>" description:"path to an oplog checkpoint file. It is read by --incremental, and rewritten with the new oplog end timestamp after a successful --oplog or --incremental dump"`
+	Archive                    string   `long:"archive" value-name:"<file-path>" optional:"true" optional-value:"-" description:"dump as an archive to the specified path or s3://<bucket>/<key> URL of object storage. If flag is specified without a value, archive is written to stdout"`
+	ArchiveIndex               bool     `long:"archiveIndex" description:"end the archive with an index of its namespaces, which lets mongorestore skip the ones it does not restore. Indexed archives can't be read by versions of mongorestore that predate this option"`
 	DumpDBUsersAndRoles        bool     `long:"dumpDbUsersAndRoles" description:"dump user and role definitions for the specified database"`
 	ExcludedCollections        []string `long:"excludeCollection" value-name:"<collection-name>" description:"collection to exclude from the dump (may be specified multiple times to exclude additional collections)"`
 	ExcludedCollectionPrefixes []string `long:"excludeCollectionsWithPrefix" value-name:"<collection-prefix>" description:"exclude all collections from the dump that have the given prefix (may be specified multiple times to exclude additional prefixes)"`
+	NSInclude                  []string `long:"nsInclude" value-name:"<namespace-pattern>" description:"include matching namespaces, like 'app_*.events_*' (may be specified multiple times)"`
+	NSExclude                  []string `long:"nsExclude" value-name:"<namespace-pattern>" description:"exclude matching namespaces (may be specified multiple times)"`
 	NumParallelCollections     int      `long:"numParallelCollections" short:"j" description:"number of collections to dump in parallel" default:"4" default-mask:"-"`
+	NumPartitions              int      `long:"numPartitionsPerCollection" value-name:"<n>" description:"number of _id ranges to split each large collection into, which are dumped in parallel on their own cursors; older versions of mongorestore restore only the first range of such a collection (defaults to 1)" default:"1" default-mask:"-"`
 	ViewsAsCollections         bool     `long:"viewsAsCollections" description:"dump views as normal collections with their produced data, omitting standard collections"`
+	SchemaSummary              bool     `long:"schemaSummary" description:"sample the documents of each collection and write a summary of its fields, with their BSON types, presence, longest array and nesting depth, to <collection>.schema.json next to its metadata, or into the prelude of an archive"`
+	SchemaSampleSize           int64    `long:"schemaSampleSize" value-name:"<n>" description:"number of documents of each collection that --schemaSummary samples, or 0 for all of them (defaults to 1000)" default:"1000" default-mask:"-"`
+	ChangeStreamPreImages      bool     `long:"changeStreamPreImages" description:"also dump the pre-images that change streams recorded in config.system.preimages for the dumped collections with changeStreamPreAndPostImages enabled, so that they're kept with the dump; mongorestore doesn't restore them, since the server manages that collection itself"`
+	Resume                     bool     `long:"resume" description:"dump collections in _id order and record the progress of the dump in the output directory, so that running the same dump with --resume again after it was interrupted continues where it stopped"`
 }
 
 // Name returns a human-readable group name for output options.
@@ -72,6 +89,24 @@ func (*OutputOptions) Name() string {
 	return "output"
 }
 
+// Codec returns the compression codec selected with --gzip or --compress, or nil
+// if the output is not compressed.
+func (outputOptions *OutputOptions) Codec() (*compression.Codec, error) {
+	if outputOptions.Gzip {
+		if outputOptions.Compress != "" && outputOptions.Compress != compression.Gzip.Name {
+			return nil, fmt.Errorf("--gzip can't be used with --compress=%v", outputOptions.Compress)
+		}
+		return compression.Gzip, nil
+	}
+	return compression.ByName(outputOptions.Compress)
+}
+
+// EncryptionKey returns the key selected with --encryptionKeyFile or
+// --encryptionKeyEnv, or nil if the output is not encrypted.
+func (outputOptions *OutputOptions) EncryptionKey() (*encryption.Key, error) {
+	return encryption.LoadKey(outputOptions.EncryptionKeyFile, outputOptions.EncryptionKeyEnv)
+}
+
 type Options struct {
 	*options.ToolOptions
 	*InputOptions
@@ -0,0 +1,287 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/dumprestore"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/progress"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const (
+	// minPartitionDocuments is the fewest documents that a collection is split
+	// into another partition for.
+	minPartitionDocuments = 10000
+
+	// samplesPerPartition is the number of _id values sampled per partition to
+	// choose the bounds of the partitions.
+	samplesPerPartition = 100
+)
+
+// numPartitions returns the number of partitions to dump the collection of
+// intent in. Only regular collections are split, since capped collections must
+// keep their order, and views, time series and clustered collections have no
+// _id index to split on.
+func (dump *MongoDump) numPartitions(intent *intents.Intent) int {
+	n := dump.OutputOptions.NumPartitions
+	if n <= 1 || intent.IsView() || intent.IsTimeseries() || intent.IsOplog() {
+		return 1
+	}
+	if capped, _ := bsonutil.FindValueByKey("capped", &intent.Options); capped == true {
+		return 1
+	}
+	if clustered, _ := bsonutil.FindValueByKey("clusteredIndex", &intent.Options); clustered != nil {
+		return 1
+	}
+	if most := intent.Size / minPartitionDocuments; int64(n) > most {
+		n = int(most)
+	}
+	return max(n, 1)
+}
+
+// partitionBounds returns the _id values that split the collection of intent
+// into ranges of about the same number of documents, or nil if the collection
+// isn't split. The bounds are chosen from a $sample of the collection's _ids.
+func (dump *MongoDump) partitionBounds(
+	intent *intents.Intent,
+	coll *mongo.Collection,
+) ([]bson.RawValue, error) {
+	n := dump.numPartitions(intent)
+	if n <= 1 {
+		return nil, nil
+	}
+
+	pipeline := mongo.Pipeline{
+		{{"$sample", bson.D{{"size", n * samplesPerPartition}}}},
+		{{"$project", bson.D{{"_id", 1}}}},
+		{{"$sort", bson.D{{"_id", 1}}}},
+	}
+	cursor, err := coll.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("error sampling %#q to partition it: %v", intent.Namespace(), err)
+	}
+	defer cursor.Close(context.Background())
+	var ids []bson.RawValue
+	for cursor.Next(context.Background()) {
+		id := cursor.Current.Lookup("_id")
+		ids = append(ids, bson.RawValue{Type: id.Type, Value: bytes.Clone(id.Value)})
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("error sampling %#q to partition it: %v", intent.Namespace(), err)
+	}
+	if len(ids) < n {
+		return nil, nil
+	}
+
+	var bounds []bson.RawValue
+	for i := 1; i < n; i++ {
+		id := ids[i*len(ids)/n]
+		if len(bounds) > 0 && bounds[len(bounds)-1].Equal(id) {
+			continue
+		}
+		bounds = append(bounds, id)
+	}
+	return bounds, nil
+}
+
+// partitionFile is where a partition is written to.
+type partitionFile interface {
+	Open() error
+	io.WriteCloser
+}
+
+// sharedMuxIn lets the partitions of a collection write to the same archive
+// namespace. Each write is a whole document, so documents of the partitions are
+// interleaved in the archive like the segments of different namespaces are.
+type sharedMuxIn struct {
+	mutex sync.Mutex
+	out   io.Writer
+}
+
+func (*sharedMuxIn) Open() error  { return nil }
+func (*sharedMuxIn) Close() error { return nil }
+
+func (s *sharedMuxIn) Write(doc []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.out.Write(doc)
+}
+
+// countingWriter counts the documents written through it. Each write must be a
+// whole document.
+type countingWriter struct {
+	io.Writer
+	documents int64
+}
+
+func (w *countingWriter) Write(doc []byte) (int, error) {
+	n, err := w.Writer.Write(doc)
+	if err == nil {
+		w.documents++
+	}
+	return n, err
+}
+
+// partitionFiles returns the files that the partitions of intent are written
+// to. In a dump directory, the first partition goes to the collection's usual
+// file and each other one to a file of its own, named foo.bson.N. Older versions
+// of mongorestore don't know that name and skip those files, restoring only the
+// first partition, which is why the number of partitions is also recorded in
+// prelude.json for mongorestore to check.
+func (dump *MongoDump) partitionFiles(intent *intents.Intent, n int) []partitionFile {
+	bsonFile, ok := intent.BSONFile.(*realBSONFile)
+	if !ok {
+		shared := &sharedMuxIn{out: intent.BSONFile}
+		files := make([]partitionFile, n)
+		for i := range files {
+			files[i] = shared
+		}
+		return files
+	}
+
+	files := []partitionFile{bsonFile}
+	bsonFile.partitions = nil
+	base := strings.TrimSuffix(bsonFile.path, dump.codec.FileName(""))
+	for i := 1; i < n; i++ {
+		part := &realBSONFile{
+			path:    dump.codec.FileName(dumprestore.PartitionFileName(base, i)),
+			intent:  intent,
+			storage: dump.storage,
+		}
+		bsonFile.partitions = append(bsonFile.partitions, part)
+		files = append(files, part)
+	}
+	return files
+}
+
+// partitionCounts returns the number of partitions of each collection that was
+// split into partitions in a dump directory, by namespace.
+func (dump *MongoDump) partitionCounts() map[string]int {
+	var counts map[string]int
+	for _, intent := range dump.manager.Intents() {
+		bsonFile, ok := intent.BSONFile.(*realBSONFile)
+		if !ok || len(bsonFile.partitions) == 0 {
+			continue
+		}
+		if counts == nil {
+			counts = map[string]int{}
+		}
+		counts[intent.Namespace()] = len(bsonFile.partitions) + 1
+	}
+	return counts
+}
+
+// dumpPartitionsToIntent dumps the collection of intent split at bounds, each
+// partition on its own cursor.
+func (dump *MongoDump) dumpPartitionsToIntent(
+	query *db.DeferredQuery,
+	intent *intents.Intent,
+	bounds []bson.RawValue,
+	sampler *dumprestore.SchemaSampler,
+) (int64, error) {
+	total, err := dump.getCount(query, intent)
+	if err != nil {
+		return 0, err
+	}
+	dumpProgressor := progress.NewCounter(total)
+	if dump.ProgressManager != nil {
+		dump.ProgressManager.Attach(intent.Namespace(), dumpProgressor)
+		defer dump.ProgressManager.Detach(intent.Namespace())
+	}
+
+	// The partitions of an archive share the intent's file, which is opened once.
+	_, isFile := intent.BSONFile.(*realBSONFile)
+	if !isFile {
+		err = intent.BSONFile.Open()
+		if err != nil {
+			return 0, err
+		}
+		defer intent.BSONFile.Close()
+	}
+
+	files := dump.partitionFiles(intent, len(bounds)+1)
+	errChan := make(chan error, len(files))
+	for i, file := range files {
+		partQuery := *query
+		partQuery.Hint = bson.D{{"_id", 1}}
+		if i > 0 {
+			partQuery.Min = bson.D{{"_id", bounds[i-1]}}
+		}
+		if i < len(bounds) {
+			partQuery.Max = bson.D{{"_id", bounds[i]}}
+		}
+		go func() {
+			errChan <- dump.dumpPartition(&partQuery, file, dumpProgressor, sampler)
+		}()
+	}
+
+	for range files {
+		if partErr := <-errChan; partErr != nil && err == nil {
+			err = partErr
+		}
+	}
+	dumpCount, _ := dumpProgressor.Progress()
+	if err != nil {
+		return dumpCount, fmt.Errorf(
+			"error writing data for collection %#q to disk: %v",
+			intent.Namespace(),
+			err,
+		)
+	}
+	return dumpCount, nil
+}
+
+// dumpPartition dumps the documents of query to file.
+func (dump *MongoDump) dumpPartition(
+	query *db.DeferredQuery,
+	file partitionFile,
+	progressor progress.Updateable,
+	sampler *dumprestore.SchemaSampler,
+) (err error) {
+	err = file.Open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	var out io.Writer = file
+	if buffer := dump.getResettableOutputBuffer(); buffer != nil {
+		buffer.Reset(file)
+		out = buffer
+		defer func() {
+			if closeErr := buffer.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+	}
+	counter := &countingWriter{Writer: out}
+
+	cursor, err := query.Iter(dump.serverVersionArray)
+	if err != nil {
+		return err
+	}
+	err = dump.dumpValidatedIterToWriter(cursor, counter, progressor, nil, sampler)
+	if bsonFile, ok := file.(*realBSONFile); ok {
+		bsonFile.documents = counter.documents
+	}
+	return err
+}
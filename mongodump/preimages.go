@@ -0,0 +1,89 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"encoding/hex"
+	"fmt"
+	"slices"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/dumprestore"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// CreatePreImagesIntent builds an intent for the pre-images of the dumped
+// collections that record them for change streams, and puts it into the
+// intent manager. It's called once the intents of the collections are built.
+func (dump *MongoDump) CreatePreImagesIntent() error {
+	namespace := "config." + dumprestore.PreImagesCollection
+	if dump.manager.IntentForNamespace(namespace) != nil {
+		log.Logvf(log.DebugLow, "%#q is already dumped with all of its pre-images", namespace)
+		return nil
+	}
+
+	uuids, err := preImageUUIDs(dump.manager.NormalIntents())
+	if err != nil {
+		return err
+	}
+	if len(uuids) == 0 {
+		log.Logvf(
+			log.Always,
+			"not dumping %#q, since no dumped collection has changeStreamPreAndPostImages enabled",
+			namespace,
+		)
+		return nil
+	}
+
+	session, err := dump.SessionProvider.GetSession()
+	if err != nil {
+		return err
+	}
+	collInfo, err := db.GetCollectionInfo(
+		session.Database("config").Collection(dumprestore.PreImagesCollection),
+	)
+	if err != nil {
+		return fmt.Errorf("error getting collection options of %#q: %v", namespace, err)
+	}
+	if collInfo == nil {
+		log.Logvf(log.Always, "not dumping %#q, which doesn't exist", namespace)
+		return nil
+	}
+
+	intent, err := dump.NewIntentFromOptions("config", collInfo)
+	if err != nil {
+		return err
+	}
+	dump.preImagesFilter = bson.D{{"_id.nsUUID", bson.D{{"$in", uuids}}}}
+	dump.manager.Put(intent)
+	return nil
+}
+
+// preImageUUIDs returns the UUIDs of the collections of intents that have
+// changeStreamPreAndPostImages enabled, which the pre-images of each
+// collection are recorded with, sorted.
+func preImageUUIDs(collIntents []*intents.Intent) (bson.A, error) {
+	var hexUUIDs []string
+	for _, intent := range collIntents {
+		if intent.UUID != "" && dumprestore.RecordsPreImages(intent.Options) {
+			hexUUIDs = append(hexUUIDs, intent.UUID)
+		}
+	}
+	slices.Sort(hexUUIDs)
+
+	var uuids bson.A
+	for _, hexUUID := range hexUUIDs {
+		data, err := hex.DecodeString(hexUUID)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing collection UUID %#q: %v", hexUUID, err)
+		}
+		uuids = append(uuids, bson.Binary{Subtype: bson.TypeBinaryUUID, Data: data})
+	}
+	return uuids, nil
+}
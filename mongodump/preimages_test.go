@@ -0,0 +1,52 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestPreImageUUIDs(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	enabled := bson.D{{"changeStreamPreAndPostImages", bson.D{{"enabled", true}}}}
+	uuids, err := preImageUUIDs([]*intents.Intent{
+		{DB: "app", C: "orders", UUID: "ff000000000000000000000000000002", Options: enabled},
+		{DB: "app", C: "users", UUID: "00000000000000000000000000000001", Options: enabled},
+		{DB: "app", C: "logs", UUID: "00000000000000000000000000000003"},
+		{DB: "app", C: "view", Options: enabled},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, bson.A{
+		bson.Binary{
+			Subtype: bson.TypeBinaryUUID,
+			Data:    []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1},
+		},
+		bson.Binary{
+			Subtype: bson.TypeBinaryUUID,
+			Data:    []byte{0xff, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2},
+		},
+	}, uuids)
+
+	_, err = preImageUUIDs([]*intents.Intent{{DB: "app", C: "bad", UUID: "xyz", Options: enabled}})
+	assert.ErrorContains(t, err, "xyz")
+
+	dump := &MongoDump{
+		query:           bson.D{{"x", 1}},
+		preImagesFilter: bson.D{{"_id.nsUUID", bson.D{{"$in", uuids}}}},
+	}
+	filter, _ := dump.queryFor(&intents.Intent{DB: "config", C: "system.preimages"})
+	assert.Equal(t, dump.preImagesFilter, filter, "--query doesn't apply to the pre-images")
+	filter, _ = dump.queryFor(&intents.Intent{DB: "app", C: "orders"})
+	assert.Equal(t, dump.query, filter)
+}
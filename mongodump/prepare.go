@@ -26,7 +26,9 @@ import (
 	"github.com/mongodb/mongo-tools/common/dumprestore"
 	"github.com/mongodb/mongo-tools/common/intents"
 	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/storage"
 	"github.com/mongodb/mongo-tools/common/util"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
 	"github.com/samber/lo"
 )
 
@@ -54,6 +56,18 @@ type realBSONFile struct {
 	errorReader
 	intent *intents.Intent
 	NilPos
+	// storage is where the file is written, or nil for the local file system
+	storage storage.Backend
+
+	// checksum and documents are recorded for the manifest
+	checksum  *dumprestore.ChecksumWriter
+	documents int64
+
+	// resumed is how much of the file an earlier attempt of the dump wrote
+	resumed resumeNamespace
+
+	// partitions are the files of the other partitions of a partitioned dump
+	partitions []*realBSONFile
 }
 
 // Open is part of the intents.file interface. realBSONFiles need to have Open called before
@@ -64,17 +78,66 @@ func (f *realBSONFile) Open() (err error) {
 		return fmt.Errorf("error creating BSON file without a path, namespace: %#q",
 			f.intent.Namespace())
 	}
-	err = os.MkdirAll(filepath.Dir(f.path), os.ModeDir|os.ModePerm)
+	backend := storage.OrLocal(f.storage)
+	err = backend.MkdirAll(filepath.Dir(f.path))
 	if err != nil {
 		return fmt.Errorf("error creating directory for BSON file %v: %v",
 			filepath.Dir(f.path), err)
 	}
 
-	f.WriteCloser, err = os.Create(f.path)
+	if f.resumed.Size > 0 {
+		return f.openForAppend()
+	}
+
+	file, err := backend.Create(f.path)
 	if err != nil {
 		return fmt.Errorf("error creating BSON file %#q: %v", f.path, err)
 	}
+	f.checksum = dumprestore.NewChecksumWriter(file)
+	f.WriteCloser = f.checksum
+
+	return nil
+}
+
+// openForAppend opens the file of a resumed dump, cutting off anything written
+// after the recorded size. Compressed streams can be concatenated, so the new
+// data is written as a stream of its own.
+func (f *realBSONFile) openForAppend() error {
+	file, err := os.OpenFile(f.path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("error opening BSON file %#q to resume: %v", f.path, err)
+	}
+	err = file.Truncate(f.resumed.Size)
+	if err == nil {
+		f.checksum = dumprestore.NewChecksumWriter(file)
+		err = f.checksum.Include(file)
+	}
+	if err == nil && f.checksum.Size() != f.resumed.Size {
+		err = fmt.Errorf("file is %v bytes long, expected %v", f.checksum.Size(), f.resumed.Size)
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error reading BSON file %#q to resume: %v", f.path, err)
+	}
+	f.WriteCloser = f.checksum
+	return nil
+}
 
+// reuse records the file of a namespace that an earlier attempt dumped in full,
+// so that it is listed in the manifest.
+func (f *realBSONFile) reuse(documents int64) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return fmt.Errorf("error opening BSON file %#q: %v", f.path, err)
+	}
+	defer file.Close()
+	checksum := dumprestore.NewChecksumWriter(nil)
+	err = checksum.Include(file)
+	if err != nil {
+		return fmt.Errorf("error reading BSON file %#q: %v", f.path, err)
+	}
+	f.checksum = checksum
+	f.documents = documents
 	return nil
 }
 
@@ -87,6 +150,11 @@ type realMetadataFile struct {
 	// intent.file ( a ReadWriteOpenCloser )
 	intent *intents.Intent
 	NilPos
+	// storage is where the file is written, or nil for the local file system
+	storage storage.Backend
+
+	// checksum is recorded for the manifest
+	checksum *dumprestore.ChecksumWriter
 }
 
 // Open opens the file on disk that the intent indicates. Any directories needed are created.
@@ -94,16 +162,19 @@ func (f *realMetadataFile) Open() (err error) {
 	if f.path == "" {
 		return fmt.Errorf("No metadata path for %#q.%#q", f.intent.DB, f.intent.C)
 	}
-	err = os.MkdirAll(filepath.Dir(f.path), os.ModeDir|os.ModePerm)
+	backend := storage.OrLocal(f.storage)
+	err = backend.MkdirAll(filepath.Dir(f.path))
 	if err != nil {
 		return fmt.Errorf("error creating directory for metadata file %v: %v",
 			filepath.Dir(f.path), err)
 	}
 
-	f.WriteCloser, err = os.Create(f.path)
+	file, err := backend.Create(f.path)
 	if err != nil {
 		return fmt.Errorf("error creating metadata file %#q: %v", f.path, err)
 	}
+	f.checksum = dumprestore.NewChecksumWriter(file)
+	f.WriteCloser = f.checksum
 	return nil
 }
 
@@ -192,13 +263,81 @@ func (dump *MongoDump) shouldSkipCollection(colName string) bool {
 	return false
 }
 
+// selectsNamespaces returns true when --nsInclude or --nsExclude is given.
+func (dump *MongoDump) selectsNamespaces() bool {
+	return len(dump.OutputOptions.NSInclude) > 0 || len(dump.OutputOptions.NSExclude) > 0
+}
+
+// initNamespaceMatchers builds the matchers of the --nsInclude and --nsExclude
+// patterns.
+func (dump *MongoDump) initNamespaceMatchers() error {
+	var err error
+	if len(dump.OutputOptions.NSInclude) > 0 {
+		dump.includer, err = ns.NewMatcher(dump.OutputOptions.NSInclude)
+		if err != nil {
+			return fmt.Errorf("invalid includes: %v", err)
+		}
+	}
+	if len(dump.OutputOptions.NSExclude) > 0 {
+		dump.excluder, err = ns.NewMatcher(dump.OutputOptions.NSExclude)
+		if err != nil {
+			return fmt.Errorf("invalid excludes: %v", err)
+		}
+	}
+	return nil
+}
+
+// shouldSkipNamespace returns true when a namespace is not matched by
+// --nsInclude, or is matched by --nsExclude.
+func (dump *MongoDump) shouldSkipNamespace(dbName, colName string) bool {
+	namespace := dbName + "." + colName
+	if dump.includer != nil && !dump.includer.Has(namespace) {
+		return true
+	}
+	return dump.excluder != nil && dump.excluder.Has(namespace)
+}
+
+// shouldSkipDatabase returns true when no namespace of a database can match
+// --nsInclude, so that its collections don't have to be listed.
+func (dump *MongoDump) shouldSkipDatabase(dbName string) bool {
+	if dump.includer == nil {
+		return false
+	}
+	for _, pattern := range dump.OutputOptions.NSInclude {
+		matcher, err := ns.NewMatcher([]string{databasePattern(pattern)})
+		if err != nil || matcher.Has(dbName) {
+			return false
+		}
+	}
+	return true
+}
+
+// databasePattern returns a pattern that matches the database of every
+// namespace that the given namespace pattern matches. Database names have no
+// dots, so that's the part of the pattern before its first dot, unless a
+// wildcard comes first, which may match dots too.
+func databasePattern(pattern string) string {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			// skip the escaped character
+			i++
+		case '.':
+			return pattern[:i]
+		case '*':
+			return pattern[:i+1]
+		}
+	}
+	return pattern
+}
+
 // outputPath creates a path for the collection to be written to (sans file extension).
 func (dump *MongoDump) outputPath(dbName, colName string) string {
 	var root string
 	if dump.OutputOptions.Out == "" {
 		root = "dump"
 	} else {
-		root = dump.OutputOptions.Out
+		root = storage.Path(dump.OutputOptions.Out)
 	}
 
 	// Encode a new output path for collection names that would result in a file name greater
@@ -234,8 +373,9 @@ func (dump *MongoDump) CreateOplogIntents() error {
 		oplogIntent.BSONFile = &archive.MuxIn{Mux: dump.archive.Mux, Intent: oplogIntent}
 	} else {
 		oplogIntent.BSONFile = &realBSONFile{
-			path:   dump.outputPath("oplog.bson", ""),
-			intent: oplogIntent,
+			path:    dump.outputPath("oplog.bson", ""),
+			intent:  oplogIntent,
+			storage: dump.storage,
 		}
 	}
 	dump.manager.Put(oplogIntent)
@@ -271,23 +411,26 @@ func (dump *MongoDump) CreateUsersRolesVersionIntentsForDB(db string) error {
 		usersIntent.BSONFile = &realBSONFile{
 			path: filepath.Join(
 				outDir,
-				nameGz(dump.OutputOptions.Gzip, "$admin.system.users.bson"),
+				dump.codec.FileName("$admin.system.users.bson"),
 			),
-			intent: usersIntent,
+			intent:  usersIntent,
+			storage: dump.storage,
 		}
 		rolesIntent.BSONFile = &realBSONFile{
 			path: filepath.Join(
 				outDir,
-				nameGz(dump.OutputOptions.Gzip, "$admin.system.roles.bson"),
+				dump.codec.FileName("$admin.system.roles.bson"),
 			),
-			intent: rolesIntent,
+			intent:  rolesIntent,
+			storage: dump.storage,
 		}
 		versionIntent.BSONFile = &realBSONFile{
 			path: filepath.Join(
 				outDir,
-				nameGz(dump.OutputOptions.Gzip, "$admin.system.version.bson"),
+				dump.codec.FileName("$admin.system.version.bson"),
 			),
-			intent: versionIntent,
+			intent:  versionIntent,
+			storage: dump.storage,
 		}
 	}
 	dump.manager.Put(usersIntent)
@@ -357,11 +500,10 @@ func (dump *MongoDump) NewIntentFromOptions(
 		} else if ci.IsTimeseries() && !dump.serverVersionArray.SupportsRawData() {
 			// 8.3+ supports viewless timeseries, so they end up in the final else block as a normal
 			// collection.
-			path := nameGz(
-				dump.OutputOptions.Gzip,
-				dump.outputPath(dbName, common.TimeseriesBucketPrefix+ci.Name)+".bson",
+			path := dump.codec.FileName(
+				dump.outputPath(dbName, common.TimeseriesBucketPrefix+ci.Name) + ".bson",
 			)
-			intent.BSONFile = &realBSONFile{path: path, intent: intent}
+			intent.BSONFile = &realBSONFile{path: path, intent: intent, storage: dump.storage}
 			intent.Location = path
 		} else if ci.IsView() && !dump.OutputOptions.ViewsAsCollections {
 			log.Logvf(
@@ -372,8 +514,8 @@ func (dump *MongoDump) NewIntentFromOptions(
 		} else {
 			// otherwise, if it's either not a view or we're treating views as collections
 			// then create a standard filesystem path for this collection.
-			path := nameGz(dump.OutputOptions.Gzip, dump.outputPath(dbName, ci.Name)+".bson")
-			intent.BSONFile = &realBSONFile{path: path, intent: intent}
+			path := dump.codec.FileName(dump.outputPath(dbName, ci.Name) + ".bson")
+			intent.BSONFile = &realBSONFile{path: path, intent: intent, storage: dump.storage}
 			intent.Location = path
 		}
 
@@ -388,11 +530,10 @@ func (dump *MongoDump) NewIntentFromOptions(
 				Buffer: &bytes.Buffer{},
 			}
 		} else {
-			path := nameGz(
-				dump.OutputOptions.Gzip,
-				dump.outputPath(dbName, ci.Name)+".metadata.json",
+			path := dump.codec.FileName(
+				dump.outputPath(dbName, ci.Name) + ".metadata.json",
 			)
-			intent.MetadataFile = &realMetadataFile{path: path, intent: intent}
+			intent.MetadataFile = &realMetadataFile{path: path, intent: intent, storage: dump.storage}
 		}
 	}
 
@@ -468,6 +609,15 @@ func (dump *MongoDump) CreateIntentsForDatabase(dbName string) error {
 			continue
 		}
 
+		if dump.shouldSkipNamespace(dbName, collInfo.Name) {
+			log.Logvf(
+				log.DebugLow,
+				"skipping dump of %#q, it does not match --nsInclude or matches --nsExclude",
+				dbName+"."+collInfo.Name,
+			)
+			continue
+		}
+
 		if dump.OutputOptions.ViewsAsCollections && !collInfo.IsView() {
 			log.Logvf(
 				log.DebugLow,
@@ -548,16 +698,13 @@ func (dump *MongoDump) CreateAllIntents() error {
 		return err
 	}
 	for _, dbName := range dbs {
+		if dump.shouldSkipDatabase(dbName) {
+			log.Logvf(log.DebugLow, "skipping dump of database %#q, it is not included", dbName)
+			continue
+		}
 		if err := dump.CreateIntentsForDatabase(dbName); err != nil {
 			return fmt.Errorf("error creating intents for database %#q: %v", dbName, err)
 		}
 	}
 	return nil
 }
-
-func nameGz(gz bool, name string) string {
-	if gz {
-		return name + ".gz"
-	}
-	return name
-}
@@ -36,6 +36,44 @@ func TestSkipCollection(t *testing.T) {
 	assert.False(t, md.shouldSkipCollection("prefix"), "do not skip 'prefix'")
 }
 
+func TestShouldSkipNamespace(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	md := &MongoDump{
+		OutputOptions: &OutputOptions{
+			NSInclude: []string{"app_*.events_*", "billing.invoices", `odd\*db.*`},
+			NSExclude: []string{"*.events_archive"},
+		},
+	}
+	require.NoError(t, md.initNamespaceMatchers())
+
+	assert.False(t, md.shouldSkipNamespace("app_eu", "events_2024"))
+	assert.False(t, md.shouldSkipNamespace("app_eu", "events_2024.daily"))
+	assert.False(t, md.shouldSkipNamespace("billing", "invoices"))
+	assert.False(t, md.shouldSkipNamespace("odd*db", "anything"))
+	assert.True(t, md.shouldSkipNamespace("app_eu", "users"), "not included")
+	assert.True(t, md.shouldSkipNamespace("app_eu", "events_archive"), "excluded")
+	assert.True(t, md.shouldSkipNamespace("billing", "payments"), "not included")
+	assert.True(t, md.shouldSkipNamespace("oddxdb", "anything"), "escaped asterisk")
+
+	assert.False(t, md.shouldSkipDatabase("app_eu"))
+	assert.False(t, md.shouldSkipDatabase("billing"))
+	assert.False(t, md.shouldSkipDatabase("odd*db"))
+	assert.True(t, md.shouldSkipDatabase("app"))
+	assert.True(t, md.shouldSkipDatabase("billing_old"))
+	assert.True(t, md.shouldSkipDatabase("oddxdb"))
+
+	assert.Equal(t, "app", databasePattern("app.*"))
+	assert.Equal(t, "a*", databasePattern("a*.b"))
+	assert.Equal(t, `a\.b\*c*`, databasePattern(`a\.b\*c*.d`))
+
+	md = &MongoDump{OutputOptions: &OutputOptions{NSExclude: []string{"app.*"}}}
+	require.NoError(t, md.initNamespaceMatchers())
+	assert.False(t, md.shouldSkipDatabase("app"), "only includes skip whole databases")
+	assert.True(t, md.shouldSkipNamespace("app", "users"))
+	assert.False(t, md.shouldSkipNamespace("other", "users"))
+}
+
 type testTable struct {
 	db       string
 	coll     string
@@ -0,0 +1,116 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/mongodb/mongo-tools/common/dumprestore"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// namespaceQuery is an entry of a --queriesFile, which filters and projects
+// the documents of the namespaces that match its pattern.
+type namespaceQuery struct {
+	matcher    *ns.Matcher
+	filter     bson.D
+	projection bson.D
+}
+
+// queriesFile is the content of a --queriesFile, like
+//
+//	{"queries": [
+//	    {"ns": "app.events", "filter": {"ts": {"$gte": {"$date": "2024-01-01T00:00:00Z"}}}},
+//	    {"ns": "app.*", "filter": {"deleted": false}, "projection": {"blob": 0}}
+//	]}
+//
+// The ns of a query is a namespace pattern like those of --nsInclude, and the
+// filter and projection are Extended JSON.
+type queriesFile struct {
+	Queries []struct {
+		NS         string          `json:"ns"`
+		Filter     json.RawMessage `json:"filter"`
+		Projection json.RawMessage `json:"projection"`
+	} `json:"queries"`
+}
+
+// readQueriesFile reads the queries of the --queriesFile at path.
+func readQueriesFile(path string) ([]*namespaceQuery, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading queriesFile: %v", err)
+	}
+	queries, err := parseQueries(content)
+	if err != nil {
+		return nil, fmt.Errorf("error reading queriesFile %#q: %v", path, err)
+	}
+	return queries, nil
+}
+
+// parseQueries parses the content of a --queriesFile.
+func parseQueries(content []byte) ([]*namespaceQuery, error) {
+	var file queriesFile
+	err := json.Unmarshal(content, &file)
+	if err != nil {
+		return nil, err
+	}
+	var queries []*namespaceQuery
+	for i, entry := range file.Queries {
+		if entry.NS == "" {
+			return nil, fmt.Errorf("query %v has no ns", i)
+		}
+		query := &namespaceQuery{}
+		query.matcher, err = ns.NewMatcher([]string{entry.NS})
+		if err != nil {
+			return nil, fmt.Errorf("query %v: %v", i, err)
+		}
+		if entry.Filter != nil {
+			err = bson.UnmarshalExtJSON(entry.Filter, false, &query.filter)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing filter of query %v as Extended JSON: %v", i, err)
+			}
+		}
+		if entry.Projection != nil {
+			err = bson.UnmarshalExtJSON(entry.Projection, false, &query.projection)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"error parsing projection of query %v as Extended JSON: %v", i, err,
+				)
+			}
+		}
+		queries = append(queries, query)
+	}
+	return queries, nil
+}
+
+// queryFor returns the filter and projection of the documents of intent, which
+// are those of --query or --queryFile, or else those of the first query of the
+// --queriesFile that matches its namespace. The pre-images of
+// --changeStreamPreImages are those of the dumped collections. Users, roles and
+// the other special collections are always dumped whole.
+func (dump *MongoDump) queryFor(intent *intents.Intent) (bson.D, bson.D) {
+	if dump.preImagesFilter != nil && intent.DB == "config" &&
+		intent.C == dumprestore.PreImagesCollection {
+		return dump.preImagesFilter, nil
+	}
+	if len(dump.query) > 0 {
+		return dump.query, nil
+	}
+	if intent.IsSpecialCollection() {
+		return nil, nil
+	}
+	for _, query := range dump.queries {
+		if query.matcher.Has(intent.Namespace()) {
+			return query.filter, query.projection
+		}
+	}
+	return nil, nil
+}
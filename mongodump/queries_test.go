@@ -0,0 +1,56 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestQueryFor(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	queries, err := parseQueries([]byte(`{"queries": [
+		{"ns": "app.events", "filter": {"ts": {"$gte": {"$date": "2024-01-01T00:00:00Z"}}}},
+		{"ns": "app.*", "filter": {"deleted": false}, "projection": {"blob": 0}},
+		{"ns": "*.system.users", "filter": {"user": "x"}}
+	]}`))
+	require.NoError(t, err)
+	dump := &MongoDump{queries: queries}
+
+	filter, projection := dump.queryFor(&intents.Intent{DB: "app", C: "events"})
+	since := bson.NewDateTimeFromTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, bson.D{{"ts", bson.D{{"$gte", since}}}}, filter)
+	assert.Nil(t, projection)
+
+	filter, projection = dump.queryFor(&intents.Intent{DB: "app", C: "orders"})
+	assert.Equal(t, bson.D{{"deleted", false}}, filter)
+	assert.Equal(t, bson.D{{"blob", int32(0)}}, projection)
+
+	filter, projection = dump.queryFor(&intents.Intent{DB: "other", C: "orders"})
+	assert.Nil(t, filter)
+	assert.Nil(t, projection)
+
+	filter, _ = dump.queryFor(&intents.Intent{DB: "admin", C: "system.users"})
+	assert.Nil(t, filter, "users are dumped whole")
+
+	dump.query = bson.D{{"x", 1}}
+	filter, projection = dump.queryFor(&intents.Intent{DB: "app", C: "orders"})
+	assert.Equal(t, dump.query, filter)
+	assert.Nil(t, projection)
+
+	_, err = parseQueries([]byte(`{"queries": [{"filter": {}}]}`))
+	assert.ErrorContains(t, err, "no ns")
+	_, err = parseQueries([]byte(`{"queries": [{"ns": "a.b", "filter": {"x": {"$numberInt": "abc"}}}]}`))
+	assert.ErrorContains(t, err, "Extended JSON")
+}
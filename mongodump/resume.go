@@ -0,0 +1,220 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// resumeFileName is the name of the file in which a --resume dump records how
+// far it got. It is written next to prelude.json, and removed once the dump
+// succeeds.
+const resumeFileName = "resume.json"
+
+// resumeState is the content of the resume file. OplogStart is the start of
+// the oplog window of the first attempt, so that the oplog of a resumed dump
+// covers the collections dumped by every attempt.
+type resumeState struct {
+	OplogStart bson.Timestamp              `bson:"oplogStart"`
+	Namespaces map[string]*resumeNamespace `bson:"namespaces"`
+}
+
+// resumeNamespace is how much of a namespace was dumped. The first Documents
+// documents, up to the one with LastID, take the first Size bytes of its file.
+// LastID is a document with just the _id, so that its type is kept.
+type resumeNamespace struct {
+	Complete  bool     `bson:"complete"`
+	LastID    bson.Raw `bson:"lastId,omitempty"`
+	Documents int64    `bson:"documents"`
+	Size      int64    `bson:"size"`
+}
+
+// dumpResume records the progress of a dump in its resume file.
+type dumpResume struct {
+	path string
+	// resumed is set when the resume file of an earlier attempt was found
+	resumed bool
+
+	mutex sync.Mutex
+	state resumeState
+}
+
+// openDumpResume reads the resume file in dir, if there is one.
+func openDumpResume(dir string) (*dumpResume, error) {
+	resume := &dumpResume{
+		path:  filepath.Join(dir, resumeFileName),
+		state: resumeState{Namespaces: map[string]*resumeNamespace{}},
+	}
+	content, err := os.ReadFile(resume.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return resume, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading resume file %#q: %v", resume.path, err)
+	}
+	err = bson.UnmarshalExtJSON(content, true, &resume.state)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing resume file %#q: %v", resume.path, err)
+	}
+	if resume.state.Namespaces == nil {
+		resume.state.Namespaces = map[string]*resumeNamespace{}
+	}
+	resume.resumed = true
+	log.Logvf(log.Always, "resuming dump from %#q", resume.path)
+	return resume, nil
+}
+
+// namespace returns how much of ns was dumped by earlier attempts.
+func (resume *dumpResume) namespace(ns string) resumeNamespace {
+	resume.mutex.Lock()
+	defer resume.mutex.Unlock()
+	if progress, ok := resume.state.Namespaces[ns]; ok {
+		return *progress
+	}
+	return resumeNamespace{}
+}
+
+// oplogStart returns the start of the oplog window of the first attempt.
+func (resume *dumpResume) oplogStart() bson.Timestamp {
+	resume.mutex.Lock()
+	defer resume.mutex.Unlock()
+	return resume.state.OplogStart
+}
+
+// setOplogStart records the start of the oplog window.
+func (resume *dumpResume) setOplogStart(ts bson.Timestamp) error {
+	resume.mutex.Lock()
+	defer resume.mutex.Unlock()
+	resume.state.OplogStart = ts
+	return resume.writeLocked()
+}
+
+// record records the progress of an intent once its file was closed. A failed
+// dump is recorded only if the file was written out, and only for collections,
+// since the documents of views aren't dumped in _id order.
+func (resume *dumpResume) record(
+	intent *intents.Intent,
+	path string,
+	last *lastIDWriter,
+	documents int64,
+	dumpErr error,
+	flushed bool,
+) {
+	if dumpErr != nil && (!flushed || intent.IsView() || last.lastID == nil) {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Logvf(log.Always, "warning: not recording progress of %#q: %v", intent.Namespace(), err)
+		return
+	}
+	progress := &resumeNamespace{
+		Complete:  dumpErr == nil,
+		LastID:    last.lastID,
+		Documents: documents,
+		Size:      info.Size(),
+	}
+	if progress.Complete {
+		progress.LastID = nil
+	} else {
+		log.Logvf(
+			log.Always,
+			"recorded progress of %#q after %v %v",
+			intent.Namespace(),
+			documents,
+			docPlural(documents),
+		)
+	}
+
+	resume.mutex.Lock()
+	defer resume.mutex.Unlock()
+	resume.state.Namespaces[intent.Namespace()] = progress
+	if err := resume.writeLocked(); err != nil {
+		log.Logvf(log.Always, "warning: %v", err)
+	}
+}
+
+// remove removes the resume file once the dump succeeded.
+func (resume *dumpResume) remove() error {
+	err := os.Remove(resume.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing resume file %#q: %v", resume.path, err)
+	}
+	return nil
+}
+
+// writeLocked replaces the resume file, so that it is never left half written.
+func (resume *dumpResume) writeLocked() error {
+	content, err := bson.MarshalExtJSONIndent(resume.state, true, false, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error marshaling resume file: %v", err)
+	}
+	err = os.MkdirAll(filepath.Dir(resume.path), os.ModeDir|os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("error creating directory for resume file: %v", err)
+	}
+	tmpPath := resume.path + ".tmp"
+	err = os.WriteFile(tmpPath, content, 0o644)
+	if err == nil {
+		err = os.Rename(tmpPath, resume.path)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing resume file %#q: %v", resume.path, err)
+	}
+	return nil
+}
+
+// lastIDWriter remembers the _id of the last document written through it. Each
+// write must be a whole document.
+type lastIDWriter struct {
+	io.Writer
+	lastID bson.Raw
+}
+
+func (w *lastIDWriter) Write(doc []byte) (int, error) {
+	n, err := w.Writer.Write(doc)
+	if err != nil {
+		return n, err
+	}
+	id, lookupErr := bson.Raw(doc).LookupErr("_id")
+	if lookupErr != nil {
+		return n, fmt.Errorf("document without _id can't be resumed: %v", lookupErr)
+	}
+	w.lastID, err = bson.Marshal(bson.D{{"_id", id}})
+	return n, err
+}
+
+// resumeAfter makes query continue a dump after the document with lastID. The
+// scan of the _id index starts at lastID instead of filtering on
+// {_id: {$gt: lastID}}, which only matches _ids of the same BSON type and would
+// drop the documents whose _ids of other types sort after it. The scan starts
+// with the document of lastID itself, which the returned validator skips.
+func resumeAfter(query *db.DeferredQuery, lastID bson.Raw) documentValidator {
+	query.Hint = bson.D{{"_id", 1}}
+	query.Min = lastID
+	id := lastID.Lookup("_id")
+	first := true
+	return func(doc []byte) error {
+		if !first {
+			return nil
+		}
+		first = false
+		if docID, err := bson.Raw(doc).LookupErr("_id"); err == nil && docID.Equal(id) {
+			return errSkipDocument
+		}
+		return nil
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/dumprestore"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/mongodb/mongo-tools/common/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestDumpResume(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	dir := t.TempDir()
+	resume, err := openDumpResume(dir)
+	require.NoError(t, err)
+	assert.False(t, resume.resumed)
+	require.NoError(t, resume.setOplogStart(bson.Timestamp{T: 10, I: 1}))
+
+	foo := &intents.Intent{ServerVersion: db.Version{7, 0, 0}, DB: "test", C: "foo"}
+	fooPath := filepath.Join(dir, "test", "foo.bson")
+	require.NoError(t, os.MkdirAll(filepath.Dir(fooPath), 0o755))
+	require.NoError(t, os.WriteFile(fooPath, []byte("0123456789"), 0o644))
+
+	last := &lastIDWriter{Writer: io.Discard}
+	for _, id := range []any{bson.NewObjectID(), int64(42)} {
+		doc, err := bson.Marshal(bson.D{{"_id", id}, {"x", 1}})
+		require.NoError(t, err)
+		_, err = last.Write(doc)
+		require.NoError(t, err)
+	}
+	resume.record(foo, fooPath, last, 2, errors.New("interrupted"), true)
+
+	bar := &intents.Intent{ServerVersion: db.Version{7, 0, 0}, DB: "test", C: "bar"}
+	resume.record(bar, fooPath, &lastIDWriter{}, 0, nil, true)
+
+	// Nothing is recorded for files that may not have been written out.
+	baz := &intents.Intent{ServerVersion: db.Version{7, 0, 0}, DB: "test", C: "baz"}
+	resume.record(baz, fooPath, last, 2, errors.New("disk full"), false)
+
+	resumed, err := openDumpResume(dir)
+	require.NoError(t, err)
+	assert.True(t, resumed.resumed)
+	assert.Equal(t, bson.Timestamp{T: 10, I: 1}, resumed.oplogStart())
+
+	fooProgress := resumed.namespace("test.foo")
+	assert.False(t, fooProgress.Complete)
+	assert.EqualValues(t, 2, fooProgress.Documents)
+	assert.EqualValues(t, 10, fooProgress.Size)
+	assert.Equal(t, bson.TypeInt64, fooProgress.LastID.Lookup("_id").Type, "the _id type is kept")
+	assert.EqualValues(t, 42, fooProgress.LastID.Lookup("_id").Int64())
+
+	assert.Equal(t, resumeNamespace{Complete: true, Size: 10}, resumed.namespace("test.bar"))
+	assert.Equal(t, resumeNamespace{}, resumed.namespace("test.baz"))
+
+	require.NoError(t, resumed.remove())
+	assert.NoFileExists(t, filepath.Join(dir, resumeFileName))
+}
+
+func TestResumeAfter(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	lastID, err := bson.Marshal(bson.D{{"_id", int32(7)}})
+	require.NoError(t, err)
+
+	query := &db.DeferredQuery{Filter: bson.D{{"x", 1}}}
+	skip := resumeAfter(query, lastID)
+	assert.Equal(t, bson.D{{"x", 1}}, query.Filter, "the filter of the dump is kept")
+	assert.Equal(t, bson.D{{"_id", 1}}, query.Hint)
+	assert.Equal(t, bson.Raw(lastID), query.Min)
+
+	// The _id index orders numbers before strings, objects and ObjectIds,
+	// none of which {_id: {$gt: 7}} would match.
+	marshal := func(id any) []byte {
+		doc, err := bson.Marshal(bson.D{{"_id", id}, {"x", 1}})
+		require.NoError(t, err)
+		return doc
+	}
+	assert.ErrorIs(t, skip(marshal(int32(7))), errSkipDocument)
+	for _, id := range []any{int64(8), "a", bson.D{{"k", 1}}, bson.NewObjectID()} {
+		assert.NoError(t, skip(marshal(id)), "%v", id)
+	}
+
+	skip = resumeAfter(&db.DeferredQuery{}, lastID)
+	assert.NoError(t, skip(marshal("a")), "the document of lastID may have been deleted")
+	assert.NoError(t, skip(marshal(int32(7))), "only the first document can be the one of lastID")
+}
+
+func TestResumeBSONFile(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	marshal := func(ids ...int) [][]byte {
+		var docs [][]byte
+		for _, id := range ids {
+			doc, err := bson.Marshal(bson.D{{"_id", id}})
+			require.NoError(t, err)
+			docs = append(docs, doc)
+		}
+		return docs
+	}
+
+	for _, codec := range []*compression.Codec{nil, compression.Gzip, compression.Zstd, compression.Snappy} {
+		name := "none"
+		if codec != nil {
+			name = codec.Name
+		}
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "foo.bson")
+			intent := &intents.Intent{DB: "test", C: "foo"}
+
+			write := func(file *realBSONFile, docs [][]byte) {
+				require.NoError(t, file.Open())
+				var w io.WriteCloser = file
+				if codec != nil {
+					w = codec.NewWriter(file)
+				}
+				for _, doc := range docs {
+					_, err := w.Write(doc)
+					require.NoError(t, err)
+				}
+				if codec != nil {
+					require.NoError(t, w.Close())
+				}
+				require.NoError(t, file.Close())
+			}
+
+			first := &realBSONFile{path: path, intent: intent}
+			write(first, marshal(1, 2))
+			size := first.checksum.Size()
+
+			// Whatever was written after the recorded size is cut off.
+			garbage, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+			require.NoError(t, err)
+			_, err = garbage.Write([]byte("partial"))
+			require.NoError(t, err)
+			require.NoError(t, garbage.Close())
+
+			second := &realBSONFile{
+				path:    path,
+				intent:  intent,
+				resumed: resumeNamespace{Size: size, Documents: 2},
+			}
+			write(second, marshal(3))
+
+			fileSize, sum, err := dumprestore.ChecksumFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, fileSize, second.checksum.Size())
+			assert.Equal(t, sum, second.checksum.Sum())
+
+			file, err := os.Open(path)
+			require.NoError(t, err)
+			defer file.Close()
+			var r io.Reader = file
+			if codec != nil {
+				rc, err := codec.NewReader(file)
+				require.NoError(t, err)
+				defer rc.Close()
+				r = rc
+			}
+			content, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, bytes.Join(marshal(1, 2, 3), nil), content)
+		})
+	}
+}
+
+// Test resuming the dump of a collection whose _ids after the last dumped one
+// are of other types.
+func TestResumeMixedIDTypes(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.IntegrationTestType)
+	log.SetWriter(io.Discard)
+
+	session, err := testutil.GetBareSession()
+	require.NoError(t, err)
+	collName := "resume_mixed_ids"
+	coll := session.Database(testDB).Collection(collName)
+	require.NoError(t, coll.Drop(t.Context()))
+	defer func() {
+		_ = coll.Drop(t.Context())
+	}()
+	ids := []any{int32(1), int32(2), int64(3), "a", bson.D{{"k", 1}}, bson.NewObjectID()}
+	var docs [][]byte
+	for _, id := range ids {
+		doc, err := bson.Marshal(bson.D{{"_id", id}})
+		require.NoError(t, err)
+		docs = append(docs, doc)
+		_, err = coll.InsertOne(t.Context(), bson.Raw(doc))
+		require.NoError(t, err)
+	}
+
+	// an earlier attempt dumped the first two documents
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, testDB), 0o755))
+	bsonPath := filepath.Join(dir, testDB, collName+".bson")
+	require.NoError(t, os.WriteFile(bsonPath, bytes.Join(docs[:2], nil), 0o644))
+	resume, err := openDumpResume(dir)
+	require.NoError(t, err)
+	resume.state.Namespaces[testDB+"."+collName] = &resumeNamespace{
+		LastID:    docs[1],
+		Documents: 2,
+		Size:      int64(len(docs[0]) + len(docs[1])),
+	}
+	require.NoError(t, resume.writeLocked())
+
+	md, err := simpleMongoDumpInstance()
+	require.NoError(t, err)
+	md.ToolOptions.Collection = collName
+	md.OutputOptions.Out = dir
+	md.OutputOptions.Resume = true
+	require.NoError(t, md.Init())
+	require.NoError(t, md.Dump())
+
+	file, err := os.Open(bsonPath)
+	require.NoError(t, err)
+	source := db.NewBSONSource(file)
+	defer source.Close()
+	var dumped [][]byte
+	for doc := source.LoadNext(); doc != nil; doc = source.LoadNext() {
+		dumped = append(dumped, slices.Clone(doc))
+	}
+	require.NoError(t, source.Err())
+	assert.Equal(t, docs, dumped, "every document is dumped once, in _id order")
+}
@@ -0,0 +1,159 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mongodb/mongo-tools/common"
+	"github.com/mongodb/mongo-tools/common/dumprestore"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/storage"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// summarizesSchema returns whether --schemaSummary samples the documents of
+// intent, which are those of the collections whose data is dumped.
+func (dump *MongoDump) summarizesSchema(intent *intents.Intent) bool {
+	if !dump.OutputOptions.SchemaSummary || intent.IsSpecialCollection() || intent.IsOplog() {
+		return false
+	}
+	return !intent.IsView() || dump.OutputOptions.ViewsAsCollections
+}
+
+// schemaSamplerFor returns the sampler of the documents of intent as they're
+// dumped to a directory, or nil if they aren't sampled. The schemas of the
+// collections of an archive are sampled before the prelude is written.
+func (dump *MongoDump) schemaSamplerFor(intent *intents.Intent) *dumprestore.SchemaSampler {
+	if !dump.summarizesSchema(intent) || dump.OutputOptions.Archive != "" ||
+		dump.OutputOptions.Out == "-" {
+		return nil
+	}
+	return dumprestore.NewSchemaSampler(dump.OutputOptions.SchemaSampleSize)
+}
+
+// marshalSchema returns the schema summary of intent as JSON.
+func marshalSchema(intent *intents.Intent, schema *dumprestore.Schema) ([]byte, error) {
+	content, err := schema.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf(
+			"error marshaling schema summary for collection %#q: %v",
+			intent.Namespace(),
+			err,
+		)
+	}
+	return content, nil
+}
+
+// writeSchema writes the schema summary of the documents that sampler sampled
+// to the .schema.json file next to the metadata file of intent.
+func (dump *MongoDump) writeSchema(
+	intent *intents.Intent,
+	sampler *dumprestore.SchemaSampler,
+) (err error) {
+	content, err := marshalSchema(intent, sampler.Schema())
+	if err != nil {
+		return err
+	}
+	path := dump.codec.FileName(
+		dump.outputPath(intent.DB, intent.C) + dumprestore.SchemaFileExtension,
+	)
+	log.Logvf(log.DebugLow, "writing schema summary of %#q to %#q", intent.Namespace(), path)
+
+	file, err := storage.OrLocal(dump.storage).Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating schema summary file %#q: %v", path, err)
+	}
+	defer func() {
+		closeErr := file.Close()
+		if err == nil && closeErr != nil {
+			err = fmt.Errorf("error writing schema summary file %#q: %v", path, closeErr)
+		}
+	}()
+
+	buffer := dump.getResettableOutputBuffer()
+	buffer.Reset(file)
+	_, err = buffer.Write(content)
+	if err == nil {
+		err = buffer.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("error writing schema summary file %#q: %v", path, err)
+	}
+	return nil
+}
+
+// sampleSchema samples the first documents of intent that are dumped, for
+// the prelude of an archive, which is written before any data is.
+func (dump *MongoDump) sampleSchema(intent *intents.Intent) (*dumprestore.Schema, error) {
+	session, err := dump.SessionProvider.GetSession()
+	if err != nil {
+		return nil, err
+	}
+	coll := session.Database(intent.DB).Collection(intent.C)
+	filter, projection := dump.queryFor(intent)
+	if intent.IsTimeseries() {
+		// the buckets are dumped, which --query filters by their meta field
+		// only, so the sample is of all of them
+		filter, projection = nil, nil
+		if !intent.ServerVersion.SupportsRawData() {
+			coll = session.Database(intent.DB).Collection(common.TimeseriesBucketPrefix + intent.C)
+		}
+	}
+	if filter == nil {
+		filter = bson.D{}
+	}
+	findOpts := options.Find()
+	if projection != nil {
+		findOpts.SetProjection(projection)
+	}
+	if dump.OutputOptions.SchemaSampleSize > 0 {
+		findOpts.SetLimit(dump.OutputOptions.SchemaSampleSize)
+	}
+
+	ctx := context.Background()
+	cursor, err := coll.Find(ctx, filter, findOpts)
+	if err != nil {
+		return nil, fmt.Errorf("error sampling %#q: %v", intent.Namespace(), err)
+	}
+	defer cursor.Close(ctx)
+	sampler := dumprestore.NewSchemaSampler(dump.OutputOptions.SchemaSampleSize)
+	for cursor.Next(ctx) {
+		if err := sampler.Add(cursor.Current); err != nil {
+			return nil, fmt.Errorf("error sampling %#q: %v", intent.Namespace(), err)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("error sampling %#q: %v", intent.Namespace(), err)
+	}
+	return sampler.Schema(), nil
+}
+
+// addSchemasToPrelude samples the schema of each collection of the archive
+// and adds it to the collection's metadata in the prelude.
+func (dump *MongoDump) addSchemasToPrelude() error {
+	for _, metadata := range dump.archive.Prelude.NamespaceMetadatas {
+		intent := dump.manager.IntentForNamespace(metadata.Database + "." + metadata.Collection)
+		if intent == nil || !dump.summarizesSchema(intent) {
+			continue
+		}
+		log.Logvf(log.DebugLow, "sampling schema of %#q", intent.Namespace())
+		schema, err := dump.sampleSchema(intent)
+		if err != nil {
+			return err
+		}
+		content, err := marshalSchema(intent, schema)
+		if err != nil {
+			return err
+		}
+		metadata.Schema = string(content)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"context"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+)
+
+// commandRunner returns the CommandRunner that mongodump runs the commands of
+// search indexes with, which tests stub.
+func (dump *MongoDump) commandRunner() db.CommandRunner {
+	if dump.runCommand != nil {
+		return dump.runCommand
+	}
+	return dump.SessionProvider.RunCommand
+}
+
+// listSearchIndexes returns the definitions of the Atlas Search and vector
+// search indexes of intent, for its metadata. A collection has none if the
+// server doesn't support search indexes, which is only asked once.
+func (dump *MongoDump) listSearchIndexes(intent *intents.Intent) []db.SearchIndex {
+	if dump.searchUnsupported.Load() || intent.IsTimeseries() {
+		return nil
+	}
+	switch intent.DB {
+	case "admin", "config", "local":
+		return nil
+	}
+
+	indexes, err := db.ListSearchIndexes(
+		context.Background(),
+		dump.commandRunner(),
+		intent.DB,
+		intent.C,
+	)
+	switch {
+	case db.IsSearchUnsupported(err):
+		log.Logvf(log.DebugLow, "not dumping search indexes, which the server doesn't support: %v", err)
+		dump.searchUnsupported.Store(true)
+		return nil
+	case err != nil:
+		log.Logvf(
+			log.Always,
+			"warning: not dumping search indexes of %#q: %v",
+			intent.Namespace(),
+			err,
+		)
+		return nil
+	}
+	return indexes
+}
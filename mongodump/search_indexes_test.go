@@ -0,0 +1,49 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestListSearchIndexes(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	definition := bson.D{{"mappings", bson.D{{"dynamic", true}}}}
+	var commands int
+	dump := &MongoDump{}
+	dump.runCommand = func(_ context.Context, dbName string, command bson.D) (bson.Raw, error) {
+		commands++
+		if dbName != "app" {
+			return nil, mongo.CommandError{Code: 31082, Name: "SearchNotEnabled"}
+		}
+		return bson.Marshal(bson.D{{"cursor", bson.D{
+			{"id", int64(0)},
+			{"firstBatch", bson.A{bson.D{{"name", "default"}, {"latestDefinition", definition}}}},
+		}}})
+	}
+
+	assert.Equal(
+		t,
+		[]db.SearchIndex{{Name: "default", Definition: definition}},
+		dump.listSearchIndexes(&intents.Intent{DB: "app", C: "movies"}),
+	)
+	assert.Nil(t, dump.listSearchIndexes(&intents.Intent{DB: "admin", C: "system.version"}))
+	assert.Equal(t, 1, commands, "system databases have no search indexes")
+
+	assert.Nil(t, dump.listSearchIndexes(&intents.Intent{DB: "other", C: "movies"}))
+	assert.Nil(t, dump.listSearchIndexes(&intents.Intent{DB: "app", C: "movies"}))
+	assert.Equal(t, 2, commands, "search indexes aren't listed once the server doesn't support them")
+}
@@ -0,0 +1,337 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongodump
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/dumprestore"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// configServerName is the name of the config server in the dump of a sharded
+// cluster. No shard can have this name.
+const configServerName = "config"
+
+var errClusterDumpAborted = errors.New("the dump of another part of the cluster failed")
+
+// clusterTimeBarrier holds back the dumps of the parts of a sharded cluster
+// until all of them have dumped their collections, and then gives them the
+// common cluster time that all of their oplogs are dumped up to.
+type clusterTimeBarrier struct {
+	mutex   sync.Mutex
+	waiting int
+	time    bson.Timestamp
+
+	done      chan struct{}
+	aborted   chan struct{}
+	abortOnce sync.Once
+}
+
+func newClusterTimeBarrier(parts int) *clusterTimeBarrier {
+	return &clusterTimeBarrier{
+		waiting: parts,
+		done:    make(chan struct{}),
+		aborted: make(chan struct{}),
+	}
+}
+
+// wait records the latest oplog time of a part and blocks until every part has
+// recorded its own. It returns the latest of these times, which is the cluster
+// time of the dump.
+func (barrier *clusterTimeBarrier) wait(ts bson.Timestamp) (bson.Timestamp, error) {
+	barrier.mutex.Lock()
+	if util.TimestampGreaterThan(ts, barrier.time) {
+		barrier.time = ts
+	}
+	barrier.waiting--
+	if barrier.waiting == 0 {
+		close(barrier.done)
+	}
+	barrier.mutex.Unlock()
+
+	select {
+	case <-barrier.done:
+		return barrier.clusterTime(), nil
+	case <-barrier.aborted:
+		return bson.Timestamp{}, errClusterDumpAborted
+	}
+}
+
+// abort releases the parts waiting at the barrier with an error.
+func (barrier *clusterTimeBarrier) abort() {
+	barrier.abortOnce.Do(func() { close(barrier.aborted) })
+}
+
+func (barrier *clusterTimeBarrier) clusterTime() bson.Timestamp {
+	barrier.mutex.Lock()
+	defer barrier.mutex.Unlock()
+	return barrier.time
+}
+
+// getOplogEnd returns the timestamp that the oplog is dumped up to. The dump of
+// a part of a sharded cluster ends at the cluster time of the whole dump rather
+// than at its own latest oplog entry.
+func (dump *MongoDump) getOplogEnd() (bson.Timestamp, error) {
+	end, err := dump.getCurrentOplogTime()
+	if err != nil || dump.clusterTime == nil {
+		return end, err
+	}
+
+	clusterTime, err := dump.clusterTime.wait(end)
+	if err != nil {
+		return bson.Timestamp{}, err
+	}
+	// The note is only written if the oplog is behind the cluster time. Either
+	// way, everything written after this gets a later timestamp, so the oplog
+	// can't gain any more entries at or before the cluster time.
+	err = dump.SessionProvider.Run(bson.D{
+		{"appendOplogNote", 1},
+		{"maxClusterTime", clusterTime},
+		{"data", bson.D{{"msg", "mongodump cluster time"}}},
+	}, &bson.M{}, "admin")
+	if err != nil {
+		return bson.Timestamp{}, fmt.Errorf("error advancing the oplog to the cluster time: %v", err)
+	}
+	return clusterTime, nil
+}
+
+// DumpShardedCluster dumps the config server and every shard of the cluster
+// behind the connected mongos, each with its oplog, into directories of their
+// own. The balancer is stopped while the dump runs, and all of the oplogs end
+// at the same cluster time, which is recorded in the cluster manifest.
+func (dump *MongoDump) DumpShardedCluster() error {
+	manifest := &dumprestore.ClusterManifest{ToolVersion: dump.ToolOptions.VersionStr}
+	var err error
+	manifest.ConfigServer, manifest.Shards, err = dump.listClusterParts()
+	if err != nil {
+		return err
+	}
+	parts := manifest.Parts()
+
+	restartBalancer, err := dump.stopBalancer()
+	if err != nil {
+		return err
+	}
+	defer restartBalancer()
+
+	barrier := newClusterTimeBarrier(len(parts))
+	dumps := make([]*MongoDump, len(parts))
+	for i, part := range parts {
+		dumps[i], err = dump.newPartDump(part, barrier)
+		if err != nil {
+			for _, partDump := range dumps[:i] {
+				partDump.SessionProvider.Close()
+			}
+			return fmt.Errorf("error connecting to %v: %v", part.Name, err)
+		}
+	}
+
+	dump.shutdownIntentsNotifier = newNotifier()
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-dump.shutdownIntentsNotifier.notified:
+			barrier.abort()
+			for _, partDump := range dumps {
+				partDump.HandleInterrupt()
+			}
+		case <-finished:
+		}
+	}()
+
+	errs := make([]error, len(parts))
+	var wg sync.WaitGroup
+	for i, partDump := range dumps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Logvf(log.Always, "dumping %v from %v", parts[i].Name, parts[i].Host)
+			errs[i] = partDump.Dump()
+			if errs[i] != nil {
+				barrier.abort()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// report the error that aborted the dump rather than those it caused
+	err = nil
+	for i, partErr := range errs {
+		if partErr != nil && (err == nil || errors.Is(err, errClusterDumpAborted)) {
+			err = fmt.Errorf("error dumping %v: %w", parts[i].Name, partErr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	manifest.ClusterTime = barrier.clusterTime()
+	manifest.ConfigServer.OplogStart = dumps[0].oplogStart
+	for i := range manifest.Shards {
+		manifest.Shards[i].OplogStart = dumps[i+1].oplogStart
+	}
+	path := filepath.Join(dump.topLevelDir(), dumprestore.ClusterManifestFileName)
+	err = manifest.Write(path)
+	if err != nil {
+		return err
+	}
+	log.Logvf(log.Always, "dumped %v %v and the config server consistent at cluster time %v",
+		len(manifest.Shards), util.Pluralize(len(manifest.Shards), "shard", "shards"),
+		manifest.ClusterTime)
+	return nil
+}
+
+// listClusterParts returns the config server and the shards of the cluster,
+// which are read from config.shards.
+func (dump *MongoDump) listClusterParts() (
+	dumprestore.ClusterPart,
+	[]dumprestore.ClusterPart,
+	error,
+) {
+	var status struct {
+		Sharding struct {
+			ConfigServer string `bson:"configsvrConnectionString"`
+		} `bson:"sharding"`
+	}
+	err := dump.SessionProvider.Run(bson.D{{"serverStatus", 1}}, &status, "admin")
+	if err != nil {
+		return dumprestore.ClusterPart{}, nil, fmt.Errorf("error finding config server: %v", err)
+	}
+	if status.Sharding.ConfigServer == "" {
+		return dumprestore.ClusterPart{}, nil, fmt.Errorf("mongos did not report its config server")
+	}
+	configServer := dumprestore.ClusterPart{
+		Name:      configServerName,
+		Host:      status.Sharding.ConfigServer,
+		Directory: configServerName,
+	}
+
+	cursor, err := dump.SessionProvider.DB("config").Collection("shards").
+		Find(context.Background(), bson.D{})
+	if err != nil {
+		return dumprestore.ClusterPart{}, nil, fmt.Errorf("error listing shards: %v", err)
+	}
+	var shardDocs []struct {
+		ID   string `bson:"_id"`
+		Host string `bson:"host"`
+	}
+	err = cursor.All(context.Background(), &shardDocs)
+	if err != nil {
+		return dumprestore.ClusterPart{}, nil, fmt.Errorf("error listing shards: %v", err)
+	}
+	if len(shardDocs) == 0 {
+		return dumprestore.ClusterPart{}, nil, fmt.Errorf("the cluster has no shards")
+	}
+
+	var shards []dumprestore.ClusterPart
+	for _, doc := range shardDocs {
+		// shard names become directory names
+		if err := util.ValidateDBName(doc.ID); err != nil {
+			return dumprestore.ClusterPart{}, nil, fmt.Errorf(
+				"validating shard name %#q: %w", doc.ID, err,
+			)
+		}
+		shards = append(shards, dumprestore.ClusterPart{
+			Name:      doc.ID,
+			Host:      doc.Host,
+			Directory: doc.ID,
+		})
+	}
+	return configServer, shards, nil
+}
+
+// stopBalancer stops the balancer of the cluster so that no chunk migrates
+// while the shards are dumped. It returns a function that starts the balancer
+// again, unless it was stopped to begin with.
+func (dump *MongoDump) stopBalancer() (func(), error) {
+	var status struct {
+		Mode string `bson:"mode"`
+	}
+	err := dump.SessionProvider.Run(bson.D{{"balancerStatus", 1}}, &status, "admin")
+	if err != nil {
+		return nil, fmt.Errorf("error getting balancer status: %v", err)
+	}
+	if status.Mode == "off" {
+		log.Logv(log.Info, "the balancer is already stopped")
+		return func() {}, nil
+	}
+
+	log.Logv(log.Always, "stopping the balancer")
+	err = dump.SessionProvider.Run(bson.D{{"balancerStop", 1}}, &bson.M{}, "admin")
+	if err != nil {
+		return nil, fmt.Errorf("error stopping the balancer: %v", err)
+	}
+	return func() {
+		log.Logv(log.Always, "restarting the balancer")
+		err := dump.SessionProvider.Run(bson.D{{"balancerStart", 1}}, &bson.M{}, "admin")
+		if err != nil {
+			log.Logvf(log.Always, "warning: failed to restart the balancer: %v", err)
+		}
+	}, nil
+}
+
+// newPartDump returns an initialized dump of a part of the cluster, which
+// dumps the part with its oplog into its own directory of the output.
+func (dump *MongoDump) newPartDump(
+	part dumprestore.ClusterPart,
+	barrier *clusterTimeBarrier,
+) (*MongoDump, error) {
+	outputOptions := *dump.OutputOptions
+	outputOptions.Out = filepath.Join(dump.topLevelDir(), part.Directory)
+	outputOptions.Oplog = true
+	outputOptions.ShardedOplog = false
+
+	partDump := &MongoDump{
+		ToolOptions:       partToolOptions(dump.ToolOptions, part.Host),
+		InputOptions:      dump.InputOptions,
+		OutputOptions:     &outputOptions,
+		SkipUsersAndRoles: dump.SkipUsersAndRoles,
+		ProgressManager:   dump.ProgressManager,
+		OutputWriter:      dump.OutputWriter,
+		clusterTime:       barrier,
+	}
+	err := partDump.Init()
+	if err != nil {
+		return nil, err
+	}
+	return partDump, nil
+}
+
+// partToolOptions returns a copy of the options with the connection to the
+// mongos replaced by one to host, a replica set given as "name/host1,host2".
+// Everything else, like credentials and TLS settings, is kept.
+func partToolOptions(opts *options.ToolOptions, host string) *options.ToolOptions {
+	hosts, setName := util.SplitHostArg(host)
+
+	partOpts := *opts
+	connection := *opts.Connection
+	connection.Host = host
+	connection.Port = ""
+	partOpts.Connection = &connection
+
+	uri := *opts.URI
+	connString := *opts.URI.ConnString
+	connString.Hosts = hosts
+	connString.ReplicaSet = setName
+	connString.DirectConnection = false
+	connString.DirectConnectionSet = false
+	uri.ConnString = &connString
+	partOpts.URI = &uri
+
+	partOpts.ReplicaSetName = setName
+	partOpts.Direct = setName == ""
+	return &partOpts
+}
@@ -0,0 +1,141 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/dumprestore"
+	"github.com/mongodb/mongo-tools/common/idx"
+	"github.com/mongodb/mongo-tools/common/log"
+)
+
+// clusterRestore is the state shared by the restores of the parts of a dump
+// of a sharded cluster, which mongodump --shardedOplog writes.
+type clusterRestore struct {
+	dir      string
+	manifest *dumprestore.ClusterManifest
+
+	mutex      sync.Mutex
+	namespaces map[string]bool
+}
+
+// firstRestoreOf returns true the first time it's called with a namespace. A
+// sharded collection is dumped from every shard that owns some of its chunks,
+// and it must only be dropped before the first of them is restored.
+func (cluster *clusterRestore) firstRestoreOf(namespace string) bool {
+	if cluster == nil {
+		return true
+	}
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+	if cluster.namespaces[namespace] {
+		return false
+	}
+	cluster.namespaces[namespace] = true
+	return true
+}
+
+// configServerDir returns the directory of the dump of the config server.
+func (cluster *clusterRestore) configServerDir() string {
+	return filepath.Join(cluster.dir, cluster.manifest.ConfigServer.Directory)
+}
+
+// readClusterManifest returns the cluster manifest of the dump directory being
+// restored, or nil if it isn't the dump of a sharded cluster.
+func (restore *MongoRestore) readClusterManifest() (*dumprestore.ClusterManifest, error) {
+	if restore.InputOptions.Archive != "" || restore.TargetDirectory == "-" ||
+		restore.storage != nil || restore.cluster != nil {
+		return nil, nil
+	}
+	dir := restore.TargetDirectory
+	if dir == "" {
+		dir = "dump"
+	}
+	manifest, err := dumprestore.ReadClusterManifest(
+		filepath.Join(dir, dumprestore.ClusterManifestFileName),
+	)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return manifest, err
+}
+
+// restoreCluster restores the dump of a sharded cluster. The config server is
+// restored first, for its users and roles, and then each shard. The oplog of
+// each part is replayed after the part, which brings every one of them to the
+// cluster time of the dump. The config database itself is never restored.
+// The target can't be a mongos, which doesn't support replaying oplogs.
+func (restore *MongoRestore) restoreCluster(manifest *dumprestore.ClusterManifest) Result {
+	switch {
+	case !restore.InputOptions.OplogReplay:
+		return Result{Err: fmt.Errorf(
+			"restoring the dump of a sharded cluster requires --oplogReplay",
+		)}
+	case restore.InputOptions.OplogFile != "" || len(restore.InputOptions.OplogSegments) > 0:
+		return Result{Err: fmt.Errorf(
+			"cannot use --oplogFile or --oplogSegment when restoring the dump of a sharded cluster",
+		)}
+	case restore.OutputOptions.Resume != "":
+		return Result{Err: fmt.Errorf(
+			"cannot use --resume when restoring the dump of a sharded cluster",
+		)}
+	case restore.isMongos:
+		// the oplog of each part is replayed with applyOps, which mongos
+		// doesn't support
+		return Result{Err: fmt.Errorf(
+			"cannot restore the dump of a sharded cluster through a mongos, since its oplogs " +
+				"can't be replayed there; restore it to a replica set or standalone instead",
+		)}
+	}
+
+	cluster := &clusterRestore{
+		dir:        restore.TargetDirectory,
+		manifest:   manifest,
+		namespaces: map[string]bool{},
+	}
+	if cluster.dir == "" {
+		cluster.dir = "dump"
+	}
+	log.Logvf(log.Always, "restoring the dump of a sharded cluster at cluster time %v",
+		manifest.ClusterTime)
+
+	var totalResult Result
+	for i, part := range manifest.Parts() {
+		dir := filepath.Join(cluster.dir, part.Directory)
+		log.Logvf(log.Always, "restoring %v from %#q", part.Name, dir)
+		partRestore := &MongoRestore{
+			ToolOptions:     restore.ToolOptions,
+			InputOptions:    restore.InputOptions,
+			OutputOptions:   restore.OutputOptions,
+			NSOptions:       restore.NSOptions,
+			SessionProvider: restore.SessionProvider,
+			ProgressManager: restore.ProgressManager,
+			TargetDirectory: dir,
+			// users and roles of a sharded cluster are on its config server
+			SkipUsersAndRoles: restore.SkipUsersAndRoles || i > 0,
+			InputReader:       restore.InputReader,
+			PlanWriter:        restore.PlanWriter,
+			isMongos:          restore.isMongos,
+			isAtlasProxy:      restore.isAtlasProxy,
+			indexCatalog:      idx.NewIndexCatalog(),
+			indexBuilds:       restore.indexBuilds,
+			serverVersion:     restore.serverVersion,
+			cluster:           cluster,
+		}
+		result := partRestore.Restore()
+		totalResult.combineWith(result)
+		if result.Err != nil {
+			return totalResult.withErr(fmt.Errorf("error restoring %v: %v", part.Name, result.Err))
+		}
+	}
+	return totalResult
+}
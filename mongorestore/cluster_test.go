@@ -0,0 +1,65 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/dumprestore"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestReadClusterManifest(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	dir := t.TempDir()
+	mr := newMongoRestore()
+	mr.OutputOptions = &OutputOptions{}
+	mr.TargetDirectory = dir
+
+	manifest, err := mr.readClusterManifest()
+	require.NoError(t, err)
+	assert.Nil(t, manifest, "a dump without a cluster manifest")
+
+	written := &dumprestore.ClusterManifest{
+		ClusterTime:  bson.Timestamp{T: 100, I: 2},
+		ConfigServer: dumprestore.ClusterPart{Name: "config", Directory: "config"},
+		Shards: []dumprestore.ClusterPart{
+			{Name: "rs0", Host: "rs0/a:27018", Directory: "rs0", OplogStart: bson.Timestamp{T: 90}},
+			{Name: "rs1", Host: "rs1/b:27018", Directory: "rs1", OplogStart: bson.Timestamp{T: 91}},
+		},
+	}
+	require.NoError(t, written.Write(filepath.Join(dir, dumprestore.ClusterManifestFileName)))
+
+	manifest, err = mr.readClusterManifest()
+	require.NoError(t, err)
+	assert.Equal(t, written, manifest)
+	var names []string
+	for _, part := range manifest.Parts() {
+		names = append(names, part.Name)
+	}
+	assert.Equal(t, []string{"config", "rs0", "rs1"}, names, "the config server comes first")
+
+	result := mr.restoreCluster(manifest)
+	assert.ErrorContains(t, result.Err, "requires --oplogReplay")
+
+	mr.InputOptions.OplogReplay = true
+	mr.isMongos = true
+	result = mr.restoreCluster(manifest)
+	assert.ErrorContains(t, result.Err, "through a mongos", "mongos doesn't support applyOps")
+
+	cluster := &clusterRestore{dir: dir, manifest: manifest, namespaces: map[string]bool{}}
+	assert.Equal(t, filepath.Join(dir, "config"), cluster.configServerDir())
+	assert.True(t, cluster.firstRestoreOf("db.c"))
+	assert.False(t, cluster.firstRestoreOf("db.c"), "only the first part drops a collection")
+	assert.True(t, cluster.firstRestoreOf("db.d"))
+	assert.True(t, (*clusterRestore)(nil).firstRestoreOf("db.c"))
+}
@@ -7,19 +7,26 @@
 package mongorestore
 
 import (
-	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"sync/atomic"
 
 	"github.com/mongodb/mongo-tools/common"
 	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/dumprestore"
+	"github.com/mongodb/mongo-tools/common/encryption"
 	"github.com/mongodb/mongo-tools/common/intents"
 	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/storage"
 	"github.com/mongodb/mongo-tools/common/util"
 )
 
@@ -98,7 +105,14 @@ type realBSONFile struct {
 	// intent.file ( a ReadWriteOpenCloser )
 	errorWriter
 	intent *intents.Intent
-	gzip   bool
+	codec  *compression.Codec
+	key    *encryption.Key
+
+	// partitions are the files of the other partitions of a collection that
+	// mongodump split into _id ranges, which are read after path
+	partitions []string
+	// storage is where the file is read from, or nil for the local file system
+	storage storage.Backend
 }
 
 // Open is part of the intents.file interface. realBSONFiles need to be Opened before Read
@@ -108,22 +122,147 @@ func (f *realBSONFile) Open() (err error) {
 		// this error shouldn't happen normally
 		return fmt.Errorf("error reading BSON file for %#q", f.intent.Namespace())
 	}
-	file, err := os.Open(f.path)
+	if len(f.partitions) > 0 {
+		reader := &partitionReader{open: f.openFile, paths: f.partitions}
+		reader.current, err = f.openFile(f.path)
+		f.PosReader = reader
+		return err
+	}
+	f.PosReader, err = f.openFile(f.path)
+	return err
+}
+
+// openFile opens a BSON file to read its decoded content.
+func (f *realBSONFile) openFile(path string) (PosReader, error) {
+	file, err := storage.OrLocal(f.storage).Open(path)
 	if err != nil {
-		return fmt.Errorf("error reading BSON file %#q: %v", f.path, err)
+		return nil, fmt.Errorf("error reading BSON file %#q: %v", path, err)
 	}
 	posFile := &posTrackingReader{0, file}
-	if f.gzip {
-		gzFile, err := gzip.NewReader(posFile)
-		posUncompressedFile := &posTrackingReader{0, gzFile}
+	in, err := encryption.Decrypt(posFile, f.key)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading BSON file %#q: %v", path, err)
+	}
+	zFile := io.NopCloser(in)
+	if f.codec != nil {
+		zFile, err = f.codec.NewReader(in)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error decompressing compressed BSON file %#q: %v", path, err)
+		}
+	}
+	// Progress is reported against the size of the file on disk, so the
+	// position is taken from the file rather than from the decoded stream.
+	posUncompressedFile := &posTrackingReader{0, zFile}
+	return &mixedPosTrackingReader{
+		readHolder: posUncompressedFile,
+		posHolder:  posFile}, nil
+}
+
+// partitionReader reads the files of the partitions of a collection one after
+// the other, as one stream.
+type partitionReader struct {
+	open  func(path string) (PosReader, error)
+	paths []string
+
+	mutex   sync.Mutex
+	current PosReader
+	// done is the position at the end of the files that were read
+	done int64
+}
+
+func (r *partitionReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.current.Read(p)
+		if err != io.EOF || n > 0 || len(r.paths) == 0 {
+			return n, err
+		}
+		err = r.current.Close()
+		if err != nil {
+			return 0, err
+		}
+		next, err := r.open(r.paths[0])
 		if err != nil {
-			return fmt.Errorf("error decompressing compressed BSON file %#q: %v", f.path, err)
+			return 0, err
+		}
+		r.mutex.Lock()
+		r.done += r.current.Pos()
+		r.current = next
+		r.mutex.Unlock()
+		r.paths = r.paths[1:]
+	}
+}
+
+func (r *partitionReader) Pos() int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.done + r.current.Pos()
+}
+
+func (r *partitionReader) Close() error {
+	return r.current.Close()
+}
+
+// isPartitionFile returns whether name is the file of a partition other than
+// the first of a collection that mongodump split into _id ranges.
+func (restore *MongoRestore) isPartitionFile(name string) bool {
+	name, ok := strings.CutSuffix(name, restore.codec.FileName(""))
+	if !ok {
+		return false
+	}
+	_, _, ok = dumprestore.ParsePartitionFileName(name)
+	return ok
+}
+
+// isSchemaFile returns whether name is a schema summary written by mongodump
+// with --schemaSummary, which isn't restored.
+func (restore *MongoRestore) isSchemaFile(name string) bool {
+	return strings.HasSuffix(name, restore.codec.FileName(dumprestore.SchemaFileExtension))
+}
+
+// findPartitions returns the files of the other partitions of the collection
+// whose first partition is at path, in order, and their total size.
+func (restore *MongoRestore) findPartitions(path string) ([]string, int64, error) {
+	base := strings.TrimSuffix(path, restore.codec.FileName(""))
+	var paths []string
+	var size int64
+	for n := 1; ; n++ {
+		partPath := restore.codec.FileName(dumprestore.PartitionFileName(base, n))
+		info, err := storage.OrLocal(restore.storage).Stat(partPath)
+		if errors.Is(err, os.ErrNotExist) {
+			return paths, size, nil
+		} else if err != nil {
+			return nil, 0, fmt.Errorf("error reading partition %#q: %v", partPath, err)
+		}
+		log.Logvf(log.DebugLow, "found partition %#q", partPath)
+		paths = append(paths, partPath)
+		size += info.Size()
+	}
+}
+
+// checkPartitions returns an error if the files of a collection that the dump
+// recorded as split into partitions aren't all found, since the documents of
+// the missing partitions would otherwise silently be left out of the restore.
+func (restore *MongoRestore) checkPartitions() error {
+	for _, ns := range slices.Sorted(maps.Keys(restore.dumpedPartitions)) {
+		intent := restore.manager.IntentForNamespace(ns)
+		if intent == nil {
+			continue
+		}
+		bsonFile, ok := intent.BSONFile.(*realBSONFile)
+		if !ok {
+			continue
+		}
+		want := restore.dumpedPartitions[ns]
+		if found := len(bsonFile.partitions) + 1; found != want {
+			return fmt.Errorf(
+				"%#q was dumped in %v partitions, but the files of %v partitions were found",
+				ns,
+				want,
+				found,
+			)
 		}
-		f.PosReader = &mixedPosTrackingReader{
-			readHolder: posUncompressedFile,
-			posHolder:  posFile}
-	} else {
-		f.PosReader = posFile
 	}
 	return nil
 }
@@ -140,7 +279,10 @@ type realMetadataFile struct {
 	// intent.file ( a ReadWriteOpenCloser )
 	errorWriter
 	intent *intents.Intent
-	gzip   bool
+	codec  *compression.Codec
+	key    *encryption.Key
+	// storage is where the file is read from, or nil for the local file system
+	storage storage.Backend
 }
 
 // Open is part of the intents.file interface. realMetadataFiles need to be Opened before Read
@@ -149,19 +291,24 @@ func (f *realMetadataFile) Open() (err error) {
 	if f.path == "" {
 		return fmt.Errorf("error reading metadata for %#q", f.intent.Namespace())
 	}
-	file, err := os.Open(f.path)
+	file, err := storage.OrLocal(f.storage).Open(f.path)
+	if err != nil {
+		return fmt.Errorf("error reading metadata %#q: %v", f.path, err)
+	}
+	in, err := encryption.Decrypt(file, f.key)
 	if err != nil {
+		file.Close()
 		return fmt.Errorf("error reading metadata %#q: %v", f.path, err)
 	}
-	if f.gzip {
-		gzFile, err := gzip.NewReader(file)
+	zFile := io.NopCloser(in)
+	if f.codec != nil {
+		zFile, err = f.codec.NewReader(in)
 		if err != nil {
+			file.Close()
 			return fmt.Errorf("error reading compressed metadata %#q: %v", f.path, err)
 		}
-		f.ReadCloser = &util.WrappedReadCloser{gzFile, file}
-	} else {
-		f.ReadCloser = file
 	}
+	f.ReadCloser = &util.WrappedReadCloser{zFile, file}
 	return nil
 }
 
@@ -222,18 +369,20 @@ func (restore *MongoRestore) getInfoFromFile(filename string) (string, FileType,
 	if before, ok := strings.CutSuffix(baseFileName, ".bin"); ok {
 		collName = before
 		fileType = BSONFileType
-	} else if restore.InputOptions.Gzip && restore.InputOptions.Archive == "" {
-		// Gzip indicates that files in a dump directory should have a .gz suffix
-		// but it does not indicate that the "files" provided by the archive should,
-		// compressed or otherwise.
-		if before, ok := strings.CutSuffix(baseFileName, ".metadata.json.gz"); ok {
+	} else if restore.codec != nil && restore.InputOptions.Archive == "" {
+		// A codec indicates that files in a dump directory should have the codec's
+		// suffix but it does not indicate that the "files" provided by the archive
+		// should, compressed or otherwise.
+		bsonExt := restore.codec.FileName(".bson")
+		metadataExt := restore.codec.FileName(".metadata.json")
+		if before, ok := strings.CutSuffix(baseFileName, metadataExt); ok {
 			collName = before
 			fileType = MetadataFileType
 			metadataFullPath = filename
-		} else if before, ok := strings.CutSuffix(baseFileName, ".bson.gz"); ok {
+		} else if before, ok := strings.CutSuffix(baseFileName, bsonExt); ok {
 			collName = before
 			fileType = BSONFileType
-			metadataFullPath = strings.TrimSuffix(filename, ".bson.gz") + ".metadata.json.gz"
+			metadataFullPath = strings.TrimSuffix(filename, bsonExt) + metadataExt
 		}
 	} else if before, ok := strings.CutSuffix(baseFileName, ".metadata.json"); ok {
 		collName = before
@@ -286,8 +435,10 @@ func (restore *MongoRestore) getCollectionNameFromMetadata(
 
 	// Open the metadata file for reading.
 	metadataFile := &realMetadataFile{
-		path: metadataFullPath,
-		gzip: strings.HasSuffix(metadataFullPath, ".gz"),
+		path:    metadataFullPath,
+		codec:   compression.ByExtension(metadataFullPath),
+		key:     restore.encryptionKey,
+		storage: restore.storage,
 	}
 	err := metadataFile.Open()
 	if err != nil {
@@ -379,9 +530,11 @@ func (restore *MongoRestore) CreateAllIntents(dir archive.DirLike) error {
 					}
 				} else {
 					oplogIntent.BSONFile = &realBSONFile{
-						path:   entry.Path(),
-						intent: oplogIntent,
-						gzip:   restore.InputOptions.Gzip,
+						path:    entry.Path(),
+						intent:  oplogIntent,
+						codec:   restore.codec,
+						key:     restore.encryptionKey,
+						storage: restore.storage,
 					}
 				}
 				restore.manager.Put(oplogIntent)
@@ -422,7 +575,8 @@ func (restore *MongoRestore) CreateIntentForOplog() error {
 	intent.BSONFile = &realBSONFile{
 		path:   target.Path(),
 		intent: intent,
-		gzip:   restore.InputOptions.Gzip,
+		codec:  restore.codec,
+		key:    restore.encryptionKey,
 	}
 	restore.manager.PutOplogIntent(intent, "oplogFile")
 	return nil
@@ -442,6 +596,12 @@ func (restore *MongoRestore) CreateIntentsForDB(db string, dir archive.DirLike)
 		if entry.IsDir() {
 			log.Logvf(log.Always, `don't know what to do with subdirectory %#q, skipping...`,
 				filepath.Join(dir.Name(), entry.Name()))
+		} else if restore.InputOptions.Archive == "" && restore.isPartitionFile(entry.Name()) {
+			// read along with the collection's first partition
+			continue
+		} else if restore.InputOptions.Archive == "" && restore.isSchemaFile(entry.Name()) {
+			log.Logvf(log.DebugLow, "skipping schema summary %#q", entry.Path())
+			continue
 		} else {
 			// Pass the full file path in case a .metadata.json file needs to be opened and inspected.
 			collection, fileType, err := restore.getInfoFromFile(entry.Path())
@@ -478,6 +638,15 @@ func (restore *MongoRestore) CreateIntentsForDB(db string, dir archive.DirLike)
 					)
 					skip = true
 				}
+				if skipsPreImages(db, collection) {
+					log.Logvf(
+						log.Always,
+						"warning: not restoring the change stream pre-images of %#q, since the "+
+							"server manages that collection and doesn't accept writes to it",
+						db+"."+collection,
+					)
+					skip = true
+				}
 				// skip restoring the indexes collection if we are using metadata
 				// files to store index information, to eliminate redundancy
 				if collection == "system.indexes" && usesMetadataFiles {
@@ -550,11 +719,19 @@ func (restore *MongoRestore) CreateIntentsForDB(db string, dir archive.DirLike)
 					if skip {
 						continue
 					}
+					partitions, size, err := restore.findPartitions(entry.Path())
+					if err != nil {
+						return err
+					}
+					intent.Size += size
 					intent.Location = entry.Path()
 					intent.BSONFile = &realBSONFile{
-						path:   entry.Path(),
-						intent: intent,
-						gzip:   restore.InputOptions.Gzip,
+						path:       entry.Path(),
+						intent:     intent,
+						codec:      restore.codec,
+						key:        restore.encryptionKey,
+						storage:    restore.storage,
+						partitions: partitions,
 					}
 				}
 				log.Logvf(log.Info, "found collection %#q bson to restore to %#q", sourceNS, destNS)
@@ -564,6 +741,10 @@ func (restore *MongoRestore) CreateIntentsForDB(db string, dir archive.DirLike)
 					log.Logvf(log.DebugLow, "skipping restore of system.profile metadata")
 					continue
 				}
+				if skipsPreImages(db, collection) {
+					log.Logvf(log.DebugLow, "skipping restore of %#q metadata", db+"."+collection)
+					continue
+				}
 
 				checkSourceNS := sourceNS
 				if trimmedColl, ok := strings.CutPrefix(
@@ -616,9 +797,11 @@ func (restore *MongoRestore) CreateIntentsForDB(db string, dir archive.DirLike)
 				} else {
 					intent.MetadataLocation = entry.Path()
 					intent.MetadataFile = &realMetadataFile{
-						path:   entry.Path(),
-						intent: intent,
-						gzip:   restore.InputOptions.Gzip,
+						path:    entry.Path(),
+						intent:  intent,
+						codec:   restore.codec,
+						key:     restore.encryptionKey,
+						storage: restore.storage,
 					}
 				}
 				log.Logvf(
@@ -675,12 +858,19 @@ func (restore *MongoRestore) CreateIntentForCollection(
 	if bsonFile.IsDir() {
 		return fmt.Errorf("file %#q is a directory, not a bson file", bsonFile.Path())
 	}
+	if restore.detectCodec {
+		restore.codec = compression.ByExtension(bsonFile.Name())
+	}
 	_, fileType, err := restore.getInfoFromFile(bsonFile.Path())
 	if err != nil {
 		return err
 	}
 	if fileType != BSONFileType {
-		return fmt.Errorf("file %#q does not have .bson or .bson.gz extension", bsonFile.Path())
+		return fmt.Errorf(
+			"file %#q does not have %v extension",
+			bsonFile.Path(),
+			restore.codec.FileName(".bson"),
+		)
 	}
 
 	var isTimeseries bool
@@ -701,10 +891,18 @@ func (restore *MongoRestore) CreateIntentForCollection(
 	if isTimeseries {
 		intent.Type = "timeseries"
 	}
+	partitions, size, err := restore.findPartitions(bsonFile.Path())
+	if err != nil {
+		return err
+	}
+	intent.Size += size
 	intent.BSONFile = &realBSONFile{
-		path:   bsonFile.Path(),
-		intent: intent,
-		gzip:   restore.InputOptions.Gzip,
+		path:       bsonFile.Path(),
+		intent:     intent,
+		codec:      restore.codec,
+		key:        restore.encryptionKey,
+		storage:    restore.storage,
+		partitions: partitions,
 	}
 	// Check if the bson file has a corresponding .metadata.json file in its folder. If there's a
 	// directory error, log a note but attempt to restore without the metadata file anyway.
@@ -724,12 +922,8 @@ func (restore *MongoRestore) CreateIntentForCollection(
 	}
 
 	// Change out the extension from the bson file name to get the metadata file name.
-	var metadataName string
-	if restore.InputOptions.Gzip {
-		metadataName = strings.TrimSuffix(bsonFile.Name(), ".bson.gz") + ".metadata.json.gz"
-	} else {
-		metadataName = strings.TrimSuffix(bsonFile.Name(), ".bson") + ".metadata.json"
-	}
+	metadataName := strings.TrimSuffix(bsonFile.Name(), restore.codec.FileName(".bson")) +
+		restore.codec.FileName(".metadata.json")
 
 	if isTimeseries {
 		metadataName = strings.TrimPrefix(metadataName, common.TimeseriesBucketPrefix)
@@ -742,9 +936,11 @@ func (restore *MongoRestore) CreateIntentForCollection(
 			log.Logvf(log.Info, "found metadata for collection at %#q", metadataPath)
 			intent.MetadataLocation = metadataPath
 			intent.MetadataFile = &realMetadataFile{
-				path:   metadataPath,
-				intent: intent,
-				gzip:   restore.InputOptions.Gzip,
+				path:    metadataPath,
+				intent:  intent,
+				codec:   restore.codec,
+				key:     restore.encryptionKey,
+				storage: restore.storage,
 			}
 			break
 		}
@@ -784,6 +980,9 @@ func hasMetadataFiles(files []archive.DirLike) bool {
 func (restore *MongoRestore) handleBSONInsteadOfDirectory(path string) error {
 	// we know we have been given a non-directory, so we should handle it
 	// like a bson file and infer as much as we can
+	if restore.detectCodec {
+		restore.codec = compression.ByExtension(path)
+	}
 	if restore.ToolOptions.Collection == "" {
 		// if the user did not set -c, get the collection name from the bson file
 		newCollectionName, fileType, err := restore.getInfoFromFile(path)
@@ -792,7 +991,11 @@ func (restore *MongoRestore) handleBSONInsteadOfDirectory(path string) error {
 		}
 
 		if fileType != BSONFileType {
-			return fmt.Errorf("file %#q does not have .bson extension", path)
+			return fmt.Errorf(
+				"file %#q does not have %v extension",
+				path,
+				restore.codec.FileName(".bson"),
+			)
 		}
 		restore.ToolOptions.Collection = newCollectionName
 		log.Logvf(
@@ -823,16 +1026,23 @@ type actualPath struct {
 	os.FileInfo
 	path   string
 	parent *actualPath
+	// storage is where the path is, or nil for the local file system
+	storage storage.Backend
 }
 
 func newActualPath(dir string) (*actualPath, error) {
-	stat, err := os.Stat(dir)
+	return newActualPathIn(nil, dir)
+}
+
+// newActualPathIn returns the path dir of backend.
+func newActualPathIn(backend storage.Backend, dir string) (*actualPath, error) {
+	stat, err := storage.OrLocal(backend).Stat(dir)
 	if err != nil {
 		return nil, err
 	}
 	path := filepath.Dir(filepath.Clean(dir))
-	parent := &actualPath{}
-	parentStat, err := os.Stat(path)
+	parent := &actualPath{storage: backend}
+	parentStat, err := storage.OrLocal(backend).Stat(path)
 	if err == nil {
 		parent.FileInfo = parentStat
 		parent.path = filepath.Dir(path)
@@ -841,6 +1051,7 @@ func newActualPath(dir string) (*actualPath, error) {
 		FileInfo: stat,
 		path:     path,
 		parent:   parent,
+		storage:  backend,
 	}
 	return ap, nil
 }
@@ -855,36 +1066,33 @@ func (ap actualPath) Parent() archive.DirLike {
 }
 
 func (ap actualPath) ReadDir() ([]archive.DirLike, error) {
-	entries, err := os.ReadDir(ap.Path())
+	entries, err := storage.OrLocal(ap.storage).ReadDir(ap.Path())
 	if err != nil {
 		return nil, err
 	}
 	var returnFileInfo = make([]archive.DirLike, 0, len(entries))
-	for _, entry := range entries {
-		info, err := entry.Info()
-		if err != nil {
-			return nil, err
-		}
+	for _, info := range entries {
 		returnFileInfo = append(returnFileInfo,
 			actualPath{
 				FileInfo: info,
 				path:     ap.Path(),
 				parent:   &ap,
+				storage:  ap.storage,
 			})
 	}
 	return returnFileInfo, nil
 }
 
 func (ap actualPath) Stat() (archive.DirLike, error) {
-	stat, err := os.Stat(ap.Path())
+	stat, err := storage.OrLocal(ap.storage).Stat(ap.Path())
 	if err != nil {
 		return nil, err
 	}
-	return &actualPath{FileInfo: stat, path: ap.Path()}, nil
+	return &actualPath{FileInfo: stat, path: ap.Path(), storage: ap.storage}, nil
 }
 
 func (ap actualPath) IsDir() bool {
-	stat, err := os.Stat(ap.Path())
+	stat, err := storage.OrLocal(ap.storage).Stat(ap.Path())
 	if err != nil {
 		return false
 	}
@@ -8,6 +8,8 @@ package mongorestore
 
 import (
 	"bytes"
+	"io"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -19,6 +21,9 @@ import (
 	"github.com/mongodb/mongo-tools/common/testtype"
 	"github.com/mongodb/mongo-tools/mongorestore/ns"
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
 func init() {
@@ -226,6 +231,78 @@ func TestCreateIntentsForDB(t *testing.T) {
 	})
 }
 
+func TestCreateIntentsForDBPartitions(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	dir := t.TempDir()
+	var all [][]byte
+	var size int64
+	for _, file := range []struct {
+		name string
+		ids  []int
+	}{
+		{"c1.bson", []int{1, 2}},
+		{"c1.bson.1", []int{3}},
+		{"c1.bson.2", []int{4, 5}},
+	} {
+		var content []byte
+		for _, id := range file.ids {
+			doc, err := bson.Marshal(bson.D{{"_id", id}})
+			require.NoError(t, err)
+			content = append(content, doc...)
+			all = append(all, doc)
+		}
+		require.NoError(t, os.WriteFile(filepath.Join(dir, file.name), content, 0o644))
+		size += int64(len(content))
+	}
+
+	mr := newMongoRestore()
+	ddl, err := newActualPath(dir)
+	require.NoError(t, err)
+	require.NoError(t, mr.CreateIntentsForDB("myDB", ddl))
+	mr.manager.Finalize(intents.Legacy)
+
+	intent := mr.manager.Pop()
+	require.NotNil(t, intent)
+	assert.Equal(t, "c1", intent.C)
+	assert.Equal(t, size, intent.Size)
+	assert.Nil(t, mr.manager.Pop(), "partition files are not collections of their own")
+
+	require.NoError(t, intent.BSONFile.Open())
+	defer intent.BSONFile.Close()
+	content, err := io.ReadAll(intent.BSONFile)
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Join(all, nil), content)
+	assert.Equal(t, size, intent.BSONFile.(*realBSONFile).Pos())
+}
+
+func TestCheckPartitions(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	dir := t.TempDir()
+	doc, err := bson.Marshal(bson.D{{"_id", 1}})
+	require.NoError(t, err)
+	// the file of the third partition is missing
+	for _, name := range []string{"c1.bson", "c1.bson.1", "c1.bson.3"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), doc, 0o644))
+	}
+
+	mr := newMongoRestore()
+	ddl, err := newActualPath(dir)
+	require.NoError(t, err)
+	require.NoError(t, mr.CreateIntentsForDB("myDB", ddl))
+
+	mr.dumpedPartitions = map[string]int{"myDB.c1": 4, "myDB.excluded": 2}
+	assert.ErrorContains(
+		t,
+		mr.checkPartitions(),
+		"`myDB.c1` was dumped in 4 partitions, but the files of 2 partitions were found",
+	)
+
+	mr.dumpedPartitions = map[string]int{"myDB.c1": 2}
+	assert.NoError(t, mr.checkPartitions())
+}
+
 func TestCreateIntentsForDBLongCollectionName(t *testing.T) {
 	// Disabled: see TOOLS-2658
 	t.Skip()
@@ -0,0 +1,355 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/idx"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// Values of --indexBuildStrategy.
+const (
+	IndexBuildAfter  = "after"
+	IndexBuildBefore = "before"
+	IndexBuildAuto   = "auto"
+)
+
+// indexBuilds is the state of the index builds of a restore, which is shared
+// with the restores of the other parts of the dump of a sharded cluster.
+type indexBuilds struct {
+	mutex sync.Mutex
+	// namespaces whose indexes were built before their documents were restored
+	beforeData map[string]bool
+	failures   []*indexFailure
+}
+
+// indexFailure is an index that failed to build, as written to the
+// --indexFailureReport file.
+type indexFailure struct {
+	Namespace string          `json:"namespace"`
+	Name      string          `json:"name"`
+	Index     json.RawMessage `json:"index"`
+	Error     string          `json:"error"`
+}
+
+func newIndexBuilds() *indexBuilds {
+	return &indexBuilds{beforeData: map[string]bool{}}
+}
+
+func (builds *indexBuilds) setBuiltBeforeData(namespace string) {
+	builds.mutex.Lock()
+	defer builds.mutex.Unlock()
+	builds.beforeData[namespace] = true
+}
+
+func (builds *indexBuilds) builtBeforeData(namespace string) bool {
+	if builds == nil {
+		return false
+	}
+	builds.mutex.Lock()
+	defer builds.mutex.Unlock()
+	return builds.beforeData[namespace]
+}
+
+// fail records that an index failed to build. An index that fails both before
+// and after the documents of its collection are restored is recorded once,
+// with the latest error.
+func (builds *indexBuilds) fail(namespace string, index *idx.IndexDocument, err error) {
+	spec, marshalErr := bson.MarshalExtJSON(indexSpec(index), false, false)
+	if marshalErr != nil {
+		spec, _ = json.Marshal(fmt.Sprint(index))
+	}
+	name, _ := index.Options["name"].(string)
+	failure := &indexFailure{Namespace: namespace, Name: name, Index: spec, Error: err.Error()}
+
+	builds.mutex.Lock()
+	defer builds.mutex.Unlock()
+	for i, existing := range builds.failures {
+		if existing.Namespace == namespace && existing.Name == name {
+			builds.failures[i] = failure
+			return
+		}
+	}
+	builds.failures = append(builds.failures, failure)
+}
+
+// writeReport writes the indexes that failed to build to path as JSON.
+func (builds *indexBuilds) writeReport(path string) error {
+	builds.mutex.Lock()
+	failures := append([]*indexFailure{}, builds.failures...)
+	builds.mutex.Unlock()
+
+	if len(failures) > 0 {
+		log.Logvf(log.Always, "%v %v failed to build, see %#q",
+			len(failures), util.Pluralize(len(failures), "index", "indexes"), path)
+	}
+	content, err := json.MarshalIndent(struct {
+		Failures []*indexFailure `json:"failures"`
+	}{failures}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling index failure report: %v", err)
+	}
+	err = os.WriteFile(path, append(content, '\n'), 0o644)
+	if err != nil {
+		return fmt.Errorf("error writing index failure report %#q: %v", path, err)
+	}
+	return nil
+}
+
+// numParallelIndexBuilds returns the number of collections whose indexes are
+// built in parallel after their documents are restored.
+func (restore *MongoRestore) numParallelIndexBuilds() int {
+	if restore.OutputOptions.NumParallelIndexBuilds > 0 {
+		return restore.OutputOptions.NumParallelIndexBuilds
+	}
+	return restore.OutputOptions.NumParallelCollections
+}
+
+// buildsIndexesBeforeData returns whether the indexes of the collection of
+// intent are built before its documents are restored, which is faster than
+// building them afterwards for small collections. The size of a collection in
+// an archive isn't known, so auto builds its indexes afterwards.
+func (restore *MongoRestore) buildsIndexesBeforeData(intent *intents.Intent) bool {
+	if restore.OutputOptions.NoIndexRestore || intent.IsView() || intent.IsTimeseries() ||
+		intent.IsSpecialCollection() {
+		return false
+	}
+	switch restore.OutputOptions.IndexBuildStrategy {
+	case IndexBuildBefore:
+		return true
+	case IndexBuildAuto:
+		return restore.InputOptions.Archive == "" &&
+			intent.Size < restore.OutputOptions.IndexBeforeDataMaxSize
+	}
+	return false
+}
+
+// restoreIndexesBeforeData builds the indexes of the collection of intent
+// before its documents are restored. They are checked again after the oplog is
+// replayed, for indexes that it created.
+func (restore *MongoRestore) restoreIndexesBeforeData(intent *intents.Intent) error {
+	log.Logvf(
+		log.Always,
+		"building indexes for collection %#q before restoring its documents",
+		intent.Namespace(),
+	)
+	err := restore.buildIndexes(intent.DB, intent.C)
+	if err != nil {
+		return err
+	}
+	restore.indexBuilds.setBuiltBeforeData(intent.Namespace())
+	return nil
+}
+
+// buildIndexes builds the indexes of a collection that don't exist on the
+// target yet. With --indexFailureReport, an index that fails to build is
+// recorded rather than returned as an error.
+func (restore *MongoRestore) buildIndexes(dbName, collName string) error {
+	namespaceString := dbName + "." + collName
+	indexes, err := restore.indexesToRestore(dbName, collName)
+	if err != nil {
+		return err
+	}
+	indexes, err = restore.skipExistingIndexes(dbName, collName, indexes)
+	if err != nil {
+		return err
+	}
+	if len(indexes) == 0 {
+		log.Logvf(log.Always, "no indexes to restore for collection %#q", namespaceString)
+		return nil
+	}
+
+	log.Logvf(log.Always, "restoring indexes for collection %#q from metadata", namespaceString)
+	for _, index := range indexes {
+		log.Logvf(log.Always, "index: %#v", index)
+	}
+	err = restore.CreateIndexes(dbName, collName, indexes)
+	if err == nil {
+		return nil
+	}
+	if restore.OutputOptions.IndexFailureReport == "" {
+		return fmt.Errorf(
+			"%s: error creating indexes for %s: %v",
+			namespaceString,
+			namespaceString,
+			err,
+		)
+	}
+
+	// find out which of the indexes failed by building each of them on its own
+	log.Logvf(log.Always, "error creating indexes for %#q, building them one at a time: %v",
+		namespaceString, err)
+	for _, index := range indexes {
+		err = restore.CreateIndexes(dbName, collName, []*idx.IndexDocument{index})
+		if err != nil {
+			log.Logvf(log.Always, "warning: failed to build index %#q of %#q: %v",
+				index.Options["name"], namespaceString, err)
+			restore.indexBuilds.fail(namespaceString, index, err)
+		}
+	}
+	return nil
+}
+
+// skipExistingIndexes returns the indexes that don't exist on the target yet.
+// An existing index with the same name but another key or other options is
+// kept, so that it fails to build rather than being silently skipped.
+func (restore *MongoRestore) skipExistingIndexes(
+	dbName, collName string,
+	indexes []*idx.IndexDocument,
+) ([]*idx.IndexDocument, error) {
+	if len(indexes) == 0 {
+		return indexes, nil
+	}
+	session, err := restore.SessionProvider.GetSession()
+	if err != nil {
+		return nil, fmt.Errorf("error establishing connection: %v", err)
+	}
+	cursor, err := db.GetIndexes(session.Database(dbName).Collection(collName))
+	if err != nil {
+		return nil, fmt.Errorf("error listing indexes of %#q: %v", dbName+"."+collName, err)
+	}
+	var existing []bson.D
+	err = cursor.All(context.Background(), &existing)
+	if err != nil {
+		return nil, fmt.Errorf("error listing indexes of %#q: %v", dbName+"."+collName, err)
+	}
+	existingSpecs := map[string]bson.D{}
+	for _, spec := range existing {
+		name, _ := bsonutil.FindStringValueByKey("name", &spec)
+		existingSpecs[name] = spec
+	}
+
+	var missing []*idx.IndexDocument
+	for _, index := range indexes {
+		name, _ := index.Options["name"].(string)
+		spec, ok := existingSpecs[name]
+		if !ok {
+			missing = append(missing, index)
+			continue
+		}
+		same, err := sameIndex(spec, index)
+		if err != nil {
+			return nil, err
+		}
+		if !same {
+			log.Logvf(log.Info, "index %#q of %#q exists with other options",
+				name, dbName+"."+collName)
+			missing = append(missing, index)
+			continue
+		}
+		log.Logvf(log.Info, "index %#q of %#q already exists, skipping",
+			name, dbName+"."+collName)
+	}
+	return missing, nil
+}
+
+// sameIndex returns whether an existing index, as listIndexes reports it, is
+// the same as an index of the dump, with the same key and options. The index
+// version and namespace aren't compared, since the restore may change them.
+func sameIndex(existing bson.D, index *idx.IndexDocument) (bool, error) {
+	raw, err := bson.Marshal(index)
+	if err != nil {
+		return false, fmt.Errorf("error marshaling index: %v", err)
+	}
+	var dumped bson.D
+	err = bson.Unmarshal(raw, &dumped)
+	if err != nil {
+		return false, fmt.Errorf("error unmarshaling index: %v", err)
+	}
+
+	options := func(spec bson.D) map[string]any {
+		fields := map[string]any{}
+		for _, elem := range spec {
+			if elem.Key != "v" && elem.Key != "ns" {
+				fields[elem.Key] = elem.Value
+			}
+		}
+		return fields
+	}
+	existingOptions, dumpedOptions := options(existing), options(dumped)
+	if len(existingOptions) != len(dumpedOptions) {
+		return false, nil
+	}
+	for name, value := range existingOptions {
+		dumpedValue, ok := dumpedOptions[name]
+		if !ok {
+			return false, nil
+		}
+		if name == "key" {
+			existingKey, _ := value.(bson.D)
+			dumpedKey, _ := dumpedValue.(bson.D)
+			if !sameIndexKey(existingKey, dumpedKey) {
+				return false, nil
+			}
+			continue
+		}
+		if !sameIndexValue(value, dumpedValue) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// sameIndexKey returns whether two index keys are the same. Key values of
+// different numeric types, like 1 and 1.0, are the same.
+func sameIndexKey(a, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || !sameIndexValue(a[i].Value, b[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameIndexValue returns whether two values of an index spec are the same.
+// Numbers of different types, like 1 and 1.0, are the same.
+func sameIndexValue(a, b any) bool {
+	numA, errA := util.ToFloat64(a)
+	numB, errB := util.ToFloat64(b)
+	if errA == nil && errB == nil {
+		return numA == numB
+	}
+	rawA, errA := bson.Marshal(bson.D{{"v", a}})
+	rawB, errB := bson.Marshal(bson.D{{"v", b}})
+	return errA == nil && errB == nil && bytes.Equal(rawA, rawB)
+}
+
+// dropIndexesBuiltBeforeData applies a replayed dropIndexes command to the
+// target when the indexes of its collection were built before its documents,
+// since they already exist there. Indexes that don't exist are ignored.
+func (restore *MongoRestore) dropIndexesBuiltBeforeData(dbName, collName string, cmd bson.D) error {
+	if !restore.indexBuilds.builtBeforeData(dbName + "." + collName) {
+		return nil
+	}
+	session, err := restore.SessionProvider.GetSession()
+	if err != nil {
+		return fmt.Errorf("error establishing connection: %v", err)
+	}
+	err = session.Database(dbName).RunCommand(context.Background(), cmd).Err()
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(db.ErrIndexNotFound) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,142 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/idx"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestBuildsIndexesBeforeData(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	mr := newMongoRestore()
+	mr.OutputOptions = &OutputOptions{IndexBeforeDataMaxSize: 1000}
+	small := &intents.Intent{DB: "db", C: "small", Size: 10}
+	large := &intents.Intent{DB: "db", C: "large", Size: 5000}
+	view := &intents.Intent{DB: "db", C: "view", Type: "view"}
+
+	assert.False(t, mr.buildsIndexesBeforeData(small), "indexes are built after data by default")
+
+	mr.OutputOptions.IndexBuildStrategy = IndexBuildAuto
+	assert.True(t, mr.buildsIndexesBeforeData(small))
+	assert.False(t, mr.buildsIndexesBeforeData(large))
+	mr.InputOptions.Archive = "dump.archive"
+	assert.False(t, mr.buildsIndexesBeforeData(small), "sizes in an archive aren't known")
+	mr.InputOptions.Archive = ""
+
+	mr.OutputOptions.IndexBuildStrategy = IndexBuildBefore
+	assert.True(t, mr.buildsIndexesBeforeData(large))
+	assert.False(t, mr.buildsIndexesBeforeData(view))
+	mr.OutputOptions.NoIndexRestore = true
+	assert.False(t, mr.buildsIndexesBeforeData(large))
+}
+
+func TestSameIndexKey(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	assert.True(t, sameIndexKey(bson.D{{"a", 1}, {"b", -1}}, bson.D{{"a", 1.0}, {"b", int64(-1)}}))
+	assert.True(t, sameIndexKey(bson.D{{"a", "text"}}, bson.D{{"a", "text"}}))
+	assert.False(t, sameIndexKey(bson.D{{"a", 1}}, bson.D{{"a", -1}}))
+	assert.False(t, sameIndexKey(bson.D{{"a", 1}, {"b", 1}}, bson.D{{"b", 1}, {"a", 1}}))
+	assert.False(t, sameIndexKey(bson.D{{"a", "hashed"}}, bson.D{{"a", 1}}))
+	assert.False(t, sameIndexKey(bson.D{{"a", 1}}, bson.D{{"a", 1}, {"b", 1}}))
+}
+
+func TestSameIndex(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	existing := bson.D{
+		{"v", int32(2)},
+		{"key", bson.D{{"a", int32(1)}}},
+		{"name", "a_1"},
+		{"unique", true},
+		{"expireAfterSeconds", int32(60)},
+		{"partialFilterExpression", bson.D{{"b", bson.D{{"$gt", int32(0)}}}}},
+		{"collation", bson.D{{"locale", "fr"}, {"strength", int32(2)}}},
+	}
+	dumped := func() *idx.IndexDocument {
+		filter := bson.D{{"b", bson.D{{"$gt", int32(0)}}}}
+		return &idx.IndexDocument{
+			Key: bson.D{{"a", 1.0}},
+			Options: bson.M{
+				"v":                  int32(1),
+				"ns":                 "app.orders",
+				"name":               "a_1",
+				"unique":             true,
+				"expireAfterSeconds": int64(60),
+				"collation":          bson.D{{"locale", "fr"}, {"strength", int32(2)}},
+			},
+			PartialFilterExpression: &filter,
+		}
+	}
+
+	same, err := sameIndex(existing, dumped())
+	require.NoError(t, err)
+	assert.True(t, same, "the version, namespace and numeric types aren't compared")
+
+	for name, change := range map[string]func(*idx.IndexDocument){
+		"key":        func(index *idx.IndexDocument) { index.Key = bson.D{{"a", -1}} },
+		"unique":     func(index *idx.IndexDocument) { delete(index.Options, "unique") },
+		"sparse":     func(index *idx.IndexDocument) { index.Options["sparse"] = true },
+		"expiry":     func(index *idx.IndexDocument) { index.Options["expireAfterSeconds"] = 120 },
+		"collation":  func(index *idx.IndexDocument) { index.Options["collation"] = bson.D{{"locale", "en"}} },
+		"filter":     func(index *idx.IndexDocument) { index.PartialFilterExpression = nil },
+		"filter doc": func(index *idx.IndexDocument) { *index.PartialFilterExpression = bson.D{{"b", 1}} },
+	} {
+		index := dumped()
+		change(index)
+		same, err := sameIndex(existing, index)
+		require.NoError(t, err)
+		assert.False(t, same, "an index with another %v is not the same", name)
+	}
+}
+
+func TestIndexFailureReport(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	builds := newIndexBuilds()
+	index := &idx.IndexDocument{Key: bson.D{{"a", 1}}, Options: bson.M{"name": "a_1", "unique": true}}
+	builds.fail("db.c", index, errors.New("duplicate key"))
+	builds.fail("db.c", index, errors.New("still a duplicate key"))
+	builds.fail("db.d", index, errors.New("too many indexes"))
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	require.NoError(t, builds.writeReport(path))
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var report struct {
+		Failures []struct {
+			Namespace string         `json:"namespace"`
+			Name      string         `json:"name"`
+			Index     map[string]any `json:"index"`
+			Error     string         `json:"error"`
+		} `json:"failures"`
+	}
+	require.NoError(t, json.Unmarshal(content, &report))
+	require.Len(t, report.Failures, 2, "an index that fails twice is reported once")
+	assert.Equal(t, "db.c", report.Failures[0].Namespace)
+	assert.Equal(t, "a_1", report.Failures[0].Name)
+	assert.Equal(t, "still a duplicate key", report.Failures[0].Error)
+	assert.Equal(t, true, report.Failures[0].Index["unique"])
+	assert.Equal(t, "db.d", report.Failures[1].Namespace)
+
+	assert.False(t, (*indexBuilds)(nil).builtBeforeData("db.c"))
+	builds.setBuiltBeforeData("db.c")
+	assert.True(t, builds.builtBeforeData("db.c"))
+	assert.False(t, builds.builtBeforeData("db.d"))
+}
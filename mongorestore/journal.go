@@ -0,0 +1,261 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/storage"
+)
+
+// journalWriteInterval is how often the journal is written while documents are
+// being inserted. Completed namespaces and index builds are written at once.
+const journalWriteInterval = time.Second
+
+// restoreJournal records the progress of a restore in a file, so that a restore
+// that was interrupted can be continued with --resume.
+type restoreJournal struct {
+	path string
+
+	mutex     sync.Mutex
+	state     journalState
+	lastWrite time.Time
+}
+
+// journalState is the content of a journal file. Source is the dump directory
+// or archive being restored, so that a journal isn't used with another dump.
+type journalState struct {
+	Source        string                       `json:"Source"`
+	OplogReplayed bool                         `json:"OplogReplayed"`
+	Namespaces    map[string]*journalNamespace `json:"Namespaces"`
+}
+
+// journalNamespace is the progress of restoring a single namespace. Documents
+// and Offset are the number and the total size of the documents at the start
+// of the namespace's BSON data that are known to have been written.
+type journalNamespace struct {
+	Complete     bool  `json:"Complete"`
+	Documents    int64 `json:"Documents"`
+	Offset       int64 `json:"Offset"`
+	IndexesBuilt bool  `json:"IndexesBuilt"`
+}
+
+// openRestoreJournal opens the journal at path, creating it if it doesn't
+// exist. It is an error to resume from a journal of a different source.
+func openRestoreJournal(path, source string) (*restoreJournal, error) {
+	journal := &restoreJournal{
+		path: path,
+		state: journalState{
+			Source:     source,
+			Namespaces: map[string]*journalNamespace{},
+		},
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Logvf(log.Info, "recording restore progress in new journal %#q", path)
+		return journal, journal.flush()
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading restore journal %#q: %v", path, err)
+	}
+
+	err = json.Unmarshal(content, &journal.state)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing restore journal %#q: %v", path, err)
+	}
+	if journal.state.Source != source {
+		return nil, fmt.Errorf(
+			"restore journal %#q is of a restore from %#q, not %#q",
+			path,
+			journal.state.Source,
+			source,
+		)
+	}
+	if journal.state.Namespaces == nil {
+		journal.state.Namespaces = map[string]*journalNamespace{}
+	}
+	log.Logvf(log.Always, "resuming restore from journal %#q", path)
+	return journal, nil
+}
+
+// namespace returns the progress of ns, and whether it was started at all.
+func (journal *restoreJournal) namespace(ns string) (journalNamespace, bool) {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	progress, ok := journal.state.Namespaces[ns]
+	if !ok {
+		return journalNamespace{}, false
+	}
+	return *progress, true
+}
+
+// start records that documents are about to be inserted into ns.
+func (journal *restoreJournal) start(ns string) error {
+	return journal.update(ns, func(*journalNamespace) {})
+}
+
+// setProgress records that the first documents of ns, which take offset bytes,
+// have been written. The journal is only written now and then.
+func (journal *restoreJournal) setProgress(ns string, documents, offset int64) {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	progress := journal.namespaceLocked(ns)
+	progress.Documents = documents
+	progress.Offset = offset
+	if time.Since(journal.lastWrite) < journalWriteInterval {
+		return
+	}
+	if err := journal.writeLocked(); err != nil {
+		log.Logvf(log.Always, "warning: %v", err)
+	}
+}
+
+// complete records that all documents of ns were restored.
+func (journal *restoreJournal) complete(ns string) error {
+	return journal.update(ns, func(progress *journalNamespace) {
+		progress.Complete = true
+	})
+}
+
+// indexesBuilt records that the indexes of ns were built.
+func (journal *restoreJournal) indexesBuilt(ns string) error {
+	return journal.update(ns, func(progress *journalNamespace) {
+		progress.IndexesBuilt = true
+	})
+}
+
+// oplogReplayed returns whether the oplog was already replayed.
+func (journal *restoreJournal) oplogReplayed() bool {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	return journal.state.OplogReplayed
+}
+
+// setOplogReplayed records that the oplog was replayed.
+func (journal *restoreJournal) setOplogReplayed() error {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	journal.state.OplogReplayed = true
+	return journal.writeLocked()
+}
+
+// flush writes the journal.
+func (journal *restoreJournal) flush() error {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	return journal.writeLocked()
+}
+
+func (journal *restoreJournal) update(ns string, change func(*journalNamespace)) error {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	change(journal.namespaceLocked(ns))
+	return journal.writeLocked()
+}
+
+func (journal *restoreJournal) namespaceLocked(ns string) *journalNamespace {
+	progress, ok := journal.state.Namespaces[ns]
+	if !ok {
+		progress = &journalNamespace{}
+		journal.state.Namespaces[ns] = progress
+	}
+	return progress
+}
+
+// writeLocked replaces the journal file, so that it is never left half written
+// if mongorestore is killed.
+func (journal *restoreJournal) writeLocked() error {
+	content, err := json.MarshalIndent(journal.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling restore journal: %v", err)
+	}
+	tmpPath := journal.path + ".tmp"
+	err = os.WriteFile(tmpPath, append(content, '\n'), 0o644)
+	if err == nil {
+		err = os.Rename(tmpPath, journal.path)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing restore journal %#q: %v", journal.path, err)
+	}
+	journal.lastWrite = time.Now()
+	return nil
+}
+
+// journalSource returns how the source of a restore is identified in its
+// journal: the absolute path of the archive or dump directory.
+func journalSource(archivePath, targetDirectory string) (string, error) {
+	source := targetDirectory
+	if archivePath != "" {
+		source = archivePath
+	}
+	if source == "-" || storage.IsURL(source) {
+		return source, nil
+	}
+	abs, err := filepath.Abs(source)
+	if err != nil {
+		return "", fmt.Errorf("error finding absolute path of %#q: %v", source, err)
+	}
+	return abs, nil
+}
+
+// flushTracker follows which documents of a collection have been written to
+// the server. Documents are numbered in the order they are read, but several
+// insertion workers write them out of order, so the tracker only reports the
+// progress up to the first document that hasn't been written yet.
+type flushTracker struct {
+	mutex     sync.Mutex
+	next      int64
+	offset    int64
+	written   map[int64]int64
+	onAdvance func(documents, offset int64)
+}
+
+// newFlushTracker returns a flushTracker for a collection whose first documents,
+// which take offset bytes, were already written.
+func newFlushTracker(
+	documents, offset int64,
+	onAdvance func(documents, offset int64),
+) *flushTracker {
+	return &flushTracker{
+		next:      documents,
+		offset:    offset,
+		written:   map[int64]int64{},
+		onAdvance: onAdvance,
+	}
+}
+
+// markWritten records that document number seq, of the given size, was
+// written. It is a no-op on a nil tracker.
+func (tracker *flushTracker) markWritten(seq, size int64) {
+	if tracker == nil {
+		return
+	}
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.written[seq] = size
+	advanced := false
+	for {
+		size, ok := tracker.written[tracker.next]
+		if !ok {
+			break
+		}
+		delete(tracker.written, tracker.next)
+		tracker.next++
+		tracker.offset += size
+		advanced = true
+	}
+	if advanced {
+		tracker.onAdvance(tracker.next, tracker.offset)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestoreJournal(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	path := filepath.Join(t.TempDir(), "restore.journal")
+	journal, err := openRestoreJournal(path, "/dumps/dump")
+	require.NoError(t, err)
+	require.FileExists(t, path, "a new journal is written at once")
+
+	_, started := journal.namespace("test.foo")
+	assert.False(t, started)
+
+	require.NoError(t, journal.start("test.foo"))
+	journal.setProgress("test.foo", 10, 400)
+	require.NoError(t, journal.complete("test.bar"))
+	require.NoError(t, journal.indexesBuilt("test.bar"))
+	require.NoError(t, journal.setOplogReplayed())
+	require.NoError(t, journal.flush())
+
+	t.Run("resume", func(t *testing.T) {
+		resumed, err := openRestoreJournal(path, "/dumps/dump")
+		require.NoError(t, err)
+
+		foo, started := resumed.namespace("test.foo")
+		assert.True(t, started)
+		assert.Equal(t, journalNamespace{Documents: 10, Offset: 400}, foo)
+		bar, _ := resumed.namespace("test.bar")
+		assert.Equal(t, journalNamespace{Complete: true, IndexesBuilt: true}, bar)
+		assert.True(t, resumed.oplogReplayed())
+	})
+
+	t.Run("different source", func(t *testing.T) {
+		_, err := openRestoreJournal(path, "/dumps/other")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "is of a restore from `/dumps/dump`")
+	})
+
+	t.Run("invalid journal", func(t *testing.T) {
+		invalidPath := filepath.Join(t.TempDir(), "invalid.journal")
+		require.NoError(t, os.WriteFile(invalidPath, []byte("{"), 0o644))
+		_, err := openRestoreJournal(invalidPath, "/dumps/dump")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "error parsing restore journal")
+	})
+}
+
+func TestFlushTracker(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	var documents, offset int64
+	tracker := newFlushTracker(5, 100, func(d, o int64) {
+		documents, offset = d, o
+	})
+
+	// documents written after one that wasn't don't count yet
+	tracker.markWritten(6, 20)
+	tracker.markWritten(7, 30)
+	assert.Zero(t, documents)
+
+	tracker.markWritten(5, 10)
+	assert.EqualValues(t, 8, documents)
+	assert.EqualValues(t, 160, offset)
+
+	tracker.markWritten(9, 40)
+	assert.EqualValues(t, 8, documents)
+	tracker.markWritten(8, 50)
+	assert.EqualValues(t, 10, documents)
+	assert.EqualValues(t, 250, offset)
+
+	var nilTracker *flushTracker
+	assert.NotPanics(t, func() { nilTracker.markWritten(0, 1) })
+}
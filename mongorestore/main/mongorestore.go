@@ -39,6 +39,11 @@ func main() {
 		return
 	}
 
+	if opts.InputOptions.VerifyOnly {
+		verify(opts)
+		return
+	}
+
 	restore, err := mongorestore.New(opts)
 	if err != nil {
 		log.Logv(log.Always, err.Error())
@@ -70,3 +75,24 @@ func main() {
 	}
 	os.Exit(util.ExitSuccess)
 }
+
+// verify checks the dump or archive without connecting to a server, and prints
+// the report to standard output.
+func verify(opts mongorestore.Options) {
+	report, err := mongorestore.Verify(opts)
+	if err != nil {
+		log.Logvf(log.Always, "Failed: %v", err)
+		os.Exit(util.ExitFailure)
+	}
+	err = report.WriteJSON(os.Stdout)
+	if err != nil {
+		log.Logvf(log.Always, "Failed: error writing verification report: %v", err)
+		os.Exit(util.ExitFailure)
+	}
+	if !report.OK {
+		log.Logvf(log.Always, "Failed: problems were found in %v", report.Source)
+		os.Exit(util.ExitFailure)
+	}
+	log.Logvf(log.Always, "no problems found in %v", report.Source)
+	os.Exit(util.ExitSuccess)
+}
@@ -0,0 +1,144 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/dumprestore"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/storage"
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// Values of --manifestCheck.
+const (
+	ManifestCheckError = "error"
+	ManifestCheckWarn  = "warn"
+	ManifestCheckSkip  = "skip"
+)
+
+// findManifest returns the path of the manifest for target, or "" if the dump
+// has none. Like prelude.json, the manifest is at the top of the dump, which is
+// the target itself or, when a database or file was given, above it.
+func findManifest(backend storage.Backend, target archive.DirLike) (string, error) {
+	dir := filepath.Clean(target.Path())
+	if !target.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+	for _, candidate := range []string{dir, filepath.Dir(dir)} {
+		path := filepath.Join(candidate, dumprestore.ManifestFileName)
+		_, err := backend.Stat(path)
+		if err == nil {
+			return path, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// readManifest returns the manifest of the dump that target is in and its path,
+// or nil if the dump has none.
+func (restore *MongoRestore) readManifest(
+	target archive.DirLike,
+) (*dumprestore.Manifest, string, error) {
+	backend := storage.OrLocal(restore.storage)
+	manifestPath, err := findManifest(backend, target)
+	if err != nil {
+		return nil, "", fmt.Errorf("error finding manifest: %v", err)
+	}
+	if manifestPath == "" {
+		return nil, "", nil
+	}
+	content, err := storage.ReadFile(backend, manifestPath)
+	if err != nil {
+		return nil, "", err
+	}
+	manifest, err := dumprestore.ParseManifest(content)
+	if err != nil {
+		return nil, "", fmt.Errorf("error parsing manifest %#q: %v", manifestPath, err)
+	}
+	return manifest, manifestPath, nil
+}
+
+// checkManifest checks the files of the dump under target against the manifest
+// that mongodump wrote. It returns a description of every file that is missing
+// or doesn't match. Dumps made without a manifest are not checked.
+func (restore *MongoRestore) checkManifest(target archive.DirLike) ([]string, error) {
+	backend := storage.OrLocal(restore.storage)
+	manifest, manifestPath, err := restore.readManifest(target)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		log.Logv(log.DebugLow, "no manifest found for the dump, skipping checks")
+		return nil, nil
+	}
+	log.Logvf(log.Info, "checking dump files against manifest %#q", manifestPath)
+
+	targetPath := filepath.Clean(target.Path())
+	var problems []string
+	for _, file := range manifest.Files {
+		path := filepath.Join(filepath.Dir(manifestPath), filepath.FromSlash(file.Path))
+		if path != targetPath && !strings.HasPrefix(path, targetPath+string(filepath.Separator)) {
+			continue
+		}
+		size, sum, err := checksumFile(backend, path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			problems = append(problems, fmt.Sprintf("%#q is missing", path))
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("error reading %#q: %v", path, err))
+		case size != file.Size:
+			problems = append(problems, fmt.Sprintf(
+				"%#q is %v bytes long, but the manifest lists %v bytes", path, size, file.Size,
+			))
+		case sum != file.SHA256:
+			problems = append(problems, fmt.Sprintf(
+				"%#q has SHA-256 %v, but the manifest lists %v", path, sum, file.SHA256,
+			))
+		}
+	}
+	return problems, nil
+}
+
+// checksumFile returns the size and the hex encoded SHA-256 of the file at path.
+func checksumFile(backend storage.Backend, path string) (int64, string, error) {
+	file, err := backend.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+	return dumprestore.Checksum(file)
+}
+
+// enforceManifest checks the dump against its manifest, and refuses to restore
+// a dump with problems unless --manifestCheck=warn was given.
+func (restore *MongoRestore) enforceManifest(target archive.DirLike) error {
+	problems, err := restore.checkManifest(target)
+	if err != nil {
+		return fmt.Errorf("error checking dump manifest: %w", err)
+	}
+	for _, problem := range problems {
+		log.Logvf(log.Always, "WARNING: %v", problem)
+	}
+	if len(problems) > 0 && restore.InputOptions.ManifestCheck != ManifestCheckWarn {
+		return fmt.Errorf(
+			"%v %v of the dump don't match its manifest; use --manifestCheck=%v to restore anyway",
+			len(problems),
+			util.Pluralize(len(problems), "file", "files"),
+			ManifestCheckWarn,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/dumprestore"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeManifestTestDump writes a dump directory with a manifest of its files.
+func writeManifestTestDump(t *testing.T) string {
+	dumpDir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dumpDir, "test"), 0o755))
+	files := map[string]string{
+		"test/foo.bson":          "foo data",
+		"test/foo.metadata.json": `{"indexes":[]}`,
+		"test/bar.bson":          "bar data",
+		"oplog.bson":             "oplog data",
+	}
+	manifest := dumprestore.Manifest{ServerVersion: "7.0.0"}
+	for path, content := range files {
+		fullPath := filepath.Join(dumpDir, filepath.FromSlash(path))
+		require.NoError(t, os.WriteFile(fullPath, []byte(content), 0o644))
+		size, sum, err := dumprestore.ChecksumFile(fullPath)
+		require.NoError(t, err)
+		manifest.Files = append(manifest.Files, dumprestore.ManifestFile{
+			Path:   path,
+			Size:   size,
+			SHA256: sum,
+		})
+	}
+	content, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dumpDir, dumprestore.ManifestFileName),
+		content,
+		0o644,
+	))
+	return dumpDir
+}
+
+func TestCheckManifest(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	checkManifest := func(t *testing.T, path string) []string {
+		target, err := newActualPath(path)
+		require.NoError(t, err)
+		problems, err := newVerifyTestRestore("", path).checkManifest(target)
+		require.NoError(t, err)
+		return problems
+	}
+
+	t.Run("matching dump", func(t *testing.T) {
+		dumpDir := writeManifestTestDump(t)
+		assert.Empty(t, checkManifest(t, dumpDir))
+	})
+
+	t.Run("dump without a manifest", func(t *testing.T) {
+		dumpDir := writeManifestTestDump(t)
+		require.NoError(t, os.Remove(filepath.Join(dumpDir, dumprestore.ManifestFileName)))
+		require.NoError(t, os.WriteFile(filepath.Join(dumpDir, "oplog.bson"), nil, 0o644))
+		assert.Empty(t, checkManifest(t, dumpDir))
+	})
+
+	t.Run("missing, truncated and modified files", func(t *testing.T) {
+		dumpDir := writeManifestTestDump(t)
+		require.NoError(t, os.Remove(filepath.Join(dumpDir, "test", "bar.bson")))
+		require.NoError(t, os.WriteFile(filepath.Join(dumpDir, "oplog.bson"), []byte("op"), 0o644))
+		require.NoError(t, os.WriteFile(
+			filepath.Join(dumpDir, "test", "foo.bson"),
+			[]byte("fox data"),
+			0o644,
+		))
+
+		problems := checkManifest(t, dumpDir)
+		require.Len(t, problems, 3)
+		assert.Contains(t, problems, "`"+filepath.Join(dumpDir, "test", "bar.bson")+"` is missing")
+		assert.Contains(
+			t,
+			problems,
+			"`"+filepath.Join(dumpDir, "oplog.bson")+"` is 2 bytes long, but the manifest lists 10 bytes",
+		)
+		assert.True(t, slices.ContainsFunc(problems, func(problem string) bool {
+			return strings.HasPrefix(problem, "`"+filepath.Join(dumpDir, "test", "foo.bson")+"` has SHA-256")
+		}), "modified file is reported: %v", problems)
+
+		restore := newVerifyTestRestore("", dumpDir)
+		target, err := newActualPath(dumpDir)
+		require.NoError(t, err)
+		require.ErrorContains(t, restore.enforceManifest(target), "3 files of the dump don't match")
+		restore.InputOptions.ManifestCheck = ManifestCheckWarn
+		require.NoError(t, restore.enforceManifest(target))
+	})
+
+	t.Run("database directory of a full dump", func(t *testing.T) {
+		dumpDir := writeManifestTestDump(t)
+		require.NoError(t, os.Remove(filepath.Join(dumpDir, "oplog.bson")))
+		require.NoError(t, os.Remove(filepath.Join(dumpDir, "test", "bar.bson")))
+
+		// Only the files of the database are checked.
+		problems := checkManifest(t, filepath.Join(dumpDir, "test"))
+		require.Len(t, problems, 1)
+		assert.Contains(t, problems[0], "bar.bson` is missing")
+
+		assert.Empty(t, checkManifest(t, filepath.Join(dumpDir, "test", "foo.bson")))
+	})
+
+	t.Run("verify reports manifest problems", func(t *testing.T) {
+		dumpDir := writeManifestTestDump(t)
+		require.NoError(t, os.Remove(filepath.Join(dumpDir, "test", "bar.bson")))
+		report, err := newVerifyTestRestore("", dumpDir).Verify()
+		require.NoError(t, err)
+		assert.False(t, report.OK)
+		assert.Contains(t, report.Problems, "`"+filepath.Join(dumpDir, "test", "bar.bson")+"` is missing")
+	})
+}
@@ -42,6 +42,12 @@ type Metadata struct {
 	Indexes        []*idx.IndexDocument `bson:"indexes"`
 	UUID           string               `bson:"uuid"`
 	CollectionName string               `bson:"collectionName"`
+	// Settings are the options of the collection that are applied with
+	// collMod once its documents are restored.
+	Settings bson.D `bson:"settings,omitempty"`
+	// SearchIndexes are the Atlas Search and vector search indexes of the
+	// collection, which are created once the restore has built its indexes.
+	SearchIndexes []db.SearchIndex `bson:"searchIndexes,omitempty"`
 }
 
 // MetadataFromJSON takes a slice of JSON bytes and unmarshals them into usable
@@ -472,7 +478,11 @@ func (restore *MongoRestore) RestoreUsersOrRoles(users, roles *intents.Intent) e
 			return err
 		}
 		defer arg.intent.BSONFile.Close()
-		bsonSource := db.NewDecodedBSONSource(db.NewBSONSource(arg.intent.BSONFile))
+		file, err := restore.usersOrRolesReader(arg.intent.BSONFile, arg.intentType)
+		if err != nil {
+			return err
+		}
+		bsonSource := db.NewDecodedBSONSource(db.NewBSONSource(file))
 		defer bsonSource.Close()
 
 		tempCollectionNameExists, err := restore.CollectionExists("admin", arg.tempCollectionName)
@@ -503,9 +513,10 @@ func (restore *MongoRestore) RestoreUsersOrRoles(users, roles *intents.Intent) e
 			"admin",
 			arg.tempCollectionName,
 			bsonSource,
-			arg.intent.BSONFile,
+			file,
 			0,
 			"",
+			nil,
 		)
 		if result.Err != nil {
 			return fmt.Errorf("error restoring %v: %v", arg.intentType, result.Err)
@@ -543,7 +554,7 @@ func (restore *MongoRestore) RestoreUsersOrRoles(users, roles *intents.Intent) e
 				)
 			}
 		}(arg)
-		userTargetDB = arg.intent.DB
+		userTargetDB = restore.renameDatabase(arg.intent.DB)
 	}
 
 	if userTargetDB == "admin" {
@@ -581,6 +592,9 @@ func (restore *MongoRestore) RestoreUsersOrRoles(users, roles *intents.Intent) e
 	if util.IsFalsy(res["ok"]) {
 		return fmt.Errorf("_mergeAuthzCollections command: %v", res["errmsg"])
 	}
+	if restore.OutputOptions.UsersAndRolesConflictReport != "" {
+		return restore.writeAuthConflictReport(restore.OutputOptions.UsersAndRolesConflictReport)
+	}
 	return nil
 }
 
@@ -762,6 +776,42 @@ func (restore *MongoRestore) DropCollection(intent *intents.Intent) error {
 	return nil
 }
 
+// collectionSettingsCommand returns the collMod command that applies the
+// settings of a collection, as mongodump recorded them in its metadata.
+func collectionSettingsCommand(intent *intents.Intent, settings bson.D) bson.D {
+	return append(bson.D{{"collMod", intent.C}}, settings...)
+}
+
+// ApplyCollectionSettings runs collMod to apply the settings of a collection
+// from its metadata, which are left out when it's created so that its
+// documents are restored before they're validated or record pre-images.
+func (restore *MongoRestore) ApplyCollectionSettings(intent *intents.Intent) error {
+	settings := restore.collectionSettings[intent.Namespace()]
+	if len(settings) == 0 {
+		return nil
+	}
+	session, err := restore.SessionProvider.GetSession()
+	if err != nil {
+		return fmt.Errorf("error establishing connection: %v", err)
+	}
+
+	ctx, cancel := restore.writeContext()
+	defer cancel()
+
+	log.Logvf(log.Info, "applying collection settings to %#q", intent.Namespace())
+	log.Logvf(log.DebugHigh, "using collection settings: %#v", settings)
+	err = session.Database(intent.DB).RunCommand(ctx, collectionSettingsCommand(intent, settings)).
+		Err()
+	if err != nil {
+		return fmt.Errorf(
+			"error applying collection settings to %v: %v",
+			intent.Namespace(),
+			err,
+		)
+	}
+	return nil
+}
+
 // EnableMixedSchemaInTimeseriesBucket runs collMod to turn on timeseriesBucketsMayHaveMixedSchemaData
 // for a timeseries collection.
 func (restore *MongoRestore) EnableMixedSchemaInTimeseriesBucket(dbName, colName string) error {
@@ -415,3 +415,37 @@ func readCollationTestData(filename string) (bson.D, error) {
 	}
 	return data, nil
 }
+
+func TestCollectionSettings(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	restore := &MongoRestore{}
+	meta, err := restore.MetadataFromJSON([]byte(`{
+		"options": {
+			"validator": {"qty": {"$gte": {"$numberInt": "0"}}},
+			"validationAction": "warn",
+			"changeStreamPreAndPostImages": {"enabled": true}
+		},
+		"indexes": [],
+		"uuid": "",
+		"collectionName": "orders",
+		"settings": {
+			"validator": {"qty": {"$gte": {"$numberInt": "0"}}},
+			"validationAction": "warn",
+			"changeStreamPreAndPostImages": {"enabled": true}
+		}
+	}`))
+	require.NoError(t, err)
+
+	intent := &intents.Intent{DB: "app", C: "orders"}
+	require.Equal(t, bson.D{
+		{"collMod", "orders"},
+		{"validator", bson.D{{"qty", bson.D{{"$gte", int32(0)}}}}},
+		{"validationAction", "warn"},
+		{"changeStreamPreAndPostImages", bson.D{{"enabled", true}}},
+	}, collectionSettingsCommand(intent, meta.Settings))
+
+	meta, err = restore.MetadataFromJSON([]byte(`{"options": {}, "indexes": [], "uuid": ""}`))
+	require.NoError(t, err)
+	require.Empty(t, meta.Settings, "dumps of older versions have no settings")
+}
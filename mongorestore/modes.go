@@ -0,0 +1,111 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// Values of --mode, which decides what happens to documents of the dump whose
+// _id is already in the target collection.
+const (
+	// ModeInsert inserts documents, which fail if their _id exists.
+	ModeInsert = "insert"
+	// ModeUpsert replaces existing documents.
+	ModeUpsert = "upsert"
+	// ModeMerge sets the fields of existing documents that are in the dump,
+	// keeping the others.
+	ModeMerge = "merge"
+	// ModeSkipNewer replaces existing documents, unless their --timestampField
+	// is newer than that of the dump.
+	ModeSkipNewer = "skip-newer"
+)
+
+// writesByID returns whether documents are written by their _id rather than
+// inserted.
+func (restore *MongoRestore) writesByID() bool {
+	return restore.OutputOptions.Mode != "" && restore.OutputOptions.Mode != ModeInsert
+}
+
+// writeDocument adds a document of the dump to bulk, to be written in the way
+// that --mode asks for. A document without an _id is inserted.
+func (restore *MongoRestore) writeDocument(
+	ctx context.Context,
+	bulk *db.BufferedBulkInserter,
+	rawDoc bson.Raw,
+) (*mongo.BulkWriteResult, error) {
+	if !restore.writesByID() {
+		return bulk.InsertRaw(ctx, rawDoc)
+	}
+	id, err := rawDoc.LookupErr("_id")
+	if err != nil {
+		return bulk.InsertRaw(ctx, rawDoc)
+	}
+	var document bson.D
+	err = bson.Unmarshal(rawDoc, &document)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding document: %v", err)
+	}
+	selector := bson.D{{"_id", id}}
+
+	switch restore.OutputOptions.Mode {
+	case ModeMerge:
+		return bulk.Update(ctx, selector, bson.D{{"$set", document}})
+	case ModeSkipNewer:
+		selector = append(selector, newerSelector(restore.OutputOptions.TimestampField, rawDoc))
+	}
+	return bulk.Replace(ctx, selector, document)
+}
+
+// newerSelector matches target documents that are no newer than rawDoc. A
+// document without the timestamp field is older than any that has it.
+func newerSelector(field string, rawDoc bson.Raw) bson.E {
+	missing := bson.D{{field, bson.D{{"$exists", false}}}}
+	ts, err := rawDoc.LookupErr(strings.Split(field, ".")...)
+	if err != nil {
+		return bson.E{"$or", bson.A{missing}}
+	}
+	return bson.E{"$or", bson.A{missing, bson.D{{field, bson.D{{"$lte", ts}}}}}}
+}
+
+// bulkResult counts the documents of a bulk write. With --mode skip-newer, a
+// document that is older than that of the target doesn't match the selector
+// and fails to be upserted with a duplicate _id, which isn't a failure.
+func (restore *MongoRestore) bulkResult(result *mongo.BulkWriteResult, err error) Result {
+	if restore.OutputOptions.Mode == ModeSkipNewer {
+		err = withoutDuplicateIDErrors(err)
+	}
+	return NewResultFromBulkResult(result, err)
+}
+
+// withoutDuplicateIDErrors removes the duplicate key errors on the _id index
+// from the errors of a bulk write, returning nil if no others remain.
+func withoutDuplicateIDErrors(err error) error {
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) {
+		return err
+	}
+	var writeErrors []mongo.BulkWriteError
+	for _, writeErr := range bwe.WriteErrors {
+		if writeErr.Code == db.ErrDuplicateKeyCode && strings.Contains(writeErr.Message, "index: _id_ ") {
+			continue
+		}
+		writeErrors = append(writeErrors, writeErr)
+	}
+	if len(writeErrors) == 0 && bwe.WriteConcernError == nil {
+		return nil
+	}
+	bwe.WriteErrors = writeErrors
+	return bwe
+}
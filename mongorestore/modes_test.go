@@ -0,0 +1,77 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestNewerSelector(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	ts := bson.Timestamp{T: 100, I: 1}
+	rawDoc, err := bson.Marshal(bson.D{{"_id", 1}, {"meta", bson.D{{"updated", ts}}}})
+	require.NoError(t, err)
+
+	selector := newerSelector("meta.updated", rawDoc)
+	assert.Equal(t, "$or", selector.Key)
+	clauses := selector.Value.(bson.A)
+	require.Len(t, clauses, 2)
+	assert.Equal(t, bson.D{{"meta.updated", bson.D{{"$exists", false}}}}, clauses[0])
+	lte := clauses[1].(bson.D)[0].Value.(bson.D)[0]
+	assert.Equal(t, "$lte", lte.Key)
+	tsT, tsI := lte.Value.(bson.RawValue).Timestamp()
+	assert.Equal(t, ts, bson.Timestamp{T: tsT, I: tsI})
+
+	selector = newerSelector("missing", rawDoc)
+	assert.Equal(t,
+		bson.E{"$or", bson.A{bson.D{{"missing", bson.D{{"$exists", false}}}}}},
+		selector,
+		"a document without the field only replaces documents without it",
+	)
+}
+
+func TestWithoutDuplicateIDErrors(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	duplicateID := mongo.BulkWriteError{WriteError: mongo.WriteError{
+		Code:    11000,
+		Message: "E11000 duplicate key error collection: db.c index: _id_ dup key: { _id: 1 }",
+	}}
+	duplicateOther := mongo.BulkWriteError{WriteError: mongo.WriteError{
+		Code:    11000,
+		Message: "E11000 duplicate key error collection: db.c index: email_1 dup key: { email: \"a\" }",
+	}}
+
+	assert.NoError(t, withoutDuplicateIDErrors(
+		mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{duplicateID, duplicateID}},
+	))
+
+	err := withoutDuplicateIDErrors(
+		mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{duplicateID, duplicateOther}},
+	)
+	var bwe mongo.BulkWriteException
+	require.ErrorAs(t, err, &bwe)
+	assert.Equal(t, []mongo.BulkWriteError{duplicateOther}, bwe.WriteErrors)
+
+	other := errors.New("connection reset")
+	assert.Equal(t, other, withoutDuplicateIDErrors(other))
+	assert.NoError(t, withoutDuplicateIDErrors(nil))
+
+	result := NewResultFromBulkResult(
+		&mongo.BulkWriteResult{InsertedCount: 1, UpsertedCount: 2, MatchedCount: 3},
+		nil,
+	)
+	assert.Equal(t, int64(6), result.Successes, "upserted and matched documents were restored")
+}
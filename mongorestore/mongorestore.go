@@ -8,7 +8,7 @@
 package mongorestore
 
 import (
-	"compress/gzip"
+	"bufio"
 	"context"
 	"encoding/json"
 	"errors"
@@ -16,6 +16,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -24,14 +25,18 @@ import (
 	"github.com/mongodb/mongo-tools/common"
 	"github.com/mongodb/mongo-tools/common/archive"
 	"github.com/mongodb/mongo-tools/common/auth"
+	"github.com/mongodb/mongo-tools/common/compression"
 	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/encryption"
 	"github.com/mongodb/mongo-tools/common/idx"
 	"github.com/mongodb/mongo-tools/common/intents"
 	"github.com/mongodb/mongo-tools/common/log"
 	"github.com/mongodb/mongo-tools/common/options"
 	"github.com/mongodb/mongo-tools/common/progress"
+	"github.com/mongodb/mongo-tools/common/storage"
 	"github.com/mongodb/mongo-tools/common/util"
 	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	"github.com/mongodb/mongo-tools/mongorestore/transform"
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
@@ -82,13 +87,69 @@ type MongoRestore struct {
 	includer *ns.Matcher
 	excluder *ns.Matcher
 
+	// users and roles selected by --usersAndRolesInclude/Exclude, and those
+	// left as they are on the target for --usersAndRolesConflictReport
+	authIncluder  *ns.Matcher
+	authExcluder  *ns.Matcher
+	authConflicts []*authConflict
+
 	// indexes belonging to dbs and collections
 	dbCollectionIndexes map[string]collectionIndexes
 
 	indexCatalog *idx.IndexCatalog
+	indexBuilds  *indexBuilds
 
 	archive *archive.Reader
 
+	// codec that dump files and archives are compressed with, either selected
+	// with --gzip/--compress or detected when detectCodec is set
+	codec       *compression.Codec
+	detectCodec bool
+
+	// encryptionKey decrypts dump files and archives, if they are encrypted
+	encryptionKey *encryption.Key
+
+	// storage is where the dump directory is, or nil for the local file system
+	storage storage.Backend
+
+	// oplogs from incremental dumps, replayed in order after the main oplog
+	oplogSegments []*oplogSegment
+
+	// journal records the progress of the restore when --resume is given
+	journal *restoreJournal
+
+	// collections to shard as they were in the dump when --shardCollections
+	// is given, keyed by the namespace they're restored to, and the shards of
+	// the cluster that the shards of the dump map to
+	shardedCollections map[string]*shardedCollection
+	shardMap           map[string]string
+
+	// settings of the collections of the dump that collMod applies once their
+	// documents are restored, keyed by the namespace they're restored to
+	collectionSettings map[string]bson.D
+
+	// namespaces that each view of the dump reads from, as the dump recorded
+	// them in prelude.json or the prelude of an archive, keyed by the
+	// namespace of the view in the dump
+	recordedViewSources map[string][]string
+
+	// number of partitions that each collection split into partitions was
+	// dumped in, as the dump recorded it in prelude.json
+	dumpedPartitions map[string]int
+
+	// search indexes of the collections of the dump, keyed by the namespace
+	// they're restored to, and what their commands are run with, or nil to
+	// run them with the SessionProvider
+	searchIndexes map[string][]db.SearchIndex
+	runCommand    db.CommandRunner
+
+	// rules from --transformRules that rewrite documents as they're restored
+	transforms *transform.Rules
+
+	// state shared with the restores of the other parts of the dump of a
+	// sharded cluster, when this restores one of them
+	cluster *clusterRestore
+
 	// boolean set if termination signal received; false by default
 	terminate atomic.Bool
 
@@ -96,6 +157,10 @@ type MongoRestore struct {
 	// This is initialized to os.Stdin if unset.
 	InputReader io.Reader
 
+	// Writer that --dryRun prints the plan of the restore to.
+	// This is initialized to os.Stdout if unset.
+	PlanWriter io.Writer
+
 	// Server versions for version-specific behavior
 	dumpServerVersion db.Version
 	serverVersion     db.Version
@@ -204,7 +269,83 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 		log.Logv(log.DebugLow, "restoring to a MongoDB Atlas free or shared cluster")
 	}
 
-	var err error
+	err := restore.setInputDecoding()
+	if err != nil {
+		return err
+	}
+
+	switch restore.OutputOptions.Mode {
+	case "", ModeInsert, ModeUpsert, ModeMerge:
+	case ModeSkipNewer:
+		if restore.OutputOptions.TimestampField == "" {
+			return fmt.Errorf("--mode %v requires --timestampField", ModeSkipNewer)
+		}
+		// a document that is skipped fails to be upserted, which stops an ordered write
+		if restore.OutputOptions.MaintainInsertionOrder {
+			return fmt.Errorf(
+				"cannot use --mode %v with --maintainInsertionOrder",
+				ModeSkipNewer,
+			)
+		}
+	default:
+		return fmt.Errorf(
+			"invalid --mode %#q, expected %v, %v, %v, or %v",
+			restore.OutputOptions.Mode,
+			ModeInsert,
+			ModeUpsert,
+			ModeMerge,
+			ModeSkipNewer,
+		)
+	}
+	if restore.OutputOptions.TimestampField != "" &&
+		restore.OutputOptions.Mode != ModeSkipNewer {
+		return fmt.Errorf("cannot use --timestampField without --mode %v", ModeSkipNewer)
+	}
+
+	switch restore.OutputOptions.IndexBuildStrategy {
+	case "", IndexBuildAfter, IndexBuildBefore, IndexBuildAuto:
+	default:
+		return fmt.Errorf(
+			"invalid --indexBuildStrategy %#q, expected %v, %v, or %v",
+			restore.OutputOptions.IndexBuildStrategy,
+			IndexBuildAfter,
+			IndexBuildBefore,
+			IndexBuildAuto,
+		)
+	}
+	if restore.OutputOptions.NumParallelIndexBuilds < 0 {
+		return fmt.Errorf("--numParallelIndexBuilds must not be negative")
+	}
+	if restore.indexBuilds == nil {
+		restore.indexBuilds = newIndexBuilds()
+	}
+
+	switch restore.OutputOptions.DryRunFormat {
+	case "", DryRunFormatText, DryRunFormatJSON:
+	default:
+		return fmt.Errorf(
+			"invalid --dryRunFormat %#q, expected %v or %v",
+			restore.OutputOptions.DryRunFormat,
+			DryRunFormatText,
+			DryRunFormatJSON,
+		)
+	}
+	if restore.OutputOptions.DryRunFormat != "" && !restore.OutputOptions.DryRun {
+		return fmt.Errorf("cannot use --dryRunFormat without --dryRun")
+	}
+
+	switch restore.InputOptions.ManifestCheck {
+	case "", ManifestCheckError, ManifestCheckWarn, ManifestCheckSkip:
+	default:
+		return fmt.Errorf(
+			"invalid --manifestCheck %#q, expected %v, %v, or %v",
+			restore.InputOptions.ManifestCheck,
+			ManifestCheckError,
+			ManifestCheckWarn,
+			ManifestCheckSkip,
+		)
+	}
+
 	if restore.InputOptions.OplogLimit != "" {
 		if !restore.InputOptions.OplogReplay {
 			return fmt.Errorf("cannot use --oplogLimit without --oplogReplay enabled")
@@ -222,6 +363,14 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 			return fmt.Errorf("cannot use --oplogFile with --archive specified")
 		}
 	}
+	if len(restore.InputOptions.OplogSegments) > 0 {
+		if !restore.InputOptions.OplogReplay {
+			return fmt.Errorf("cannot use --oplogSegment without --oplogReplay enabled")
+		}
+		if slices.Contains(restore.InputOptions.OplogSegments, "-") {
+			return fmt.Errorf("cannot read an oplog segment from standard input")
+		}
+	}
 
 	// check if we are using a replica set and fall back to w=1 if we aren't (for <= 2.4)
 	nodeType, err := restore.SessionProvider.GetNodeType()
@@ -281,6 +430,10 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 		)
 	}
 	excludes := restore.NSOptions.NSExclude
+	if restore.OutputOptions.ShardCollections || restore.cluster != nil {
+		// the config database of the dump is only read to shard collections
+		excludes = append(excludes, "config.*")
+	}
 	for _, col := range restore.NSOptions.ExcludedCollections {
 		excludes = append(excludes, "*."+ns.Escape(col))
 	}
@@ -301,15 +454,53 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 	if err != nil {
 		return fmt.Errorf("invalid renames: %v", err)
 	}
+	restore.authIncluder, err = ns.NewMatcher(restore.InputOptions.UsersAndRolesInclude)
+	if err != nil {
+		return fmt.Errorf("invalid --usersAndRolesInclude: %v", err)
+	}
+	restore.authExcluder, err = ns.NewMatcher(restore.InputOptions.UsersAndRolesExclude)
+	if err != nil {
+		return fmt.Errorf("invalid --usersAndRolesExclude: %v", err)
+	}
+	err = restore.validateUsersAndRolesOptions()
+	if err != nil {
+		return err
+	}
 
 	if restore.OutputOptions.NumInsertionWorkers < 0 {
 		return fmt.Errorf(
 			"cannot specify a negative number of insertion workers per collection")
 	}
 
+	if restore.OutputOptions.NumReaders < 0 {
+		return fmt.Errorf(
+			"cannot specify a negative number of readers per collection")
+	}
+
 	if restore.OutputOptions.MaintainInsertionOrder {
 		restore.OutputOptions.StopOnError = true
 		restore.OutputOptions.NumInsertionWorkers = 1
+		restore.OutputOptions.NumReaders = 1
+	}
+
+	if restore.OutputOptions.NumReaders > 1 && restore.OutputOptions.Resume != "" {
+		return fmt.Errorf("cannot specify --numReadersPerCollection with --resume")
+	}
+
+	if restore.OutputOptions.TransformRules != "" {
+		restore.transforms, err = transform.Load(restore.OutputOptions.TransformRules)
+		if err != nil {
+			return err
+		}
+	}
+
+	if restore.OutputOptions.ShardCollections {
+		if restore.InputOptions.Archive != "" || restore.TargetDirectory == "-" {
+			return fmt.Errorf("cannot specify --shardCollections unless restoring a dump directory")
+		}
+		if !restore.isMongos {
+			return fmt.Errorf("cannot specify --shardCollections unless connected to mongos")
+		}
 	}
 
 	if restore.OutputOptions.PreserveUUID && !restore.OutputOptions.Drop {
@@ -329,6 +520,9 @@ func (restore *MongoRestore) ParseAndValidateOptions() error {
 	if restore.InputReader == nil {
 		restore.InputReader = os.Stdin
 	}
+	if restore.PlanWriter == nil {
+		restore.PlanWriter = os.Stdout
+	}
 
 	return nil
 }
@@ -342,6 +536,14 @@ func (restore *MongoRestore) Restore() Result {
 		return Result{Err: err}
 	}
 
+	clusterManifest, err := restore.readClusterManifest()
+	if err != nil {
+		return Result{Err: err}
+	}
+	if clusterManifest != nil {
+		return restore.restoreCluster(clusterManifest)
+	}
+
 	// Build up all intents to be restored
 	restore.manager = intents.NewIntentManager()
 	if restore.InputOptions.Archive == "" && restore.InputOptions.OplogReplay {
@@ -364,6 +566,7 @@ func (restore *MongoRestore) Restore() Result {
 		if err != nil {
 			return Result{Err: err}
 		}
+		restore.recordedViewSources = restore.archive.Prelude.ViewDependencies()
 		log.Logvf(
 			log.DebugLow,
 			`archive format version %#q`,
@@ -382,6 +585,13 @@ func (restore *MongoRestore) Restore() Result {
 			`archive tool version %#q`,
 			restore.archive.Prelude.Header.ToolVersion,
 		)
+		if compressionName := restore.archive.Prelude.Header.Compression; compressionName != "" {
+			log.Logvf(log.DebugLow, `archive compression %#q`, compressionName)
+		}
+		if cipherName := restore.archive.Prelude.Header.Encryption; cipherName != "" {
+			log.Logvf(log.DebugLow, `archive encryption %#q with key ID %v`,
+				cipherName, restore.archive.Prelude.Header.EncryptionKeyID)
+		}
 
 		if restore.dumpServerVersion.CmpMinor(restore.serverVersion) != 0 {
 			log.Logvf(
@@ -403,7 +613,7 @@ func (restore *MongoRestore) Restore() Result {
 			log.Logv(log.Always, "using default 'dump' directory")
 			usedDefaultTarget = true
 		}
-		target, err = newActualPath(restore.TargetDirectory)
+		target, err = restore.openTarget()
 		if err != nil {
 			if usedDefaultTarget {
 				log.Logv(log.Always, util.ShortUsage("mongorestore"))
@@ -427,6 +637,13 @@ func (restore *MongoRestore) Restore() Result {
 			return Result{Err: fmt.Errorf("error reading dump metadata: %w", err)}
 		}
 
+		if restore.InputOptions.ManifestCheck != ManifestCheckSkip {
+			err = restore.enforceManifest(target)
+			if err != nil {
+				return Result{Err: err}
+			}
+		}
+
 		// handle cases where the user passes in a file instead of a directory
 		if !target.IsDir() {
 			log.Logv(log.DebugLow, "mongorestore target is a file, not a directory")
@@ -533,6 +750,11 @@ func (restore *MongoRestore) Restore() Result {
 		}
 	}
 
+	err = restore.checkPartitions()
+	if err != nil {
+		return Result{Err: err}
+	}
+
 	if restore.InputOptions.OplogFile != "" {
 		err = restore.CreateIntentForOplog()
 		if err != nil {
@@ -553,6 +775,13 @@ func (restore *MongoRestore) Restore() Result {
 		}
 	}
 
+	if len(restore.InputOptions.OplogSegments) > 0 {
+		err = restore.loadOplogSegments()
+		if err != nil {
+			return Result{Err: fmt.Errorf("error reading oplog segments: %v", err)}
+		}
+	}
+
 	conflicts := restore.manager.GetDestinationConflicts()
 	if len(conflicts) > 0 {
 		for _, conflict := range conflicts {
@@ -561,7 +790,18 @@ func (restore *MongoRestore) Restore() Result {
 		return Result{Err: fmt.Errorf("cannot restore with conflicting namespace destinations")}
 	}
 
+	if restore.OutputOptions.ShardCollections {
+		err = restore.loadShardedCollections(target)
+		if err != nil {
+			return Result{Err: err}
+		}
+	}
+
 	if restore.OutputOptions.DryRun {
+		err = restore.printPlan(target)
+		if err != nil {
+			return Result{Err: err}
+		}
 		log.Logvf(log.Always, "dry run completed")
 		return Result{}
 	}
@@ -569,6 +809,14 @@ func (restore *MongoRestore) Restore() Result {
 	demuxFinished := make(chan any)
 	var demuxErr error
 	if restore.InputOptions.Archive != "" {
+		indexedArchive, err := restore.openArchiveIndex()
+		if err != nil {
+			return Result{Err: err}
+		}
+		if indexedArchive != nil {
+			defer indexedArchive.Close()
+		}
+
 		namespaceChan := make(chan string, 1)
 		namespaceErrorChan := make(chan error)
 		restore.archive.Demux.NamespaceChan = namespaceChan
@@ -647,6 +895,19 @@ func (restore *MongoRestore) Restore() Result {
 	if err != nil {
 		return Result{Err: fmt.Errorf("restore error: %v", err)}
 	}
+	restore.applyRecordedViewSources()
+	restore.warnAboutMissingViewSources()
+
+	if restore.OutputOptions.Resume != "" {
+		source, err := journalSource(restore.InputOptions.Archive, restore.TargetDirectory)
+		if err != nil {
+			return Result{Err: fmt.Errorf("restore error: %v", err)}
+		}
+		restore.journal, err = openRestoreJournal(restore.OutputOptions.Resume, source)
+		if err != nil {
+			return Result{Err: fmt.Errorf("restore error: %v", err)}
+		}
+	}
 
 	// Restore the regular collections
 	if restore.InputOptions.Archive != "" {
@@ -657,14 +918,15 @@ func (restore *MongoRestore) Restore() Result {
 		// in the same database simultaneously due to the database-level locking.
 		// Up to 4.2, foreground index builds take a database-level lock for the entire build,
 		// but this prioritizer is not used for index builds so we don't need to worry about that here.
+		// Either way, views are held back until what they read from is restored.
 		if restore.serverVersion.GTE(db.Version{3, 0, 0}) {
-			restore.manager.Finalize(intents.LongestTaskFirst)
+			restore.manager.FinalizeWithViewDependencies(intents.LongestTaskFirst)
 		} else {
-			restore.manager.Finalize(intents.MultiDatabaseLTF)
+			restore.manager.FinalizeWithViewDependencies(intents.MultiDatabaseLTF)
 		}
 	} else {
 		// use legacy restoration order if we are single-threaded
-		restore.manager.Finalize(intents.Legacy)
+		restore.manager.FinalizeWithViewDependencies(intents.Legacy)
 	}
 
 	result := restore.RestoreIntents()
@@ -681,11 +943,23 @@ func (restore *MongoRestore) Restore() Result {
 	}
 
 	// Restore oplog
-	if restore.InputOptions.OplogReplay {
+	if restore.InputOptions.OplogReplay && restore.journal != nil && restore.journal.oplogReplayed() {
+		log.Logv(log.Always, "skipping oplog replay, which the restore journal records as done")
+		err = restore.skipOplog()
+		if err != nil {
+			return result.withErr(fmt.Errorf("restore error: %v", err))
+		}
+	} else if restore.InputOptions.OplogReplay {
 		err = restore.RestoreOplog()
 		if err != nil {
 			return result.withErr(fmt.Errorf("restore error: %v", err))
 		}
+		if restore.journal != nil {
+			err = restore.journal.setOplogReplayed()
+			if err != nil {
+				return result.withErr(fmt.Errorf("restore error: %v", err))
+			}
+		}
 	}
 
 	if !restore.OutputOptions.NoIndexRestore {
@@ -693,6 +967,13 @@ func (restore *MongoRestore) Restore() Result {
 		if err != nil {
 			return result.withErr(err)
 		}
+		restore.RestoreSearchIndexes()
+		if restore.OutputOptions.IndexFailureReport != "" {
+			err = restore.indexBuilds.writeReport(restore.OutputOptions.IndexFailureReport)
+			if err != nil {
+				return result.withErr(err)
+			}
+		}
 	}
 
 	if restore.InputOptions.Archive != "" {
@@ -707,47 +988,49 @@ func (restore *MongoRestore) Restore() Result {
 // It currently only sets the server.dumpServerVersion, but in the future we can read and set other metadata from the dump as required.
 // Returns true if the metadata file exists.
 func (restore *MongoRestore) ReadPreludeMetadata(target archive.DirLike) (bool, error) {
-	filename := "prelude.json"
-	if restore.InputOptions.Gzip {
-		filename += ".gz"
-	}
-
 	var err error
 	var reader io.ReadCloser
 	if !target.IsDir() {
 		// Look for prelude.json in target's directory if target is .bson file.
-		target, err = newActualPath(target.Parent().Path())
+		target, err = newActualPathIn(restore.storage, target.Parent().Path())
 		if err != nil {
 			return false, fmt.Errorf("error finding parent of target file: %w", err)
 		}
 	}
-	filePath := filepath.Join(target.Path(), filename)
-	file, err := os.Open(filePath)
+	file, filePath, codec, err := restore.openPreludeFile(target.Path())
 	if errors.Is(err, os.ErrNotExist) {
 		// If the mongodump was for all databases, prelude.json will be in the top level directory.
 		// If a single database's directory was used as the target, look for prelude.json in the target's parent directory.
-		filePath = filepath.Join(target.Parent().Path(), filename)
-		file, err = os.Open(filePath)
+		file, filePath, codec, err = restore.openPreludeFile(target.Parent().Path())
 		if errors.Is(err, os.ErrNotExist) {
 			return false, nil
 		} else if err != nil {
-			return false, fmt.Errorf("error opening file %#q: %w", filePath, err)
+			return false, err
 		}
 	} else if err != nil {
-		return false, fmt.Errorf("error opening file %#q: %w", filePath, err)
+		return false, err
 	}
 
 	defer file.Close()
 
-	if restore.InputOptions.Gzip {
-		zipfile, err := gzip.NewReader(file)
+	if restore.detectCodec && codec != nil {
+		log.Logvf(log.Always, "detected %v compressed dump from %#q", codec, filePath)
+		restore.codec = codec
+	}
+
+	in, err := encryption.Decrypt(file, restore.encryptionKey)
+	if err != nil {
+		return true, fmt.Errorf("failed to open file %#q: %w", filePath, err)
+	}
+	if codec != nil {
+		zipfile, err := codec.NewReader(in)
 		if err != nil {
-			return true, fmt.Errorf("failed to open gzip file %#q: %w", filePath, err)
+			return true, fmt.Errorf("failed to open compressed file %#q: %w", filePath, err)
 		}
 		defer zipfile.Close()
 		reader = zipfile
 	} else {
-		reader = file
+		reader = io.NopCloser(in)
 	}
 	bytes, err := io.ReadAll(reader)
 	if err != nil {
@@ -755,12 +1038,16 @@ func (restore *MongoRestore) ReadPreludeMetadata(target archive.DirLike) (bool,
 	}
 
 	var prelude struct {
-		ServerVersion string `json:"ServerVersion"`
+		ServerVersion    string              `json:"ServerVersion"`
+		ViewDependencies map[string][]string `json:"ViewDependencies"`
+		Partitions       map[string]int      `json:"Partitions"`
 	}
 	err = json.Unmarshal(bytes, &prelude)
 	if err != nil {
 		return true, fmt.Errorf("failed to unmarshal prelude metadata from %#q: %w", filePath, err)
 	}
+	restore.recordedViewSources = prelude.ViewDependencies
+	restore.dumpedPartitions = prelude.Partitions
 
 	dumpVersion := prelude.ServerVersion
 	if dumpVersion == "" {
@@ -783,6 +1070,40 @@ func (restore *MongoRestore) ReadPreludeMetadata(target archive.DirLike) (bool,
 	}
 }
 
+// openPreludeFile opens the prelude.json file in dir. When the codec is being
+// detected, a prelude.json compressed with any codec is accepted, and the codec
+// it was compressed with is returned.
+func (restore *MongoRestore) openPreludeFile(
+	dir string,
+) (storage.File, string, *compression.Codec, error) {
+	codecs := []*compression.Codec{restore.codec}
+	if restore.detectCodec {
+		codecs = append([]*compression.Codec{nil}, compression.Codecs...)
+	}
+	for _, codec := range codecs {
+		filePath := filepath.Join(dir, codec.FileName("prelude.json"))
+		file, err := storage.OrLocal(restore.storage).Open(filePath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, "", nil, fmt.Errorf("error opening file %#q: %w", filePath, err)
+		}
+		return file, filePath, codec, nil
+	}
+	return nil, "", nil, os.ErrNotExist
+}
+
+// openTarget returns the dump directory or BSON file given as the target, and
+// sets the storage that it is in.
+func (restore *MongoRestore) openTarget() (*actualPath, error) {
+	backend, path, err := storage.Parse(context.Background(), restore.TargetDirectory)
+	if err != nil {
+		return nil, err
+	}
+	restore.storage = backend
+	return newActualPathIn(backend, path)
+}
+
 func (restore *MongoRestore) preFlightChecks() error {
 
 	for _, intent := range restore.manager.Intents() {
@@ -844,40 +1165,133 @@ func (restore *MongoRestore) preFlightChecks() error {
 	return nil
 }
 
+// setInputDecoding sets the codec and the encryption key that dump files and
+// archives are read with.
+func (restore *MongoRestore) setInputDecoding() error {
+	var err error
+	restore.codec, err = restore.InputOptions.Codec()
+	if err != nil {
+		return err
+	}
+	restore.detectCodec = !restore.InputOptions.CodecSelected()
+
+	restore.encryptionKey, err = restore.InputOptions.EncryptionKey()
+	return err
+}
+
 func (restore *MongoRestore) getArchiveReader() (rc io.ReadCloser, err error) {
 	if restore.InputOptions.Archive == "-" {
 		rc = io.NopCloser(restore.InputReader)
 	} else {
-		targetStat, err := os.Stat(restore.InputOptions.Archive)
+		backend, path, err := restore.archivePath()
 		if err != nil {
 			return nil, err
 		}
-		if targetStat.IsDir() {
-			defaultArchiveFilePath := filepath.Join(restore.InputOptions.Archive, "archive")
-			if restore.InputOptions.Gzip {
-				defaultArchiveFilePath = defaultArchiveFilePath + ".gz"
-			}
-			rc, err = os.Open(defaultArchiveFilePath)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			rc, err = os.Open(restore.InputOptions.Archive)
-			if err != nil {
-				return nil, err
-			}
+		rc, err = backend.Open(path)
+		if err != nil {
+			return nil, err
 		}
 	}
-	if restore.InputOptions.Gzip {
-		gzrc, err := gzip.NewReader(rc)
+	// mongodump encrypts the whole archive after compressing it.
+	in, err := encryption.Decrypt(rc, restore.encryptionKey)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("error reading archive: %v", err)
+	}
+	rc = &util.WrappedReadCloser{io.NopCloser(in), rc}
+	if restore.detectCodec {
+		// mongodump compresses the whole archive, so the codec is detected from
+		// the magic number at the start of the stream.
+		buffered := bufio.NewReader(rc)
+		restore.codec, err = compression.Detect(buffered)
 		if err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("error reading archive: %v", err)
+		}
+		if restore.codec != nil {
+			log.Logvf(log.Info, "detected %v compressed archive", restore.codec)
+		}
+		rc = &util.WrappedReadCloser{io.NopCloser(buffered), rc}
+	}
+	if restore.codec != nil {
+		zrc, err := restore.codec.NewReader(rc)
+		if err != nil {
+			rc.Close()
 			return nil, err
 		}
-		return &util.WrappedReadCloser{gzrc, rc}, nil
+		return &util.WrappedReadCloser{zrc, rc}, nil
 	}
 	return rc, nil
 }
 
+// archivePath returns the path of the archive file given with --archive, and
+// the storage it is in.
+func (restore *MongoRestore) archivePath() (storage.Backend, string, error) {
+	backend, target, err := storage.Parse(context.Background(), restore.InputOptions.Archive)
+	if err != nil {
+		return nil, "", err
+	}
+	targetStat, err := backend.Stat(target)
+	if err != nil {
+		return nil, "", err
+	}
+	if targetStat.IsDir() {
+		return backend, restore.archiveFileInDir(backend, target), nil
+	}
+	return backend, target, nil
+}
+
+// openArchiveIndex opens the archive file again to read it through its index, so
+// that the demux can seek past the namespaces that are not restored. It returns
+// a nil file if the archive has no index or can't be seeked, in which case the
+// demux reads the archive from start to end.
+func (restore *MongoRestore) openArchiveIndex() (storage.File, error) {
+	if restore.InputOptions.Archive == "-" ||
+		restore.codec != nil ||
+		restore.encryptionKey != nil ||
+		restore.archive.Prelude.Header.FormatVersion != archive.IndexedFormatVersion {
+		return nil, nil
+	}
+	backend, path, err := restore.archivePath()
+	if err != nil {
+		return nil, err
+	}
+	info, err := backend.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, nil
+	}
+	file, err := backend.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	index, err := archive.ReadIndex(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading index of archive %#q: %v", path, err)
+	}
+	log.Logvf(log.Info, "using the index of archive %#q", path)
+	restore.archive.Demux.UseIndex(file, index)
+	return file, nil
+}
+
+// archiveFileInDir returns the path of the archive that mongodump writes when
+// --archive is given a directory.
+func (restore *MongoRestore) archiveFileInDir(backend storage.Backend, dir string) string {
+	path := filepath.Join(dir, "archive")
+	if !restore.detectCodec {
+		return restore.codec.FileName(path)
+	}
+	for _, codec := range append([]*compression.Codec{nil}, compression.Codecs...) {
+		if _, err := backend.Stat(codec.FileName(path)); err == nil {
+			return codec.FileName(path)
+		}
+	}
+	return path
+}
+
 func (restore *MongoRestore) HandleInterrupt() {
 	restore.terminate.Store(true)
 }
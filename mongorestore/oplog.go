@@ -8,6 +8,7 @@ package mongorestore
 
 import (
 	"fmt"
+	"io"
 	"slices"
 	"strconv"
 	"strings"
@@ -95,7 +96,8 @@ func shouldIgnoreNamespace(ns string) bool {
 	return false
 }
 
-// RestoreOplog attempts to restore a MongoDB oplog.
+// RestoreOplog attempts to restore a MongoDB oplog, followed by any oplog
+// segments given with --oplogSegment.
 func (restore *MongoRestore) RestoreOplog() error {
 	log.Logv(log.Always, "replaying oplog")
 	intent := restore.manager.Oplog()
@@ -112,23 +114,18 @@ func (restore *MongoRestore) RestoreOplog() error {
 	}
 	defer intent.BSONFile.Close()
 
-	// NewBufferlessBSONSource reads each bson document into its own buffer
-	// because bson.Unmarshal currently can't unmarshal binary types without
-	// them referencing the source buffer.
-	// We also increase the max BSON size by 16 KiB to accommodate the maximum
-	// document size of 16 MiB plus any additional oplog-specific data.
-	bsonSource := db.NewBufferlessBSONSource(intent.BSONFile)
-	bsonSource.SetMaxBSONSize(db.MaxBSONSize + 16*1024)
-	decodedBsonSource := db.NewDecodedBSONSource(bsonSource)
-	defer decodedBsonSource.Close()
-
 	session, err := restore.SessionProvider.GetSession()
 	if err != nil {
 		return fmt.Errorf("error establishing connection: %v", err)
 	}
 
+	totalSize := intent.BSONSize
+	for _, segment := range restore.oplogSegments {
+		totalSize += segment.size
+	}
+
 	oplogCtx := &oplogContext{
-		progressor: progress.NewCounter(intent.BSONSize),
+		progressor: progress.NewCounter(totalSize),
 		txnBuffer:  txn.NewBuffer(),
 		session:    session,
 	}
@@ -139,6 +136,70 @@ func (restore *MongoRestore) RestoreOplog() error {
 		defer restore.ProgressManager.Detach("oplog")
 	}
 
+	last, reachedLimit, err := restore.replayOplog(oplogCtx, intent.BSONFile, bson.Timestamp{})
+	if err != nil {
+		return err
+	}
+	if fileNeedsIOBuffer, ok := intent.BSONFile.(intents.FileNeedsIOBuffer); ok {
+		fileNeedsIOBuffer.ReleaseIOBuffer()
+	}
+
+	for _, segment := range restore.oplogSegments {
+		if reachedLimit {
+			break
+		}
+		err = checkOplogContinuity(last, segment.first, segment.path)
+		if err != nil {
+			return err
+		}
+
+		log.Logvf(log.Always, "replaying oplog segment %#q", segment.path)
+		in, err := segment.Open()
+		if err != nil {
+			return fmt.Errorf("error opening oplog segment %#q: %v", segment.path, err)
+		}
+		// The first entry of the segment is the last one already applied.
+		last, reachedLimit, err = restore.replayOplog(oplogCtx, in, last)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Logvf(log.Always, "applied %v oplog entries", oplogCtx.totalOps)
+	return nil
+}
+
+// skipOplog reads through the oplog of an archive without replaying it.
+func (restore *MongoRestore) skipOplog() error {
+	intent := restore.manager.Oplog()
+	if intent == nil || intent.BSONFile == nil {
+		return nil
+	}
+	if fileNeedsIOBuffer, ok := intent.BSONFile.(intents.FileNeedsIOBuffer); ok {
+		fileNeedsIOBuffer.TakeIOBuffer(make([]byte, db.MaxBSONSize))
+	}
+	return restore.skipIntent(intent)
+}
+
+// replayOplog applies the oplog entries read from in, skipping entries at or
+// before the given timestamp. It returns the timestamp of the last entry read,
+// and whether replay stopped because that entry reached --oplogLimit.
+func (restore *MongoRestore) replayOplog(
+	oplogCtx *oplogContext,
+	in io.ReadCloser,
+	after bson.Timestamp,
+) (last bson.Timestamp, reachedLimit bool, err error) {
+	// NewBufferlessBSONSource reads each bson document into its own buffer
+	// because bson.Unmarshal currently can't unmarshal binary types without
+	// them referencing the source buffer.
+	// We also increase the max BSON size by 16 KiB to accommodate the maximum
+	// document size of 16 MiB plus any additional oplog-specific data.
+	bsonSource := db.NewBufferlessBSONSource(in)
+	bsonSource.SetMaxBSONSize(db.MaxBSONSize + 16*1024)
+	decodedBsonSource := db.NewDecodedBSONSource(bsonSource)
+	defer decodedBsonSource.Close()
+
+	last = after
 	for {
 		rawOplogEntry := decodedBsonSource.LoadNext()
 		if rawOplogEntry == nil {
@@ -150,28 +211,26 @@ func (restore *MongoRestore) RestoreOplog() error {
 
 		err = bson.Unmarshal(rawOplogEntry, &entryAsOplog)
 		if err != nil {
-			return fmt.Errorf("error reading oplog: %v", err)
+			return last, false, fmt.Errorf("error reading oplog: %v", err)
+		}
+		if !util.TimestampGreaterThan(entryAsOplog.Timestamp, after) {
+			continue
 		}
 
 		err := restore.HandleOp(oplogCtx, entryAsOplog)
 		if err == errorTimestampBeforeLimit {
-			break
+			return last, true, nil
 		}
 		if err != nil {
-			return err
+			return last, false, err
 		}
-
-	}
-	if fileNeedsIOBuffer, ok := intent.BSONFile.(intents.FileNeedsIOBuffer); ok {
-		fileNeedsIOBuffer.ReleaseIOBuffer()
+		last = entryAsOplog.Timestamp
 	}
 
-	log.Logvf(log.Always, "applied %v oplog entries", oplogCtx.totalOps)
 	if err := decodedBsonSource.Err(); err != nil {
-		return fmt.Errorf("error reading oplog bson input: %v", err)
+		return last, false, fmt.Errorf("error reading oplog bson input: %v", err)
 	}
-	return nil
-
+	return last, false, nil
 }
 
 var ignoredOps = mapset.NewSet(
@@ -202,6 +261,10 @@ func (restore *MongoRestore) HandleOp(oplogCtx *oplogContext, op db.Oplog) error
 	if shouldIgnoreNamespace(op.Namespace) {
 		return nil
 	}
+	// the config database isn't restored from the dump of a sharded cluster
+	if restore.cluster != nil && strings.HasPrefix(op.Namespace, "config.") {
+		return nil
+	}
 
 	if ignoredOps.ContainsOne(op.Operation) {
 		return nil
@@ -254,6 +317,7 @@ func (restore *MongoRestore) HandleNonTxnOp(oplogCtx *oplogContext, op db.Oplog)
 	}
 
 	op = restore.filterRecordIdsReplicated(op)
+	op = restore.transformOp(op)
 
 	if op.Operation == "c" {
 		if len(op.Object) == 0 {
@@ -364,6 +428,9 @@ func (restore *MongoRestore) HandleNonTxnOp(oplogCtx *oplogContext, op db.Oplog)
 			if err := restore.indexCatalog.DeleteIndexes(dbName, collName, op.Object); err != nil {
 				return fmt.Errorf("error deleting indexes: %v", err)
 			}
+			if err := restore.dropIndexesBuiltBeforeData(dbName, collName, op.Object); err != nil {
+				return fmt.Errorf("error dropping indexes: %v", err)
+			}
 			return nil
 		case "collMod":
 			if restore.serverVersion.GTE(db.Version{4, 1, 11}) {
@@ -604,6 +671,26 @@ func (restore *MongoRestore) filterRecordIdsReplicated(op db.Oplog) db.Oplog {
 	return op
 }
 
+// transformOp rewrites the documents of an insert, update or delete with the
+// rules from --transformRules, so documents that were masked as they were
+// restored stay masked as the oplog is replayed.
+func (restore *MongoRestore) transformOp(op db.Oplog) db.Oplog {
+	transformer := restore.transforms.For(op.Namespace)
+	if transformer == nil {
+		return op
+	}
+	switch op.Operation {
+	case "i":
+		op.Object = transformer.Document(op.Object)
+	case "u":
+		op.Object = transformer.Update(op.Object)
+		op.Query = transformer.Fields(op.Query)
+	case "d":
+		op.Object = transformer.Fields(op.Object)
+	}
+	return op
+}
+
 // convertCreateIndexToIndexInsert converts from new-style create indexes
 // command to old style special index insert.
 func convertCreateIndexToIndexInsert(op db.Oplog) (db.Oplog, error) {
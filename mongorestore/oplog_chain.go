@@ -0,0 +1,287 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/encryption"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// oplogSegment is the oplog of an incremental dump, given with --oplogSegment.
+// Segments are replayed in order after the oplog of the dump being restored.
+//
+// Each incremental dump starts its oplog at the last entry of the previous dump,
+// so consecutive segments share exactly one entry. That entry proves there is no
+// gap between the segments, and it is skipped when the later segment is replayed.
+type oplogSegment struct {
+	path    string
+	archive bool
+	codec   *compression.Codec
+	key     *encryption.Key
+	size    int64
+
+	first bson.Timestamp
+	last  bson.Timestamp
+}
+
+// newOplogSegment resolves the path given to --oplogSegment. A directory is
+// expected to hold an oplog.bson file, a path ending in .bson is read as a bson
+// file, and anything else is read as an archive. mongodump names oplog.bson the
+// same whatever codec it is compressed with, so the codec of a directory is the
+// one the restore uses. Files may also carry a codec extension, and archives are
+// detected from their magic number. Segments are decrypted with key, if given.
+func newOplogSegment(
+	path string,
+	codec *compression.Codec,
+	key *encryption.Key,
+) (*oplogSegment, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	segment := &oplogSegment{path: path, codec: codec, key: key}
+	if info.IsDir() {
+		segment.path = filepath.Join(path, "oplog.bson")
+		info, err = os.Stat(segment.path)
+		if err != nil {
+			return nil, fmt.Errorf("no oplog found in directory %#q: %v", path, err)
+		}
+	} else {
+		if extCodec := compression.ByExtension(path); extCodec != nil {
+			segment.codec = extCodec
+			path = strings.TrimSuffix(path, extCodec.Extension)
+		}
+		segment.archive = !strings.HasSuffix(path, ".bson")
+	}
+	segment.size = info.Size()
+
+	return segment, nil
+}
+
+// Open returns a stream of the raw oplog entries in the segment.
+func (segment *oplogSegment) Open() (io.ReadCloser, error) {
+	file, err := os.Open(segment.path)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted, err := encryption.Decrypt(file, segment.key)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading oplog segment %#q: %w", segment.path, err)
+	}
+	var in io.ReadCloser = &util.WrappedReadCloser{io.NopCloser(decrypted), file}
+	codec := segment.codec
+	if segment.archive {
+		buffered := bufio.NewReader(decrypted)
+		codec, err = compression.Detect(buffered)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		in = &util.WrappedReadCloser{io.NopCloser(buffered), file}
+	}
+	if codec != nil {
+		zrc, err := codec.NewReader(in)
+		if err != nil {
+			in.Close()
+			return nil, err
+		}
+		in = &util.WrappedReadCloser{zrc, in}
+	}
+
+	if !segment.archive {
+		return in, nil
+	}
+
+	prelude := &archive.Prelude{}
+	err = prelude.Read(in)
+	if err != nil {
+		in.Close()
+		return nil, fmt.Errorf("error reading archive %#q: %v", segment.path, err)
+	}
+
+	// The archive is parsed in the background, and the body of its oplog
+	// namespace is streamed through a pipe as it is found.
+	pr, pw := io.Pipe()
+	go func() {
+		defer in.Close()
+		parser := archive.Parser{In: in}
+		err := parser.ReadAllBlocks(&archiveOplogConsumer{out: pw})
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// Scan reads the whole segment to find the timestamps of its first and last entries.
+func (segment *oplogSegment) Scan() error {
+	in, err := segment.Open()
+	if err != nil {
+		return err
+	}
+	segment.first, segment.last, err = scanOplog(in, segment.path)
+	if err != nil {
+		return err
+	}
+	if segment.first.IsZero() {
+		return fmt.Errorf("oplog segment %#q is empty", segment.path)
+	}
+	return nil
+}
+
+// scanOplog reads the oplog entries of in to find the timestamps of the first
+// and last ones, which are zero if there are none. It closes in.
+func scanOplog(in io.ReadCloser, path string) (first, last bson.Timestamp, err error) {
+	bsonSource := db.NewBufferlessBSONSource(in)
+	bsonSource.SetMaxBSONSize(db.MaxBSONSize + 16*1024)
+	defer bsonSource.Close()
+
+	for {
+		rawOplogEntry := bsonSource.LoadNext()
+		if rawOplogEntry == nil {
+			break
+		}
+		t, i, ok := bson.Raw(rawOplogEntry).Lookup("ts").TimestampOK()
+		if !ok {
+			return first, last, fmt.Errorf("oplog entry in %#q has no timestamp", path)
+		}
+		last = bson.Timestamp{T: t, I: i}
+		if first.IsZero() {
+			first = last
+		}
+	}
+	if err := bsonSource.Err(); err != nil {
+		return first, last, fmt.Errorf("error reading oplog %#q: %v", path, err)
+	}
+	return first, last, nil
+}
+
+// checkOplogContinuity returns an error unless an oplog whose first entry is at
+// next picks up exactly where an oplog whose last entry is at prev left off.
+func checkOplogContinuity(prev, next bson.Timestamp, nextPath string) error {
+	switch {
+	case util.TimestampLessThan(prev, next):
+		return fmt.Errorf(
+			"gap in oplog chain: %#q starts at %v, but the previous oplog ends at %v",
+			nextPath, next, prev,
+		)
+	case util.TimestampGreaterThan(prev, next):
+		return fmt.Errorf(
+			"overlap in oplog chain: %#q starts at %v, before the previous oplog ends at %v",
+			nextPath, next, prev,
+		)
+	}
+	return nil
+}
+
+// loadOplogSegments scans every --oplogSegment and checks that each one picks up
+// exactly where the one before it ended, starting with the oplog of the dump
+// when it's read from a file. The oplog of an archive can only be checked
+// against the first segment once it has been replayed.
+func (restore *MongoRestore) loadOplogSegments() error {
+	restore.oplogSegments = nil
+	for _, path := range restore.InputOptions.OplogSegments {
+		segment, err := newOplogSegment(path, restore.codec, restore.encryptionKey)
+		if err != nil {
+			return fmt.Errorf("error opening oplog segment: %v", err)
+		}
+		log.Logvf(log.Always, "checking oplog segment %#q", segment.path)
+		err = segment.Scan()
+		if err != nil {
+			return err
+		}
+		log.Logvf(log.Info, "oplog segment %#q covers %v to %v",
+			segment.path, segment.first, segment.last)
+
+		if n := len(restore.oplogSegments); n > 0 {
+			err = checkOplogContinuity(restore.oplogSegments[n-1].last, segment.first, segment.path)
+			if err != nil {
+				return err
+			}
+		}
+		restore.oplogSegments = append(restore.oplogSegments, segment)
+	}
+	if len(restore.oplogSegments) == 0 {
+		return nil
+	}
+	return restore.checkDumpOplog(restore.oplogSegments[0])
+}
+
+// checkDumpOplog checks that segment picks up where the oplog of the dump ends,
+// so that a gap is found before anything is replayed. Only an oplog read from
+// a file is checked, since the oplog of an archive can't be read ahead of the
+// collections it comes after.
+func (restore *MongoRestore) checkDumpOplog(segment *oplogSegment) error {
+	intent := restore.manager.Oplog()
+	if intent == nil {
+		return nil
+	}
+	bsonFile, ok := intent.BSONFile.(*realBSONFile)
+	if !ok {
+		return nil
+	}
+	err := bsonFile.Open()
+	if err != nil {
+		return err
+	}
+	log.Logvf(log.Always, "checking oplog %#q", bsonFile.path)
+	_, last, err := scanOplog(bsonFile, bsonFile.path)
+	if err != nil || last.IsZero() {
+		return err
+	}
+	return checkOplogContinuity(last, segment.first, segment.path)
+}
+
+// archiveOplogConsumer is a ParserConsumer that writes the body of the oplog
+// namespace of an archive to out, and ignores every other namespace.
+type archiveOplogConsumer struct {
+	out       io.Writer
+	isOplog   bool
+	seenOplog bool
+}
+
+// HeaderBSON is part of the ParserConsumer interface.
+func (consumer *archiveOplogConsumer) HeaderBSON(buf []byte) error {
+	header := archive.NamespaceHeader{}
+	err := bson.Unmarshal(buf, &header)
+	if err != nil {
+		return fmt.Errorf("header bson doesn't unmarshal as a collection header: %v", err)
+	}
+	consumer.isOplog = header.Database == "" && header.Collection == "oplog" && !header.EOF
+	consumer.seenOplog = consumer.seenOplog || consumer.isOplog
+	return nil
+}
+
+// BodyBSON is part of the ParserConsumer interface.
+func (consumer *archiveOplogConsumer) BodyBSON(buf []byte) error {
+	if !consumer.isOplog {
+		return nil
+	}
+	_, err := consumer.out.Write(buf)
+	return err
+}
+
+// End is part of the ParserConsumer interface.
+func (consumer *archiveOplogConsumer) End() error {
+	if !consumer.seenOplog {
+		return fmt.Errorf("archive does not contain an oplog")
+	}
+	return nil
+}
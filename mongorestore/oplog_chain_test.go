@@ -0,0 +1,218 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/encryption"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func oplogSegmentEntries(timestamps ...uint32) []bson.D {
+	var entries []bson.D
+	for _, t := range timestamps {
+		entries = append(entries, bson.D{
+			{"ts", bson.Timestamp{T: t, I: 1}},
+			{"op", "n"},
+			{"ns", ""},
+			{"o", bson.D{{"msg", "noop"}}},
+		})
+	}
+	return entries
+}
+
+func writeOplogSegmentBSON(t *testing.T, path string, timestamps ...uint32) {
+	var content []byte
+	for _, entry := range oplogSegmentEntries(timestamps...) {
+		raw, err := bson.Marshal(entry)
+		require.NoError(t, err)
+		content = append(content, raw...)
+	}
+	require.NoError(t, os.WriteFile(path, content, 0o644))
+}
+
+func writeOplogSegmentArchive(t *testing.T, path string, timestamps ...uint32) {
+	simpleArchive := archive.SimpleArchive{
+		Namespaces: []archive.SimpleNamespace{
+			{
+				Database:   "test",
+				Collection: "foo",
+				Documents:  []bson.D{{{"_id", 1}}},
+			},
+			{
+				Collection: "oplog",
+				Documents:  oplogSegmentEntries(timestamps...),
+			},
+		},
+	}
+	content, err := simpleArchive.Marshal()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, content, 0o644))
+}
+
+func TestOplogSegmentScan(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	dir := t.TempDir()
+
+	t.Run("bson file", func(t *testing.T) {
+		path := filepath.Join(dir, "segment.bson")
+		writeOplogSegmentBSON(t, path, 10, 11, 12)
+
+		segment, err := newOplogSegment(path, nil, nil)
+		require.NoError(t, err)
+		assert.False(t, segment.archive)
+		require.NoError(t, segment.Scan())
+		assert.Equal(t, bson.Timestamp{T: 10, I: 1}, segment.first)
+		assert.Equal(t, bson.Timestamp{T: 12, I: 1}, segment.last)
+	})
+
+	t.Run("dump directory", func(t *testing.T) {
+		dumpDir := filepath.Join(dir, "dump")
+		require.NoError(t, os.Mkdir(dumpDir, 0o755))
+		writeOplogSegmentBSON(t, filepath.Join(dumpDir, "oplog.bson"), 20, 21)
+
+		segment, err := newOplogSegment(dumpDir, nil, nil)
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(dumpDir, "oplog.bson"), segment.path)
+		require.NoError(t, segment.Scan())
+		assert.Equal(t, bson.Timestamp{T: 20, I: 1}, segment.first)
+		assert.Equal(t, bson.Timestamp{T: 21, I: 1}, segment.last)
+	})
+
+	t.Run("archive", func(t *testing.T) {
+		path := filepath.Join(dir, "segment.archive")
+		writeOplogSegmentArchive(t, path, 30, 31, 32, 33)
+
+		segment, err := newOplogSegment(path, nil, nil)
+		require.NoError(t, err)
+		assert.True(t, segment.archive)
+		require.NoError(t, segment.Scan())
+		assert.Equal(t, bson.Timestamp{T: 30, I: 1}, segment.first)
+		assert.Equal(t, bson.Timestamp{T: 33, I: 1}, segment.last)
+	})
+
+	t.Run("compressed archive", func(t *testing.T) {
+		path := filepath.Join(dir, "plain.archive")
+		writeOplogSegmentArchive(t, path, 40, 41)
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		var compressed bytes.Buffer
+		writer := compression.Zstd.NewWriter(&compressed)
+		_, err = writer.Write(content)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+		path = filepath.Join(dir, "compressed.archive")
+		require.NoError(t, os.WriteFile(path, compressed.Bytes(), 0o644))
+
+		segment, err := newOplogSegment(path, nil, nil)
+		require.NoError(t, err)
+		require.NoError(t, segment.Scan())
+		assert.Equal(t, bson.Timestamp{T: 40, I: 1}, segment.first)
+		assert.Equal(t, bson.Timestamp{T: 41, I: 1}, segment.last)
+	})
+
+	t.Run("encrypted compressed archive", func(t *testing.T) {
+		path := filepath.Join(dir, "plain.archive")
+		writeOplogSegmentArchive(t, path, 50, 51)
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		key, err := encryption.NewKey(bytes.Repeat([]byte{1}, encryption.KeySize))
+		require.NoError(t, err)
+		var encrypted bytes.Buffer
+		encrypter := key.NewWriter(&encrypted)
+		compressor := compression.Gzip.NewWriter(encrypter)
+		_, err = compressor.Write(content)
+		require.NoError(t, err)
+		require.NoError(t, compressor.Close())
+		require.NoError(t, encrypter.Close())
+		path = filepath.Join(dir, "encrypted.archive")
+		require.NoError(t, os.WriteFile(path, encrypted.Bytes(), 0o644))
+
+		segment, err := newOplogSegment(path, nil, nil)
+		require.NoError(t, err)
+		require.ErrorIs(t, segment.Scan(), encryption.ErrKeyRequired)
+
+		segment, err = newOplogSegment(path, nil, key)
+		require.NoError(t, err)
+		require.NoError(t, segment.Scan())
+		assert.Equal(t, bson.Timestamp{T: 50, I: 1}, segment.first)
+		assert.Equal(t, bson.Timestamp{T: 51, I: 1}, segment.last)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		path := filepath.Join(dir, "empty.bson")
+		writeOplogSegmentBSON(t, path)
+
+		segment, err := newOplogSegment(path, nil, nil)
+		require.NoError(t, err)
+		assert.ErrorContains(t, segment.Scan(), "is empty")
+	})
+}
+
+func TestLoadOplogSegments(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.bson")
+	writeOplogSegmentBSON(t, first, 10, 11, 12)
+	chained := filepath.Join(dir, "chained.archive")
+	writeOplogSegmentArchive(t, chained, 12, 13, 14)
+	gap := filepath.Join(dir, "gap.bson")
+	writeOplogSegmentBSON(t, gap, 13, 14)
+	overlap := filepath.Join(dir, "overlap.bson")
+	writeOplogSegmentBSON(t, overlap, 11, 12, 13)
+
+	newRestore := func(paths ...string) *MongoRestore {
+		return &MongoRestore{
+			InputOptions: &InputOptions{OplogSegments: paths},
+			manager:      intents.NewIntentManager(),
+		}
+	}
+
+	restore := newRestore(first, chained)
+	require.NoError(t, restore.loadOplogSegments())
+	require.Len(t, restore.oplogSegments, 2)
+	assert.Equal(t, bson.Timestamp{T: 14, I: 1}, restore.oplogSegments[1].last)
+
+	restore = newRestore(first, gap)
+	assert.ErrorContains(t, restore.loadOplogSegments(), "gap in oplog chain")
+
+	restore = newRestore(first, overlap)
+	assert.ErrorContains(t, restore.loadOplogSegments(), "overlap in oplog chain")
+
+	// the oplog of the dump is checked against the first segment before it's replayed
+	dumpOplog := filepath.Join(dir, "oplog.bson")
+	writeOplogSegmentBSON(t, dumpOplog, 8, 9)
+	withDumpOplog := func(paths ...string) *MongoRestore {
+		restore := newRestore(paths...)
+		restore.InputOptions.OplogFile = dumpOplog
+		require.NoError(t, restore.CreateIntentForOplog())
+		return restore
+	}
+
+	restore = withDumpOplog(first, chained)
+	assert.ErrorContains(t, restore.loadOplogSegments(), "gap in oplog chain")
+
+	writeOplogSegmentBSON(t, dumpOplog, 9, 10)
+	restore = withDumpOplog(first, chained)
+	require.NoError(t, restore.loadOplogSegments())
+	require.NoError(t, restore.manager.Oplog().BSONFile.Open(), "the oplog can be read again")
+	require.NoError(t, restore.manager.Oplog().BSONFile.Close())
+}
@@ -10,7 +10,9 @@ import (
 	"fmt"
 	"path/filepath"
 
+	"github.com/mongodb/mongo-tools/common/compression"
 	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/encryption"
 	"github.com/mongodb/mongo-tools/common/log"
 	"github.com/mongodb/mongo-tools/common/options"
 )
@@ -23,6 +25,9 @@ Restore backups generated with mongodump to a running server.
 Specify a database with -d to restore a single database from the target directory,
 or use -d and -c to restore a single collection from a single .bson file.
 
+The change stream pre-images that mongodump --changeStreamPreImages dumps are not
+restored, since the server manages config.system.preimages itself.
+
 Connection strings must begin with mongodb:// or mongodb+srv://.
 
 See http://docs.mongodb.com/database-tools/mongorestore/ for more information.`
@@ -42,22 +47,36 @@ const (
 	OplogReplayOption            = "--oplogReplay"
 	OplogLimitOption             = "--oplogLimit"
 	OplogFileOption              = "--oplogFile"
+	OplogSegmentOption           = "--oplogSegment"
 	ArchiveOption                = "--archive" // Value is optional, so must use '=' if specifying one
 	RestoreDBUsersAndRolesOption = "--restoreDbUsersAndRoles"
+	UsersAndRolesIncludeOption   = "--usersAndRolesInclude"
+	UsersAndRolesExcludeOption   = "--usersAndRolesExclude"
+	SkipBuiltinRolesOption       = "--skipBuiltinRoles"
 	DirectoryOption              = "--dir"
 	GzipOption                   = "--gzip"
+	CompressOption               = "--compress"
 )
 
 // InputOptions defines the set of options to use in configuring the restore process.
 type InputOptions struct {
-	Objcheck               bool   `long:"objcheck" description:"validate all objects before inserting"`
-	OplogReplay            bool   `long:"oplogReplay" description:"for recovering a point-in-time snapshot on a replica set that is not part of a sharded cluster."`
-	OplogLimit             string `long:"oplogLimit" value-name:"<seconds>[:ordinal]" description:"only include oplog entries before the provided Timestamp"`
-	OplogFile              string `long:"oplogFile" value-name:"<filename>" description:"oplog file to use for replay of oplog"`
-	Archive                string `long:"archive" value-name:"<filename>" optional:"true" optional-value:"-" description:"restore dump from the specified archive file.  If flag is specified without a value, archive is read from stdin"`
-	RestoreDBUsersAndRoles bool   `long:"restoreDbUsersAndRoles" description:"restore user and role definitions for the given database"`
-	Directory              string `long:"dir" value-name:"<directory-name>" description:"input directory, use '-' for stdin"`
-	Gzip                   bool   `long:"gzip" description:"decompress gzipped input"`
+	Objcheck               bool     `long:"objcheck" description:"validate all objects before inserting"`
+	OplogReplay            bool     `long:"oplogReplay" description:"for recovering a point-in-time snapshot on a replica set that is not part of a sharded cluster."`
+	OplogLimit             string   `long:"oplogLimit" value-name:"<seconds>[:ordinal]" description:"only include oplog entries before the provided Timestamp"`
+	OplogFile              string   `long:"oplogFile" value-name:"<filename>" description:"oplog file to use for replay of oplog"`
+	OplogSegments          []string `long:"oplogSegment" value-name:"<path>" description:"dump directory, oplog file, or archive from an incremental mongodump to replay after the oplog being restored (may be specified multiple times, oldest first)"`
+	Archive                string   `long:"archive" value-name:"<filename>" optional:"true" optional-value:"-" description:"restore dump from the specified archive file or s3://<bucket>/<key> URL of object storage.  If flag is specified without a value, archive is read from stdin"`
+	RestoreDBUsersAndRoles bool     `long:"restoreDbUsersAndRoles" description:"restore user and role definitions for the given database"`
+	UsersAndRolesInclude   []string `long:"usersAndRolesInclude" value-name:"<db.name-pattern>" description:"only restore users and roles whose database and name, joined by a dot, match the pattern (may be specified multiple times)"`
+	UsersAndRolesExclude   []string `long:"usersAndRolesExclude" value-name:"<db.name-pattern>" description:"don't restore users and roles whose database and name, joined by a dot, match the pattern (may be specified multiple times)"`
+	SkipBuiltinRoles       bool     `long:"skipBuiltinRoles" description:"don't restore roles of the dump named like built-in roles, which dumps of some managed services contain"`
+	Directory              string   `long:"dir" value-name:"<directory-name>" description:"input directory or s3://<bucket>/<prefix> URL of object storage, use '-' for stdin"`
+	Gzip                   bool     `long:"gzip" description:"decompress gzipped input"`
+	Compress               string   `long:"compress" value-name:"<codec>" description:"decompress input with the given codec: gzip, zstd, snappy, or none. By default the codec of an archive or dump directory is detected"`
+	EncryptionKeyFile      string   `long:"encryptionKeyFile" value-name:"<file-path>" description:"decrypt input with the 32 byte key in the given file, stored raw or base64 encoded"`
+	EncryptionKeyEnv       string   `long:"encryptionKeyEnv" value-name:"<variable>" description:"decrypt input with the base64 encoded 32 byte key in the given environment variable"`
+	ManifestCheck          string   `long:"manifestCheck" value-name:"<mode>" default:"error" default-mask:"-" description:"what to do when files of a dump directory are missing or don't match the manifest written by mongodump: error, warn, or skip to not check the manifest (defaults to 'error')"`
+	VerifyOnly             bool     `long:"verifyOnly" description:"check the dump or archive for corruption without connecting to a server, and print a JSON report of any problems found"`
 }
 
 // Name returns a human-readable group name for input options.
@@ -65,48 +84,99 @@ func (*InputOptions) Name() string {
 	return "input"
 }
 
+// Codec returns the compression codec selected with --gzip or --compress, or nil
+// if the input is not compressed or the codec should be detected.
+func (inputOptions *InputOptions) Codec() (*compression.Codec, error) {
+	if inputOptions.Gzip {
+		if inputOptions.Compress != "" && inputOptions.Compress != compression.Gzip.Name {
+			return nil, fmt.Errorf(
+				"%v can't be used with %v=%v", GzipOption, CompressOption, inputOptions.Compress,
+			)
+		}
+		return compression.Gzip, nil
+	}
+	return compression.ByName(inputOptions.Compress)
+}
+
+// CodecSelected returns whether the codec was chosen explicitly rather than
+// being left for mongorestore to detect.
+func (inputOptions *InputOptions) CodecSelected() bool {
+	return inputOptions.Gzip || inputOptions.Compress != ""
+}
+
+// EncryptionKey returns the key selected with --encryptionKeyFile or
+// --encryptionKeyEnv, or nil if the input is not encrypted.
+func (inputOptions *InputOptions) EncryptionKey() (*encryption.Key, error) {
+	return encryption.LoadKey(inputOptions.EncryptionKeyFile, inputOptions.EncryptionKeyEnv)
+}
+
 // OutputOptions command line argument long names.
 const (
-	DropOption                     = "--drop"
-	DryRunOption                   = "--dryRun"
-	WriteConcernOption             = "--writeConcern"
-	NoIndexRestoreOption           = "--noIndexRestore"
-	ConvertLegacyIndexesOption     = "--convertLegacyIndexes"
-	NoOptionsRestoreOption         = "--noOptionsRestore"
-	KeepIndexVersionOption         = "--keepIndexVersion"
-	MaintainInsertionOrderOption   = "--maintainInsertionOrder"
-	NumParallelCollectionsOption   = "--numParallelCollections"
-	NumInsertionWorkersOption      = "--numInsertionWorkersPerCollection"
-	StopOnErrorOption              = "--stopOnError"
-	BypassDocumentValidationOption = "--bypassDocumentValidation"
-	PreserveUUIDOption             = "--preserveUUID"
-	TempUsersCollOption            = "--tempUsersColl"
-	TempRolesCollOption            = "--tempRolesColl"
-	BulkBufferSizeOption           = "--batchSize"
-	FixDottedHashedIndexesOption   = "--fixDottedHashIndex"
+	DropOption                        = "--drop"
+	DryRunOption                      = "--dryRun"
+	DryRunFormatOption                = "--dryRunFormat"
+	WriteConcernOption                = "--writeConcern"
+	NoIndexRestoreOption              = "--noIndexRestore"
+	ConvertLegacyIndexesOption        = "--convertLegacyIndexes"
+	NoOptionsRestoreOption            = "--noOptionsRestore"
+	KeepIndexVersionOption            = "--keepIndexVersion"
+	MaintainInsertionOrderOption      = "--maintainInsertionOrder"
+	NumParallelCollectionsOption      = "--numParallelCollections"
+	NumInsertionWorkersOption         = "--numInsertionWorkersPerCollection"
+	NumReadersOption                  = "--numReadersPerCollection"
+	StopOnErrorOption                 = "--stopOnError"
+	BypassDocumentValidationOption    = "--bypassDocumentValidation"
+	PreserveUUIDOption                = "--preserveUUID"
+	TempUsersCollOption               = "--tempUsersColl"
+	TempRolesCollOption               = "--tempRolesColl"
+	BulkBufferSizeOption              = "--batchSize"
+	FixDottedHashedIndexesOption      = "--fixDottedHashIndex"
+	ResumeOption                      = "--resume"
+	ShardCollectionsOption            = "--shardCollections"
+	TransformRulesOption              = "--transformRules"
+	IndexBuildStrategyOption          = "--indexBuildStrategy"
+	IndexBeforeDataMaxSizeOption      = "--indexBeforeDataMaxSize"
+	NumParallelIndexBuildsOption      = "--numParallelIndexBuilds"
+	IndexFailureReportOption          = "--indexFailureReport"
+	ModeOption                        = "--mode"
+	TimestampFieldOption              = "--timestampField"
+	UsersAndRolesConflictReportOption = "--usersAndRolesConflictReport"
 )
 
 // OutputOptions defines the set of options for restoring dump data.
 type OutputOptions struct {
 	Drop   bool `long:"drop" description:"drop each collection before import"`
-	DryRun bool `long:"dryRun" description:"view summary without importing anything. recommended with verbosity"`
+	DryRun bool `long:"dryRun" description:"print the plan of the restore without changing anything on the target, which is only read from"`
+	// Format of the plan printed by --dryRun, text when not given.
+	DryRunFormat string `long:"dryRunFormat" value-name:"<format>" description:"format of the plan printed by --dryRun: text or json (default: text)"`
 
 	// By default mongorestore uses a write concern of 'majority'.
-	WriteConcern             string `long:"writeConcern" value-name:"<write-concern>" default-mask:"-" description:"write concern options e.g. --writeConcern majority, --writeConcern '{w: 3, wtimeout: 500, fsync: true, j: true}'"`
-	NoIndexRestore           bool   `long:"noIndexRestore" description:"don't restore indexes"`
-	ConvertLegacyIndexes     bool   `long:"convertLegacyIndexes" description:"Removes invalid index options and rewrites legacy option values (e.g. true becomes 1)."`
-	NoOptionsRestore         bool   `long:"noOptionsRestore" description:"don't restore collection options"`
-	KeepIndexVersion         bool   `long:"keepIndexVersion" description:"don't update index version"`
-	MaintainInsertionOrder   bool   `long:"maintainInsertionOrder" description:"restore the documents in the order of their appearance in the input source. By default the insertions will be performed in an arbitrary order. Setting this flag also enables the behavior of --stopOnError and restricts NumInsertionWorkersPerCollection to 1."`
-	NumParallelCollections   int    `long:"numParallelCollections" short:"j" description:"number of collections to restore in parallel" default:"4" default-mask:"-"`
-	NumInsertionWorkers      int    `long:"numInsertionWorkersPerCollection" description:"number of insert operations to run concurrently per collection" default:"1" default-mask:"-"`
-	StopOnError              bool   `long:"stopOnError" description:"halt after encountering any error during insertion. By default, mongorestore will attempt to continue through document validation and DuplicateKey errors, but with this option enabled, the tool will stop instead. A small number of documents may be inserted after encountering an error even with this option enabled; use --maintainInsertionOrder to halt immediately after an error"`
-	BypassDocumentValidation bool   `long:"bypassDocumentValidation" description:"bypass document validation"`
-	PreserveUUID             bool   `long:"preserveUUID" description:"preserve original collection UUIDs (off by default, requires drop)"`
-	TempUsersColl            string `long:"tempUsersColl" default:"tempusers" hidden:"true"`
-	TempRolesColl            string `long:"tempRolesColl" default:"temproles" hidden:"true"`
-	BulkBufferSize           int    `long:"batchSize" default:"1000" hidden:"true"`
-	FixDottedHashedIndexes   bool   `long:"fixDottedHashIndex" description:"when enabled, all the hashed indexes on dotted fields will be created as single field ascending indexes on the destination"`
+	WriteConcern                string `long:"writeConcern" value-name:"<write-concern>" default-mask:"-" description:"write concern options e.g. --writeConcern majority, --writeConcern '{w: 3, wtimeout: 500, fsync: true, j: true}'"`
+	NoIndexRestore              bool   `long:"noIndexRestore" description:"don't restore indexes"`
+	ConvertLegacyIndexes        bool   `long:"convertLegacyIndexes" description:"Removes invalid index options and rewrites legacy option values (e.g. true becomes 1)."`
+	NoOptionsRestore            bool   `long:"noOptionsRestore" description:"don't restore collection options"`
+	KeepIndexVersion            bool   `long:"keepIndexVersion" description:"don't update index version"`
+	MaintainInsertionOrder      bool   `long:"maintainInsertionOrder" description:"restore the documents in the order of their appearance in the input source. By default the insertions will be performed in an arbitrary order. Setting this flag also enables the behavior of --stopOnError and restricts NumInsertionWorkersPerCollection to 1."`
+	NumParallelCollections      int    `long:"numParallelCollections" short:"j" description:"number of collections to restore in parallel" default:"4" default-mask:"-"`
+	NumInsertionWorkers         int    `long:"numInsertionWorkersPerCollection" description:"number of insert operations to run concurrently per collection" default:"1" default-mask:"-"`
+	NumReaders                  int    `long:"numReadersPerCollection" description:"number of byte ranges to split each uncompressed, unencrypted BSON file of a dump directory into, each read by its own reader with its own insertion workers" default:"1" default-mask:"-"`
+	StopOnError                 bool   `long:"stopOnError" description:"halt after encountering any error during insertion. By default, mongorestore will attempt to continue through document validation and DuplicateKey errors, but with this option enabled, the tool will stop instead. A small number of documents may be inserted after encountering an error even with this option enabled; use --maintainInsertionOrder to halt immediately after an error"`
+	BypassDocumentValidation    bool   `long:"bypassDocumentValidation" description:"bypass document validation"`
+	PreserveUUID                bool   `long:"preserveUUID" description:"preserve original collection UUIDs (off by default, requires drop)"`
+	TempUsersColl               string `long:"tempUsersColl" default:"tempusers" hidden:"true"`
+	TempRolesColl               string `long:"tempRolesColl" default:"temproles" hidden:"true"`
+	UsersAndRolesConflictReport string `long:"usersAndRolesConflictReport" value-name:"<filename>" description:"leave users and roles that already exist on the target as they are, rather than merging those of the dump into them, and write them as JSON to the given file"`
+	BulkBufferSize              int    `long:"batchSize" default:"1000" hidden:"true"`
+	FixDottedHashedIndexes      bool   `long:"fixDottedHashIndex" description:"when enabled, all the hashed indexes on dotted fields will be created as single field ascending indexes on the destination"`
+	ShardCollections            bool   `long:"shardCollections" description:"when restoring a dump of a sharded cluster through mongos, shard each collection that was sharded with its original shard key, then split it and move its chunks to match the dumped config.chunks before restoring its documents. Shards of the dump missing from the cluster are spread over its shards. The config database itself isn't restored"`
+	TransformRules              string `long:"transformRules" value-name:"<filename>" description:"JSON file of per-namespace rules that set, unset, hash, fake or rename fields of documents as they're restored, including documents of a replayed oplog, e.g. to mask personal data"`
+	IndexBuildStrategy          string `long:"indexBuildStrategy" value-name:"<strategy>" description:"when to build the indexes of each collection: after its documents are restored, before them, or auto, which builds them before for collections whose dump file is smaller than --indexBeforeDataMaxSize and after for the rest (default: after)"`
+	IndexBeforeDataMaxSize      int64  `long:"indexBeforeDataMaxSize" value-name:"<bytes>" default:"16777216" default-mask:"-" description:"size of the dump file of a collection below which --indexBuildStrategy auto builds its indexes before its documents (default: 16MB)"`
+	NumParallelIndexBuilds      int    `long:"numParallelIndexBuilds" description:"number of collections to build indexes of in parallel after their documents are restored (default: --numParallelCollections)"`
+	IndexFailureReport          string `long:"indexFailureReport" value-name:"<filename>" description:"write the indexes that fail to build, with the reason, as JSON to the given file and finish the restore, rather than stopping at the first failure"`
+	Mode                        string `long:"mode" choice:"insert" choice:"upsert" choice:"merge" choice:"skip-newer" description:"what to do with documents whose _id is already in the collection. insert: leave them, failing to insert the documents of the dump. upsert: replace them. merge: set the fields of the documents of the dump in them. skip-newer: replace them unless their --timestampField is newer (default: insert)"`
+	TimestampField              string `long:"timestampField" value-name:"<field>" description:"field, which may be dotted, that --mode skip-newer compares to keep documents of the target that are newer than those of the dump"`
+	Resume                      string `long:"resume" value-name:"<journal-file>" description:"record the progress of the restore in the given journal file; if the file exists, resume the restore it records, skipping collections, documents and indexes that were already restored"`
 }
 
 // Name returns a human-readable group name for output options.
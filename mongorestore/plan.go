@@ -0,0 +1,385 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/dumprestore"
+	"github.com/mongodb/mongo-tools/common/idx"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/text"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Values of --dryRunFormat.
+const (
+	DryRunFormatText = "text"
+	DryRunFormatJSON = "json"
+)
+
+// restorePlan is what a restore would do, which --dryRun prints.
+type restorePlan struct {
+	Collections   []*collectionPlan `json:"collections"`
+	UsersAndRoles bool              `json:"usersAndRoles"`
+	Oplog         *oplogPlan        `json:"oplog,omitempty"`
+}
+
+// collectionPlan is what a restore would do with a collection of the dump.
+// Options and indexes are Extended JSON, and Documents is only known when the
+// dump has a manifest.
+type collectionPlan struct {
+	Source     string            `json:"source"`
+	Target     string            `json:"target"`
+	Type       string            `json:"type"`
+	Exists     bool              `json:"exists"`
+	Drop       bool              `json:"drop"`
+	Create     bool              `json:"create"`
+	Options    json.RawMessage   `json:"options,omitempty"`
+	Indexes    []json.RawMessage `json:"indexes,omitempty"`
+	Documents  *int64            `json:"documents,omitempty"`
+	Bytes      int64             `json:"bytes"`
+	Collisions []string          `json:"collisions,omitempty"`
+}
+
+// oplogPlan is the range of the oplog that a restore would replay. The range
+// of an oplog read from an archive is only known when it's replayed.
+type oplogPlan struct {
+	Location string          `json:"location"`
+	Start    *bson.Timestamp `json:"start,omitempty"`
+	End      *bson.Timestamp `json:"end,omitempty"`
+	Limit    *bson.Timestamp `json:"limit,omitempty"`
+	Segments []string        `json:"segments,omitempty"`
+}
+
+// printPlan works out what the restore would do and prints it to the
+// PlanWriter in the --dryRun format. The target is only read from.
+func (restore *MongoRestore) printPlan(target archive.DirLike) error {
+	plan, err := restore.buildPlan(target)
+	if err != nil {
+		return fmt.Errorf("error planning restore: %v", err)
+	}
+	if restore.OutputOptions.DryRunFormat == DryRunFormatJSON {
+		content, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error marshaling plan: %v", err)
+		}
+		_, err = restore.PlanWriter.Write(append(content, '\n'))
+		return err
+	}
+	return plan.writeText(restore.PlanWriter)
+}
+
+// buildPlan works out what the restore would do from its intents, the
+// metadata of the dump and the collections that exist on the target.
+func (restore *MongoRestore) buildPlan(target archive.DirLike) (*restorePlan, error) {
+	if restore.InputOptions.Archive == "" {
+		err := restore.LoadIndexesFromBSON()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := restore.PopulateMetadataForIntents()
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest *dumprestore.Manifest
+	if target != nil && restore.InputOptions.Archive == "" {
+		manifest, _, err = restore.readManifest(target)
+		if err != nil {
+			return nil, err
+		}
+	}
+	documents := map[string]int64{}
+	if manifest != nil {
+		for _, file := range manifest.Files {
+			if file.Type == dumprestore.ManifestBSONFile {
+				documents[file.Namespace] += file.Documents
+			}
+		}
+	}
+
+	plan := &restorePlan{
+		Collections:   []*collectionPlan{},
+		UsersAndRoles: restore.ShouldRestoreUsersAndRoles(),
+	}
+	for _, intent := range restore.manager.NormalIntents() {
+		collection, err := restore.planCollection(intent)
+		if err != nil {
+			return nil, err
+		}
+		if count, ok := documents[collection.Source]; ok {
+			collection.Documents = &count
+		}
+		plan.Collections = append(plan.Collections, collection)
+	}
+	sort.Slice(plan.Collections, func(i, j int) bool {
+		return plan.Collections[i].Target < plan.Collections[j].Target
+	})
+
+	plan.Oplog, err = restore.planOplog(manifest)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// planCollection works out what the restore would do with the collection of
+// intent, and how it collides with a collection of the same name on the target.
+func (restore *MongoRestore) planCollection(intent *intents.Intent) (*collectionPlan, error) {
+	collection := &collectionPlan{
+		Source: restore.manager.SourceNamespace(intent),
+		Target: intent.Namespace(),
+		Type:   intent.Type,
+		Bytes:  intent.Size,
+	}
+	if collection.Type == "" {
+		collection.Type = "collection"
+	}
+
+	session, err := restore.SessionProvider.GetSession()
+	if err != nil {
+		return nil, fmt.Errorf("error establishing connection: %v", err)
+	}
+	existing, err := db.GetCollectionInfo(session.Database(intent.DB).Collection(intent.C))
+	if err != nil {
+		return nil, fmt.Errorf("error reading collection %#q: %v", intent.Namespace(), err)
+	}
+	collection.Exists = existing != nil
+	collection.Drop = collection.Exists && restore.OutputOptions.Drop &&
+		!existing.IsSystemCollection()
+	collection.Create = !collection.Exists || collection.Drop
+
+	options := intent.Options
+	if restore.OutputOptions.NoOptionsRestore {
+		options = nil
+	}
+	if len(options) > 0 {
+		collection.Options, err = bson.MarshalExtJSON(options, false, false)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling options of %#q: %v", intent.Namespace(), err)
+		}
+	}
+
+	if !intent.IsView() {
+		indexes, err := restore.indexesToRestore(intent.DB, intent.C)
+		if err != nil {
+			return nil, err
+		}
+		for _, index := range indexes {
+			spec, err := bson.MarshalExtJSON(indexSpec(index), false, false)
+			if err != nil {
+				return nil, fmt.Errorf("error marshaling index of %#q: %v", intent.Namespace(), err)
+			}
+			collection.Indexes = append(collection.Indexes, spec)
+		}
+	}
+
+	if existing != nil && !collection.Drop {
+		collection.Collisions = collisions(intent, existing, options, restore.OutputOptions.Drop)
+	}
+	return collection, nil
+}
+
+// collisions describes how restoring intent without dropping would collide with
+// the existing collection of the same name.
+func collisions(
+	intent *intents.Intent,
+	existing *db.CollectionInfo,
+	options bson.D,
+	drop bool,
+) []string {
+	var found []string
+	if drop && existing.IsSystemCollection() {
+		found = append(found, "system collections are not dropped")
+	}
+	existingType := existing.Type
+	if existingType == "" {
+		existingType = "collection"
+	}
+	switch {
+	case intent.IsView() != existing.IsView(), intent.IsTimeseries() != existing.IsTimeseries():
+		found = append(found, fmt.Sprintf("exists on the target as a %v", existingType))
+	case intent.IsView():
+		found = append(found, "the view exists on the target")
+	default:
+		found = append(found, "documents would be inserted into the existing collection")
+	}
+	if len(options) > 0 && !sameOptions(options, existing.Options) {
+		found = append(found, "exists on the target with different options")
+	}
+	return found
+}
+
+// sameOptions returns whether two sets of collection options are the same,
+// whatever order they are in.
+func sameOptions(a, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sorted := func(options bson.D) bson.D {
+		options = slices.Clone(options)
+		slices.SortFunc(options, func(x, y bson.E) int { return strings.Compare(x.Key, y.Key) })
+		return options
+	}
+	rawA, errA := bson.Marshal(sorted(a))
+	rawB, errB := bson.Marshal(sorted(b))
+	return errA == nil && errB == nil && bytes.Equal(rawA, rawB)
+}
+
+// indexSpec returns an index as a document in the order that's easiest to read,
+// with the key first and then the options sorted by name.
+func indexSpec(index *idx.IndexDocument) bson.D {
+	spec := bson.D{{"key", index.Key}}
+	names := make([]string, 0, len(index.Options))
+	for name := range index.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		spec = append(spec, bson.E{name, index.Options[name]})
+	}
+	if index.PartialFilterExpression != nil {
+		spec = append(spec, bson.E{"partialFilterExpression", *index.PartialFilterExpression})
+	}
+	return spec
+}
+
+// planOplog returns the range of the oplog that the restore would replay, or
+// nil if it would replay none. The range is that of the manifest, or else
+// that of the oplog file.
+func (restore *MongoRestore) planOplog(manifest *dumprestore.Manifest) (*oplogPlan, error) {
+	intent := restore.manager.Oplog()
+	if !restore.InputOptions.OplogReplay || intent == nil {
+		return nil, nil
+	}
+
+	plan := &oplogPlan{Location: intent.Location}
+	switch {
+	case restore.InputOptions.OplogFile == "" && manifest != nil && manifest.OplogStart != nil:
+		plan.Start = manifest.OplogStart
+		plan.End = manifest.OplogEnd
+	case restore.InputOptions.Archive == "" || restore.InputOptions.OplogFile != "":
+		first, last, err := scanOplogRange(intent)
+		if err != nil {
+			return nil, err
+		}
+		if !first.IsZero() {
+			plan.Start, plan.End = &first, &last
+		}
+	}
+
+	for _, segment := range restore.oplogSegments {
+		plan.Segments = append(plan.Segments, segment.path)
+		last := segment.last
+		plan.End = &last
+	}
+	if !restore.oplogLimit.IsZero() {
+		limit := restore.oplogLimit
+		plan.Limit = &limit
+	}
+	return plan, nil
+}
+
+// scanOplogRange reads the whole oplog of intent to find the timestamps of its
+// first and last entries, which are zero if it's empty.
+func scanOplogRange(intent *intents.Intent) (first, last bson.Timestamp, err error) {
+	err = intent.BSONFile.Open()
+	if err != nil {
+		return first, last, err
+	}
+	defer intent.BSONFile.Close()
+
+	bsonSource := db.NewBufferlessBSONSource(intent.BSONFile)
+	bsonSource.SetMaxBSONSize(db.MaxBSONSize + 16*1024)
+	for {
+		rawOplogEntry := bsonSource.LoadNext()
+		if rawOplogEntry == nil {
+			break
+		}
+		t, i, ok := bson.Raw(rawOplogEntry).Lookup("ts").TimestampOK()
+		if !ok {
+			return first, last, fmt.Errorf("oplog entry in %#q has no timestamp", intent.Location)
+		}
+		last = bson.Timestamp{T: t, I: i}
+		if first.IsZero() {
+			first = last
+		}
+	}
+	if err := bsonSource.Err(); err != nil {
+		return first, last, fmt.Errorf("error reading oplog %#q: %v", intent.Location, err)
+	}
+	return first, last, nil
+}
+
+// writeText writes the plan for people to read.
+func (plan *restorePlan) writeText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "restore plan: %v %v\n",
+		len(plan.Collections), util.Pluralize(len(plan.Collections), "collection", "collections"))
+	for _, collection := range plan.Collections {
+		if collection.Source == collection.Target {
+			fmt.Fprintf(&b, "%v (%v)\n", collection.Target, collection.Type)
+		} else {
+			fmt.Fprintf(&b, "%v -> %v (%v)\n", collection.Source, collection.Target, collection.Type)
+		}
+		switch {
+		case collection.Drop:
+			b.WriteString("    drop the existing collection and create it\n")
+		case collection.Create:
+			b.WriteString("    create the collection\n")
+		default:
+			b.WriteString("    restore into the existing collection\n")
+		}
+		if collection.Options != nil {
+			fmt.Fprintf(&b, "    options: %s\n", collection.Options)
+		}
+		if collection.Type != "view" {
+			documents := "unknown"
+			if collection.Documents != nil {
+				documents = fmt.Sprint(*collection.Documents)
+			}
+			fmt.Fprintf(&b, "    documents: %v (%v)\n",
+				documents, text.FormatByteAmount(collection.Bytes))
+		}
+		for _, index := range collection.Indexes {
+			fmt.Fprintf(&b, "    index: %s\n", index)
+		}
+		for _, collision := range collection.Collisions {
+			fmt.Fprintf(&b, "    collision: %v\n", collision)
+		}
+	}
+	if plan.UsersAndRoles {
+		b.WriteString("users and roles: restore\n")
+	}
+	if plan.Oplog != nil {
+		fmt.Fprintf(&b, "oplog: replay %v\n", plan.Oplog.Location)
+		for _, segment := range plan.Oplog.Segments {
+			fmt.Fprintf(&b, "    then segment %v\n", segment)
+		}
+		if plan.Oplog.Start != nil {
+			fmt.Fprintf(&b, "    from %v to %v\n", *plan.Oplog.Start, *plan.Oplog.End)
+		} else {
+			b.WriteString("    range unknown until the archive is read\n")
+		}
+		if plan.Oplog.Limit != nil {
+			fmt.Fprintf(&b, "    stopping before %v\n", *plan.Oplog.Limit)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
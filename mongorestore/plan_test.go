@@ -0,0 +1,127 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/idx"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestRestorePlan(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	filter := bson.D{{"a", bson.D{{"$gt", 1}}}}
+	spec, err := bson.MarshalExtJSON(indexSpec(&idx.IndexDocument{
+		Options:                 bson.M{"v": 2, "name": "a_1", "unique": true},
+		Key:                     bson.D{{"a", 1}},
+		PartialFilterExpression: &filter,
+	}), false, false)
+	require.NoError(t, err)
+	assert.Equal(t,
+		`{"key":{"a":1},"name":"a_1","unique":true,"v":2,"partialFilterExpression":{"a":{"$gt":1}}}`,
+		string(spec),
+		"the key comes first and then the sorted options",
+	)
+
+	documents := int64(3)
+	start, end := bson.Timestamp{T: 100, I: 1}, bson.Timestamp{T: 200, I: 4}
+	plan := &restorePlan{
+		Collections: []*collectionPlan{
+			{
+				Source:    "db.c",
+				Target:    "other.c",
+				Type:      "collection",
+				Create:    true,
+				Options:   json.RawMessage(`{"capped":true}`),
+				Indexes:   []json.RawMessage{spec},
+				Documents: &documents,
+				Bytes:     2048,
+			},
+			{
+				Source:     "db.v",
+				Target:     "db.v",
+				Type:       "view",
+				Exists:     true,
+				Collisions: []string{"the view exists on the target"},
+			},
+		},
+		Oplog: &oplogPlan{Location: "dump/oplog.bson", Start: &start, End: &end},
+	}
+
+	var text strings.Builder
+	require.NoError(t, plan.writeText(&text))
+	assert.Equal(t, strings.Join([]string{
+		"restore plan: 2 collections",
+		"db.c -> other.c (collection)",
+		"    create the collection",
+		`    options: {"capped":true}`,
+		"    documents: 3 (2.00KB)",
+		"    index: " + string(spec),
+		"db.v (view)",
+		"    restore into the existing collection",
+		"    collision: the view exists on the target",
+		"oplog: replay dump/oplog.bson",
+		"    from {100 1} to {200 4}",
+		"",
+	}, "\n"), text.String())
+
+	content, err := json.Marshal(plan)
+	require.NoError(t, err)
+	var decoded map[string]any
+	require.NoError(t, json.Unmarshal(content, &decoded))
+	collection := decoded["collections"].([]any)[0].(map[string]any)
+	assert.Equal(t, "other.c", collection["target"])
+	assert.Equal(t, map[string]any{"capped": true}, collection["options"])
+	assert.EqualValues(t, 3, collection["documents"])
+	assert.NotContains(t, decoded["collections"].([]any)[1], "documents", "views have no count")
+	assert.Equal(t, "dump/oplog.bson", decoded["oplog"].(map[string]any)["location"])
+}
+
+func TestRestorePlanCollisions(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	collection := &intents.Intent{DB: "db", C: "c", Options: bson.D{{"capped", true}, {"size", 10}}}
+	existing := &db.CollectionInfo{Name: "c", Options: bson.D{{"size", 10}, {"capped", true}}}
+	assert.Equal(t,
+		[]string{"documents would be inserted into the existing collection"},
+		collisions(collection, existing, collection.Options, false),
+		"options in another order are the same",
+	)
+
+	existing.Options = bson.D{{"capped", true}, {"size", 20}}
+	assert.Equal(t,
+		[]string{
+			"documents would be inserted into the existing collection",
+			"exists on the target with different options",
+		},
+		collisions(collection, existing, collection.Options, false),
+	)
+
+	view := &intents.Intent{DB: "db", C: "c", Type: "view"}
+	assert.Equal(t,
+		[]string{"exists on the target as a collection"},
+		collisions(view, &db.CollectionInfo{Name: "c"}, nil, false),
+	)
+
+	systemJS := &intents.Intent{DB: "db", C: "system.js"}
+	assert.Equal(t,
+		[]string{
+			"system collections are not dropped",
+			"documents would be inserted into the existing collection",
+		},
+		collisions(systemJS, &db.CollectionInfo{Name: "system.js"}, nil, true),
+	)
+}
@@ -0,0 +1,17 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import "github.com/mongodb/mongo-tools/common/dumprestore"
+
+// skipsPreImages returns whether a collection of the dump is the pre-images
+// collection that mongodump --changeStreamPreImages dumps, which is never
+// restored. The server manages that collection itself and doesn't accept
+// writes to it, so its dump is only kept for inspection.
+func skipsPreImages(dbName, collName string) bool {
+	return dbName == "config" && collName == dumprestore.PreImagesCollection
+}
@@ -0,0 +1,52 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestPreImagesAreNotRestored(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	dir := t.TempDir()
+	doc, err := bson.Marshal(bson.D{{"_id", "shard0"}})
+	require.NoError(t, err)
+	for _, name := range []string{"settings.bson", "system.preimages.bson"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), doc, 0o644))
+	}
+	for _, name := range []string{"settings.metadata.json", "system.preimages.metadata.json"} {
+		require.NoError(t, os.WriteFile(
+			filepath.Join(dir, name),
+			[]byte(`{"options": {"clusteredIndex": true}, "indexes": [], "uuid": ""}`),
+			0o644,
+		))
+	}
+
+	mr := newMongoRestore()
+	ddl, err := newActualPath(dir)
+	require.NoError(t, err)
+	require.NoError(t, mr.CreateIntentsForDB("config", ddl))
+	mr.manager.Finalize(intents.Legacy)
+
+	intent := mr.manager.Pop()
+	require.NotNil(t, intent)
+	assert.Equal(t, "config.settings", intent.Namespace())
+	assert.NotNil(t, intent.MetadataFile)
+	assert.Nil(t, mr.manager.Pop(), "the pre-images are skipped, so their collection isn't created")
+
+	assert.True(t, skipsPreImages("config", "system.preimages"))
+	assert.False(t, skipsPreImages("app", "system.preimages"))
+}
@@ -18,6 +18,7 @@ import (
 
 	"github.com/mongodb/mongo-tools/common/bsonutil"
 	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/dumprestore"
 	"github.com/mongodb/mongo-tools/common/idx"
 	"github.com/mongodb/mongo-tools/common/intents"
 	"github.com/mongodb/mongo-tools/common/log"
@@ -67,7 +68,8 @@ func NewResultFromBulkResult(result *mongo.BulkWriteResult, err error) Result {
 		return Result{}
 	}
 
-	nSuccess := result.InsertedCount
+	// documents written by --mode upsert, merge or skip-newer are upserted or matched
+	nSuccess := result.InsertedCount + result.UpsertedCount + result.MatchedCount
 	var nFailure int64
 
 	// if a write concern error is encountered, the failure count may be inaccurate.
@@ -82,16 +84,16 @@ func (restore *MongoRestore) RestoreIndexes() error {
 	log.Logvf(
 		log.DebugLow,
 		"building indexes up to %v collections in parallel",
-		restore.OutputOptions.NumParallelCollections,
+		restore.numParallelIndexBuilds(),
 	)
 
 	namespaceQueue := restore.indexCatalog.Queue()
 
-	if restore.OutputOptions.NumParallelCollections > 0 {
+	if restore.numParallelIndexBuilds() > 0 {
 		errChan := make(chan error)
 
 		// start a goroutine for each job thread
-		for i := 0; i < restore.OutputOptions.NumParallelCollections; i++ {
+		for i := 0; i < restore.numParallelIndexBuilds(); i++ {
 			go func(id int) {
 				log.Logvf(log.DebugHigh, "starting index build routine with id=%v", id)
 				for {
@@ -115,7 +117,7 @@ func (restore *MongoRestore) RestoreIndexes() error {
 		}
 
 		// wait until all goroutines are done or one of them errors out
-		for i := 0; i < restore.OutputOptions.NumParallelCollections; i++ {
+		for i := 0; i < restore.numParallelIndexBuilds(); i++ {
 			err := <-errChan
 			if err != nil {
 				// Return first error we encounter
@@ -141,7 +143,34 @@ func (restore *MongoRestore) RestoreIndexes() error {
 
 func (restore *MongoRestore) RestoreIndexesForNamespace(namespace *options.Namespace) error {
 	namespaceString := fmt.Sprintf("%s.%s", namespace.DB, namespace.Collection)
-	indexesFull := restore.indexCatalog.GetIndexes(namespace.DB, namespace.Collection)
+	if restore.journal != nil {
+		if progress, _ := restore.journal.namespace(namespaceString); progress.IndexesBuilt {
+			log.Logvf(
+				log.Always,
+				"skipping indexes for collection %#q, which the restore journal records as built",
+				namespaceString,
+			)
+			return nil
+		}
+	}
+	err := restore.buildIndexes(namespace.DB, namespace.Collection)
+	if err != nil {
+		return err
+	}
+
+	if restore.journal != nil {
+		return restore.journal.indexesBuilt(namespaceString)
+	}
+	return nil
+}
+
+// indexesToRestore returns the indexes of a collection as they are built, after
+// the conversions that the options ask for. The default _id index is left out,
+// since it is created along with the collection.
+func (restore *MongoRestore) indexesToRestore(
+	dbName, collName string,
+) ([]*idx.IndexDocument, error) {
+	indexesFull := restore.indexCatalog.GetIndexes(dbName, collName)
 
 	// The default _id index is created along with the collection,
 	// so we do not build that index here. We could try to submit it
@@ -149,58 +178,45 @@ func (restore *MongoRestore) RestoreIndexesForNamespace(namespace *options.Names
 	// that would significantly complicate the logic.
 	indexes, err := removeDefaultIdIndex(indexesFull)
 	if err != nil {
-		return fmt.Errorf(
+		return nil, fmt.Errorf(
 			"failed to remove default _id index from indexes list (%+v): %w",
 			indexesFull,
 			err,
 		)
 	}
+	if len(indexes) == 0 || restore.OutputOptions.NoIndexRestore {
+		return nil, nil
+	}
 
-	if len(indexes) > 0 && !restore.OutputOptions.NoIndexRestore {
-		for _, index := range indexes {
-			if addedOpts := index.EnsureIndexVersions(); len(addedOpts) != 0 {
-				optNames := slices.Sorted(maps.Keys(addedOpts))
-
-				for _, optName := range optNames {
-					log.Logvf(
-						log.Info,
-						"index %#q (%v) lacks %#q; inferring %#q",
-						index.Options["name"],
-						index.Key,
-						optName,
-						addedOpts[optName],
-					)
-				}
-			}
-		}
+	for _, index := range indexes {
+		if addedOpts := index.EnsureIndexVersions(); len(addedOpts) != 0 {
+			optNames := slices.Sorted(maps.Keys(addedOpts))
 
-		log.Logvf(log.Always, "restoring indexes for collection %#q from metadata", namespaceString)
-		if restore.OutputOptions.ConvertLegacyIndexes {
-			indexes = restore.convertLegacyIndexes(indexes, namespaceString)
-		}
-		if restore.OutputOptions.FixDottedHashedIndexes {
-			fixDottedHashedIndexes(indexes)
-		}
-		for _, index := range indexes {
-			// A v:1 index dumped from a 9.0+ server carries a redundant simple collation that the
-			// server refuses to create alongside v:1; strip it before building the index.
-			stripSimpleCollation(index.Options)
-			log.Logvf(log.Always, "index: %#v", index)
-		}
-		err = restore.CreateIndexes(namespace.DB, namespace.Collection, indexes)
-		if err != nil {
-			return fmt.Errorf(
-				"%s: error creating indexes for %s: %v",
-				namespaceString,
-				namespaceString,
-				err,
-			)
+			for _, optName := range optNames {
+				log.Logvf(
+					log.Info,
+					"index %#q (%v) lacks %#q; inferring %#q",
+					index.Options["name"],
+					index.Key,
+					optName,
+					addedOpts[optName],
+				)
+			}
 		}
-	} else {
-		log.Logvf(log.Always, "no indexes to restore for collection %#q", namespaceString)
 	}
 
-	return nil
+	if restore.OutputOptions.ConvertLegacyIndexes {
+		indexes = restore.convertLegacyIndexes(indexes, dbName+"."+collName)
+	}
+	if restore.OutputOptions.FixDottedHashedIndexes {
+		fixDottedHashedIndexes(indexes)
+	}
+	for _, index := range indexes {
+		// A v:1 index dumped from a 9.0+ server carries a redundant simple collation that the
+		// server refuses to create alongside v:1; strip it before building the index.
+		stripSimpleCollation(index.Options)
+	}
+	return indexes, nil
 }
 
 func removeDefaultIdIndex(indexes []*idx.IndexDocument) ([]*idx.IndexDocument, error) {
@@ -273,6 +289,20 @@ func (restore *MongoRestore) PopulateMetadataForIntents() error {
 			}
 			if metadata != nil {
 				intent.Options = metadata.Options
+				if len(metadata.Settings) > 0 {
+					// the settings are applied once the documents are restored
+					intent.Options, _ = dumprestore.SplitCollectionSettings(intent.Options)
+					if restore.collectionSettings == nil {
+						restore.collectionSettings = map[string]bson.D{}
+					}
+					restore.collectionSettings[intent.Namespace()] = metadata.Settings
+				}
+				if len(metadata.SearchIndexes) > 0 {
+					if restore.searchIndexes == nil {
+						restore.searchIndexes = map[string][]db.SearchIndex{}
+					}
+					restore.searchIndexes[intent.Namespace()] = metadata.SearchIndexes
+				}
 
 				for _, indexDefinition := range metadata.Indexes {
 					restore.indexCatalog.AddIndex(intent.DB, intent.C, indexDefinition)
@@ -282,6 +312,10 @@ func (restore *MongoRestore) PopulateMetadataForIntents() error {
 				if err == nil {
 					intent.Type = "timeseries"
 				}
+				_, err = bsonutil.FindValueByKey("viewOn", &intent.Options)
+				if err == nil {
+					intent.Type = "view"
+				}
 
 				restore.indexCatalog.SetCollation(intent.DB, intent.C, intent.HasSimpleCollation())
 
@@ -384,6 +418,21 @@ func (restore *MongoRestore) RestoreIntents() Result {
 
 // RestoreIntent attempts to restore a given intent into MongoDB.
 func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) Result {
+	// a namespace in the journal was at least partly restored before
+	var resumed journalNamespace
+	var started bool
+	if restore.journal != nil {
+		resumed, started = restore.journal.namespace(intent.Namespace())
+		if resumed.Complete {
+			log.Logvf(
+				log.Always,
+				"skipping %#q, which the restore journal records as restored",
+				intent.Namespace(),
+			)
+			return Result{Err: restore.skipIntent(intent)}
+		}
+	}
+
 	collectionExists, err := restore.CollectionExists(intent.DB, intent.C)
 	if err != nil {
 		return Result{Err: fmt.Errorf("error reading database: %v", err)}
@@ -397,7 +446,19 @@ func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) Result {
 		)
 	}
 
-	if restore.OutputOptions.Drop {
+	if restore.OutputOptions.Drop && started {
+		log.Logvf(
+			log.Always,
+			"not dropping collection %#q, which is being resumed",
+			intent.Namespace(),
+		)
+	} else if restore.OutputOptions.Drop && !restore.cluster.firstRestoreOf(intent.Namespace()) {
+		log.Logvf(
+			log.Always,
+			"not dropping collection %#q, which another part of the cluster dump restored",
+			intent.Namespace(),
+		)
+	} else if restore.OutputOptions.Drop {
 		if collectionExists {
 			if strings.HasPrefix(intent.C, "system.") {
 				log.Logvf(
@@ -475,6 +536,10 @@ func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) Result {
 		options = nil
 	}
 
+	// the settings of a collection are only applied to those that the restore
+	// creates, like the rest of its options
+	appliesSettings := !restore.OutputOptions.NoOptionsRestore && (!collectionExists || started)
+
 	if !collectionExists {
 		log.Logvf(log.Info, "creating collection %#q %s", intent.Namespace(), logMessageSuffix)
 		log.Logvf(log.DebugHigh, "using collection options: %#v", options)
@@ -485,6 +550,12 @@ func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) Result {
 			}
 		}
 		restore.addToKnownCollections(intent)
+		if sharded := restore.shardedCollections[intent.Namespace()]; sharded != nil {
+			err = restore.shardCollection(intent, sharded)
+			if err != nil {
+				return Result{Err: err}
+			}
+		}
 	} else {
 		log.Logvf(
 			log.Info,
@@ -493,6 +564,28 @@ func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) Result {
 		)
 	}
 
+	if restore.journal != nil {
+		err = restore.journal.start(intent.Namespace())
+		if err != nil {
+			return Result{Err: err}
+		}
+	}
+
+	if !started && restore.buildsIndexesBeforeData(intent) {
+		err = restore.restoreIndexesBeforeData(intent)
+		if err != nil {
+			return Result{Err: err}
+		}
+	}
+
+	if n := restore.numReaders(intent); n > 1 {
+		result := restore.restoreSplitIntent(intent, n)
+		if result.Err == nil && appliesSettings {
+			result = result.withErr(restore.ApplyCollectionSettings(intent))
+		}
+		return result
+	}
+
 	var result Result
 	if intent.BSONFile != nil {
 		err = intent.BSONFile.Open()
@@ -503,6 +596,33 @@ func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) Result {
 
 		log.Logvf(log.Always, "restoring %#q from %#q", intent.DataNamespace(), intent.Location)
 
+		var tracker *flushTracker
+		if restore.journal != nil {
+			if resumed.Offset > 0 {
+				log.Logvf(
+					log.Always,
+					"skipping %v %v of %#q that were already restored",
+					resumed.Documents,
+					util.Pluralize(int(resumed.Documents), "document", "documents"),
+					intent.Namespace(),
+				)
+				_, err = io.CopyN(io.Discard, intent.BSONFile, resumed.Offset)
+				if err != nil {
+					return Result{Err: fmt.Errorf(
+						"error skipping restored documents of %v: %v", intent.Location, err,
+					)}
+				}
+			}
+			namespace := intent.Namespace()
+			tracker = newFlushTracker(
+				resumed.Documents,
+				resumed.Offset,
+				func(documents, offset int64) {
+					restore.journal.setProgress(namespace, documents, offset)
+				},
+			)
+		}
+
 		bsonSource := db.NewDecodedBSONSource(db.NewBSONSource(intent.BSONFile))
 		defer bsonSource.Close()
 
@@ -513,16 +633,49 @@ func (restore *MongoRestore) RestoreIntent(intent *intents.Intent) Result {
 			intent.BSONFile,
 			intent.Size,
 			intent.Type,
+			tracker,
 		)
 		if result.Err != nil {
 			result.Err = fmt.Errorf("error restoring from %v: %v", intent.Location, result.Err)
+			if restore.journal != nil {
+				if err := restore.journal.flush(); err != nil {
+					log.Logvf(log.Always, "warning: %v", err)
+				}
+			}
 			return result
 		}
 	}
 
+	if appliesSettings {
+		if err := restore.ApplyCollectionSettings(intent); err != nil {
+			return result.withErr(err)
+		}
+	}
+
+	if restore.journal != nil {
+		return result.withErr(restore.journal.complete(intent.Namespace()))
+	}
 	return result
 }
 
+// skipIntent reads through the data of an intent that is not restored. The
+// data of an archive must be read for the restore to get past it.
+func (restore *MongoRestore) skipIntent(intent *intents.Intent) error {
+	if intent == nil || intent.BSONFile == nil || restore.InputOptions.Archive == "" {
+		return nil
+	}
+	err := intent.BSONFile.Open()
+	if err != nil {
+		return err
+	}
+	defer intent.BSONFile.Close()
+	_, err = io.Copy(io.Discard, intent.BSONFile)
+	if err != nil {
+		return fmt.Errorf("error reading through %v: %v", intent.Location, err)
+	}
+	return nil
+}
+
 func (restore *MongoRestore) convertLegacyIndexes(
 	indexes []*idx.IndexDocument,
 	ns string,
@@ -632,17 +785,56 @@ func fixDottedHashedIndex(index *idx.IndexDocument) {
 	}
 }
 
+// sequencedDoc is a document numbered in the order it was read, so that the
+// documents that were written can be tracked.
+type sequencedDoc struct {
+	raw bson.Raw
+	seq int64
+}
+
 // RestoreCollectionToDB pipes the given BSON data into the database.
 // Returns the number of documents restored and any errors that occurred.
+// If tracker isn't nil, it is told about every document that was written.
 func (restore *MongoRestore) RestoreCollectionToDB(
 	dbName, colName string,
 	bsonSource *db.DecodedBSONSource,
 	file PosReader,
 	fileSize int64,
 	collectionType string,
+	tracker *flushTracker,
 ) Result {
+	return restore.restoreSourcesToDB(
+		dbName,
+		colName,
+		[]*db.DecodedBSONSource{bsonSource},
+		file,
+		fileSize,
+		collectionType,
+		tracker,
+	)
+}
 
-	var termErr error
+// restoreSourcesToDB pipes the BSON data of each of bsonSources into the
+// database, each with its own insertion workers. file reports the position in
+// the data of all of them. A tracker can only be given for a single source.
+func (restore *MongoRestore) restoreSourcesToDB(
+	dbName, colName string,
+	bsonSources []*db.DecodedBSONSource,
+	file interface{ Pos() int64 },
+	fileSize int64,
+	collectionType string,
+	tracker *flushTracker,
+) Result {
+
+	if collectionType == "timeseries" && restore.writesByID() {
+		return Result{Err: fmt.Errorf(
+			"cannot use --mode %v to restore timeseries collection %#q",
+			restore.OutputOptions.Mode,
+			dbName+"."+colName,
+		)}
+	}
+
+	var terminated atomic.Bool
 	session, err := restore.SessionProvider.GetSession()
 	if err != nil {
 		return Result{Err: fmt.Errorf("error establishing connection: %v", err)}
@@ -650,7 +842,10 @@ func (restore *MongoRestore) RestoreCollectionToDB(
 
 	collection := session.Database(dbName).Collection(colName)
 
-	documentCount := int64(0)
+	firstSeq := int64(0)
+	if tracker != nil {
+		firstSeq = tracker.next
+	}
 	watchProgressor := progress.NewCounter(fileSize)
 	if restore.ProgressManager != nil {
 		name := fmt.Sprintf("%v.%v", dbName, colName)
@@ -659,38 +854,47 @@ func (restore *MongoRestore) RestoreCollectionToDB(
 	}
 
 	maxInsertWorkers := restore.OutputOptions.NumInsertionWorkers
+	transformer := restore.transforms.For(dbName + "." + colName)
+
+	// the channel each insertion worker reads from
+	var workerChans []chan sequencedDoc
+	for _, bsonSource := range bsonSources {
+		docChan := make(chan sequencedDoc, insertBufferFactor)
+		for range maxInsertWorkers {
+			workerChans = append(workerChans, docChan)
+		}
 
-	docChan := make(chan bson.Raw, insertBufferFactor)
-	resultChan := make(chan Result, maxInsertWorkers)
+		// stream documents for this collection on docChan
+		go func() {
+			documentCount := firstSeq
+			for {
+				doc := bsonSource.LoadNext()
+				if doc == nil {
+					break
+				}
 
-	// stream documents for this collection on docChan
-	go func() {
-		for {
-			doc := bsonSource.LoadNext()
-			if doc == nil {
-				break
-			}
+				if restore.terminate.Load() {
+					log.Logvf(log.Always, "terminating read on %#q", dbName+"."+colName)
+					terminated.Store(true)
+					close(docChan)
+					return
+				}
 
-			if restore.terminate.Load() {
-				log.Logvf(log.Always, "terminating read on %#q", dbName+"."+colName)
-				termErr = util.ErrTerminated
-				close(docChan)
-				return
+				rawBytes := make([]byte, len(doc))
+				copy(rawBytes, doc)
+				docChan <- sequencedDoc{raw: bson.Raw(rawBytes), seq: documentCount}
+				documentCount++
 			}
+			close(docChan)
+		}()
+	}
+	resultChan := make(chan Result, len(workerChans))
 
-			rawBytes := make([]byte, len(doc))
-			copy(rawBytes, doc)
-			docChan <- bson.Raw(rawBytes)
-			documentCount++
-		}
-		close(docChan)
-	}()
-
-	log.Logvf(log.DebugLow, "using %v insertion workers", maxInsertWorkers)
+	log.Logvf(log.DebugLow, "using %v insertion workers", len(workerChans))
 
 	var warnedAboutEmptyTimestamp atomic.Bool
 
-	for range maxInsertWorkers {
+	for _, docChan := range workerChans {
 		go func() {
 			var result Result
 
@@ -699,11 +903,15 @@ func (restore *MongoRestore) RestoreCollectionToDB(
 				restore.OutputOptions.BulkBufferSize,
 				restore.serverVersion,
 			).
-				SetOrdered(restore.OutputOptions.MaintainInsertionOrder)
+				SetOrdered(restore.OutputOptions.MaintainInsertionOrder).
+				SetUpsert(restore.writesByID())
 			if collectionType != "timeseries" {
 				bulk.SetBypassDocumentValidation(restore.OutputOptions.BypassDocumentValidation)
 			}
-			for rawDoc := range docChan {
+			// the documents in the bulk inserter's buffer, oldest first
+			var buffered []sequencedDoc
+			for doc := range docChan {
+				rawDoc := doc.raw
 				if restore.objCheck {
 					result.Err = bson.Unmarshal(rawDoc, &bson.D{})
 					if result.Err != nil {
@@ -711,6 +919,15 @@ func (restore *MongoRestore) RestoreCollectionToDB(
 						return
 					}
 				}
+				if transformer != nil {
+					rawDoc, result.Err = transformer.Raw(rawDoc)
+					if result.Err != nil {
+						resultChan <- result.withErr(
+							fmt.Errorf("error transforming document: %v", result.Err),
+						)
+						return
+					}
+				}
 
 				needsSpecialZeroTimestampHandling := false
 				if !bulk.CanDoZeroTimestamp() {
@@ -754,8 +971,11 @@ func (restore *MongoRestore) RestoreCollectionToDB(
 							newResult = Result{1, 0, nil}
 						}
 					} else {
+						if tracker != nil {
+							buffered = append(buffered, doc)
+						}
 						ctx, cancel := restore.writeContext()
-						newResult = NewResultFromBulkResult(bulk.InsertRaw(ctx, rawDoc))
+						newResult = restore.bulkResult(restore.writeDocument(ctx, bulk, rawDoc))
 						cancel()
 					}
 
@@ -767,6 +987,14 @@ func (restore *MongoRestore) RestoreCollectionToDB(
 					resultChan <- result
 					return
 				}
+				if needsSpecialZeroTimestampHandling {
+					tracker.markWritten(doc.seq, int64(len(doc.raw)))
+				}
+				// whatever is no longer buffered was written by a flush
+				for len(buffered) > bulk.BufferedCount() {
+					tracker.markWritten(buffered[0].seq, int64(len(buffered[0].raw)))
+					buffered = buffered[1:]
+				}
 				watchProgressor.Set(file.Pos())
 			}
 			// flush the remaining docs
@@ -797,8 +1025,14 @@ func (restore *MongoRestore) RestoreCollectionToDB(
 				bwResult, bwErr = bulk.TryFlush(ctx)
 				cancel()
 			}
-			result.combineWith(NewResultFromBulkResult(bwResult, bwErr))
-			resultChan <- result.withErr(db.FilterError(restore.OutputOptions.StopOnError, result.Err))
+			result.combineWith(restore.bulkResult(bwResult, bwErr))
+			result.Err = db.FilterError(restore.OutputOptions.StopOnError, result.Err)
+			if result.Err == nil {
+				for _, doc := range buffered {
+					tracker.markWritten(doc.seq, int64(len(doc.raw)))
+				}
+			}
+			resultChan <- result
 			return
 		}()
 
@@ -810,7 +1044,7 @@ func (restore *MongoRestore) RestoreCollectionToDB(
 	var finalErr error
 
 	// wait until all insert jobs finish
-	for range maxInsertWorkers {
+	for range workerChans {
 		totalResult.combineWith(<-resultChan)
 		if finalErr == nil && totalResult.Err != nil {
 			finalErr = totalResult.Err
@@ -820,10 +1054,16 @@ func (restore *MongoRestore) RestoreCollectionToDB(
 
 	if finalErr != nil {
 		totalResult.Err = finalErr
-	} else if err = bsonSource.Err(); err != nil {
-		totalResult.Err = fmt.Errorf("reading bson input: %v", err)
-	} else if termErr != nil {
-		totalResult.Err = termErr
+		return totalResult
+	}
+	for _, bsonSource := range bsonSources {
+		if err = bsonSource.Err(); err != nil {
+			totalResult.Err = fmt.Errorf("reading bson input: %v", err)
+			return totalResult
+		}
+	}
+	if terminated.Load() {
+		totalResult.Err = util.ErrTerminated
 	}
 	return totalResult
 }
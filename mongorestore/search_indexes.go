@@ -0,0 +1,82 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// commandRunner returns the CommandRunner that mongorestore runs the commands
+// it builds itself with, such as those of search indexes and of the lookups
+// of conflicting users and roles, which tests stub.
+func (restore *MongoRestore) commandRunner() db.CommandRunner {
+	if restore.runCommand != nil {
+		return restore.runCommand
+	}
+	return restore.SessionProvider.RunCommand
+}
+
+// RestoreSearchIndexes creates the Atlas Search and vector search indexes of
+// the restored collections with createSearchIndexes. Those that the target
+// can't create, such as when it doesn't support search indexes, are listed in
+// a warning instead, and don't fail the restore.
+func (restore *MongoRestore) RestoreSearchIndexes() {
+	for _, namespace := range slices.Sorted(maps.Keys(restore.searchIndexes)) {
+		for _, line := range restore.restoreSearchIndexes(namespace) {
+			log.Logv(log.Always, line)
+		}
+	}
+}
+
+// restoreSearchIndexes creates the search indexes of namespace, and returns
+// the lines of the warning about them if they weren't created.
+func (restore *MongoRestore) restoreSearchIndexes(namespace string) []string {
+	indexes := restore.searchIndexes[namespace]
+	dbName, collName := util.SplitNamespace(namespace)
+	ctx, cancel := restore.writeContext()
+	defer cancel()
+
+	log.Logvf(log.Info, "creating search indexes of %#q", namespace)
+	err := db.CreateSearchIndexes(ctx, restore.commandRunner(), dbName, collName, indexes)
+	if err == nil {
+		log.Logvf(
+			log.Always,
+			"created %v search %v on %#q",
+			len(indexes),
+			util.Pluralize(len(indexes), "index", "indexes"),
+			namespace,
+		)
+		return nil
+	}
+
+	reason := err.Error()
+	if db.IsSearchUnsupported(err) {
+		reason = "the target doesn't support search indexes"
+	}
+	lines := []string{fmt.Sprintf(
+		"warning: skipped %v search %v of %#q: %v",
+		len(indexes),
+		util.Pluralize(len(indexes), "index", "indexes"),
+		namespace,
+		reason,
+	)}
+	for _, index := range indexes {
+		definition, err := bson.MarshalExtJSON(index, false, false)
+		if err != nil {
+			definition = []byte(fmt.Sprintf("%#v", index))
+		}
+		lines = append(lines, fmt.Sprintf("\tskipped search index %#q: %s", index.Name, definition))
+	}
+	return lines
+}
@@ -0,0 +1,76 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/mongodb/mongo-tools/common/wcwrapper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/writeconcern"
+)
+
+func TestRestoreSearchIndexes(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	meta, err := (&MongoRestore{}).MetadataFromJSON([]byte(`{
+		"options": {},
+		"indexes": [],
+		"uuid": "",
+		"collectionName": "movies",
+		"searchIndexes": [
+			{"name": "default", "definition": {"mappings": {"dynamic": true}}},
+			{"name": "plots", "type": "vectorSearch", "definition": {"fields": [
+				{"type": "vector", "path": "embedding", "numDimensions": 3, "similarity": "cosine"}
+			]}}
+		]
+	}`))
+	require.NoError(t, err)
+	require.Len(t, meta.SearchIndexes, 2)
+
+	var commands []bson.D
+	supported := true
+	restore := &MongoRestore{
+		ToolOptions: &options.ToolOptions{
+			WriteConcern: wcwrapper.Wrap(&writeconcern.WriteConcern{W: 1}),
+		},
+		searchIndexes: map[string][]db.SearchIndex{"app.movies": meta.SearchIndexes},
+		runCommand: func(_ context.Context, dbName string, command bson.D) (bson.Raw, error) {
+			commands = append(commands, append(bson.D{{"$db", dbName}}, command...))
+			if !supported {
+				return nil, mongo.CommandError{Code: 59, Name: "CommandNotFound"}
+			}
+			return bson.Marshal(bson.D{{"ok", 1}})
+		},
+	}
+
+	assert.Empty(t, restore.restoreSearchIndexes("app.movies"))
+	require.Len(t, commands, 1)
+	assert.Equal(t, bson.D{
+		{"$db", "app"},
+		{"createSearchIndexes", "movies"},
+		{"indexes", meta.SearchIndexes},
+	}, commands[0])
+
+	supported = false
+	assert.Equal(t, []string{
+		"warning: skipped 2 search indexes of `app.movies`: " +
+			"the target doesn't support search indexes",
+		"\tskipped search index `default`: " +
+			`{"name":"default","definition":{"mappings":{"dynamic":true}}}`,
+		"\tskipped search index `plots`: " +
+			`{"name":"plots","type":"vectorSearch","definition":{"fields":[{"type":"vector",` +
+			`"path":"embedding","numDimensions":3,"similarity":"cosine"}]}}`,
+	}, restore.restoreSearchIndexes("app.movies"))
+}
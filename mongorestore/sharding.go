@@ -0,0 +1,366 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/storage"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// shardedCollection is a collection that was sharded when it was dumped, as
+// recorded by the config.collections and config.chunks of the dump.
+type shardedCollection struct {
+	Key    bson.D
+	Unique bool
+	Chunks []dumpedChunk
+}
+
+// dumpedCollection is a document of config.collections.
+type dumpedCollection struct {
+	ID      string      `bson:"_id"`
+	Key     bson.D      `bson:"key"`
+	Unique  bool        `bson:"unique"`
+	UUID    bson.Binary `bson:"uuid"`
+	Dropped bool        `bson:"dropped"`
+}
+
+// dumpedChunk is a document of config.chunks. Chunks are identified by the
+// namespace of their collection before 5.0, and by its UUID since.
+type dumpedChunk struct {
+	NS    string      `bson:"ns"`
+	UUID  bson.Binary `bson:"uuid"`
+	Min   bson.D      `bson:"min"`
+	Max   bson.D      `bson:"max"`
+	Shard string      `bson:"shard"`
+}
+
+// loadShardedCollections reads the sharded collections of the dump, and maps
+// the shards of the dump to the shards of the cluster being restored to.
+func (restore *MongoRestore) loadShardedCollections(target archive.DirLike) error {
+	var sourceShards []string
+	var err error
+	restore.shardedCollections, sourceShards, err = restore.readShardedCollections(target)
+	if err != nil {
+		return err
+	}
+	if len(restore.shardedCollections) == 0 {
+		return nil
+	}
+
+	targetShards, err := restore.listShards()
+	if err != nil {
+		return err
+	}
+	restore.shardMap = mapShards(sourceShards, targetShards)
+	for _, shard := range sourceShards {
+		log.Logvf(log.Info, "moving chunks of shard %#q to shard %#q", shard, restore.shardMap[shard])
+	}
+	return nil
+}
+
+// readShardedCollections reads the sharded collections of the dump from its
+// config database, keyed by the namespace they're restored to, along with the
+// shards that their chunks were on.
+func (restore *MongoRestore) readShardedCollections(
+	target archive.DirLike,
+) (map[string]*shardedCollection, []string, error) {
+	configDir, err := restore.findConfigDir(target)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	shardedCollections := map[string]*shardedCollection{}
+	byUUID := map[string]*shardedCollection{}
+	err = restore.readConfigCollection(configDir, "collections", func(raw bson.Raw) error {
+		var collection dumpedCollection
+		if err := bson.Unmarshal(raw, &collection); err != nil {
+			return err
+		}
+		if collection.Dropped || len(collection.Key) == 0 {
+			return nil
+		}
+		sharded := &shardedCollection{Key: collection.Key, Unique: collection.Unique}
+		shardedCollections[restore.renamer.Get(collection.ID)] = sharded
+		if len(collection.UUID.Data) > 0 {
+			byUUID[string(collection.UUID.Data)] = sharded
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sourceShards []string
+	err = restore.readConfigCollection(configDir, "chunks", func(raw bson.Raw) error {
+		var chunk dumpedChunk
+		if err := bson.Unmarshal(raw, &chunk); err != nil {
+			return err
+		}
+		var sharded *shardedCollection
+		if chunk.NS != "" {
+			sharded = shardedCollections[restore.renamer.Get(chunk.NS)]
+		} else if len(chunk.UUID.Data) > 0 {
+			sharded = byUUID[string(chunk.UUID.Data)]
+		}
+		if sharded == nil {
+			return nil
+		}
+		sharded.Chunks = append(sharded.Chunks, chunk)
+		if !slices.Contains(sourceShards, chunk.Shard) {
+			sourceShards = append(sourceShards, chunk.Shard)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Logvf(
+		log.Always,
+		"found %v sharded collections in %#q",
+		len(shardedCollections),
+		configDir,
+	)
+	return shardedCollections, sourceShards, nil
+}
+
+// findConfigDir returns the directory of the config database of the dump that
+// target is in, which is either target or its parent when a single database
+// or collection is restored. In the dump of a sharded cluster, it's in the
+// dump of the config server.
+func (restore *MongoRestore) findConfigDir(target archive.DirLike) (string, error) {
+	backend := storage.OrLocal(restore.storage)
+	dir := target.Path()
+	if !target.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+	if restore.cluster != nil {
+		dir = restore.cluster.configServerDir()
+	}
+	for range 2 {
+		configDir := filepath.Join(dir, "config")
+		_, err := backend.Stat(filepath.Join(configDir, restore.codec.FileName("collections.bson")))
+		if err == nil {
+			return configDir, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("error reading config database of dump: %v", err)
+		}
+		dir = filepath.Dir(dir)
+	}
+	return "", fmt.Errorf(
+		"no config database in dump; %v needs a dump of a sharded cluster made through mongos",
+		ShardCollectionsOption,
+	)
+}
+
+// readConfigCollection calls handle with each document of the collection
+// of the config database in configDir.
+func (restore *MongoRestore) readConfigCollection(
+	configDir, collection string,
+	handle func(bson.Raw) error,
+) error {
+	file := &realBSONFile{
+		path:    filepath.Join(configDir, restore.codec.FileName(collection+".bson")),
+		intent:  &intents.Intent{DB: "config", C: collection},
+		codec:   restore.codec,
+		key:     restore.encryptionKey,
+		storage: restore.storage,
+	}
+	err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	source := db.NewDecodedBSONSource(db.NewBSONSource(file))
+	for doc := source.LoadNext(); doc != nil; doc = source.LoadNext() {
+		err = handle(bson.Raw(doc))
+		if err != nil {
+			return fmt.Errorf("error reading config.%v of dump: %v", collection, err)
+		}
+	}
+	if err = source.Err(); err != nil {
+		return fmt.Errorf("error reading config.%v of dump: %v", collection, err)
+	}
+	return nil
+}
+
+// listShards returns the names of the shards of the cluster being restored to.
+func (restore *MongoRestore) listShards() ([]string, error) {
+	session, err := restore.SessionProvider.GetSession()
+	if err != nil {
+		return nil, fmt.Errorf("error establishing connection: %v", err)
+	}
+	ctx, cancel := restore.writeContext()
+	defer cancel()
+
+	var result struct {
+		Shards []struct {
+			ID string `bson:"_id"`
+		} `bson:"shards"`
+	}
+	err = session.Database("admin").RunCommand(ctx, bson.D{{"listShards", 1}}).Decode(&result)
+	if err != nil {
+		return nil, fmt.Errorf("error listing shards: %v", err)
+	}
+	var shards []string
+	for _, shard := range result.Shards {
+		shards = append(shards, shard.ID)
+	}
+	return shards, nil
+}
+
+// mapShards maps each shard of the dump to a shard of the cluster being
+// restored to. A shard keeps its name if the cluster has a shard by that name,
+// and the rest are spread over the shards of the cluster in order.
+func mapShards(sourceShards, targetShards []string) map[string]string {
+	shardMap := map[string]string{}
+	if len(targetShards) == 0 {
+		return shardMap
+	}
+	targetShards = slices.Sorted(slices.Values(targetShards))
+	var unmatched []string
+	for _, shard := range sourceShards {
+		if slices.Contains(targetShards, shard) {
+			shardMap[shard] = shard
+		} else {
+			unmatched = append(unmatched, shard)
+		}
+	}
+	slices.Sort(unmatched)
+	for i, shard := range unmatched {
+		shardMap[shard] = targetShards[i%len(targetShards)]
+	}
+	return shardMap
+}
+
+// shardCollection shards the collection of intent, which was just created,
+// with the shard key it was dumped with. It then splits the collection into
+// the dumped chunks and moves each of them to the shard its dumped shard maps
+// to, so the documents are inserted where they belong rather than being left
+// for the balancer to move. Chunks that can't be split or moved are left to
+// the balancer.
+func (restore *MongoRestore) shardCollection(
+	intent *intents.Intent,
+	sharded *shardedCollection,
+) error {
+	namespace := intent.Namespace()
+	log.Logvf(
+		log.Always,
+		"sharding %#q with shard key %v into %v chunks",
+		namespace,
+		sharded.Key,
+		len(sharded.Chunks),
+	)
+
+	err := restore.runAdminCommand(bson.D{{"enableSharding", intent.DB}})
+	if err != nil {
+		return fmt.Errorf("error enabling sharding for %#q: %v", intent.DB, err)
+	}
+	command := bson.D{{"shardCollection", namespace}, {"key", sharded.Key}}
+	if sharded.Unique {
+		command = append(command, bson.E{"unique", true})
+	}
+	if !restore.OutputOptions.NoOptionsRestore && !intent.HasSimpleCollation() {
+		// the shard key index of a collection with a default collation must
+		// use the simple collation
+		command = append(command, bson.E{"collation", bson.D{{"locale", "simple"}}})
+	}
+	err = restore.runAdminCommand(command)
+	if err != nil {
+		return fmt.Errorf("error sharding collection %#q: %v", namespace, err)
+	}
+
+	for _, chunk := range sharded.Chunks {
+		if isMinBound(chunk.Min) {
+			continue
+		}
+		err = restore.runAdminCommand(bson.D{{"split", namespace}, {"middle", chunk.Min}})
+		if err != nil {
+			log.Logvf(log.Always, "warning: could not split %#q at %v: %v", namespace, chunk.Min, err)
+		}
+	}
+
+	primary, err := restore.primaryShard(intent.DB)
+	if err != nil {
+		return err
+	}
+	for _, chunk := range sharded.Chunks {
+		to := restore.shardMap[chunk.Shard]
+		if to == "" || to == primary {
+			continue
+		}
+		err = restore.runAdminCommand(bson.D{
+			{"moveChunk", namespace},
+			{"bounds", bson.A{chunk.Min, chunk.Max}},
+			{"to", to},
+		})
+		if err != nil {
+			log.Logvf(
+				log.Always,
+				"warning: could not move chunk of %#q at %v to shard %#q: %v",
+				namespace,
+				chunk.Min,
+				to,
+				err,
+			)
+		}
+	}
+	return nil
+}
+
+// primaryShard returns the shard that the unsharded collections of dbName are
+// on, which is where a collection's chunks are when it's sharded.
+func (restore *MongoRestore) primaryShard(dbName string) (string, error) {
+	session, err := restore.SessionProvider.GetSession()
+	if err != nil {
+		return "", fmt.Errorf("error establishing connection: %v", err)
+	}
+	ctx, cancel := restore.writeContext()
+	defer cancel()
+
+	var database struct {
+		Primary string `bson:"primary"`
+	}
+	err = session.Database("config").
+		Collection("databases").
+		FindOne(ctx, bson.D{{"_id", dbName}}).
+		Decode(&database)
+	if err != nil {
+		return "", fmt.Errorf("error finding primary shard of %#q: %v", dbName, err)
+	}
+	return database.Primary, nil
+}
+
+func (restore *MongoRestore) runAdminCommand(command bson.D) error {
+	session, err := restore.SessionProvider.GetSession()
+	if err != nil {
+		return fmt.Errorf("error establishing connection: %v", err)
+	}
+	ctx, cancel := restore.writeContext()
+	defer cancel()
+	return session.Database("admin").RunCommand(ctx, command).Err()
+}
+
+// isMinBound returns whether bound is the lowest bound of a shard key, which
+// starts the first chunk of a collection.
+func isMinBound(bound bson.D) bool {
+	for _, elem := range bound {
+		if _, ok := elem.Value.(bson.MinKey); !ok {
+			return false
+		}
+	}
+	return true
+}
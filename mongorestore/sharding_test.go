@@ -0,0 +1,108 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestReadShardedCollections(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	dir := t.TempDir()
+	configDir := filepath.Join(dir, "config")
+	require.NoError(t, os.MkdirAll(configDir, 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "db"), 0o755))
+
+	uuid := bson.Binary{Subtype: 4, Data: []byte("0123456789abcdef")}
+	writeDocs := func(name string, docs ...bson.D) {
+		var content []byte
+		for _, doc := range docs {
+			raw, err := bson.Marshal(doc)
+			require.NoError(t, err)
+			content = append(content, raw...)
+		}
+		require.NoError(t, os.WriteFile(filepath.Join(configDir, name), content, 0o644))
+	}
+	writeDocs("collections.bson",
+		bson.D{{"_id", "db.new"}, {"key", bson.D{{"a", 1}}}, {"unique", true}, {"uuid", uuid}},
+		bson.D{{"_id", "db.old"}, {"key", bson.D{{"b", "hashed"}}}},
+		bson.D{{"_id", "db.dropped"}, {"key", bson.D{{"c", 1}}}, {"dropped", true}},
+	)
+	writeDocs("chunks.bson",
+		bson.D{
+			{"uuid", uuid},
+			{"min", bson.D{{"a", bson.MinKey{}}}},
+			{"max", bson.D{{"a", 10}}},
+			{"shard", "rs0"},
+		},
+		bson.D{
+			{"uuid", uuid},
+			{"min", bson.D{{"a", 10}}},
+			{"max", bson.D{{"a", bson.MaxKey{}}}},
+			{"shard", "rs1"},
+		},
+		bson.D{
+			{"ns", "db.old"},
+			{"min", bson.D{{"b", bson.MinKey{}}}},
+			{"max", bson.D{{"b", bson.MaxKey{}}}},
+			{"shard", "rs2"},
+		},
+		bson.D{
+			{"ns", "db.dropped"},
+			{"min", bson.D{{"c", bson.MinKey{}}}},
+			{"max", bson.D{{"c", bson.MaxKey{}}}},
+			{"shard", "rs3"},
+		},
+	)
+
+	mr := newMongoRestore()
+	var err error
+	mr.renamer, err = ns.NewRenamer([]string{"db.old"}, []string{"other.renamed"})
+	require.NoError(t, err)
+
+	// the config database is found from the directory of a single database
+	target, err := newActualPath(filepath.Join(dir, "db"))
+	require.NoError(t, err)
+	sharded, shards, err := mr.readShardedCollections(target)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"rs0", "rs1", "rs2"}, shards)
+	require.Len(t, sharded, 2)
+	require.Contains(t, sharded, "db.new")
+	assert.Equal(t, bson.D{{"a", int32(1)}}, sharded["db.new"].Key)
+	assert.True(t, sharded["db.new"].Unique)
+	require.Len(t, sharded["db.new"].Chunks, 2)
+	assert.True(t, isMinBound(sharded["db.new"].Chunks[0].Min))
+	assert.False(t, isMinBound(sharded["db.new"].Chunks[1].Min))
+	require.Contains(t, sharded, "other.renamed")
+	assert.Len(t, sharded["other.renamed"].Chunks, 1)
+
+	empty, err := newActualPath(t.TempDir())
+	require.NoError(t, err)
+	_, _, err = mr.readShardedCollections(empty)
+	assert.ErrorContains(t, err, "no config database")
+}
+
+func TestMapShards(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	assert.Equal(
+		t,
+		map[string]string{"a": "a", "x": "a", "y": "b", "z": "a"},
+		mapShards([]string{"z", "a", "y", "x"}, []string{"b", "a"}),
+	)
+	assert.Empty(t, mapShards([]string{"a"}, nil))
+}
@@ -0,0 +1,185 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/storage"
+)
+
+const (
+	// boundaryScanBufferSize is how much of a BSON file is read at a time to
+	// find the boundaries of its ranges.
+	boundaryScanBufferSize = 1024 * 1024
+
+	// rangeReadBufferSize is the size of the read buffer of each range.
+	rangeReadBufferSize = 1024 * 1024
+)
+
+// numReaders returns the number of readers to split the BSON file of intent
+// between. Only a plain BSON file of a dump directory can be split, since the
+// documents of a compressed or encrypted file can't be found without reading
+// it from the start.
+func (restore *MongoRestore) numReaders(intent *intents.Intent) int {
+	n := restore.OutputOptions.NumReaders
+	if n <= 1 {
+		return 1
+	}
+	bsonFile, ok := intent.BSONFile.(*realBSONFile)
+	if !ok || bsonFile.codec != nil || bsonFile.key != nil || len(bsonFile.partitions) > 0 {
+		log.Logvf(
+			log.DebugLow,
+			"not splitting %#q, which isn't a plain BSON file",
+			intent.Location,
+		)
+		return 1
+	}
+	return n
+}
+
+// restoreSplitIntent restores the BSON file of intent with up to n readers,
+// each reading its own range of the file.
+func (restore *MongoRestore) restoreSplitIntent(intent *intents.Intent, n int) Result {
+	bsonFile := intent.BSONFile.(*realBSONFile)
+	split, err := openSplitBSONFile(storage.OrLocal(bsonFile.storage), bsonFile.path, n)
+	if err != nil {
+		return Result{Err: err}
+	}
+	defer split.Close()
+
+	log.Logvf(
+		log.Always,
+		"restoring %#q from %#q with %v readers",
+		intent.DataNamespace(),
+		intent.Location,
+		len(split.ranges),
+	)
+	var sources []*db.DecodedBSONSource
+	for _, r := range split.ranges {
+		source := db.NewDecodedBSONSource(db.NewBSONSource(r))
+		defer source.Close()
+		sources = append(sources, source)
+	}
+	result := restore.restoreSourcesToDB(
+		intent.DB,
+		intent.DataCollection(),
+		sources,
+		split,
+		split.size,
+		intent.Type,
+		nil,
+	)
+	if result.Err != nil {
+		result.Err = fmt.Errorf("error restoring from %v: %v", intent.Location, result.Err)
+	}
+	return result
+}
+
+// splitBSONFile is a BSON file split into ranges of whole documents, which are
+// read concurrently.
+type splitBSONFile struct {
+	file   storage.File
+	size   int64
+	ranges []*rangeReader
+}
+
+// openSplitBSONFile opens the BSON file at path split into up to n ranges.
+func openSplitBSONFile(backend storage.Backend, path string, n int) (*splitBSONFile, error) {
+	info, err := backend.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading BSON file %#q: %v", path, err)
+	}
+	file, err := backend.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading BSON file %#q: %v", path, err)
+	}
+	bounds, err := documentBoundaries(file, info.Size(), n)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error splitting BSON file %#q: %v", path, err)
+	}
+	split := &splitBSONFile{file: file, size: info.Size()}
+	for i := 1; i < len(bounds); i++ {
+		r := &rangeReader{}
+		section := io.NewSectionReader(file, bounds[i-1], bounds[i]-bounds[i-1])
+		r.in = bufio.NewReaderSize(section, rangeReadBufferSize)
+		split.ranges = append(split.ranges, r)
+	}
+	return split, nil
+}
+
+// Pos returns how much of the file was read by all of the ranges.
+func (split *splitBSONFile) Pos() int64 {
+	var pos int64
+	for _, r := range split.ranges {
+		pos += r.pos.Load()
+	}
+	return pos
+}
+
+func (split *splitBSONFile) Close() error {
+	return split.file.Close()
+}
+
+// rangeReader reads a range of a split BSON file.
+type rangeReader struct {
+	in  io.Reader
+	pos atomic.Int64
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	n, err := r.in.Read(p)
+	r.pos.Add(int64(n))
+	return n, err
+}
+
+// Close does nothing, since the file is closed by the splitBSONFile.
+func (*rangeReader) Close() error {
+	return nil
+}
+
+// documentBoundaries returns the offsets that split the BSON documents in r
+// into up to n ranges of about the same size, starting with 0 and ending with
+// size. A document can't be told apart from the bytes inside another one, so
+// the boundaries are found by skipping from each document to the next by its
+// length, which is much cheaper than decoding them.
+func documentBoundaries(r io.ReaderAt, size int64, n int) ([]int64, error) {
+	bounds := []int64{0}
+	buf := make([]byte, boundaryScanBufferSize)
+	// buf holds the bytes of r from bufStart to bufEnd
+	var bufStart, bufEnd int64
+	offset := int64(0)
+	for offset < size && len(bounds) < n {
+		if offset+4 > bufEnd {
+			m, err := r.ReadAt(buf, offset)
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			bufStart, bufEnd = offset, offset+int64(m)
+			if offset+4 > bufEnd {
+				return nil, fmt.Errorf("truncated document at offset %v", offset)
+			}
+		}
+		length := int64(int32(binary.LittleEndian.Uint32(buf[offset-bufStart:])))
+		if length < 5 || offset+length > size {
+			return nil, fmt.Errorf("invalid document length %v at offset %v", length, offset)
+		}
+		offset += length
+		if offset < size && offset >= int64(len(bounds))*size/int64(n) {
+			bounds = append(bounds, offset)
+		}
+	}
+	return append(bounds, size), nil
+}
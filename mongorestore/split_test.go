@@ -0,0 +1,85 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/storage"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestDocumentBoundaries(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	var content []byte
+	var offsets []int64
+	for i := range 100 {
+		offsets = append(offsets, int64(len(content)))
+		doc, err := bson.Marshal(bson.D{{"_id", i}, {"s", strings.Repeat("x", i)}})
+		require.NoError(t, err)
+		content = append(content, doc...)
+	}
+	size := int64(len(content))
+
+	bounds, err := documentBoundaries(bytes.NewReader(content), size, 4)
+	require.NoError(t, err)
+	require.Len(t, bounds, 5)
+	assert.EqualValues(t, 0, bounds[0])
+	assert.Equal(t, size, bounds[4])
+	for i, bound := range bounds[1:4] {
+		assert.Contains(t, offsets, bound, "bounds are at the start of a document")
+		assert.GreaterOrEqual(t, bound, int64(i+1)*size/4)
+	}
+
+	bounds, err = documentBoundaries(bytes.NewReader(content[:offsets[1]]), offsets[1], 4)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{0, offsets[1]}, bounds, "a single document isn't split")
+
+	_, err = documentBoundaries(bytes.NewReader(content), size+3, len(offsets)+1)
+	assert.Error(t, err, "a truncated document is an error")
+}
+
+func TestSplitBSONFile(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	var content []byte
+	ids := map[int32]bool{}
+	for i := range int32(1000) {
+		doc, err := bson.Marshal(bson.D{{"_id", i}})
+		require.NoError(t, err)
+		content = append(content, doc...)
+		ids[i] = true
+	}
+	path := filepath.Join(t.TempDir(), "c.bson")
+	require.NoError(t, os.WriteFile(path, content, 0o644))
+
+	split, err := openSplitBSONFile(storage.Local{}, path, 3)
+	require.NoError(t, err)
+	defer split.Close()
+	require.Len(t, split.ranges, 3)
+
+	for _, r := range split.ranges {
+		source := db.NewDecodedBSONSource(db.NewBSONSource(r))
+		for doc := source.LoadNext(); doc != nil; doc = source.LoadNext() {
+			id := bson.Raw(doc).Lookup("_id").Int32()
+			assert.True(t, ids[id], "document %v is read once", id)
+			delete(ids, id)
+		}
+		require.NoError(t, source.Err())
+	}
+	assert.Empty(t, ids)
+	assert.EqualValues(t, len(content), split.Pos())
+}
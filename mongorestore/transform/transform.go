@@ -0,0 +1,456 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+// Package transform rewrites the documents that mongorestore restores, so that
+// personal data can be masked when a dump is restored into a less trusted
+// deployment. The rewriting follows per-namespace rules read from a JSON file:
+//
+//	{"rules": [{
+//	    "ns": "app.users",
+//	    "actions": [
+//	        {"op": "unset", "field": "ssn"},
+//	        {"op": "set", "field": "status", "value": "inactive"},
+//	        {"op": "hash", "field": "email", "salt": "pepper"},
+//	        {"op": "fake", "field": "name", "fake": "name"},
+//	        {"op": "rename", "field": "address.zip", "to": "postcode"}
+//	    ]
+//	}]}
+//
+// The ns of a rule is a namespace pattern like those of --nsInclude, and the
+// actions of every rule that matches a namespace are applied in order. Fields
+// are dotted paths into subdocuments; fields inside arrays aren't rewritten.
+// The value of a set action is Extended JSON. Hashed and fake values are
+// derived from the original value, so the same value is always masked the
+// same way and masked fields can still be joined on.
+package transform
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// The operations of actions.
+const (
+	SetOp    = "set"
+	UnsetOp  = "unset"
+	HashOp   = "hash"
+	FakeOp   = "fake"
+	RenameOp = "rename"
+)
+
+// The kinds of fake values.
+const (
+	FakeName   = "name"
+	FakeEmail  = "email"
+	FakePhone  = "phone"
+	FakeString = "string"
+)
+
+var (
+	fakeFirstNames = []string{
+		"Alex", "Blake", "Casey", "Dana", "Elliot", "Frankie", "Gray", "Harper",
+		"Jesse", "Kai", "Logan", "Morgan", "Noel", "Parker", "Quinn", "Riley",
+		"Sam", "Taylor",
+	}
+	fakeLastNames = []string{
+		"Adams", "Brooks", "Carter", "Diaz", "Evans", "Foster", "Garcia", "Hughes",
+		"Ito", "Jensen", "Kim", "Lopez", "Murphy", "Novak", "Owens", "Patel",
+		"Reyes", "Smith",
+	}
+)
+
+// Rules are the transformation rules of a rules file.
+type Rules struct {
+	rules []rule
+}
+
+type rule struct {
+	matcher *ns.Matcher
+	actions []*action
+}
+
+type action struct {
+	op string
+	// field is the dotted path of the field the action applies to
+	field string
+	// value is what a set action sets the field to
+	value any
+	// salt is mixed into hashed and fake values
+	salt string
+	// fake is the kind of value a fake action replaces the field with
+	fake string
+	// to is the dotted path that a rename action renames the field to
+	to string
+}
+
+type rulesFile struct {
+	Rules []struct {
+		NS      string `json:"ns"`
+		Actions []struct {
+			Op    string          `json:"op"`
+			Field string          `json:"field"`
+			Value json.RawMessage `json:"value"`
+			Salt  string          `json:"salt"`
+			Fake  string          `json:"fake"`
+			To    string          `json:"to"`
+		} `json:"actions"`
+	} `json:"rules"`
+}
+
+// Load reads the rules file at path.
+func Load(path string) (*Rules, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading transformation rules: %v", err)
+	}
+	rules, err := Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("error reading transformation rules %#q: %v", path, err)
+	}
+	return rules, nil
+}
+
+// Parse parses the content of a rules file.
+func Parse(content []byte) (*Rules, error) {
+	var file rulesFile
+	err := json.Unmarshal(content, &file)
+	if err != nil {
+		return nil, err
+	}
+	rules := &Rules{}
+	for i, fileRule := range file.Rules {
+		if fileRule.NS == "" {
+			return nil, fmt.Errorf("rule %v has no ns", i)
+		}
+		matcher, err := ns.NewMatcher([]string{fileRule.NS})
+		if err != nil {
+			return nil, fmt.Errorf("rule %v: %v", i, err)
+		}
+		r := rule{matcher: matcher}
+		for j, fileAction := range fileRule.Actions {
+			a := &action{
+				op:    fileAction.Op,
+				field: fileAction.Field,
+				salt:  fileAction.Salt,
+				fake:  fileAction.Fake,
+			}
+			if a.field == "" || strings.HasPrefix(a.field, "$") {
+				return nil, fmt.Errorf("rule %v, action %v: invalid field %#q", i, j, a.field)
+			}
+			switch a.op {
+			case UnsetOp, HashOp:
+			case SetOp:
+				if fileAction.Value == nil {
+					return nil, fmt.Errorf("rule %v, action %v: set needs a value", i, j)
+				}
+				var wrapper bson.D
+				err = bson.UnmarshalExtJSON(
+					append(append([]byte(`{"v":`), fileAction.Value...), '}'),
+					false,
+					&wrapper,
+				)
+				if err != nil {
+					return nil, fmt.Errorf("rule %v, action %v: invalid value: %v", i, j, err)
+				}
+				a.value = wrapper[0].Value
+			case FakeOp:
+				switch a.fake {
+				case FakeName, FakeEmail, FakePhone, FakeString:
+				default:
+					return nil, fmt.Errorf(
+						"rule %v, action %v: unknown fake %#q; expected %v, %v, %v or %v",
+						i, j, a.fake, FakeName, FakeEmail, FakePhone, FakeString,
+					)
+				}
+			case RenameOp:
+				if fileAction.To == "" || strings.ContainsAny(fileAction.To, ".$") {
+					return nil, fmt.Errorf(
+						"rule %v, action %v: invalid new name %#q", i, j, fileAction.To,
+					)
+				}
+				// the field keeps its place among the fields of its subdocument
+				a.to = a.field[:strings.LastIndex(a.field, ".")+1] + fileAction.To
+			default:
+				return nil, fmt.Errorf("rule %v, action %v: unknown op %#q", i, j, a.op)
+			}
+			r.actions = append(r.actions, a)
+		}
+		rules.rules = append(rules.rules, r)
+	}
+	return rules, nil
+}
+
+// For returns the transformer of the documents of namespace, or nil if no rule
+// matches it.
+func (rules *Rules) For(namespace string) *Transformer {
+	if rules == nil {
+		return nil
+	}
+	var actions []*action
+	for _, r := range rules.rules {
+		if r.matcher.Has(namespace) {
+			actions = append(actions, r.actions...)
+		}
+	}
+	if len(actions) == 0 {
+		return nil
+	}
+	return &Transformer{actions: actions}
+}
+
+// Transformer rewrites the documents of a namespace.
+type Transformer struct {
+	actions []*action
+}
+
+// Raw rewrites a whole document.
+func (t *Transformer) Raw(raw bson.Raw) (bson.Raw, error) {
+	var doc bson.D
+	err := bson.Unmarshal(raw, &doc)
+	if err != nil {
+		return nil, err
+	}
+	return bson.Marshal(t.Document(doc))
+}
+
+// Document rewrites a whole document, setting the fields of set actions even
+// if the document doesn't have them.
+func (t *Transformer) Document(doc bson.D) bson.D {
+	return t.document(doc, "", true)
+}
+
+// Fields rewrites the fields that a partial document has, like the query of
+// an update.
+func (t *Transformer) Fields(doc bson.D) bson.D {
+	return t.document(doc, "", false)
+}
+
+// Update rewrites the update of an oplog entry, which is either a replacement
+// document, a $set and $unset of fields, or a $v:2 diff.
+func (t *Transformer) Update(update bson.D) bson.D {
+	if len(update) == 0 || !strings.HasPrefix(update[0].Key, "$") {
+		return t.Document(update)
+	}
+	out := make(bson.D, 0, len(update))
+	for _, elem := range update {
+		switch elem.Key {
+		case "$set":
+			if fields, ok := elem.Value.(bson.D); ok {
+				elem.Value = t.document(fields, "", false)
+			}
+		case "$unset":
+			if fields, ok := elem.Value.(bson.D); ok {
+				elem.Value = t.renameFields(fields, "")
+			}
+		case "diff":
+			if diff, ok := elem.Value.(bson.D); ok {
+				elem.Value = t.diff(diff, "")
+			}
+		}
+		out = append(out, elem)
+	}
+	return out
+}
+
+// document rewrites the fields of doc, a subdocument at prefix. Its keys may
+// be dotted paths, like those of $set.
+func (t *Transformer) document(doc bson.D, prefix string, whole bool) bson.D {
+	out := make(bson.D, 0, len(doc))
+	for _, elem := range doc {
+		path, value, keep := t.field(prefix+elem.Key, elem.Value, whole)
+		if keep {
+			out = append(out, bson.E{Key: strings.TrimPrefix(path, prefix), Value: value})
+		}
+	}
+	if whole {
+		out = t.setMissing(out, prefix)
+	}
+	return out
+}
+
+// field rewrites the field at path, returning its new path and value, or
+// false if it's removed.
+func (t *Transformer) field(path string, value any, whole bool) (string, any, bool) {
+	for _, a := range t.actions {
+		if strings.HasPrefix(a.field, path+".") {
+			// an action applies inside the field
+			if sub, ok := value.(bson.D); ok {
+				value = t.document(sub, path+".", whole)
+			}
+			break
+		}
+	}
+	for _, a := range t.actions {
+		rest, ok := strings.CutPrefix(path, a.field)
+		if !ok || (rest != "" && rest[0] != '.') {
+			continue
+		}
+		switch a.op {
+		case UnsetOp:
+			return path, nil, false
+		case SetOp:
+			if rest != "" {
+				// the whole field is set, so setting inside it is dropped
+				return path, nil, false
+			}
+			value = a.value
+		case HashOp:
+			value = a.hash(value)
+		case FakeOp:
+			value = a.fakeValue(value)
+		case RenameOp:
+			path = a.to + rest
+		}
+	}
+	return path, value, true
+}
+
+// setMissing adds the fields of set actions to doc, a whole subdocument at
+// prefix, that it doesn't have.
+func (t *Transformer) setMissing(doc bson.D, prefix string) bson.D {
+	for _, a := range t.actions {
+		rest, ok := strings.CutPrefix(a.field, prefix)
+		if a.op != SetOp || !ok {
+			continue
+		}
+		name, _, _ := strings.Cut(rest, ".")
+		if hasKey(doc, name) {
+			continue
+		}
+		// build the subdocuments the field is in
+		segments := strings.Split(rest, ".")
+		value := a.value
+		for i := len(segments) - 1; i > 0; i-- {
+			value = bson.D{{segments[i], value}}
+		}
+		doc = append(doc, bson.E{Key: name, Value: value})
+	}
+	return doc
+}
+
+// renameFields renames the fields of doc, a partial subdocument at prefix,
+// leaving their values as they are.
+func (t *Transformer) renameFields(doc bson.D, prefix string) bson.D {
+	out := make(bson.D, 0, len(doc))
+	for _, elem := range doc {
+		path, _ := t.rewritePath(prefix + elem.Key)
+		out = append(out, bson.E{Key: strings.TrimPrefix(path, prefix), Value: elem.Value})
+	}
+	return out
+}
+
+// diff rewrites a $v:2 diff of the subdocument at prefix. Its u and i fields
+// set fields, its d fields delete them, and its s fields hold the diffs of
+// subdocuments or arrays.
+func (t *Transformer) diff(diff bson.D, prefix string) bson.D {
+	out := make(bson.D, 0, len(diff))
+	for _, elem := range diff {
+		switch {
+		case elem.Key == "u" || elem.Key == "i":
+			if fields, ok := elem.Value.(bson.D); ok {
+				elem.Value = t.document(fields, prefix, false)
+			}
+		case elem.Key == "d":
+			if fields, ok := elem.Value.(bson.D); ok {
+				elem.Value = t.renameFields(fields, prefix)
+			}
+		case strings.HasPrefix(elem.Key, "s"):
+			path, masked := t.rewritePath(prefix + elem.Key[1:])
+			if masked {
+				// the field is replaced as a whole, so it stays as it was
+				// masked rather than being changed in place
+				continue
+			}
+			if sub, ok := elem.Value.(bson.D); ok && !hasKey(sub, "a") {
+				elem.Value = t.diff(sub, prefix+elem.Key[1:]+".")
+			}
+			elem.Key = "s" + strings.TrimPrefix(path, prefix)
+		}
+		out = append(out, elem)
+	}
+	return out
+}
+
+// rewritePath returns the path that the field at path is renamed to, and
+// whether any other action applies to the whole field.
+func (t *Transformer) rewritePath(path string) (string, bool) {
+	masked := false
+	for _, a := range t.actions {
+		rest, ok := strings.CutPrefix(path, a.field)
+		if !ok || (rest != "" && rest[0] != '.') {
+			continue
+		}
+		if a.op == RenameOp {
+			path = a.to + rest
+		} else {
+			masked = true
+		}
+	}
+	return path, masked
+}
+
+// hash returns the hex SHA-256 hash of the salted value. A null value stays
+// null.
+func (a *action) hash(value any) any {
+	if value == nil {
+		return nil
+	}
+	sum := a.sum(value)
+	return hex.EncodeToString(sum[:])
+}
+
+// fakeValue returns a fake value that is derived from value. A null value
+// stays null.
+func (a *action) fakeValue(value any) any {
+	if value == nil {
+		return nil
+	}
+	sum := a.sum(value)
+	n := binary.BigEndian.Uint64(sum[:8])
+	m := binary.BigEndian.Uint64(sum[8:16])
+	switch a.fake {
+	case FakeName:
+		return fakeFirstNames[n%uint64(len(fakeFirstNames))] + " " +
+			fakeLastNames[m%uint64(len(fakeLastNames))]
+	case FakeEmail:
+		return "user" + hex.EncodeToString(sum[:5]) + "@example.com"
+	case FakePhone:
+		return fmt.Sprintf("+1-555-%03d-%04d", n%1000, m%10000)
+	default:
+		return hex.EncodeToString(sum[:8])
+	}
+}
+
+// sum returns the SHA-256 hash of the salt and value. A string is hashed as
+// its bytes, and any other value as its BSON type and bytes.
+func (a *action) sum(value any) [sha256.Size]byte {
+	content := []byte(a.salt)
+	if s, ok := value.(string); ok {
+		content = append(content, s...)
+	} else if typ, data, err := bson.MarshalValue(value); err == nil {
+		content = append(append(content, byte(typ)), data...)
+	} else {
+		content = fmt.Append(content, value)
+	}
+	return sha256.Sum256(content)
+}
+
+func hasKey(doc bson.D, key string) bool {
+	for _, elem := range doc {
+		if elem.Key == key {
+			return true
+		}
+	}
+	return false
+}
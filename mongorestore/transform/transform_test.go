@@ -0,0 +1,164 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package transform
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const testRules = `{"rules": [
+	{"ns": "app.users", "actions": [
+		{"op": "unset", "field": "ssn"},
+		{"op": "set", "field": "status", "value": "inactive"},
+		{"op": "set", "field": "flags.reviewed", "value": {"$numberInt": "1"}},
+		{"op": "hash", "field": "email", "salt": "pepper"},
+		{"op": "fake", "field": "name", "fake": "name"},
+		{"op": "rename", "field": "address.zip", "to": "postcode"}
+	]},
+	{"ns": "app.*", "actions": [
+		{"op": "unset", "field": "secret"}
+	]}
+]}`
+
+func TestParse(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	rules, err := Parse([]byte(testRules))
+	require.NoError(t, err)
+	require.NotNil(t, rules.For("app.users"))
+	assert.Len(t, rules.For("app.users").actions, 7)
+	assert.Len(t, rules.For("app.orders").actions, 1)
+	assert.Nil(t, rules.For("other.users"))
+	assert.Nil(t, (*Rules)(nil).For("app.users"))
+
+	for _, invalid := range []string{
+		`{"rules": [{"actions": []}]}`,
+		`{"rules": [{"ns": "a.b", "actions": [{"op": "drop", "field": "x"}]}]}`,
+		`{"rules": [{"ns": "a.b", "actions": [{"op": "unset"}]}]}`,
+		`{"rules": [{"ns": "a.b", "actions": [{"op": "set", "field": "x"}]}]}`,
+		`{"rules": [{"ns": "a.b", "actions": [{"op": "fake", "field": "x", "fake": "ssn"}]}]}`,
+		`{"rules": [{"ns": "a.b", "actions": [{"op": "rename", "field": "x", "to": "y.z"}]}]}`,
+	} {
+		_, err := Parse([]byte(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestDocument(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	rules, err := Parse([]byte(testRules))
+	require.NoError(t, err)
+	transformer := rules.For("app.users")
+
+	doc := bson.D{
+		{"_id", 1},
+		{"name", "Jane Doe"},
+		{"email", "jane@example.org"},
+		{"ssn", "123-45-6789"},
+		{"address", bson.D{{"city", "Springfield"}, {"zip", "12345"}}},
+		{"secret", true},
+	}
+	out := transformer.Document(doc)
+
+	require.Equal(t, []string{"_id", "name", "email", "address", "status", "flags"}, keys(out))
+	assert.NotEqual(t, "Jane Doe", lookup(out, "name"))
+	assert.IsType(t, "", lookup(out, "name"))
+	assert.Len(t, lookup(out, "email"), 64)
+	assert.Equal(t, bson.D{{"city", "Springfield"}, {"postcode", "12345"}}, lookup(out, "address"))
+	assert.Equal(t, "inactive", lookup(out, "status"))
+	assert.Equal(t, bson.D{{"reviewed", int32(1)}}, lookup(out, "flags"))
+
+	again := transformer.Document(doc)
+	assert.Equal(t, out, again, "values are masked the same way every time")
+
+	raw, err := bson.Marshal(doc)
+	require.NoError(t, err)
+	transformedRaw, err := transformer.Raw(raw)
+	require.NoError(t, err)
+	expected, err := bson.Marshal(out)
+	require.NoError(t, err)
+	assert.Equal(t, bson.Raw(expected), transformedRaw)
+}
+
+func TestUpdate(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	rules, err := Parse([]byte(testRules))
+	require.NoError(t, err)
+	transformer := rules.For("app.users")
+	hashed := lookup(transformer.Document(bson.D{{"email", "a@b.c"}}), "email")
+
+	update := transformer.Update(bson.D{
+		{"$set", bson.D{
+			{"email", "a@b.c"},
+			{"ssn", "123"},
+			{"address.zip", "54321"},
+			{"status", "active"},
+		}},
+		{"$unset", bson.D{{"address.zip", true}, {"ssn", true}}},
+	})
+	assert.Equal(t, bson.D{
+		{"$set", bson.D{
+			{"email", hashed},
+			{"address.postcode", "54321"},
+			{"status", "inactive"},
+		}},
+		{"$unset", bson.D{{"address.postcode", true}, {"ssn", true}}},
+	}, update)
+
+	diff := transformer.Update(bson.D{
+		{"$v", int32(2)},
+		{"diff", bson.D{
+			{"u", bson.D{{"email", "a@b.c"}, {"age", int32(30)}}},
+			{"d", bson.D{{"secret", false}}},
+			{"saddress", bson.D{{"u", bson.D{{"zip", "54321"}}}}},
+			{"sname", bson.D{{"u", bson.D{{"first", "Jane"}}}}},
+		}},
+	})
+	assert.Equal(t, bson.D{
+		{"$v", int32(2)},
+		{"diff", bson.D{
+			{"u", bson.D{{"email", hashed}, {"age", int32(30)}}},
+			{"d", bson.D{{"secret", false}}},
+			{"saddress", bson.D{{"u", bson.D{{"postcode", "54321"}}}}},
+		}},
+	}, diff, "a diff inside a masked field is dropped")
+
+	replacement := transformer.Update(bson.D{{"_id", 1}, {"email", "a@b.c"}})
+	assert.Equal(t, hashed, lookup(replacement, "email"))
+	assert.Equal(t, "inactive", lookup(replacement, "status"))
+
+	assert.Equal(
+		t,
+		bson.D{{"_id", 1}, {"email", hashed}},
+		transformer.Fields(bson.D{{"_id", 1}, {"email", "a@b.c"}}),
+		"fields are only rewritten, not set",
+	)
+}
+
+func keys(doc bson.D) []string {
+	var names []string
+	for _, elem := range doc {
+		names = append(names, elem.Key)
+	}
+	return names
+}
+
+func lookup(doc bson.D, key string) any {
+	for _, elem := range doc {
+		if elem.Key == key {
+			return elem.Value
+		}
+	}
+	return nil
+}
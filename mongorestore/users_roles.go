@@ -0,0 +1,354 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// builtinRoles are the roles that every server has. They're never in
+// admin.system.roles of a server, but may be in dumps of managed services.
+var builtinRoles = map[string]bool{
+	"read":                  true,
+	"readWrite":             true,
+	"dbAdmin":               true,
+	"dbOwner":               true,
+	"userAdmin":             true,
+	"clusterAdmin":          true,
+	"clusterManager":        true,
+	"clusterMonitor":        true,
+	"hostManager":           true,
+	"backup":                true,
+	"restore":               true,
+	"readAnyDatabase":       true,
+	"readWriteAnyDatabase":  true,
+	"userAdminAnyDatabase":  true,
+	"dbAdminAnyDatabase":    true,
+	"root":                  true,
+	"directShardOperations": true,
+	"searchCoordinator":     true,
+	"__system":              true,
+}
+
+// authConflict is a user or role of the dump that already exists on the
+// target, as written to the --usersAndRolesConflictReport file.
+type authConflict struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// selectsUsersAndRoles returns whether users and roles are filtered or
+// rewritten before they're merged, rather than merged as they were dumped.
+func (restore *MongoRestore) selectsUsersAndRoles() bool {
+	return restore.filtersUsersAndRoles() || len(restore.NSOptions.NSFrom) > 0
+}
+
+// filtersUsersAndRoles returns whether some users or roles of the dump are
+// left out, or left as they are on the target, rather than merged.
+func (restore *MongoRestore) filtersUsersAndRoles() bool {
+	return len(restore.InputOptions.UsersAndRolesInclude) > 0 ||
+		len(restore.InputOptions.UsersAndRolesExclude) > 0 ||
+		restore.InputOptions.SkipBuiltinRoles ||
+		restore.OutputOptions.UsersAndRolesConflictReport != ""
+}
+
+// validateUsersAndRolesOptions checks that users and roles aren't filtered
+// with --drop, which would delete those of the target that are left out
+// instead of leaving them alone.
+func (restore *MongoRestore) validateUsersAndRolesOptions() error {
+	if restore.OutputOptions.Drop && restore.filtersUsersAndRoles() {
+		return fmt.Errorf(
+			"%v can't be used with %v, %v, %v or %v, since it would delete the users "+
+				"and roles of the target that they leave out",
+			DropOption,
+			UsersAndRolesIncludeOption,
+			UsersAndRolesExcludeOption,
+			SkipBuiltinRolesOption,
+			UsersAndRolesConflictReportOption,
+		)
+	}
+	return nil
+}
+
+// includesUserOrRole returns whether a user or role, given as "<db>.<name>",
+// is selected by --usersAndRolesInclude and --usersAndRolesExclude.
+func (restore *MongoRestore) includesUserOrRole(id string) bool {
+	if len(restore.InputOptions.UsersAndRolesInclude) > 0 && !restore.authIncluder.Has(id) {
+		return false
+	}
+	return !restore.authExcluder.Has(id)
+}
+
+// renameDatabase returns the name that --nsFrom and --nsTo give a database,
+// which is its own name unless they rename every collection of it.
+func (restore *MongoRestore) renameDatabase(dbName string) string {
+	// no collection can have this name, so that only renames of whole
+	// databases apply to it
+	const probe = "\x00"
+	renamed := restore.renamer.Get(dbName + "." + probe)
+	if newName, ok := strings.CutSuffix(renamed, "."+probe); ok {
+		return newName
+	}
+	return dbName
+}
+
+// selectUsersOrRoles reads the users or roles of the dump from in and returns
+// those that are restored, as BSON, with the databases that they belong to
+// and reference renamed. When --usersAndRolesConflictReport is given, those
+// that already exist on the target are left out and recorded as conflicts.
+func (restore *MongoRestore) selectUsersOrRoles(
+	in io.ReadCloser,
+	intentType string,
+) ([]byte, error) {
+	nameField := strings.TrimSuffix(intentType, "s")
+	var selected []bson.D
+	bsonSource := db.NewDecodedBSONSource(db.NewBufferlessBSONSource(in))
+	var doc bson.D
+	for bsonSource.Next(&doc) {
+		dbName, _ := bsonutil.FindStringValueByKey("db", &doc)
+		name, _ := bsonutil.FindStringValueByKey(nameField, &doc)
+		id := dbName + "." + name
+		switch {
+		case !restore.includesUserOrRole(id):
+			log.Logvf(log.DebugLow, "skipping %v %#q, which isn't selected", nameField, id)
+		case intentType == "roles" && restore.InputOptions.SkipBuiltinRoles && builtinRoles[name]:
+			log.Logvf(log.DebugLow, "skipping built-in role %#q", id)
+		default:
+			selected = append(selected, restore.renameUserOrRole(doc, nameField))
+		}
+		doc = nil
+	}
+	if err := bsonSource.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %v: %v", intentType, err)
+	}
+
+	if !restore.OutputOptions.Drop {
+		conflicts, err := restore.findAuthConflicts(selected, intentType)
+		if err != nil {
+			return nil, err
+		}
+		for _, conflict := range conflicts {
+			log.Logvf(log.Always, "%v %#q %v", nameField, conflict.ID, conflict.Reason)
+		}
+		if restore.OutputOptions.UsersAndRolesConflictReport != "" {
+			restore.authConflicts = append(restore.authConflicts, conflicts...)
+			selected = slices.DeleteFunc(selected, func(doc bson.D) bool {
+				id, _ := bsonutil.FindStringValueByKey("_id", &doc)
+				return slices.ContainsFunc(conflicts, func(conflict *authConflict) bool {
+					return conflict.ID == id
+				})
+			})
+		}
+	}
+
+	var content []byte
+	for _, doc := range selected {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling %v: %v", nameField, err)
+		}
+		content = append(content, raw...)
+	}
+	return content, nil
+}
+
+// renameUserOrRole rewrites the database of a user or role, and those of the
+// roles and resources that it references, as --nsFrom and --nsTo rename them.
+func (restore *MongoRestore) renameUserOrRole(doc bson.D, nameField string) bson.D {
+	dbName, _ := bsonutil.FindStringValueByKey("db", &doc)
+	name, _ := bsonutil.FindStringValueByKey(nameField, &doc)
+	newDB := restore.renameDatabase(dbName)
+	for i, elem := range doc {
+		switch elem.Key {
+		case "_id":
+			doc[i].Value = newDB + "." + name
+		case "db":
+			doc[i].Value = newDB
+		case "roles":
+			doc[i].Value = restore.renameRoleReferences(elem.Value)
+		case "privileges":
+			doc[i].Value = restore.renamePrivileges(elem.Value)
+		}
+	}
+	return doc
+}
+
+// renameRoleReferences renames the databases of an array of {role, db}.
+func (restore *MongoRestore) renameRoleReferences(roles any) any {
+	array, ok := roles.(bson.A)
+	if !ok {
+		return roles
+	}
+	for _, role := range array {
+		if ref, ok := role.(bson.D); ok {
+			for i, elem := range ref {
+				if dbName, ok := elem.Value.(string); ok && elem.Key == "db" {
+					ref[i].Value = restore.renameDatabase(dbName)
+				}
+			}
+		}
+	}
+	return array
+}
+
+// renamePrivileges renames the resources of an array of privileges, which
+// are a database, a collection of one, or the whole cluster.
+func (restore *MongoRestore) renamePrivileges(privileges any) any {
+	array, ok := privileges.(bson.A)
+	if !ok {
+		return privileges
+	}
+	for _, privilege := range array {
+		privilegeDoc, ok := privilege.(bson.D)
+		if !ok {
+			continue
+		}
+		resource, err := bsonutil.FindSubdocumentByKey("resource", &privilegeDoc)
+		if err != nil {
+			continue
+		}
+		dbName, dbErr := bsonutil.FindStringValueByKey("db", &resource)
+		collName, collErr := bsonutil.FindStringValueByKey("collection", &resource)
+		if dbErr != nil || dbName == "" {
+			// a resource of any database is kept as it is
+			continue
+		}
+		newDB, newColl := restore.renameDatabase(dbName), collName
+		if collErr == nil && collName != "" {
+			newDB, newColl, _ = strings.Cut(restore.renamer.Get(dbName+"."+collName), ".")
+		}
+		for i, elem := range resource {
+			switch elem.Key {
+			case "db":
+				resource[i].Value = newDB
+			case "collection":
+				resource[i].Value = newColl
+			}
+		}
+	}
+	return array
+}
+
+// findAuthConflicts returns the users or roles of docs that already exist on
+// the target, which merging them would overwrite.
+func (restore *MongoRestore) findAuthConflicts(
+	docs []bson.D,
+	intentType string,
+) ([]*authConflict, error) {
+	if len(docs) == 0 {
+		return nil, nil
+	}
+	dumped := map[string]bson.D{}
+	var ids bson.A
+	for _, doc := range docs {
+		id, _ := bsonutil.FindStringValueByKey("_id", &doc)
+		dumped[id] = doc
+		ids = append(ids, id)
+	}
+
+	collName := "system." + intentType
+	found, err := db.RunCursorCommand(
+		context.Background(),
+		restore.commandRunner(),
+		"admin",
+		collName,
+		bson.D{
+			{"find", collName},
+			{"filter", bson.D{{"_id", bson.D{{"$in", ids}}}}},
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error reading existing %v: %v", intentType, err)
+	}
+	var existing []bson.D
+	for _, raw := range found {
+		var doc bson.D
+		err = bson.Unmarshal(raw, &doc)
+		if err != nil {
+			return nil, fmt.Errorf("error reading existing %v: %v", intentType, err)
+		}
+		existing = append(existing, doc)
+	}
+
+	var conflicts []*authConflict
+	for _, doc := range existing {
+		id, _ := bsonutil.FindStringValueByKey("_id", &doc)
+		reason := "already exists on the target"
+		if !sameRoleReferences(dumped[id], doc) {
+			reason += " with different roles"
+		}
+		conflicts = append(conflicts, &authConflict{
+			Type:   strings.TrimSuffix(intentType, "s"),
+			ID:     id,
+			Reason: reason,
+		})
+	}
+	slices.SortFunc(conflicts, func(a, b *authConflict) int { return strings.Compare(a.ID, b.ID) })
+	return conflicts, nil
+}
+
+// sameRoleReferences returns whether two users or roles are granted the same
+// roles, in any order.
+func sameRoleReferences(a, b bson.D) bool {
+	refs := func(doc bson.D) []string {
+		var ids []string
+		roles, _ := bsonutil.FindValueByKey("roles", &doc)
+		array, _ := roles.(bson.A)
+		for _, role := range array {
+			if ref, ok := role.(bson.D); ok {
+				dbName, _ := bsonutil.FindStringValueByKey("db", &ref)
+				name, _ := bsonutil.FindStringValueByKey("role", &ref)
+				ids = append(ids, dbName+"."+name)
+			}
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	return slices.Equal(refs(a), refs(b))
+}
+
+// writeAuthConflictReport writes the users and roles that weren't restored
+// because they already exist on the target to path as JSON.
+func (restore *MongoRestore) writeAuthConflictReport(path string) error {
+	content, err := json.MarshalIndent(struct {
+		Conflicts []*authConflict `json:"conflicts"`
+	}{append([]*authConflict{}, restore.authConflicts...)}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling users and roles conflict report: %v", err)
+	}
+	err = os.WriteFile(path, append(content, '\n'), 0o644)
+	if err != nil {
+		return fmt.Errorf("error writing users and roles conflict report %#q: %v", path, err)
+	}
+	return nil
+}
+
+// usersOrRolesReader returns a reader of the users or roles that are merged,
+// which is the file of the dump unless they're selected or rewritten.
+func (restore *MongoRestore) usersOrRolesReader(file PosReader, intentType string) (PosReader, error) {
+	if !restore.selectsUsersAndRoles() {
+		return file, nil
+	}
+	content, err := restore.selectUsersOrRoles(file, intentType)
+	if err != nil {
+		return nil, err
+	}
+	return &posTrackingReader{ReadCloser: io.NopCloser(bytes.NewReader(content))}, nil
+}
@@ -0,0 +1,165 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestSelectUsersAndRoles(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	var err error
+	mr := newMongoRestore()
+	mr.OutputOptions = &OutputOptions{}
+	var commands []bson.D
+	mr.runCommand = func(_ context.Context, dbName string, command bson.D) (bson.Raw, error) {
+		commands = append(commands, append(bson.D{{"$db", dbName}}, command...))
+		return bson.Marshal(bson.D{{"cursor", bson.D{{"id", int64(0)}, {"firstBatch", bson.A{}}}}})
+	}
+	mr.NSOptions.NSFrom = []string{"app.*", "other.old"}
+	mr.NSOptions.NSTo = []string{"staging.*", "other.new"}
+	mr.renamer, err = ns.NewRenamer(mr.NSOptions.NSFrom, mr.NSOptions.NSTo)
+	require.NoError(t, err)
+	mr.InputOptions.UsersAndRolesExclude = []string{"app.legacy*"}
+	mr.InputOptions.SkipBuiltinRoles = true
+	mr.authIncluder, err = ns.NewMatcher(nil)
+	require.NoError(t, err)
+	mr.authExcluder, err = ns.NewMatcher(mr.InputOptions.UsersAndRolesExclude)
+	require.NoError(t, err)
+
+	assert.Equal(t, "staging", mr.renameDatabase("app"))
+	assert.Equal(t, "other", mr.renameDatabase("other"), "only one collection is renamed")
+	assert.Equal(t, "admin", mr.renameDatabase("admin"))
+
+	var dump []byte
+	for _, doc := range []bson.D{
+		{
+			{"_id", "app.reporting"},
+			{"role", "reporting"},
+			{"db", "app"},
+			{"privileges", bson.A{
+				bson.D{
+					{"resource", bson.D{{"db", "app"}, {"collection", "orders"}}},
+					{"actions", bson.A{"find"}},
+				},
+				bson.D{
+					{"resource", bson.D{{"db", "other"}, {"collection", "old"}}},
+					{"actions", bson.A{"find"}},
+				},
+				bson.D{{"resource", bson.D{{"cluster", true}}}, {"actions", bson.A{"serverStatus"}}},
+				bson.D{
+					{"resource", bson.D{{"db", ""}, {"collection", "logs"}}},
+					{"actions", bson.A{"find"}},
+				},
+			}},
+			{"roles", bson.A{bson.D{{"role", "read"}, {"db", "app"}}}},
+		},
+		{{"_id", "app.legacyReports"}, {"role", "legacyReports"}, {"db", "app"}},
+		{{"_id", "admin.readWrite"}, {"role", "readWrite"}, {"db", "admin"}},
+	} {
+		raw, err := bson.Marshal(doc)
+		require.NoError(t, err)
+		dump = append(dump, raw...)
+	}
+
+	content, err := mr.selectUsersOrRoles(io.NopCloser(bytes.NewReader(dump)), "roles")
+	require.NoError(t, err)
+	var roles []bson.D
+	source := db.NewDecodedBSONSource(
+		db.NewBufferlessBSONSource(io.NopCloser(bytes.NewReader(content))),
+	)
+	var role bson.D
+	for source.Next(&role) {
+		roles = append(roles, role)
+		role = nil
+	}
+	require.NoError(t, source.Err())
+	require.Len(t, roles, 1, "excluded and built-in roles are left out")
+	assert.Equal(t, []bson.D{{
+		{"$db", "admin"},
+		{"find", "system.roles"},
+		{"filter", bson.D{{"_id", bson.D{{"$in", bson.A{"staging.reporting"}}}}}},
+	}}, commands, "only the selected roles are looked up on the target")
+	assert.Equal(t, bson.D{
+		{"_id", "staging.reporting"},
+		{"role", "reporting"},
+		{"db", "staging"},
+		{"privileges", bson.A{
+			bson.D{
+				{"resource", bson.D{{"db", "staging"}, {"collection", "orders"}}},
+				{"actions", bson.A{"find"}},
+			},
+			bson.D{
+				{"resource", bson.D{{"db", "other"}, {"collection", "new"}}},
+				{"actions", bson.A{"find"}},
+			},
+			bson.D{{"resource", bson.D{{"cluster", true}}}, {"actions", bson.A{"serverStatus"}}},
+			bson.D{
+				{"resource", bson.D{{"db", ""}, {"collection", "logs"}}},
+				{"actions", bson.A{"find"}},
+			},
+		}},
+		{"roles", bson.A{bson.D{{"role", "read"}, {"db", "staging"}}}},
+	}, roles[0])
+}
+
+func TestValidateUsersAndRolesOptions(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	mr := newMongoRestore()
+	mr.OutputOptions = &OutputOptions{Drop: true}
+	require.NoError(t, mr.validateUsersAndRolesOptions())
+	mr.NSOptions.NSFrom = []string{"app.*"}
+	require.NoError(t, mr.validateUsersAndRolesOptions(), "renaming keeps every user and role")
+
+	for name, set := range map[string]func(){
+		"include":         func() { mr.InputOptions.UsersAndRolesInclude = []string{"app.*"} },
+		"exclude":         func() { mr.InputOptions.UsersAndRolesExclude = []string{"app.*"} },
+		"skip built-ins":  func() { mr.InputOptions.SkipBuiltinRoles = true },
+		"conflict report": func() { mr.OutputOptions.UsersAndRolesConflictReport = "report.json" },
+	} {
+		mr.InputOptions = &InputOptions{}
+		mr.OutputOptions = &OutputOptions{Drop: true}
+		set()
+		assert.ErrorContains(
+			t,
+			mr.validateUsersAndRolesOptions(),
+			"--drop can't be used",
+			"--drop with %v deletes the users and roles it leaves out",
+			name,
+		)
+		mr.OutputOptions.Drop = false
+		assert.NoError(t, mr.validateUsersAndRolesOptions(), name)
+	}
+}
+
+func TestSameRoleReferences(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	user := bson.D{{"roles", bson.A{
+		bson.D{{"role", "read"}, {"db", "app"}},
+		bson.D{{"role", "readWrite"}, {"db", "logs"}},
+	}}}
+	reordered := bson.D{{"roles", bson.A{
+		bson.D{{"role", "readWrite"}, {"db", "logs"}},
+		bson.D{{"role", "read"}, {"db", "app"}},
+	}}}
+	assert.True(t, sameRoleReferences(user, reordered))
+	assert.False(t, sameRoleReferences(user, bson.D{{"roles", bson.A{}}}))
+	assert.True(t, sameRoleReferences(bson.D{}, bson.D{{"roles", bson.A{}}}))
+}
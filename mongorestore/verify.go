@@ -0,0 +1,496 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common"
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/compression"
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/mongodb/mongo-tools/common/dumprestore"
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/util"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// maxVerifyProblems is the number of problems reported for a single namespace.
+// Corruption early in a file tends to make everything after it fail too, so
+// only the count of any further problems is kept.
+const maxVerifyProblems = 20
+
+// VerifyReport is the result of checking a dump directory or archive with
+// --verifyOnly. It is printed as JSON.
+type VerifyReport struct {
+	Source     string                   `json:"source"`
+	Archive    bool                     `json:"archive"`
+	OK         bool                     `json:"ok"`
+	Namespaces []*VerifyNamespaceReport `json:"namespaces"`
+	Problems   []string                 `json:"problems"`
+}
+
+// VerifyNamespaceReport is the part of a VerifyReport about a single namespace.
+type VerifyNamespaceReport struct {
+	Namespace          string   `json:"namespace"`
+	Documents          int64    `json:"documents"`
+	Bytes              int64    `json:"bytes"`
+	Metadata           bool     `json:"metadata"`
+	Problems           []string `json:"problems"`
+	SuppressedProblems int      `json:"suppressedProblems,omitempty"`
+}
+
+func (nr *VerifyNamespaceReport) problemf(format string, args ...any) {
+	if len(nr.Problems) >= maxVerifyProblems {
+		nr.SuppressedProblems++
+		return
+	}
+	nr.Problems = append(nr.Problems, fmt.Sprintf(format, args...))
+}
+
+// WriteJSON writes the report to out as indented JSON.
+func (report *VerifyReport) WriteJSON(out io.Writer) error {
+	buf, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(append(buf, '\n'))
+	return err
+}
+
+// Verify checks the dump directory or archive selected by opts without
+// connecting to a server. The files of a dump directory are checked against its
+// manifest, every document is validated as BSON, every metadata file is parsed,
+// the oplog is checked for well-formed entries in timestamp order, and the
+// checksum of every namespace in an archive is compared with its data. Problems
+// with the dump are collected in the report, so an error is only returned for
+// invalid options.
+func Verify(opts Options) (*VerifyReport, error) {
+	restore := &MongoRestore{
+		ToolOptions:     opts.ToolOptions,
+		InputOptions:    opts.InputOptions,
+		OutputOptions:   opts.OutputOptions,
+		NSOptions:       opts.NSOptions,
+		TargetDirectory: opts.TargetDirectory,
+		InputReader:     os.Stdin,
+	}
+	return restore.Verify()
+}
+
+// Verify checks the dump directory or archive of the MongoRestore. See Verify.
+func (restore *MongoRestore) Verify() (*VerifyReport, error) {
+	err := restore.setInputDecoding()
+	if err != nil {
+		return nil, err
+	}
+
+	v := &verifier{
+		restore:    restore,
+		report:     &VerifyReport{Namespaces: []*VerifyNamespaceReport{}, Problems: []string{}},
+		namespaces: make(map[string]*VerifyNamespaceReport),
+	}
+	switch {
+	case restore.InputOptions.Archive != "":
+		v.report.Archive = true
+		v.report.Source = restore.InputOptions.Archive
+		v.verifyArchive()
+	case restore.TargetDirectory == "-":
+		v.report.Source = "-"
+		ns := restore.ToolOptions.DB + "." + restore.ToolOptions.Collection
+		v.verifyBSON(io.NopCloser(restore.InputReader), v.namespace(ns), false)
+	default:
+		if restore.TargetDirectory == "" {
+			restore.TargetDirectory = "dump"
+		}
+		v.report.Source = restore.TargetDirectory
+		v.verifyDirectory()
+	}
+
+	v.report.OK = len(v.report.Problems) == 0
+	for _, nr := range v.report.Namespaces {
+		v.report.OK = v.report.OK && len(nr.Problems) == 0
+	}
+	return v.report, nil
+}
+
+// verifier holds the state of a single Verify run.
+type verifier struct {
+	restore    *MongoRestore
+	report     *VerifyReport
+	namespaces map[string]*VerifyNamespaceReport
+}
+
+func (v *verifier) problemf(format string, args ...any) {
+	v.report.Problems = append(v.report.Problems, fmt.Sprintf(format, args...))
+}
+
+// namespace returns the report for ns, adding it to the report if needed. The
+// data of a timeseries collection is reported with the collection itself.
+func (v *verifier) namespace(ns string) *VerifyNamespaceReport {
+	dbName, coll := util.SplitNamespace(ns)
+	if dbName == "" {
+		// The oplog is stored as a collection without a database.
+		ns = coll
+	} else {
+		ns = dbName + "." + strings.TrimPrefix(coll, common.TimeseriesBucketPrefix)
+	}
+	nr, ok := v.namespaces[ns]
+	if !ok {
+		nr = &VerifyNamespaceReport{Namespace: ns, Problems: []string{}}
+		v.namespaces[ns] = nr
+		v.report.Namespaces = append(v.report.Namespaces, nr)
+	}
+	return nr
+}
+
+// verifyMetadata parses the metadata of a namespace the way it is parsed
+// during a restore.
+func (v *verifier) verifyMetadata(in io.Reader, nr *VerifyNamespaceReport) {
+	nr.Metadata = true
+	jsonBytes, err := io.ReadAll(in)
+	if err != nil {
+		nr.problemf("error reading metadata: %v", err)
+		return
+	}
+	_, err = v.restore.MetadataFromJSON(jsonBytes)
+	if err != nil {
+		nr.problemf("error parsing metadata: %v", err)
+	}
+}
+
+// verifyBSON reads every document from in and checks it.
+func (v *verifier) verifyBSON(in io.ReadCloser, nr *VerifyNamespaceReport, isOplog bool) {
+	source := db.NewBufferlessBSONSource(in)
+	defer source.Close()
+	if isOplog {
+		source.SetMaxBSONSize(db.MaxBSONSize + 16*1024)
+	}
+	checker := &documentChecker{report: nr, isOplog: isOplog}
+	for {
+		doc := source.LoadNext()
+		if doc == nil {
+			break
+		}
+		checker.check(doc)
+	}
+	if err := source.Err(); err != nil {
+		nr.problemf("error reading documents: %v", err)
+	}
+}
+
+// verifyDirectory checks the dump directory or BSON file given as the target.
+func (v *verifier) verifyDirectory() {
+	restore := v.restore
+	target, err := restore.openTarget()
+	if err != nil {
+		v.problemf("mongorestore target '%v' invalid: %v", restore.TargetDirectory, err)
+		return
+	}
+	_, err = restore.ReadPreludeMetadata(target)
+	if err != nil {
+		v.problemf("error reading dump metadata: %v", err)
+	}
+	problems, err := restore.checkManifest(target)
+	if err != nil {
+		v.problemf("error checking dump manifest: %v", err)
+	}
+	v.report.Problems = append(v.report.Problems, problems...)
+
+	if !target.IsDir() {
+		dbName := restore.ToolOptions.DB
+		if dbName == "" {
+			dbName = target.Parent().Name()
+		}
+		v.verifyDumpFile(dbName, target, restore.ToolOptions.DB == "")
+		return
+	}
+
+	entries, err := target.ReadDir()
+	if err != nil {
+		v.problemf("error reading dump folder: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			// A dump of the whole server has only the oplog and prelude at the
+			// top level, while a dump of a database has its collections there.
+			v.verifyDumpFile(target.Name(), entry, true)
+			continue
+		}
+		dbEntries, err := entry.ReadDir()
+		if err != nil {
+			v.problemf("error reading db folder %v: %v", entry.Name(), err)
+			continue
+		}
+		for _, dbEntry := range dbEntries {
+			if dbEntry.IsDir() {
+				log.Logvf(log.Always, `don't know what to do with subdirectory %#q, skipping...`,
+					dbEntry.Path())
+				continue
+			}
+			v.verifyDumpFile(entry.Name(), dbEntry, false)
+		}
+	}
+}
+
+// verifyDumpFile checks a single file of a dump directory. Its codec is taken
+// from its extension, or else from the codec of the dump, since mongodump names
+// oplog.bson the same whatever it is compressed with. Only an oplog.bson at the
+// top level of the dump is checked as an oplog.
+func (v *verifier) verifyDumpFile(dbName string, file archive.DirLike, topLevel bool) {
+	restore := v.restore
+	codec := restore.codec
+	if restore.detectCodec {
+		if extCodec := compression.ByExtension(file.Name()); extCodec != nil {
+			codec = extCodec
+		}
+	}
+	name := strings.TrimSuffix(file.Name(), codec.FileName(""))
+	if bsonName, _, ok := dumprestore.ParsePartitionFileName(name); ok {
+		// the documents of the other partitions of a collection count for it
+		name = bsonName
+	}
+
+	var collName string
+	isMetadata := false
+	switch {
+	case name == "prelude.json":
+		// ReadPreludeMetadata has already checked it.
+		return
+	case name == "oplog.bson" && topLevel:
+		bsonFile := &realBSONFile{
+			path:    file.Path(),
+			codec:   codec,
+			key:     restore.encryptionKey,
+			storage: restore.storage,
+		}
+		v.verifyBSONFile(bsonFile, v.namespace(".oplog"), true)
+		return
+	case strings.HasSuffix(name, ".metadata.json"):
+		collName, isMetadata = strings.TrimSuffix(name, ".metadata.json"), true
+	case strings.HasSuffix(name, ".bson"):
+		collName = strings.TrimSuffix(name, ".bson")
+	case strings.HasSuffix(name, ".bin"):
+		collName = strings.TrimSuffix(name, ".bin")
+	default:
+		log.Logvf(log.Always, `don't know what to do with file %#q, skipping...`, file.Path())
+		return
+	}
+	collName, err := url.QueryUnescape(collName)
+	if err != nil {
+		v.problemf("error parsing collection name from filename %#q: %v", file.Path(), err)
+		return
+	}
+
+	nr := v.namespace(dbName + "." + collName)
+	if !isMetadata {
+		bsonFile := &realBSONFile{
+			path:    file.Path(),
+			codec:   codec,
+			key:     restore.encryptionKey,
+			storage: restore.storage,
+		}
+		v.verifyBSONFile(bsonFile, nr, false)
+		return
+	}
+	metadataFile := &realMetadataFile{
+		path:    file.Path(),
+		codec:   codec,
+		key:     restore.encryptionKey,
+		storage: restore.storage,
+	}
+	err = metadataFile.Open()
+	if err != nil {
+		nr.Metadata = true
+		nr.problemf("%v", err)
+		return
+	}
+	defer metadataFile.Close()
+	v.verifyMetadata(metadataFile, nr)
+}
+
+func (v *verifier) verifyBSONFile(file *realBSONFile, nr *VerifyNamespaceReport, isOplog bool) {
+	err := file.Open()
+	if err != nil {
+		nr.problemf("%v", err)
+		return
+	}
+	v.verifyBSON(file, nr, isOplog)
+}
+
+// verifyArchive checks the archive given with --archive. The metadata is
+// checked from the prelude, and the data by demultiplexing the archive into a
+// verifyingOut per namespace, which lets the demultiplexer check each
+// namespace's CRC.
+func (v *verifier) verifyArchive() {
+	restore := v.restore
+	in, err := restore.getArchiveReader()
+	if err != nil {
+		v.problemf("%v", err)
+		return
+	}
+	defer in.Close()
+
+	prelude := &archive.Prelude{}
+	err = prelude.Read(in)
+	if err != nil {
+		v.problemf("%v", err)
+		return
+	}
+	v.verifyArchiveMetadata(prelude)
+
+	sourceVersion, err := db.StrToVersion(prelude.Header.ServerVersion)
+	if err != nil {
+		sourceVersion = db.Version{}
+	}
+	demux := archive.CreateDemux(sourceVersion, prelude.NamespaceMetadatas, in, false)
+	demux.NamespaceChan = make(chan string)
+	demux.NamespaceErrorChan = make(chan error)
+	go func() {
+		// The demux waits for each namespace to be opened before it goes on,
+		// so the namespaces are reported in archive order.
+		for ns := range demux.NamespaceChan {
+			out := &verifyingOut{
+				checker: &documentChecker{report: v.namespace(ns), isOplog: ns == ".oplog"},
+				hash:    crc64.New(crc64.MakeTable(crc64.ECMA)),
+			}
+			demux.Open(ns, out)
+			demux.NamespaceErrorChan <- nil
+		}
+	}()
+	err = demux.Run()
+	if err != nil {
+		v.problemf("%v", err)
+	}
+}
+
+// verifyArchiveMetadata parses the metadata of every namespace in the prelude.
+func (v *verifier) verifyArchiveMetadata(prelude *archive.Prelude) {
+	root, err := prelude.NewPreludeExplorer()
+	if err != nil {
+		v.problemf("%v", err)
+		return
+	}
+	entries, err := root.ReadDir()
+	if err != nil {
+		v.problemf("error reading archive prelude: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dbEntries, err := entry.ReadDir()
+		if err != nil {
+			v.problemf("error reading archive prelude for %v: %v", entry.Name(), err)
+			continue
+		}
+		for _, dbEntry := range dbEntries {
+			collName, ok := strings.CutSuffix(dbEntry.Name(), ".metadata.json")
+			if !ok {
+				continue
+			}
+			collName, err = url.QueryUnescape(collName)
+			if err != nil {
+				v.problemf("error parsing collection name %#q: %v", dbEntry.Name(), err)
+				continue
+			}
+			ns := entry.Name() + "." + collName
+			metadataFile := &archive.MetadataPreludeFile{Origin: ns, Prelude: prelude}
+			nr := v.namespace(ns)
+			err = metadataFile.Open()
+			if err != nil {
+				nr.Metadata = true
+				nr.problemf("%v", err)
+				continue
+			}
+			v.verifyMetadata(metadataFile, nr)
+		}
+	}
+}
+
+// verifyingOut is a DemuxOut that checks the documents of a namespace instead
+// of restoring them.
+type verifyingOut struct {
+	checker *documentChecker
+	hash    hash.Hash64
+}
+
+func (out *verifyingOut) Write(buf []byte) (int, error) {
+	out.hash.Write(buf)
+	out.checker.check(buf)
+	return len(buf), nil
+}
+
+func (*verifyingOut) End() {}
+
+func (out *verifyingOut) Sum64() (uint64, bool) {
+	return out.hash.Sum64(), true
+}
+
+// documentChecker checks the documents of a single namespace, in order.
+type documentChecker struct {
+	report  *VerifyNamespaceReport
+	isOplog bool
+	lastTS  bson.Timestamp
+}
+
+func (checker *documentChecker) check(doc []byte) {
+	checker.report.Documents++
+	checker.report.Bytes += int64(len(doc))
+	// This is the same check as --objcheck.
+	err := bson.Unmarshal(doc, &bson.D{})
+	if err != nil {
+		checker.report.problemf(
+			"document %v is not valid BSON: %v",
+			checker.report.Documents,
+			err,
+		)
+		return
+	}
+	if checker.isOplog {
+		checker.checkOplogEntry(doc)
+	}
+}
+
+// checkOplogEntry checks that an oplog entry can be applied and that it comes
+// after the previous one.
+func (checker *documentChecker) checkOplogEntry(doc []byte) {
+	n := checker.report.Documents
+	entry := db.Oplog{}
+	err := bson.Unmarshal(doc, &entry)
+	if err != nil {
+		checker.report.problemf("oplog entry %v is not a valid oplog entry: %v", n, err)
+		return
+	}
+	switch entry.Operation {
+	case "i", "u", "d", "c", "n":
+	default:
+		checker.report.problemf("oplog entry %v has unknown operation %#q", n, entry.Operation)
+	}
+	if entry.Operation != "n" && entry.Namespace == "" {
+		checker.report.problemf("oplog entry %v has no namespace", n)
+	}
+	if entry.Timestamp.IsZero() {
+		checker.report.problemf("oplog entry %v has no timestamp", n)
+		return
+	}
+	if !checker.lastTS.IsZero() && !util.TimestampGreaterThan(entry.Timestamp, checker.lastTS) {
+		checker.report.problemf(
+			"oplog entry %v at %v does not come after the previous entry at %v",
+			n, entry.Timestamp, checker.lastTS,
+		)
+	}
+	checker.lastTS = entry.Timestamp
+}
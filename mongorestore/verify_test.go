@@ -0,0 +1,242 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/archive"
+	"github.com/mongodb/mongo-tools/common/compression"
+	commonOpts "github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const verifyTestMetadata = `{"options":{},"indexes":[{"v":2,"key":{"_id":1},"name":"_id_"}],` +
+	`"uuid":"0123456789abcdef0123456789abcdef","collectionName":"foo"}`
+
+func newVerifyTestRestore(archivePath, targetDirectory string) *MongoRestore {
+	return &MongoRestore{
+		ToolOptions:     &commonOpts.ToolOptions{Namespace: &commonOpts.Namespace{}},
+		InputOptions:    &InputOptions{Archive: archivePath},
+		OutputOptions:   &OutputOptions{},
+		NSOptions:       &NSOptions{},
+		TargetDirectory: targetDirectory,
+	}
+}
+
+func verifyTestArchive(t *testing.T, metadata string, oplogTimestamps ...uint32) []byte {
+	simpleArchive := archive.SimpleArchive{
+		Header: archive.Header{ServerVersion: "7.0.0"},
+		CollectionMetadata: []archive.CollectionMetadata{
+			{Database: "test", Collection: "foo", Metadata: metadata},
+		},
+		Namespaces: []archive.SimpleNamespace{
+			{
+				Database:   "test",
+				Collection: "foo",
+				Documents:  []bson.D{{{"_id", 1}, {"name", "hello"}}, {{"_id", 2}}},
+			},
+			{
+				Collection: "oplog",
+				Documents:  oplogSegmentEntries(oplogTimestamps...),
+			},
+		},
+	}
+	content, err := simpleArchive.Marshal()
+	require.NoError(t, err)
+	return content
+}
+
+func verifyArchiveBytes(t *testing.T, content []byte) *VerifyReport {
+	path := filepath.Join(t.TempDir(), "dump.archive")
+	require.NoError(t, os.WriteFile(path, content, 0o644))
+	report, err := newVerifyTestRestore(path, "").Verify()
+	require.NoError(t, err)
+	return report
+}
+
+func findNamespaceReport(
+	t *testing.T,
+	report *VerifyReport,
+	ns string,
+) *VerifyNamespaceReport {
+	for _, nr := range report.Namespaces {
+		if nr.Namespace == ns {
+			return nr
+		}
+	}
+	require.Failf(t, "namespace not in report", "%#q", ns)
+	return nil
+}
+
+func TestVerifyArchive(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	t.Run("good archive", func(t *testing.T) {
+		report := verifyArchiveBytes(t, verifyTestArchive(t, verifyTestMetadata, 10, 11, 12))
+		assert.True(t, report.OK, "%+v", report)
+		assert.True(t, report.Archive)
+		assert.Empty(t, report.Problems)
+
+		foo := findNamespaceReport(t, report, "test.foo")
+		assert.EqualValues(t, 2, foo.Documents)
+		assert.True(t, foo.Metadata)
+		assert.Empty(t, foo.Problems)
+		assert.EqualValues(t, 3, findNamespaceReport(t, report, "oplog").Documents)
+
+		var buf bytes.Buffer
+		require.NoError(t, report.WriteJSON(&buf))
+		var decoded map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+		assert.Equal(t, true, decoded["ok"])
+	})
+
+	t.Run("corrupted data", func(t *testing.T) {
+		content := verifyTestArchive(t, verifyTestMetadata, 10, 11)
+		i := bytes.Index(content, []byte("hello"))
+		require.Positive(t, i)
+		content[i] = 'j'
+
+		report := verifyArchiveBytes(t, content)
+		assert.False(t, report.OK)
+		require.Len(t, report.Problems, 1)
+		assert.Contains(t, report.Problems[0], "CRC mismatch for namespace `test.foo`")
+	})
+
+	t.Run("invalid metadata", func(t *testing.T) {
+		report := verifyArchiveBytes(t, verifyTestArchive(t, `{"indexes": [`, 10))
+		assert.False(t, report.OK)
+		foo := findNamespaceReport(t, report, "test.foo")
+		require.Len(t, foo.Problems, 1)
+		assert.Contains(t, foo.Problems[0], "error parsing metadata")
+	})
+
+	t.Run("oplog out of order", func(t *testing.T) {
+		report := verifyArchiveBytes(t, verifyTestArchive(t, verifyTestMetadata, 10, 12, 11))
+		assert.False(t, report.OK)
+		oplog := findNamespaceReport(t, report, "oplog")
+		require.Len(t, oplog.Problems, 1)
+		assert.Contains(t, oplog.Problems[0], "oplog entry 3")
+		assert.Contains(t, oplog.Problems[0], "does not come after the previous entry")
+	})
+
+	t.Run("not an archive", func(t *testing.T) {
+		report := verifyArchiveBytes(t, []byte("definitely not an archive"))
+		assert.False(t, report.OK)
+		require.Len(t, report.Problems, 1)
+		assert.Contains(t, report.Problems[0], "does not appear to be a mongodump archive")
+	})
+}
+
+func TestVerifyDirectory(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	marshal := func(docs ...bson.D) []byte {
+		var content []byte
+		for _, doc := range docs {
+			raw, err := bson.Marshal(doc)
+			require.NoError(t, err)
+			content = append(content, raw...)
+		}
+		return content
+	}
+
+	dumpDir := t.TempDir()
+	dbDir := filepath.Join(dumpDir, "test")
+	require.NoError(t, os.Mkdir(dbDir, 0o755))
+	writeOplogSegmentBSON(t, filepath.Join(dumpDir, "oplog.bson"), 10, 11)
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dumpDir, "prelude.json"),
+		[]byte(`{"ServerVersion":"7.0.0"}`),
+		0o644,
+	))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dbDir, "foo.bson"),
+		marshal(bson.D{{"_id", 1}}, bson.D{{"_id", 2}}),
+		0o644,
+	))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dbDir, "foo.metadata.json"),
+		[]byte(verifyTestMetadata),
+		0o644,
+	))
+
+	var compressed bytes.Buffer
+	writer := compression.Zstd.NewWriter(&compressed)
+	_, err := writer.Write(marshal(bson.D{{"_id", "a"}}))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dbDir, compression.Zstd.FileName("bar.bson")),
+		compressed.Bytes(),
+		0o644,
+	))
+
+	t.Run("good dump", func(t *testing.T) {
+		report, err := newVerifyTestRestore("", dumpDir).Verify()
+		require.NoError(t, err)
+		assert.True(t, report.OK, "%+v", report)
+		assert.False(t, report.Archive)
+
+		foo := findNamespaceReport(t, report, "test.foo")
+		assert.EqualValues(t, 2, foo.Documents)
+		assert.True(t, foo.Metadata)
+		bar := findNamespaceReport(t, report, "test.bar")
+		assert.EqualValues(t, 1, bar.Documents)
+		assert.False(t, bar.Metadata)
+		assert.EqualValues(t, 2, findNamespaceReport(t, report, "oplog").Documents)
+	})
+
+	t.Run("invalid document", func(t *testing.T) {
+		content := marshal(bson.D{{"_id", 1}, {"name", "hello"}})
+		// Turn the string element into an element of an unknown type.
+		content[bytes.Index(content, []byte("name"))-1] = 0x7e
+		require.NoError(t, os.WriteFile(filepath.Join(dbDir, "baz.bson"), content, 0o644))
+		defer os.Remove(filepath.Join(dbDir, "baz.bson"))
+
+		report, err := newVerifyTestRestore("", dumpDir).Verify()
+		require.NoError(t, err)
+		assert.False(t, report.OK)
+		baz := findNamespaceReport(t, report, "test.baz")
+		require.Len(t, baz.Problems, 1)
+		assert.Contains(t, baz.Problems[0], "document 1 is not valid BSON")
+	})
+
+	t.Run("truncated file", func(t *testing.T) {
+		content := marshal(bson.D{{"_id", 1}}, bson.D{{"_id", 2}})
+		require.NoError(t, os.WriteFile(
+			filepath.Join(dbDir, "baz.bson"),
+			content[:len(content)-3],
+			0o644,
+		))
+		defer os.Remove(filepath.Join(dbDir, "baz.bson"))
+
+		report, err := newVerifyTestRestore("", dumpDir).Verify()
+		require.NoError(t, err)
+		assert.False(t, report.OK)
+		baz := findNamespaceReport(t, report, "test.baz")
+		assert.EqualValues(t, 1, baz.Documents)
+		require.Len(t, baz.Problems, 1)
+		assert.Contains(t, baz.Problems[0], "error reading documents")
+	})
+
+	t.Run("single bson file", func(t *testing.T) {
+		report, err := newVerifyTestRestore("", filepath.Join(dbDir, "foo.bson")).Verify()
+		require.NoError(t, err)
+		assert.True(t, report.OK, "%+v", report)
+		require.Len(t, report.Namespaces, 1)
+		assert.Equal(t, "test.foo", report.Namespaces[0].Namespace)
+		assert.EqualValues(t, 2, report.Namespaces[0].Documents)
+	})
+}
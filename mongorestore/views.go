@@ -0,0 +1,68 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/mongodb/mongo-tools/common/log"
+)
+
+// applyRecordedViewSources sets the namespaces that each view of the restore
+// reads from to those that the dump recorded, renamed like the collections
+// that they're restored to. Views of dumps that didn't record them fall back
+// to their options.
+func (restore *MongoRestore) applyRecordedViewSources() {
+	for namespace, sources := range restore.recordedViewSources {
+		intent := restore.manager.IntentForNamespace(namespace)
+		if intent == nil || !intent.IsView() {
+			continue
+		}
+		renamed := make([]string, 0, len(sources))
+		for _, source := range sources {
+			renamed = append(renamed, restore.renamer.Get(source))
+		}
+		slices.Sort(renamed)
+		intent.RecordedViewSources = renamed
+	}
+}
+
+// missingViewSources returns the namespaces that each view of the restore
+// reads from that aren't restored along with it, by the namespace of the view.
+func (restore *MongoRestore) missingViewSources() map[string][]string {
+	allIntents := restore.manager.Intents()
+	restored := map[string]bool{}
+	for _, intent := range allIntents {
+		restored[intent.Namespace()] = true
+	}
+	missing := map[string][]string{}
+	for _, intent := range allIntents {
+		for _, source := range intent.ViewSources() {
+			if !restored[source] {
+				missing[intent.Namespace()] = append(missing[intent.Namespace()], source)
+			}
+		}
+	}
+	return missing
+}
+
+// warnAboutMissingViewSources warns about the views that read from
+// collections or views that aren't in the dump, or that aren't restored.
+func (restore *MongoRestore) warnAboutMissingViewSources() {
+	missing := restore.missingViewSources()
+	for _, view := range slices.Sorted(maps.Keys(missing)) {
+		for _, source := range missing[view] {
+			log.Logvf(
+				log.Always,
+				"warning: view %#q reads from %#q, which is not restored from the dump",
+				view,
+				source,
+			)
+		}
+	}
+}
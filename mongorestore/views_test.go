@@ -0,0 +1,69 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongorestore
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/intents"
+	"github.com/mongodb/mongo-tools/common/testtype"
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestMissingViewSources(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	mr := newMongoRestore()
+	mr.manager.Put(&intents.Intent{DB: "app", C: "orders"})
+	mr.manager.Put(&intents.Intent{DB: "app", C: "recent", Type: "view", Options: bson.D{
+		{"viewOn", "orders"},
+		{"pipeline", bson.A{bson.D{{"$lookup", bson.D{{"from", "customers"}}}}}},
+	}})
+	mr.manager.Put(&intents.Intent{DB: "app", C: "top", Type: "view", Options: bson.D{
+		{"viewOn", "recent"},
+	}})
+
+	assert.Equal(t, map[string][]string{"app.recent": {"app.customers"}}, mr.missingViewSources())
+}
+
+func TestApplyRecordedViewSources(t *testing.T) {
+	testtype.SkipUnlessTestType(t, testtype.UnitTestType)
+
+	var err error
+	mr := newMongoRestore()
+	mr.renamer, err = ns.NewRenamer([]string{"app.*"}, []string{"staging.*"})
+	require.NoError(t, err)
+	view := &intents.Intent{DB: "staging", C: "recent", Type: "view", Options: bson.D{
+		{"viewOn", "orders"},
+	}}
+	old := &intents.Intent{DB: "other", C: "old", Type: "view", Options: bson.D{
+		{"viewOn", "events"},
+	}}
+	mr.manager.PutWithNamespace("app.recent", view)
+	mr.manager.PutWithNamespace("other.old", old)
+	mr.recordedViewSources = map[string][]string{
+		"app.recent": {"app.orders", "other.customers"},
+		"app.gone":   {"app.orders"},
+	}
+
+	mr.applyRecordedViewSources()
+	assert.Equal(
+		t,
+		[]string{"other.customers", "staging.orders"},
+		view.ViewSources(),
+		"the recorded sources are renamed like the collections they're restored to",
+	)
+	assert.Equal(
+		t,
+		[]string{"other.events"},
+		old.ViewSources(),
+		"views that the dump didn't record fall back to their options",
+	)
+}